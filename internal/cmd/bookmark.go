@@ -0,0 +1,38 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+)
+
+func newBookmarkCommand(cmdCfg *zsmCommandConfig) *cobra.Command {
+	bookmarkCmd := &cobra.Command{
+		Use:   "bookmark <SNAPSHOT>...",
+		Short: "Create bookmarks of snapshots.",
+		Long: `Create bookmarks of snapshots.
+
+bookmark creates a ZFS bookmark of each of the passed snapshots. The bookmark
+of FILE_SYSTEM@TIMESTAMP is named FILE_SYSTEM#TIMESTAMP. zsm send and zsm pull
+bookmark each snapshot they transfer. Once the snapshots both hosts have in
+common are destroyed, they send incrementally to the newest bookmark instead.
+
+Use zsm list --bookmarks to list all bookmarks created by zsm.`,
+		Args: cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			sm, err := cmdCfg.SnapshotManager()
+			if err != nil {
+				return err
+			}
+			names, err := parseNames(args)
+			if err != nil {
+				return err
+			}
+			for _, name := range names {
+				if err := sm.BookmarkSnapshot(name); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	}
+	return bookmarkCmd
+}
@@ -0,0 +1,44 @@
+package cmd_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/fhofherr/zsm/internal/cmd"
+	"github.com/fhofherr/zsm/internal/snapshot"
+)
+
+func TestBookmark(t *testing.T) {
+	tests := []cmd.TestCase{
+		{
+			Name: "bookmark snapshots",
+			MakeArgs: func(t *testing.T) []string {
+				return []string{
+					"bookmark",
+					"zsm_test@2020-04-10T09:45:58.564585005Z",
+					"zsm_test/fs_1@2020-04-10T09:45:58.564585005Z",
+				}
+			},
+			MakeMSM: func(t *testing.T) *snapshot.MockManager {
+				sm := &snapshot.MockManager{}
+				sm.On("BookmarkSnapshot", snapshot.MustParseName(t, "zsm_test@2020-04-10T09:45:58.564585005Z")).
+					Return(nil)
+				sm.On("BookmarkSnapshot", snapshot.MustParseName(t, "zsm_test/fs_1@2020-04-10T09:45:58.564585005Z")).
+					Return(nil)
+				return sm
+			},
+		},
+		{
+			Name: "invalid snapshot name",
+			MakeArgs: func(t *testing.T) []string {
+				return []string{"bookmark", "zsm_test@manual"}
+			},
+			MakeMSM: func(t *testing.T) *snapshot.MockManager {
+				return &snapshot.MockManager{}
+			},
+			ExpectedErr: errors.New("invalid snapshot name: zsm_test@manual"),
+		},
+	}
+
+	cmd.RunTests(t, tests)
+}
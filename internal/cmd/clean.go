@@ -1,9 +1,16 @@
 package cmd
 
 import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
 	"github.com/fhofherr/zsm/internal/config"
 	"github.com/fhofherr/zsm/internal/snapshot"
 	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
 )
 
 var cleanIntervalFlags = []struct {
@@ -65,6 +72,13 @@ var cleanIntervalFlags = []struct {
 }
 
 func newCleanCommand(cmdCfg *zsmCommandConfig) *cobra.Command {
+	var (
+		dryRun  bool
+		explain bool
+		outType string
+		label   string
+	)
+
 	cleanCmd := &cobra.Command{
 		Use:   "clean",
 		Short: "Clean obsolete zfs snapshots created by zsm",
@@ -80,7 +94,95 @@ snapshot in the hour zsm clean is called, is retained because it is one of the
 last m minutely as well as one of the last H hourly snapshots.
 
 Snapshots that have not been created by zsm, i.e. snapshots that do not fit zsm's
-naming conventions, are not removed.`,
+naming conventions, are not removed.
+
+The number of snapshots to keep may be configured per file system using the
+snapshots.policies setting:
+
+    snapshots:
+      policies:
+        - file_system: tank/scratch
+          keep:
+            hour: 2
+            day: 0
+
+A policy applies to its file system and all its descendants, unless a
+descendant has a policy of its own. Counts missing from a policy are taken from
+the command line flags or the snapshots.keep settings.
+
+By default the snapshots kept per interval are at least the interval apart,
+e.g. 24 hours for daily snapshots. The --calendar option, or the
+snapshots.keep.calendar setting, instead keeps a single snapshot per calendar
+minute, hour, day, ISO week, month, or year. Set it to first to keep the first
+snapshot of each period, or to last to keep the last one. The default is
+sliding. The periods are aligned to the time zone set using --time-zone or the
+snapshots.keep.time_zone setting, e.g. Europe/Berlin. It defaults to the local
+time zone. Policies may override both using calendar and time_zone:
+
+    snapshots:
+      policies:
+        - file_system: tank/db
+          calendar: first
+          time_zone: UTC
+
+The --buckets option, or the snapshots.keep.buckets setting, adds buckets of
+arbitrary length to the ones above. It accepts a comma separated list of
+period:count pairs, e.g. --buckets 15m:8,6h:4,1d:14,3mo:4 keeps 8 snapshots 15
+minutes apart, 4 snapshots 6 hours apart, 14 snapshots a day apart, and 4
+quarterly snapshots. Periods accept the units of Go durations as well as d, w,
+mo, and y for days, weeks, calendar months, and calendar years. With --calendar
+periods of months and years are aligned to the start of the year, periods
+shorter than a day to midnight, and all other periods to Mondays. Policies may
+override the buckets using buckets. An empty value removes them:
+
+    snapshots:
+      policies:
+        - file_system: tank/db
+          buckets: 15m:8, 3mo:4
+
+The --dry-run option makes clean print which snapshots it would keep and which
+it would destroy without actually destroying any snapshot. The --output option
+allows to switch the output format of --dry-run. The currently supported values
+are text and jsonl. The jsonl format prints one json document per file system.
+
+The --explain option implies --dry-run. Additionally it prints the intervals
+each kept snapshot fills. A snapshot is kept as long as it fills at least one
+interval. The jsonl format always contains the intervals.
+
+By default clean considers only snapshots without a label. The --label option
+makes clean consider only the snapshots created using zsm create --label
+instead. Snapshots with different labels never affect each other.
+
+The --keep-within option, or the snapshots.keep.within setting, keeps all
+snapshots younger than the passed duration in addition to the snapshots kept
+per interval, e.g. --keep-within 48h. The --max-age option, or the
+snapshots.keep.max_age setting, destroys all snapshots older than the passed
+duration, even if they would be kept per interval, e.g. --max-age 3y. The
+newest snapshot of a file system is never destroyed because of its age. Both
+accept the same periods as --buckets, i.e. mo and y denote calendar months and
+years. A max age less than the keep within duration is an error. Both apply to
+all file systems regardless of the snapshots.policies setting. --explain prints
+within for snapshots kept because of --keep-within.
+
+Snapshots created using zsm create --expire-in or --expire-at do not count
+towards the numbers of snapshots to keep. clean keeps them until they expire
+and destroys them afterwards. --explain prints their expiry.
+
+Snapshots protected using zsm hold can't be destroyed. clean skips them and
+prints them prefixed with held instead of failing. The --dry-run option prints
+them the same way. They are destroyed by the first clean after they have been
+released using zsm release.
+
+By default clean attempts to destroy the outdated snapshots of all file
+systems, even if destroying some of them fails. If any of them fails, clean
+prints the snapshots it destroyed prefixed with destroyed, the ones it failed
+to destroy prefixed with failed, and exits with an error listing the output of
+zfs for every failure. The --best-effort=false option, or setting
+snapshots.clean.best_effort to false, makes clean stop at the first error
+instead.
+
+Commands configured using the snapshots.clean.hooks setting are executed before
+and after destroying snapshots. See zsm create --help for the format.`,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			sm, err := cmdCfg.SnapshotManager()
 			if err != nil {
@@ -91,7 +193,41 @@ naming conventions, are not removed.`,
 			for _, iv := range cleanIntervalFlags {
 				cfg[iv.Interval] = cmdCfg.V.GetInt(iv.Key)
 			}
-			return sm.CleanSnapshots(cfg)
+			policies, err := cleanPolicies(cmdCfg.V, cfg)
+			if err != nil {
+				return err
+			}
+			hooks, ok, err := readHooks(cmdCfg.V, config.SnapshotsCleanHooks)
+			if err != nil {
+				return err
+			}
+			cleanOpts, err := cleanWindows(cmdCfg.V)
+			if err != nil {
+				return err
+			}
+			if label != "" {
+				if err := snapshot.ValidateLabel(label); err != nil {
+					return err
+				}
+				cleanOpts = append(cleanOpts, snapshot.CleanLabel(label))
+			}
+			if !dryRun && !explain {
+				if ok {
+					cleanOpts = append(cleanOpts, snapshot.CleanHooks(hooks))
+				}
+				if cmdCfg.V.GetBool(config.SnapshotsCleanBestEffort) {
+					cleanOpts = append(cleanOpts, snapshot.CleanBestEffort())
+				}
+				held, err := sm.CleanSnapshots(policies, cleanOpts...)
+				printHeld(cmdCfg.Stdout(), held)
+				printPartialError(cmdCfg.Stdout(), "destroyed", err)
+				return err
+			}
+			plans, err := sm.PlanClean(policies, cleanOpts...)
+			if err != nil {
+				return err
+			}
+			return printCleanPlans(cmdCfg.Stdout(), outType, explain, plans)
 		},
 	}
 
@@ -100,5 +236,268 @@ naming conventions, are not removed.`,
 		cmdCfg.V.BindPFlag(iv.Key, cleanCmd.Flags().Lookup(iv.Long))
 	}
 
+	cleanCmd.Flags().String("calendar", config.DefaultSnapshotsKeepCalendar,
+		"Keep snapshots per calendar period. Supported values: sliding, first, last.")
+	cmdCfg.V.BindPFlag(config.SnapshotsKeepCalendar, cleanCmd.Flags().Lookup("calendar"))
+	cleanCmd.Flags().String("time-zone", config.DefaultSnapshotsKeepTimeZone,
+		"Time zone of the calendar periods used by --calendar, e.g. Europe/Berlin.")
+	cmdCfg.V.BindPFlag(config.SnapshotsKeepTimeZone, cleanCmd.Flags().Lookup("time-zone"))
+	cleanCmd.Flags().StringSlice("buckets", nil,
+		"Additionally keep snapshots per period:count pair, e.g. 15m:8,3mo:4.")
+	cmdCfg.V.BindPFlag(config.SnapshotsKeepBuckets, cleanCmd.Flags().Lookup("buckets"))
+	cleanCmd.Flags().String("keep-within", "",
+		"Keep all snapshots younger than the passed duration, e.g. 48h.")
+	cmdCfg.V.BindPFlag(config.SnapshotsKeepWithin, cleanCmd.Flags().Lookup("keep-within"))
+	cleanCmd.Flags().String("max-age", "",
+		"Destroy all snapshots but the newest which are older than the passed duration, e.g. 3y.")
+	cmdCfg.V.BindPFlag(config.SnapshotsKeepMaxAge, cleanCmd.Flags().Lookup("max-age"))
+	cleanCmd.Flags().StringVar(&label, "label", "",
+		"Clean only the snapshots with this label instead of the snapshots without label.")
+	cleanCmd.Flags().BoolVar(&dryRun, "dry-run", false,
+		"Print the snapshots clean would keep and destroy without destroying them.")
+	cleanCmd.Flags().BoolVar(&explain, "explain", false,
+		"Like --dry-run, but additionally print the intervals each kept snapshot fills.")
+	cleanCmd.Flags().StringVarP(&outType, "output", "o", "text",
+		"Change the output format of --dry-run. Supported values: text, jsonl.")
+	cleanCmd.Flags().Bool("best-effort", config.DefaultSnapshotsCleanBestEffort,
+		"Attempt to destroy the outdated snapshots of all file systems, even if some of them fail.")
+	cmdCfg.V.BindPFlag(config.SnapshotsCleanBestEffort, cleanCmd.Flags().Lookup("best-effort"))
+
 	return cleanCmd
 }
+
+// cleanWindows returns the CleanOptions for the snapshots.keep.within and
+// snapshots.keep.max_age settings.
+func cleanWindows(v *viper.Viper) ([]snapshot.CleanOption, error) {
+	var (
+		opts   []snapshot.CleanOption
+		within snapshot.Period
+		maxAge snapshot.Period
+	)
+
+	for _, w := range []struct {
+		Key string
+		P   *snapshot.Period
+		Opt func(snapshot.Period) snapshot.CleanOption
+	}{
+		{Key: config.SnapshotsKeepWithin, P: &within, Opt: snapshot.KeepWithin},
+		{Key: config.SnapshotsKeepMaxAge, P: &maxAge, Opt: snapshot.MaxAge},
+	} {
+		s := v.GetString(w.Key)
+		if s == "" {
+			continue
+		}
+		p, err := snapshot.ParsePeriod(s)
+		if err != nil {
+			return nil, fmt.Errorf("%s: invalid duration: %s", w.Key, s)
+		}
+		*w.P = p
+		opts = append(opts, w.Opt(p))
+	}
+	now := time.Now()
+	if !maxAge.IsZero() && within.Before(now).Before(maxAge.Before(now)) {
+		return nil, fmt.Errorf("%s: less than %s", config.SnapshotsKeepMaxAge, config.SnapshotsKeepWithin)
+	}
+	return opts, nil
+}
+
+// policyConfig represents a single entry of the snapshots.policies setting.
+type policyConfig struct {
+	FileSystem string         `mapstructure:"file_system"`
+	Keep       map[string]int `mapstructure:"keep"`
+	Calendar   string         `mapstructure:"calendar"`
+	TimeZone   string         `mapstructure:"time_zone"`
+	Buckets    *string        `mapstructure:"buckets"`
+}
+
+// valueOr returns v, or def if v is empty.
+func valueOr(v, def string) string {
+	if v == "" {
+		return def
+	}
+	return v
+}
+
+// Values of the calendar setting.
+const (
+	calendarSliding = "sliding"
+	calendarFirst   = "first"
+	calendarLast    = "last"
+)
+
+// cleanCalendar returns the Calendar selected by the calendar setting mode
+// and the time zone setting timeZone. It returns nil if mode selects sliding
+// intervals. The keys of the settings are used in error messages.
+func cleanCalendar(mode, timeZone, modeKey, timeZoneKey string) (*snapshot.Calendar, error) {
+	loc, err := time.LoadLocation(timeZone)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", timeZoneKey, err)
+	}
+	switch mode {
+	case calendarSliding:
+		return nil, nil
+	case calendarFirst, calendarLast:
+		return &snapshot.Calendar{Location: loc, Last: mode == calendarLast}, nil
+	default:
+		return nil, fmt.Errorf("%s: invalid value: %s", modeKey, mode)
+	}
+}
+
+// policyBucketConfig returns def updated with the counts in keep.
+func policyBucketConfig(def snapshot.BucketConfig, keep map[string]int) (snapshot.BucketConfig, error) {
+	cfg := def
+	for k, n := range keep {
+		var iv snapshot.Interval
+		if err := iv.UnmarshalText([]byte(k)); err != nil {
+			return cfg, err
+		}
+		if n < 0 {
+			return cfg, fmt.Errorf("negative value for %s", k)
+		}
+		cfg[iv] = n
+	}
+	return cfg, nil
+}
+
+// usesCalendar returns true if there are calendars and def or any of them
+// is not nil.
+func usesCalendar(def *snapshot.Calendar, calendars map[string]*snapshot.Calendar) bool {
+	for _, cal := range calendars {
+		if cal != nil || def != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// usesCustomBuckets returns true if there are custom buckets and def or any
+// of them is not empty.
+func usesCustomBuckets(def []snapshot.CustomBucket, custom map[string][]snapshot.CustomBucket) bool {
+	for _, cbs := range custom {
+		if len(cbs) > 0 || len(def) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func cleanPolicies(v *viper.Viper, def snapshot.BucketConfig) (snapshot.Policies, error) {
+	var pcs []policyConfig
+
+	if err := v.UnmarshalKey(config.SnapshotsPolicies, &pcs); err != nil {
+		return snapshot.Policies{}, fmt.Errorf("%s: %w", config.SnapshotsPolicies, err)
+	}
+	mode := v.GetString(config.SnapshotsKeepCalendar)
+	timeZone := v.GetString(config.SnapshotsKeepTimeZone)
+	defCal, err := cleanCalendar(mode, timeZone, config.SnapshotsKeepCalendar, config.SnapshotsKeepTimeZone)
+	if err != nil {
+		return snapshot.Policies{}, err
+	}
+	defCustom, err := snapshot.ParseCustomBuckets(strings.Join(v.GetStringSlice(config.SnapshotsKeepBuckets), ","))
+	if err != nil {
+		return snapshot.Policies{}, fmt.Errorf("%s: %w", config.SnapshotsKeepBuckets, err)
+	}
+	policies := snapshot.Policies{Default: def, DefaultCalendar: defCal, DefaultCustomBuckets: defCustom}
+	calendars := make(map[string]*snapshot.Calendar)
+	customs := make(map[string][]snapshot.CustomBucket)
+	for _, pc := range pcs {
+		fs := strings.TrimPrefix(pc.FileSystem, "/")
+		if fs == "" {
+			return snapshot.Policies{}, fmt.Errorf("%s: file_system missing", config.SnapshotsPolicies)
+		}
+		if _, ok := policies.FileSystems[fs]; ok {
+			return snapshot.Policies{}, fmt.Errorf("%s: duplicate policy for %s", config.SnapshotsPolicies, fs)
+		}
+		cfg, err := policyBucketConfig(def, pc.Keep)
+		if err != nil {
+			return snapshot.Policies{}, fmt.Errorf("%s: %s: %w", config.SnapshotsPolicies, fs, err)
+		}
+		if policies.FileSystems == nil {
+			policies.FileSystems = make(map[string]snapshot.BucketConfig)
+		}
+		policies.FileSystems[fs] = cfg
+		cal, err := cleanCalendar(valueOr(pc.Calendar, mode), valueOr(pc.TimeZone, timeZone), "calendar", "time_zone")
+		if err != nil {
+			return snapshot.Policies{}, fmt.Errorf("%s: %s: %w", config.SnapshotsPolicies, fs, err)
+		}
+		calendars[fs] = cal
+		customs[fs] = defCustom
+		if pc.Buckets != nil {
+			if customs[fs], err = snapshot.ParseCustomBuckets(*pc.Buckets); err != nil {
+				return snapshot.Policies{}, fmt.Errorf("%s: %s: buckets: %w", config.SnapshotsPolicies, fs, err)
+			}
+		}
+	}
+	// Only record the calendars and custom buckets of the policies if they
+	// are used. Otherwise every file system uses sliding intervals and no
+	// custom buckets anyway.
+	if usesCalendar(defCal, calendars) {
+		policies.Calendars = calendars
+	}
+	if usesCustomBuckets(defCustom, customs) {
+		policies.CustomBuckets = customs
+	}
+	return policies, nil
+}
+
+// printHeld prints the held snapshots clean can't destroy.
+func printHeld(w io.Writer, held []snapshot.Name) {
+	for _, n := range held {
+		fmt.Fprintf(w, "held\t%s\n", n)
+	}
+}
+
+// printPartialError prints the snapshots which succeeded prefixed with done
+// and the snapshots which failed if err is a *snapshot.PartialError.
+func printPartialError(w io.Writer, done string, err error) {
+	var partialErr *snapshot.PartialError
+
+	if !errors.As(err, &partialErr) {
+		return
+	}
+	for _, name := range partialErr.Succeeded {
+		fmt.Fprintf(w, "%s\t%s\n", done, name)
+	}
+	for _, f := range partialErr.Failures {
+		fmt.Fprintf(w, "failed\t%s\n", f.Name)
+	}
+}
+
+func printCleanPlans(w io.Writer, outType string, explain bool, plans []snapshot.CleanPlan) error {
+	for _, p := range plans {
+		switch outType {
+		case "text":
+			for _, r := range p.Keep {
+				if !explain {
+					fmt.Fprintf(w, "keep\t%s\n", r.Name)
+					continue
+				}
+				ivs := make([]string, len(r.Intervals))
+				for i, iv := range r.Intervals {
+					ivs[i] = strings.ToLower(iv.String())
+				}
+				for _, period := range r.Periods {
+					ivs = append(ivs, period.String())
+				}
+				if r.Within {
+					ivs = append(ivs, "within")
+				}
+				if r.Expires != nil {
+					ivs = append(ivs, "expires="+r.Expires.Format(time.RFC3339))
+				}
+				fmt.Fprintf(w, "keep\t%s\t%s\n", r.Name, strings.Join(ivs, ","))
+			}
+			for _, n := range p.Reject {
+				fmt.Fprintf(w, "destroy\t%s\n", n)
+			}
+			printHeld(w, p.Held)
+		case "jsonl":
+			if err := p.ToJSONW(w); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("unsupported output format: %s", outType)
+		}
+	}
+	return nil
+}
@@ -1,11 +1,19 @@
 package cmd_test
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/fhofherr/zsm/internal/cmd"
 	"github.com/fhofherr/zsm/internal/config"
 	"github.com/fhofherr/zsm/internal/snapshot"
+	"github.com/fhofherr/zsm/internal/zfs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
 )
 
 func TestClean(t *testing.T) {
@@ -26,11 +34,102 @@ func TestClean(t *testing.T) {
 				}
 
 				sm := &snapshot.MockManager{}
-				sm.On("CleanSnapshots", cfg).Return(nil)
+				sm.On("CleanSnapshots", snapshot.Policies{Default: cfg}, mock.AnythingOfType("snapshot.CleanOption")).
+					Return([]snapshot.Name(nil), nil)
+				sm.ExpectCleanOptions(snapshot.CleanBestEffort())
 
 				return sm
 			},
 		},
+		{
+			Name: "held snapshots",
+			MakeArgs: func(_ *testing.T) []string {
+				return []string{"clean", "-m", "1", "-H", "0", "-d", "0", "-w", "0", "-M", "0", "-y", "0"}
+			},
+			MakeMSM: func(t *testing.T) *snapshot.MockManager {
+				cfg := snapshot.BucketConfig{snapshot.Minute: 1}
+				held := []snapshot.Name{
+					snapshot.MustParseName(t, "zsm_test@2020-04-10T09:43:58.564585005Z"),
+					snapshot.MustParseName(t, "zsm_test/fs_1@2020-04-10T09:43:58.564585005Z"),
+				}
+
+				sm := &snapshot.MockManager{}
+				sm.On("CleanSnapshots", snapshot.Policies{Default: cfg}, mock.AnythingOfType("snapshot.CleanOption")).
+					Return(held, nil)
+				sm.ExpectCleanOptions(snapshot.CleanBestEffort())
+
+				return sm
+			},
+			AssertOutput: func(t *testing.T, stdout, stderr string) {
+				expected := "held\tzsm_test@2020-04-10T09:43:58.564585005Z\n" +
+					"held\tzsm_test/fs_1@2020-04-10T09:43:58.564585005Z\n"
+				assert.Equal(t, expected, stdout)
+				assert.Empty(t, stderr)
+			},
+		},
+		{
+			Name: "disable best effort",
+			MakeArgs: func(_ *testing.T) []string {
+				return []string{"clean", "--best-effort=false"}
+			},
+			MakeMSM: func(t *testing.T) *snapshot.MockManager {
+				cfg := snapshot.BucketConfig{
+					snapshot.Minute: config.DefaultSnapshotsKeepMinute,
+					snapshot.Hour:   config.DefaultSnapshotsKeepHour,
+					snapshot.Day:    config.DefaultSnapshotsKeepDay,
+					snapshot.Week:   config.DefaultSnapshotsKeepWeek,
+					snapshot.Month:  config.DefaultSnapshotsKeepMonth,
+					snapshot.Year:   config.DefaultSnapshotsKeepYear,
+				}
+
+				sm := &snapshot.MockManager{}
+				sm.On("CleanSnapshots", snapshot.Policies{Default: cfg}).Return([]snapshot.Name(nil), nil)
+
+				return sm
+			},
+		},
+		{
+			Name: "report failures",
+			MakeArgs: func(_ *testing.T) []string {
+				return []string{"clean", "-m", "1", "-H", "0", "-d", "0", "-w", "0", "-M", "0", "-y", "0"}
+			},
+			MakeMSM: func(t *testing.T) *snapshot.MockManager {
+				cfg := snapshot.BucketConfig{snapshot.Minute: 1}
+				held := []snapshot.Name{
+					snapshot.MustParseName(t, "zsm_test@2020-04-10T09:42:58.564585005Z"),
+				}
+				err := &snapshot.PartialError{
+					Succeeded: []string{"zsm_test/fs_1@2020-04-10T09:43:58.564585005Z"},
+					Failures: []snapshot.Failure{
+						{
+							Name: "zsm_test@2020-04-10T09:43:58.564585005Z",
+							Err: &zfs.Error{
+								SubCommand: "destroy",
+								ExitCode:   1,
+								Stderr: "cannot destroy snapshot zsm_test@2020-04-10T09:43:58.564585005Z: " +
+									"dataset is busy\n",
+							},
+						},
+					},
+				}
+
+				sm := &snapshot.MockManager{}
+				sm.On("CleanSnapshots", snapshot.Policies{Default: cfg}, mock.AnythingOfType("snapshot.CleanOption")).
+					Return(held, fmt.Errorf("clean snapshots: %w", err))
+				sm.ExpectCleanOptions(snapshot.CleanBestEffort())
+
+				return sm
+			},
+			AssertOutput: func(t *testing.T, stdout, stderr string) {
+				expected := "held\tzsm_test@2020-04-10T09:42:58.564585005Z\n" +
+					"destroyed\tzsm_test/fs_1@2020-04-10T09:43:58.564585005Z\n" +
+					"failed\tzsm_test@2020-04-10T09:43:58.564585005Z\n"
+				assert.Equal(t, expected, stdout)
+			},
+			ExpectedErr: errors.New("clean snapshots: 1 of 2 snapshots failed\n" +
+				"zsm_test@2020-04-10T09:43:58.564585005Z: zfs destroy: exit code: 1: " +
+				"cannot destroy snapshot zsm_test@2020-04-10T09:43:58.564585005Z: dataset is busy"),
+		},
 		{
 			Name: "command line user-defined buckets",
 			MakeArgs: func(t *testing.T) []string {
@@ -47,7 +146,9 @@ func TestClean(t *testing.T) {
 				}
 
 				sm := &snapshot.MockManager{}
-				sm.On("CleanSnapshots", cfg).Return(nil)
+				sm.On("CleanSnapshots", snapshot.Policies{Default: cfg}, mock.AnythingOfType("snapshot.CleanOption")).
+					Return([]snapshot.Name(nil), nil)
+				sm.ExpectCleanOptions(snapshot.CleanBestEffort())
 
 				return sm
 			},
@@ -69,12 +170,484 @@ func TestClean(t *testing.T) {
 				}
 
 				sm := &snapshot.MockManager{}
-				sm.On("CleanSnapshots", cfg).Return(nil)
+				sm.On("CleanSnapshots", snapshot.Policies{Default: cfg}, mock.AnythingOfType("snapshot.CleanOption")).
+					Return([]snapshot.Name(nil), nil)
+				sm.ExpectCleanOptions(snapshot.CleanBestEffort())
+
+				return sm
+			},
+		},
+		{
+			Name: "policies",
+			MakeArgs: func(t *testing.T) []string {
+				cfgFile := cmd.ConfigFile(t, "config.yaml")
+				return []string{"--config-file", cfgFile, "clean"}
+			},
+			MakeMSM: func(t *testing.T) *snapshot.MockManager {
+				cfg := snapshot.BucketConfig{
+					snapshot.Minute: 1,
+					snapshot.Hour:   2,
+					snapshot.Day:    3,
+					snapshot.Week:   4,
+					snapshot.Month:  5,
+					snapshot.Year:   6,
+				}
+				scratchCfg := cfg
+				scratchCfg[snapshot.Hour] = 0
+				scratchCfg[snapshot.Day] = 1
+				dbCfg := cfg
+				dbCfg[snapshot.Minute] = 60
+
+				sm := &snapshot.MockManager{}
+				sm.On("CleanSnapshots", snapshot.Policies{
+					Default: cfg,
+					FileSystems: map[string]snapshot.BucketConfig{
+						"zsm_test/scratch": scratchCfg,
+						"zsm_test/db":      dbCfg,
+					},
+				}, mock.AnythingOfType("snapshot.CleanOption")).Return([]snapshot.Name(nil), nil)
+				sm.ExpectCleanOptions(snapshot.CleanBestEffort())
+
+				return sm
+			},
+		},
+		{
+			Name: "hooks",
+			MakeArgs: func(t *testing.T) []string {
+				cfgFile := cmd.ConfigFile(t, "config.yaml")
+				return []string{
+					"--config-file", cfgFile, "clean",
+					"-m", "1", "-H", "2", "-d", "3", "-w", "4", "-M", "5", "-y", "6",
+				}
+			},
+			MakeMSM: func(t *testing.T) *snapshot.MockManager {
+				cfg := snapshot.BucketConfig{
+					snapshot.Minute: 1,
+					snapshot.Hour:   2,
+					snapshot.Day:    3,
+					snapshot.Week:   4,
+					snapshot.Month:  5,
+					snapshot.Year:   6,
+				}
+
+				sm := &snapshot.MockManager{}
+				sm.On("CleanSnapshots", snapshot.Policies{Default: cfg},
+					mock.AnythingOfType("snapshot.CleanOption"),
+					mock.AnythingOfType("snapshot.CleanOption"),
+				).Return([]snapshot.Name(nil), nil)
+				sm.ExpectCleanOptions(snapshot.CleanHooks(snapshot.Hooks{
+					Pre:  []snapshot.Hook{{Command: "systemctl stop backup.service"}},
+					Post: []snapshot.Hook{{Command: "systemctl start backup.service"}},
+				}), snapshot.CleanBestEffort())
+
+				return sm
+			},
+		},
+		{
+			Name: "time windows",
+			MakeArgs: func(t *testing.T) []string {
+				return []string{
+					"clean", "--keep-within", "48h", "--max-age", "18mo",
+					"-m", "1", "-H", "2", "-d", "3", "-w", "4", "-M", "5", "-y", "6",
+				}
+			},
+			MakeMSM: func(t *testing.T) *snapshot.MockManager {
+				cfg := snapshot.BucketConfig{
+					snapshot.Minute: 1,
+					snapshot.Hour:   2,
+					snapshot.Day:    3,
+					snapshot.Week:   4,
+					snapshot.Month:  5,
+					snapshot.Year:   6,
+				}
+
+				sm := &snapshot.MockManager{}
+				sm.On("CleanSnapshots", snapshot.Policies{Default: cfg},
+					mock.AnythingOfType("snapshot.CleanOption"),
+					mock.AnythingOfType("snapshot.CleanOption"),
+					mock.AnythingOfType("snapshot.CleanOption"),
+				).Return([]snapshot.Name(nil), nil)
+				sm.ExpectCleanOptions(
+					snapshot.KeepWithin(snapshot.Period{Duration: 48 * time.Hour}),
+					snapshot.MaxAge(snapshot.Period{Months: 18}),
+					snapshot.CleanBestEffort(),
+				)
+
+				return sm
+			},
+		},
+		{
+			Name: "time windows config file",
+			MakeArgs: func(t *testing.T) []string {
+				cfgFile := cmd.ConfigFile(t, "config.yaml")
+				return []string{"--config-file", cfgFile, "clean"}
+			},
+			MakeMSM: func(t *testing.T) *snapshot.MockManager {
+				cfg := snapshot.BucketConfig{
+					snapshot.Minute: 1,
+					snapshot.Hour:   2,
+					snapshot.Day:    3,
+					snapshot.Week:   4,
+					snapshot.Month:  5,
+					snapshot.Year:   6,
+				}
+
+				sm := &snapshot.MockManager{}
+				sm.On("CleanSnapshots", snapshot.Policies{Default: cfg},
+					mock.AnythingOfType("snapshot.CleanOption"),
+					mock.AnythingOfType("snapshot.CleanOption"),
+					mock.AnythingOfType("snapshot.CleanOption"),
+				).Return([]snapshot.Name(nil), nil)
+				sm.ExpectCleanOptions(
+					snapshot.KeepWithin(snapshot.Period{Duration: 48 * time.Hour}),
+					snapshot.MaxAge(snapshot.Period{Months: 36}),
+					snapshot.CleanBestEffort(),
+				)
 
 				return sm
 			},
 		},
+		{
+			Name: "invalid max age",
+			MakeArgs: func(t *testing.T) []string {
+				return []string{"clean", "--max-age", "3 years"}
+			},
+			MakeMSM: func(t *testing.T) *snapshot.MockManager {
+				return &snapshot.MockManager{}
+			},
+			ExpectedErr: errors.New("snapshots.keep.max_age: invalid duration: 3 years"),
+		},
+		{
+			Name: "max age less than keep within",
+			MakeArgs: func(t *testing.T) []string {
+				return []string{"clean", "--keep-within", "2w", "--max-age", "7d"}
+			},
+			MakeMSM: func(t *testing.T) *snapshot.MockManager {
+				return &snapshot.MockManager{}
+			},
+			ExpectedErr: errors.New("snapshots.keep.max_age: less than snapshots.keep.within"),
+		},
+		{
+			Name: "calendar",
+			MakeArgs: func(t *testing.T) []string {
+				return []string{
+					"clean", "--calendar", "last", "--time-zone", "UTC",
+					"-m", "1", "-H", "2", "-d", "3", "-w", "4", "-M", "5", "-y", "6",
+				}
+			},
+			MakeMSM: func(t *testing.T) *snapshot.MockManager {
+				cfg := snapshot.BucketConfig{
+					snapshot.Minute: 1,
+					snapshot.Hour:   2,
+					snapshot.Day:    3,
+					snapshot.Week:   4,
+					snapshot.Month:  5,
+					snapshot.Year:   6,
+				}
+
+				sm := &snapshot.MockManager{}
+				sm.On("CleanSnapshots", snapshot.Policies{
+					Default:         cfg,
+					DefaultCalendar: &snapshot.Calendar{Location: time.UTC, Last: true},
+				}, mock.AnythingOfType("snapshot.CleanOption")).Return([]snapshot.Name(nil), nil)
+				sm.ExpectCleanOptions(snapshot.CleanBestEffort())
+
+				return sm
+			},
+		},
+		{
+			Name: "calendar policies",
+			MakeArgs: func(t *testing.T) []string {
+				cfgFile := cmd.ConfigFile(t, "config.yaml")
+				return []string{"--config-file", cfgFile, "clean"}
+			},
+			MakeMSM: func(t *testing.T) *snapshot.MockManager {
+				cfg := snapshot.BucketConfig{
+					snapshot.Minute: 1,
+					snapshot.Hour:   2,
+					snapshot.Day:    3,
+					snapshot.Week:   4,
+					snapshot.Month:  5,
+					snapshot.Year:   6,
+				}
+				berlin, err := time.LoadLocation("Europe/Berlin")
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				sm := &snapshot.MockManager{}
+				sm.On("CleanSnapshots", snapshot.Policies{
+					Default: cfg,
+					FileSystems: map[string]snapshot.BucketConfig{
+						"zsm_test/scratch": cfg,
+						"zsm_test/db":      cfg,
+					},
+					DefaultCalendar: &snapshot.Calendar{Location: berlin},
+					Calendars: map[string]*snapshot.Calendar{
+						"zsm_test/scratch": nil,
+						"zsm_test/db":      {Location: time.UTC, Last: true},
+					},
+				}, mock.AnythingOfType("snapshot.CleanOption")).Return([]snapshot.Name(nil), nil)
+				sm.ExpectCleanOptions(snapshot.CleanBestEffort())
+
+				return sm
+			},
+		},
+		{
+			Name: "custom buckets",
+			MakeArgs: func(t *testing.T) []string {
+				return []string{
+					"clean", "--buckets", "15m:8,3mo:4",
+					"-m", "1", "-H", "2", "-d", "3", "-w", "4", "-M", "5", "-y", "6",
+				}
+			},
+			MakeMSM: func(t *testing.T) *snapshot.MockManager {
+				cfg := snapshot.BucketConfig{
+					snapshot.Minute: 1,
+					snapshot.Hour:   2,
+					snapshot.Day:    3,
+					snapshot.Week:   4,
+					snapshot.Month:  5,
+					snapshot.Year:   6,
+				}
+
+				sm := &snapshot.MockManager{}
+				sm.On("CleanSnapshots", snapshot.Policies{
+					Default: cfg,
+					DefaultCustomBuckets: []snapshot.CustomBucket{
+						{Period: snapshot.Period{Duration: 15 * time.Minute}, Count: 8},
+						{Period: snapshot.Period{Months: 3}, Count: 4},
+					},
+				}, mock.AnythingOfType("snapshot.CleanOption")).Return([]snapshot.Name(nil), nil)
+				sm.ExpectCleanOptions(snapshot.CleanBestEffort())
+
+				return sm
+			},
+		},
+		{
+			Name: "custom buckets policies",
+			MakeArgs: func(t *testing.T) []string {
+				cfgFile := cmd.ConfigFile(t, "config.yaml")
+				return []string{"--config-file", cfgFile, "clean"}
+			},
+			MakeMSM: func(t *testing.T) *snapshot.MockManager {
+				cfg := snapshot.BucketConfig{snapshot.Day: 7}
+				def := []snapshot.CustomBucket{
+					{Period: snapshot.Period{Duration: 6 * time.Hour}, Count: 4},
+					{Period: snapshot.Period{Months: 3}, Count: 4},
+				}
+
+				sm := &snapshot.MockManager{}
+				sm.On("CleanSnapshots", snapshot.Policies{
+					Default: cfg,
+					FileSystems: map[string]snapshot.BucketConfig{
+						"zsm_test/scratch": cfg,
+						"zsm_test/db":      cfg,
+						"zsm_test/home":    cfg,
+					},
+					DefaultCustomBuckets: def,
+					CustomBuckets: map[string][]snapshot.CustomBucket{
+						"zsm_test/scratch": nil,
+						"zsm_test/db": {
+							{Period: snapshot.Period{Duration: 15 * time.Minute}, Count: 8},
+							{Period: snapshot.Period{Months: 12}, Count: 2},
+						},
+						"zsm_test/home": def,
+					},
+				}, mock.AnythingOfType("snapshot.CleanOption")).Return([]snapshot.Name(nil), nil)
+				sm.ExpectCleanOptions(snapshot.CleanBestEffort())
+
+				return sm
+			},
+		},
+		{
+			Name: "invalid custom bucket",
+			MakeArgs: func(t *testing.T) []string {
+				return []string{"clean", "--buckets", "15m:8,fortnight:2"}
+			},
+			MakeMSM: func(t *testing.T) *snapshot.MockManager {
+				return &snapshot.MockManager{}
+			},
+			ExpectedErr: errors.New(
+				"snapshots.keep.buckets: invalid bucket: fortnight:2: invalid period: fortnight",
+			),
+		},
+		{
+			Name: "invalid calendar",
+			MakeArgs: func(t *testing.T) []string {
+				return []string{"clean", "--calendar", "middle"}
+			},
+			MakeMSM: func(t *testing.T) *snapshot.MockManager {
+				return &snapshot.MockManager{}
+			},
+			ExpectedErr: errors.New("snapshots.keep.calendar: invalid value: middle"),
+		},
+		{
+			Name: "invalid time zone in policy",
+			MakeArgs: func(t *testing.T) []string {
+				cfgFile := cmd.ConfigFile(t, "config.yaml")
+				return []string{"--config-file", cfgFile, "clean"}
+			},
+			MakeMSM: func(t *testing.T) *snapshot.MockManager {
+				return &snapshot.MockManager{}
+			},
+			ExpectedErr: errors.New(
+				"snapshots.policies: zsm_test/db: time_zone: unknown time zone Mars/Olympus_Mons",
+			),
+		},
+		{
+			Name: "unknown interval in policy",
+			MakeArgs: func(t *testing.T) []string {
+				cfgFile := cmd.ConfigFile(t, "config.yaml")
+				return []string{"--config-file", cfgFile, "clean"}
+			},
+			MakeMSM: func(t *testing.T) *snapshot.MockManager {
+				return &snapshot.MockManager{}
+			},
+			ExpectedErr: errors.New(
+				"snapshots.policies: zsm_test/scratch: unmarshal interval: unknown interval: fortnight",
+			),
+		},
+		{
+			Name: "dry run",
+			MakeArgs: func(t *testing.T) []string {
+				return []string{"clean", "--dry-run", "-m", "1", "-H", "0", "-d", "0", "-w", "0", "-M", "0", "-y", "0"}
+			},
+			MakeMSM: func(t *testing.T) *snapshot.MockManager {
+				cfg := snapshot.BucketConfig{snapshot.Minute: 1}
+
+				sm := &snapshot.MockManager{}
+				sm.On("PlanClean", snapshot.Policies{Default: cfg}).Return(cleanPlans(t), nil)
+
+				return sm
+			},
+			AssertOutput: func(t *testing.T, stdout, stderr string) {
+				expected := []string{
+					"keep\tzsm_test@2020-04-10T09:45:58.564585005Z",
+					"destroy\tzsm_test@2020-04-10T09:44:58.564585005Z",
+					"held\tzsm_test@2020-04-10T09:43:58.564585005Z",
+					"keep\tzsm_test/fs_1@2020-04-10T09:45:58.564585005Z",
+					"keep\tzsm_test/fs_1@2020-04-10T09:40:58.564585005Z",
+				}
+				actual := strings.Split(strings.TrimSpace(stdout), "\n")
+				assert.Equal(t, expected, actual)
+				assert.Empty(t, stderr)
+			},
+		},
+		{
+			Name: "dry run with label",
+			MakeArgs: func(t *testing.T) []string {
+				return []string{"clean", "--dry-run", "--label", "hourly", "-m", "1", "-H", "0", "-d", "0", "-w", "0",
+					"-M", "0", "-y", "0"}
+			},
+			MakeMSM: func(t *testing.T) *snapshot.MockManager {
+				cfg := snapshot.BucketConfig{snapshot.Minute: 1}
+
+				sm := &snapshot.MockManager{}
+				sm.On("PlanClean", snapshot.Policies{Default: cfg}, mock.AnythingOfType("snapshot.CleanOption")).
+					Return([]snapshot.CleanPlan{}, nil)
+				sm.ExpectCleanOptions(snapshot.CleanLabel("hourly"))
+
+				return sm
+			},
+		},
+		{
+			Name: "invalid label",
+			MakeArgs: func(t *testing.T) []string {
+				return []string{"clean", "--label", "hourly/db"}
+			},
+			MakeMSM: func(t *testing.T) *snapshot.MockManager {
+				return &snapshot.MockManager{}
+			},
+			ExpectedErr: errors.New(`invalid label: "hourly/db"`),
+		},
+		{
+			Name: "explain",
+			MakeArgs: func(t *testing.T) []string {
+				return []string{"clean", "--explain", "-m", "1", "-H", "0", "-d", "0", "-w", "0", "-M", "0", "-y", "0"}
+			},
+			MakeMSM: func(t *testing.T) *snapshot.MockManager {
+				cfg := snapshot.BucketConfig{snapshot.Minute: 1}
+
+				sm := &snapshot.MockManager{}
+				sm.On("PlanClean", snapshot.Policies{Default: cfg}).Return(cleanPlans(t), nil)
+
+				return sm
+			},
+			AssertOutput: func(t *testing.T, stdout, stderr string) {
+				expected := []string{
+					"keep\tzsm_test@2020-04-10T09:45:58.564585005Z\tminute,hour,3mo",
+					"destroy\tzsm_test@2020-04-10T09:44:58.564585005Z",
+					"held\tzsm_test@2020-04-10T09:43:58.564585005Z",
+					"keep\tzsm_test/fs_1@2020-04-10T09:45:58.564585005Z\tminute,within",
+					"keep\tzsm_test/fs_1@2020-04-10T09:40:58.564585005Z\texpires=2020-04-24T00:00:00Z",
+				}
+				actual := strings.Split(strings.TrimSpace(stdout), "\n")
+				assert.Equal(t, expected, actual)
+				assert.Empty(t, stderr)
+			},
+		},
+		{
+			Name: "dry run json output",
+			MakeArgs: func(t *testing.T) []string {
+				return []string{"clean", "--dry-run", "-o", "jsonl", "-m", "1", "-H", "0", "-d", "0", "-w", "0",
+					"-M", "0", "-y", "0"}
+			},
+			MakeMSM: func(t *testing.T) *snapshot.MockManager {
+				cfg := snapshot.BucketConfig{snapshot.Minute: 1}
+
+				sm := &snapshot.MockManager{}
+				sm.On("PlanClean", snapshot.Policies{Default: cfg}).Return(cleanPlans(t), nil)
+
+				return sm
+			},
+			AssertOutput: func(t *testing.T, stdout, stderr string) {
+				var actual []snapshot.CleanPlan
+				for _, line := range strings.Split(strings.TrimSpace(stdout), "\n") {
+					var p snapshot.CleanPlan
+					if err := json.Unmarshal([]byte(line), &p); err != nil {
+						t.Error(err)
+						continue
+					}
+					actual = append(actual, p)
+				}
+				assert.Equal(t, cleanPlans(t), actual)
+				assert.Empty(t, stderr)
+			},
+		},
 	}
 
 	cmd.RunTests(t, tests)
 }
+
+func cleanPlans(t *testing.T) []snapshot.CleanPlan {
+	expires := time.Date(2020, 4, 24, 0, 0, 0, 0, time.UTC)
+	return []snapshot.CleanPlan{
+		{
+			FileSystem: "zsm_test",
+			Keep: []snapshot.Retained{
+				{
+					Name:      snapshot.MustParseName(t, "zsm_test@2020-04-10T09:45:58.564585005Z"),
+					Intervals: []snapshot.Interval{snapshot.Minute, snapshot.Hour},
+					Periods:   []snapshot.Period{{Months: 3}},
+				},
+			},
+			Reject: []snapshot.Name{snapshot.MustParseName(t, "zsm_test@2020-04-10T09:44:58.564585005Z")},
+			Held:   []snapshot.Name{snapshot.MustParseName(t, "zsm_test@2020-04-10T09:43:58.564585005Z")},
+		},
+		{
+			FileSystem: "zsm_test/fs_1",
+			Keep: []snapshot.Retained{
+				{
+					Name:      snapshot.MustParseName(t, "zsm_test/fs_1@2020-04-10T09:45:58.564585005Z"),
+					Intervals: []snapshot.Interval{snapshot.Minute},
+					Within:    true,
+				},
+				{
+					Name:    snapshot.MustParseName(t, "zsm_test/fs_1@2020-04-10T09:40:58.564585005Z"),
+					Expires: &expires,
+				},
+			},
+		},
+	}
+}
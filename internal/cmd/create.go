@@ -1,24 +1,116 @@
 package cmd
 
 import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
 	"github.com/fhofherr/zsm/internal/config"
 	"github.com/fhofherr/zsm/internal/snapshot"
 	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
 )
 
 func newCreateCommand(cmdCfg *zsmCommandConfig) *cobra.Command {
+	var (
+		recursive bool
+		label     string
+		expireIn  string
+		expireAt  string
+	)
+
 	createCmd := &cobra.Command{
-		Use:   "create <FILE SYSTEM>",
+		Use:   "create [FILE SYSTEM...]",
 		Short: "Create snapshots for all ZFS file systems",
 		Long: `Creates snapshots for all ZFS file systems, except for those explicitly excluded.
 
-If <FILE SYSTEM> is passed, then a snapshot of only the passed file system is
-created unless <FILE SYSTEM> is marked as excluded.
+If one or more file systems are passed, then snapshots of only the passed file
+systems are created unless they are marked as excluded. The --recursive option
+additionally creates snapshots of all descendants of the passed file systems.
+
+Excluding a file system excludes all its descendants as well. Prefix the file
+system with only: to exclude just the file system itself, e.g.
+--exclude only:tank/vm.
+
+Both the passed and the excluded file systems may be shell-style globs, e.g.
+tank/docker/*, or regular expressions prefixed with re:, e.g.
+re:tank/home/[a-z]+. Just like in a shell, * does not match the / separating a
+file system from its children. A regular expression has to match the whole
+name of a file system.
+
+ZFS volumes are only considered if the snapshots.dataset_types setting or the
+--dataset-types option include volume, e.g. --dataset-types filesystem,volume.
 
 The created snapshots start with the same name as the dataset and are suffixed with @TIMESTAMP
 where TIMESTAMP is an RFC3339 timestamp. The time zone of the TIMESTAMP is always UTC regardles
-of the system time.`,
-		Args: cobra.RangeArgs(0, 1),
+of the system time.
+
+The --label option labels the created snapshots. Labelled snapshots are suffixed
+with @zsm_LABEL_TIMESTAMP instead. Use zsm clean --label to clean them. A label
+must start with a letter or digit and may only contain letters, digits, _, .,
+and -.
+
+The --expire-in and --expire-at options create manual snapshots which expire,
+e.g. before an upgrade. --expire-in accepts a duration like 36h, 14d, 2w, or
+3mo, where mo and y denote calendar months and years.
+--expire-at accepts an RFC3339 timestamp or a date like 2020-12-24, which is
+interpreted as midnight in the local time zone. The expiry is stored in the
+com.github.fhofherr.zsm:expires ZFS user property of the snapshots. zsm clean
+keeps such snapshots until they expire, regardless of the number of snapshots
+to keep, and destroys them afterwards.
+
+File systems that must always be snapshotted together can be combined into
+named consistency groups using the snapshots.create.consistency_groups setting:
+
+    snapshots:
+      create:
+        consistency_groups:
+          app:
+            - tank/app/db
+            - tank/app/files
+
+If a snapshot of any member of a consistency group is created, snapshots of
+all its members are created atomically. None of the members may be excluded.
+
+The --skip-unchanged option, or the snapshots.create.skip_unchanged setting,
+skips datasets which did not change since their newest snapshot created by
+zsm with the same --label. Snapshots with other labels, expiring snapshots,
+and snapshots not created by zsm are ignored. The
+com.github.fhofherr.zsm:skip-unchanged ZFS user property enables or disables
+skipping for a single dataset and its descendants. Skipped datasets are
+printed to stdout.
+
+By default create attempts to create the snapshots of all datasets, even if
+creating some of them fails, e.g. because a dataset is busy. If any of them
+fails, create prints the snapshots it created prefixed with created, the ones
+it failed to create prefixed with failed, and exits with an error listing the
+output of zfs for every failure. The --best-effort=false option, or setting
+snapshots.create.best_effort to false, makes create stop at the first error
+instead.
+
+Commands configured using the snapshots.create.hooks setting are executed
+before and after the snapshots are created, e.g. to freeze a database:
+
+    snapshots:
+      create:
+        hooks:
+          pre:
+            - command: psql -c "CHECKPOINT"
+              timeout: 30s
+              on_error: continue
+              file_systems:
+                - tank/db
+          post:
+            - command: logger "zsm created $ZSM_TIMESTAMP"
+
+Each hook applies to all datasets, unless it is restricted using file_systems
+or consistency_groups. The datasets are passed in the ZSM_DATASETS
+environment variable, the timestamp of the snapshots in ZSM_TIMESTAMP. A
+failing pre hook aborts creating the snapshots unless on_error is set to
+continue. Post hooks are always executed. They receive the result in
+ZSM_STATUS.`,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			var createOpts []snapshot.CreateOption
 
@@ -26,20 +118,146 @@ of the system time.`,
 			if err != nil {
 				return err
 			}
-			if len(args) == 1 {
-				createOpts = append(createOpts, snapshot.FromFileSystem(args[0]))
+			for _, fs := range args {
+				createOpts = append(createOpts, snapshot.FromFileSystem(fs))
+			}
+			if recursive {
+				createOpts = append(createOpts, snapshot.Recursive())
+			}
+			flagOpts, err := createFlagOptions(label, expireIn, expireAt)
+			if err != nil {
+				return err
 			}
+			createOpts = append(createOpts, flagOpts...)
 			excludes := cmdCfg.V.GetStringSlice(config.SnapshotsCreateExcludeFileSystems)
 			for _, e := range excludes {
-				createOpts = append(createOpts, snapshot.ExcludeFileSystem(e))
+				createOpts = append(createOpts, excludeFileSystem(e))
+			}
+			groupOpts, err := consistencyGroups(cmdCfg.V)
+			if err != nil {
+				return err
+			}
+			createOpts = append(createOpts, groupOpts...)
+			hooks, ok, err := readHooks(cmdCfg.V, config.SnapshotsCreateHooks)
+			if err != nil {
+				return err
+			}
+			if ok {
+				createOpts = append(createOpts, snapshot.CreateHooks(hooks))
+			}
+			if cmdCfg.V.GetBool(config.SnapshotsCreateSkipUnchanged) {
+				createOpts = append(createOpts, snapshot.SkipUnchanged())
+			}
+			if cmdCfg.V.GetBool(config.SnapshotsCreateBestEffort) {
+				createOpts = append(createOpts, snapshot.CreateBestEffort())
+			}
+			skipped, err := sm.CreateSnapshots(createOpts...)
+
+			stdout := cmdCfg.Stdout()
+			for _, fs := range skipped {
+				fmt.Fprintf(stdout, "skip\t%s\n", fs)
 			}
-			return sm.CreateSnapshots(createOpts...)
+			printPartialError(stdout, "created", err)
+			return err
 		},
 	}
 
+	createCmd.Flags().BoolVarP(&recursive, "recursive", "r", false,
+		"Create snapshots of all descendants of the passed file systems.")
+	createCmd.Flags().StringVar(&label, "label", "",
+		"Label the created snapshots, e.g. hourly.")
+	createCmd.Flags().StringVar(&expireIn, "expire-in", "",
+		"Create snapshots which expire after the passed duration, e.g. 14d.")
+	createCmd.Flags().StringVar(&expireAt, "expire-at", "",
+		"Create snapshots which expire at the passed time, e.g. 2020-12-24.")
 	createCmd.Flags().StringSliceP("exclude", "e", nil,
 		"File systems to exclude when creating a snapshot.")
 	cmdCfg.V.BindPFlag(config.SnapshotsCreateExcludeFileSystems, createCmd.Flags().Lookup("exclude"))
+	createCmd.Flags().Bool("skip-unchanged", false,
+		"Skip datasets which did not change since their newest snapshot.")
+	cmdCfg.V.BindPFlag(config.SnapshotsCreateSkipUnchanged, createCmd.Flags().Lookup("skip-unchanged"))
+	createCmd.Flags().Bool("best-effort", config.DefaultSnapshotsCreateBestEffort,
+		"Attempt to create the snapshots of all datasets, even if some of them fail.")
+	cmdCfg.V.BindPFlag(config.SnapshotsCreateBestEffort, createCmd.Flags().Lookup("best-effort"))
 
 	return createCmd
 }
+
+// createFlagOptions returns the CreateOptions for the --label, --expire-in,
+// and --expire-at options.
+func createFlagOptions(label, expireIn, expireAt string) ([]snapshot.CreateOption, error) {
+	var opts []snapshot.CreateOption
+
+	if label != "" {
+		if err := snapshot.ValidateLabel(label); err != nil {
+			return nil, err
+		}
+		opts = append(opts, snapshot.CreateLabel(label))
+	}
+	if expireIn != "" || expireAt != "" {
+		expires, err := parseExpiry(expireIn, expireAt, time.Now())
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, snapshot.Expires(expires))
+	}
+	return opts, nil
+}
+
+// parseExpiry returns the expiry of the snapshots created by zsm create. At
+// most one of expireIn and expireAt may be set. The expiry must be after now.
+func parseExpiry(expireIn, expireAt string, now time.Time) (time.Time, error) {
+	var expires time.Time
+
+	switch {
+	case expireIn != "" && expireAt != "":
+		return expires, errors.New("--expire-in and --expire-at are mutually exclusive")
+	case expireIn != "":
+		p, err := snapshot.ParsePeriod(expireIn)
+		if err != nil {
+			return expires, fmt.Errorf("--expire-in: invalid duration: %s", expireIn)
+		}
+		expires = p.After(now)
+	default:
+		var err error
+		if expires, err = time.Parse(time.RFC3339, expireAt); err != nil {
+			expires, err = time.ParseInLocation("2006-01-02", expireAt, time.Local)
+		}
+		if err != nil {
+			return expires, fmt.Errorf("--expire-at: invalid time: %s", expireAt)
+		}
+		if !expires.After(now) {
+			return expires, fmt.Errorf("--expire-at: not in the future: %s", expireAt)
+		}
+	}
+	return expires.UTC(), nil
+}
+
+// excludeOnlyPrefix marks an excluded file system whose descendants are not
+// excluded.
+const excludeOnlyPrefix = "only:"
+
+func excludeFileSystem(e string) snapshot.CreateOption {
+	if strings.HasPrefix(e, excludeOnlyPrefix) {
+		return snapshot.ExcludeFileSystemOnly(strings.TrimPrefix(e, excludeOnlyPrefix))
+	}
+	return snapshot.ExcludeFileSystem(e)
+}
+
+func consistencyGroups(v *viper.Viper) ([]snapshot.CreateOption, error) {
+	groups := v.GetStringMapStringSlice(config.SnapshotsCreateConsistencyGroups)
+	names := make([]string, 0, len(groups))
+	for name := range groups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	opts := make([]snapshot.CreateOption, 0, len(names))
+	for _, name := range names {
+		if len(groups[name]) == 0 {
+			return nil, fmt.Errorf("%s: %s: no file systems", config.SnapshotsCreateConsistencyGroups, name)
+		}
+		opts = append(opts, snapshot.ConsistencyGroup(name, groups[name]...))
+	}
+	return opts, nil
+}
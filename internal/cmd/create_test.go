@@ -1,10 +1,15 @@
 package cmd_test
 
 import (
+	"errors"
+	"fmt"
 	"testing"
+	"time"
 
 	"github.com/fhofherr/zsm/internal/cmd"
 	"github.com/fhofherr/zsm/internal/snapshot"
+	"github.com/fhofherr/zsm/internal/zfs"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 )
 
@@ -17,7 +22,8 @@ func TestCreate(t *testing.T) {
 			},
 			MakeMSM: func(t *testing.T) *snapshot.MockManager {
 				sm := &snapshot.MockManager{}
-				sm.On("CreateSnapshots").Return(nil)
+				sm.On("CreateSnapshots", mock.AnythingOfType("snapshot.CreateOption")).Return([]string(nil), nil)
+				sm.ExpectCreateOptions(snapshot.CreateBestEffort())
 				return sm
 			},
 		},
@@ -28,8 +34,121 @@ func TestCreate(t *testing.T) {
 			},
 			MakeMSM: func(t *testing.T) *snapshot.MockManager {
 				sm := &snapshot.MockManager{}
-				sm.On("CreateSnapshots", mock.AnythingOfType("snapshot.CreateOption")).Return(nil)
-				sm.ExpectCreateOptions(snapshot.FromFileSystem("zsm_test/fs_1"))
+				sm.On("CreateSnapshots",
+					mock.AnythingOfType("snapshot.CreateOption"),
+					mock.AnythingOfType("snapshot.CreateOption"),
+				).Return([]string(nil), nil)
+				sm.ExpectCreateOptions(snapshot.FromFileSystem("zsm_test/fs_1"), snapshot.CreateBestEffort())
+				return sm
+			},
+		},
+		{
+			Name: "specify several file systems recursively",
+			MakeArgs: func(t *testing.T) []string {
+				return []string{"create", "-r", "zsm_test/fs_1", "zsm_test/fs_2"}
+			},
+			MakeMSM: func(t *testing.T) *snapshot.MockManager {
+				sm := &snapshot.MockManager{}
+				sm.On("CreateSnapshots",
+					mock.AnythingOfType("snapshot.CreateOption"),
+					mock.AnythingOfType("snapshot.CreateOption"),
+					mock.AnythingOfType("snapshot.CreateOption"),
+					mock.AnythingOfType("snapshot.CreateOption"),
+				).Return([]string(nil), nil)
+				sm.ExpectCreateOptions(
+					snapshot.FromFileSystem("zsm_test/fs_1"),
+					snapshot.FromFileSystem("zsm_test/fs_2"),
+					snapshot.Recursive(),
+					snapshot.CreateBestEffort(),
+				)
+				return sm
+			},
+		},
+		{
+			Name: "label snapshots",
+			MakeArgs: func(t *testing.T) []string {
+				return []string{"create", "--label", "hourly"}
+			},
+			MakeMSM: func(t *testing.T) *snapshot.MockManager {
+				sm := &snapshot.MockManager{}
+				sm.On("CreateSnapshots",
+					mock.AnythingOfType("snapshot.CreateOption"),
+					mock.AnythingOfType("snapshot.CreateOption"),
+				).Return([]string(nil), nil)
+				sm.ExpectCreateOptions(snapshot.CreateLabel("hourly"), snapshot.CreateBestEffort())
+				return sm
+			},
+		},
+		{
+			Name: "invalid label",
+			MakeArgs: func(t *testing.T) []string {
+				return []string{"create", "--label", "pre deploy"}
+			},
+			MakeMSM: func(t *testing.T) *snapshot.MockManager {
+				return &snapshot.MockManager{}
+			},
+			ExpectedErr: errors.New(`invalid label: "pre deploy"`),
+		},
+		{
+			Name: "expire at",
+			MakeArgs: func(t *testing.T) []string {
+				return []string{"create", "--expire-at", "2099-12-24T18:00:00+01:00"}
+			},
+			MakeMSM: func(t *testing.T) *snapshot.MockManager {
+				sm := &snapshot.MockManager{}
+				sm.On("CreateSnapshots",
+					mock.AnythingOfType("snapshot.CreateOption"),
+					mock.AnythingOfType("snapshot.CreateOption"),
+				).Return([]string(nil), nil)
+				sm.ExpectCreateOptions(
+					snapshot.Expires(time.Date(2099, 12, 24, 17, 0, 0, 0, time.UTC)),
+					snapshot.CreateBestEffort(),
+				)
+				return sm
+			},
+		},
+		{
+			Name: "expire at date in the past",
+			MakeArgs: func(t *testing.T) []string {
+				return []string{"create", "--expire-at", "2020-04-10"}
+			},
+			MakeMSM: func(t *testing.T) *snapshot.MockManager {
+				return &snapshot.MockManager{}
+			},
+			ExpectedErr: errors.New("--expire-at: not in the future: 2020-04-10"),
+		},
+		{
+			Name: "invalid expire in",
+			MakeArgs: func(t *testing.T) []string {
+				return []string{"create", "--expire-in", "14days"}
+			},
+			MakeMSM: func(t *testing.T) *snapshot.MockManager {
+				return &snapshot.MockManager{}
+			},
+			ExpectedErr: errors.New("--expire-in: invalid duration: 14days"),
+		},
+		{
+			Name: "expire in and expire at",
+			MakeArgs: func(t *testing.T) []string {
+				return []string{"create", "--expire-in", "14d", "--expire-at", "2099-12-24"}
+			},
+			MakeMSM: func(t *testing.T) *snapshot.MockManager {
+				return &snapshot.MockManager{}
+			},
+			ExpectedErr: errors.New("--expire-in and --expire-at are mutually exclusive"),
+		},
+		{
+			Name: "exclude single file system only",
+			MakeArgs: func(t *testing.T) []string {
+				return []string{"create", "-e", "only:zsm_test/fs_1"}
+			},
+			MakeMSM: func(t *testing.T) *snapshot.MockManager {
+				sm := &snapshot.MockManager{}
+				sm.On("CreateSnapshots",
+					mock.AnythingOfType("snapshot.CreateOption"),
+					mock.AnythingOfType("snapshot.CreateOption"),
+				).Return([]string(nil), nil)
+				sm.ExpectCreateOptions(snapshot.ExcludeFileSystemOnly("zsm_test/fs_1"), snapshot.CreateBestEffort())
 				return sm
 			},
 		},
@@ -43,10 +162,12 @@ func TestCreate(t *testing.T) {
 				sm.On("CreateSnapshots",
 					mock.AnythingOfType("snapshot.CreateOption"),
 					mock.AnythingOfType("snapshot.CreateOption"),
-				).Return(nil)
+					mock.AnythingOfType("snapshot.CreateOption"),
+				).Return([]string(nil), nil)
 				sm.ExpectCreateOptions(
 					snapshot.ExcludeFileSystem("zsm_test/fs_1"),
 					snapshot.ExcludeFileSystem("zsm_test/fs_2"),
+					snapshot.CreateBestEffort(),
 				)
 				return sm
 			},
@@ -62,14 +183,174 @@ func TestCreate(t *testing.T) {
 				sm.On("CreateSnapshots",
 					mock.AnythingOfType("snapshot.CreateOption"),
 					mock.AnythingOfType("snapshot.CreateOption"),
-				).Return(nil)
+					mock.AnythingOfType("snapshot.CreateOption"),
+				).Return([]string(nil), nil)
 				sm.ExpectCreateOptions(
 					snapshot.ExcludeFileSystem("zsm_test/fs_3"),
 					snapshot.ExcludeFileSystem("zsm_test/fs_4"),
+					snapshot.CreateBestEffort(),
+				)
+				return sm
+			},
+		},
+		{
+			Name: "invalid exclude pattern",
+			MakeArgs: func(t *testing.T) []string {
+				cfgFile := cmd.ConfigFile(t, "config.yaml")
+				return []string{"--config-file", cfgFile, "create"}
+			},
+			MakeMSM: func(t *testing.T) *snapshot.MockManager {
+				return &snapshot.MockManager{}
+			},
+			ExpectedErr: errors.New(
+				"snapshots.create.exclude_file_systems: invalid pattern \"re:zsm_test/(docker\": " +
+					"error parsing regexp: missing closing ): `^(?:zsm_test/(docker)$`",
+			),
+		},
+		{
+			Name: "consistency groups",
+			MakeArgs: func(t *testing.T) []string {
+				cfgFile := cmd.ConfigFile(t, "config.yaml")
+				return []string{"--config-file", cfgFile, "create"}
+			},
+			MakeMSM: func(t *testing.T) *snapshot.MockManager {
+				sm := &snapshot.MockManager{}
+				sm.On("CreateSnapshots",
+					mock.AnythingOfType("snapshot.CreateOption"),
+					mock.AnythingOfType("snapshot.CreateOption"),
+					mock.AnythingOfType("snapshot.CreateOption"),
+				).Return([]string(nil), nil)
+				sm.ExpectCreateOptions(
+					snapshot.ConsistencyGroup("app", "zsm_test/app/db", "zsm_test/app/files"),
+					snapshot.ConsistencyGroup("mail", "zsm_test/mail"),
+					snapshot.CreateBestEffort(),
 				)
 				return sm
 			},
 		},
+		{
+			Name: "empty consistency group",
+			MakeArgs: func(t *testing.T) []string {
+				cfgFile := cmd.ConfigFile(t, "config.yaml")
+				return []string{"--config-file", cfgFile, "create"}
+			},
+			MakeMSM: func(t *testing.T) *snapshot.MockManager {
+				return &snapshot.MockManager{}
+			},
+			ExpectedErr: errors.New("snapshots.create.consistency_groups: app: no file systems"),
+		},
+		{
+			Name: "skip unchanged file systems",
+			MakeArgs: func(t *testing.T) []string {
+				return []string{"create", "--skip-unchanged"}
+			},
+			MakeMSM: func(t *testing.T) *snapshot.MockManager {
+				sm := &snapshot.MockManager{}
+				sm.On("CreateSnapshots",
+					mock.AnythingOfType("snapshot.CreateOption"),
+					mock.AnythingOfType("snapshot.CreateOption"),
+				).Return([]string{"zsm_test/fs_1", "zsm_test/fs_2"}, nil)
+				sm.ExpectCreateOptions(snapshot.SkipUnchanged(), snapshot.CreateBestEffort())
+				return sm
+			},
+			AssertOutput: func(t *testing.T, stdout, stderr string) {
+				assert.Equal(t, "skip\tzsm_test/fs_1\nskip\tzsm_test/fs_2\n", stdout)
+			},
+		},
+		{
+			Name: "disable best effort",
+			MakeArgs: func(t *testing.T) []string {
+				return []string{"create", "--best-effort=false"}
+			},
+			MakeMSM: func(t *testing.T) *snapshot.MockManager {
+				sm := &snapshot.MockManager{}
+				sm.On("CreateSnapshots").Return([]string(nil), nil)
+				return sm
+			},
+		},
+		{
+			Name: "report failures",
+			MakeArgs: func(t *testing.T) []string {
+				return []string{"create", "--skip-unchanged"}
+			},
+			MakeMSM: func(t *testing.T) *snapshot.MockManager {
+				err := &snapshot.PartialError{
+					Succeeded: []string{"zsm_test@2020-04-10T09:45:58.564585005Z"},
+					Failures: []snapshot.Failure{
+						{
+							Name: "zsm_test/fs_1@2020-04-10T09:45:58.564585005Z",
+							Err: &zfs.Error{
+								SubCommand: "snapshot",
+								ExitCode:   1,
+								Stderr: "cannot create snapshot 'zsm_test/fs_1@2020-04-10T09:45:58.564585005Z': " +
+									"dataset is busy\n",
+							},
+						},
+					},
+				}
+				sm := &snapshot.MockManager{}
+				sm.On("CreateSnapshots",
+					mock.AnythingOfType("snapshot.CreateOption"),
+					mock.AnythingOfType("snapshot.CreateOption"),
+				).Return([]string{"zsm_test/fs_2"}, fmt.Errorf("create snapshot: %w", err))
+				sm.ExpectCreateOptions(snapshot.SkipUnchanged(), snapshot.CreateBestEffort())
+				return sm
+			},
+			AssertOutput: func(t *testing.T, stdout, stderr string) {
+				expected := "skip\tzsm_test/fs_2\n" +
+					"created\tzsm_test@2020-04-10T09:45:58.564585005Z\n" +
+					"failed\tzsm_test/fs_1@2020-04-10T09:45:58.564585005Z\n"
+				assert.Equal(t, expected, stdout)
+			},
+			ExpectedErr: errors.New("create snapshot: 1 of 2 snapshots failed\n" +
+				"zsm_test/fs_1@2020-04-10T09:45:58.564585005Z: zfs snapshot: exit code: 1: " +
+				"cannot create snapshot 'zsm_test/fs_1@2020-04-10T09:45:58.564585005Z': dataset is busy"),
+		},
+		{
+			Name: "hooks",
+			MakeArgs: func(t *testing.T) []string {
+				cfgFile := cmd.ConfigFile(t, "config.yaml")
+				return []string{"--config-file", cfgFile, "create"}
+			},
+			MakeMSM: func(t *testing.T) *snapshot.MockManager {
+				sm := &snapshot.MockManager{}
+				sm.On("CreateSnapshots",
+					mock.AnythingOfType("snapshot.CreateOption"),
+					mock.AnythingOfType("snapshot.CreateOption"),
+				).Return([]string(nil), nil)
+				sm.ExpectCreateOptions(snapshot.CreateHooks(snapshot.Hooks{
+					Pre: []snapshot.Hook{
+						{
+							Command:     "fsfreeze --freeze /srv/db",
+							Timeout:     30 * time.Second,
+							FileSystems: []string{"zsm_test/db"},
+						},
+						{
+							Command:         "notify-send starting",
+							ContinueOnError: true,
+						},
+					},
+					Post: []snapshot.Hook{
+						{
+							Command:           "fsfreeze --unfreeze /srv/db",
+							ConsistencyGroups: []string{"db"},
+						},
+					},
+				}), snapshot.CreateBestEffort())
+				return sm
+			},
+		},
+		{
+			Name: "invalid hook",
+			MakeArgs: func(t *testing.T) []string {
+				cfgFile := cmd.ConfigFile(t, "config.yaml")
+				return []string{"--config-file", cfgFile, "create"}
+			},
+			MakeMSM: func(t *testing.T) *snapshot.MockManager {
+				return &snapshot.MockManager{}
+			},
+			ExpectedErr: errors.New("snapshots.create.hooks.pre[0]: invalid value for on_error: ignore"),
+		},
 	}
 
 	cmd.RunTests(t, tests)
@@ -0,0 +1,45 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/fhofherr/zsm/internal/snapshot"
+	"github.com/spf13/cobra"
+)
+
+func newGUIDCommand(cmdCfg *zsmCommandConfig) *cobra.Command {
+	guidCmd := &cobra.Command{
+		Use:   "guid <SNAPSHOT|BOOKMARK>",
+		Short: "Print the guid of a snapshot or bookmark.",
+		Long: `Print the guid of a snapshot or bookmark.
+
+The guid identifies a snapshot across hosts. zsm send and zsm pull call guid on
+the remote host to find the newest snapshot both hosts have in common.
+
+The guid of a bookmark created by zsm bookmark equals the guid of the
+bookmarked snapshot.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			sm, err := cmdCfg.SnapshotManager()
+			if err != nil {
+				return err
+			}
+			var guid uint64
+			if name, ok := snapshot.ParseBookmark(args[0]); ok {
+				guid, err = sm.BookmarkGUID(name)
+			} else {
+				name, ok := snapshot.ParseName(args[0])
+				if !ok {
+					return fmt.Errorf("invalid snapshot name: %s", args[0])
+				}
+				guid, err = sm.SnapshotGUID(name)
+			}
+			if err != nil {
+				return err
+			}
+			fmt.Fprintln(cmdCfg.Stdout(), guid)
+			return nil
+		},
+	}
+	return guidCmd
+}
@@ -0,0 +1,50 @@
+package cmd_test
+
+import (
+	"testing"
+
+	"github.com/fhofherr/zsm/internal/cmd"
+	"github.com/fhofherr/zsm/internal/snapshot"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGUID(t *testing.T) {
+	tests := []cmd.TestCase{
+		{
+			Name: "print guid",
+			MakeArgs: func(t *testing.T) []string {
+				return []string{"guid", "zsm_test@2020-04-10T09:45:58.564585005Z"}
+			},
+			MakeMSM: func(t *testing.T) *snapshot.MockManager {
+				name := snapshot.MustParseName(t, "zsm_test@2020-04-10T09:45:58.564585005Z")
+
+				sm := &snapshot.MockManager{}
+				sm.On("SnapshotGUID", name).Return(uint64(6917529027641081856), nil)
+				return sm
+			},
+			AssertOutput: func(t *testing.T, stdout, stderr string) {
+				assert.Equal(t, "6917529027641081856\n", stdout)
+				assert.Empty(t, stderr)
+			},
+		},
+		{
+			Name: "print guid of bookmark",
+			MakeArgs: func(t *testing.T) []string {
+				return []string{"guid", "zsm_test#2020-04-10T09:45:58.564585005Z"}
+			},
+			MakeMSM: func(t *testing.T) *snapshot.MockManager {
+				name := snapshot.MustParseName(t, "zsm_test@2020-04-10T09:45:58.564585005Z")
+
+				sm := &snapshot.MockManager{}
+				sm.On("BookmarkGUID", name).Return(uint64(6917529027641081856), nil)
+				return sm
+			},
+			AssertOutput: func(t *testing.T, stdout, stderr string) {
+				assert.Equal(t, "6917529027641081856\n", stdout)
+				assert.Empty(t, stderr)
+			},
+		},
+	}
+
+	cmd.RunTests(t, tests)
+}
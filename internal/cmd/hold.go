@@ -0,0 +1,121 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/fhofherr/zsm/internal/snapshot"
+	"github.com/spf13/cobra"
+)
+
+// defaultHoldTag is the tag of the holds placed and released by zsm hold and
+// zsm release unless --tag is passed.
+const defaultHoldTag = "zsm"
+
+func newHoldCommand(cmdCfg *zsmCommandConfig) *cobra.Command {
+	var tag string
+
+	holdCmd := &cobra.Command{
+		Use:   "hold <SNAPSHOT>...",
+		Short: "Protect snapshots from being destroyed.",
+		Long: `Protect snapshots from being destroyed.
+
+hold places a ZFS user hold on each of the passed snapshots. A snapshot with at
+least one hold can't be destroyed. zsm clean skips held snapshots it would
+otherwise destroy and prints them prefixed with held.
+
+The --tag option sets the tag of the hold. It defaults to zsm. Holding a
+snapshot twice using the same tag is an error. Use zsm release with the same
+tag to remove the hold again.`,
+		Args: cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			sm, err := cmdCfg.SnapshotManager()
+			if err != nil {
+				return err
+			}
+			names, err := parseNames(args)
+			if err != nil {
+				return err
+			}
+			return sm.HoldSnapshots(tag, names...)
+		},
+	}
+
+	holdCmd.Flags().StringVar(&tag, "tag", defaultHoldTag, "Tag of the hold.")
+
+	return holdCmd
+}
+
+func newReleaseCommand(cmdCfg *zsmCommandConfig) *cobra.Command {
+	var tag string
+
+	releaseCmd := &cobra.Command{
+		Use:   "release <SNAPSHOT>...",
+		Short: "Release snapshots held using zsm hold.",
+		Long: `Release snapshots held using zsm hold.
+
+release removes the ZFS user hold with the tag passed using --tag from each of
+the passed snapshots. It defaults to zsm. Once all holds of a snapshot are
+released zsm clean destroys it as usual.`,
+		Args: cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			sm, err := cmdCfg.SnapshotManager()
+			if err != nil {
+				return err
+			}
+			names, err := parseNames(args)
+			if err != nil {
+				return err
+			}
+			return sm.ReleaseSnapshots(tag, names...)
+		},
+	}
+
+	releaseCmd.Flags().StringVar(&tag, "tag", defaultHoldTag, "Tag of the hold to release.")
+
+	return releaseCmd
+}
+
+func newHoldsCommand(cmdCfg *zsmCommandConfig) *cobra.Command {
+	holdsCmd := &cobra.Command{
+		Use:   "holds <SNAPSHOT>",
+		Short: "Print the tags of all holds on a snapshot.",
+		Long: `Print the tags of all holds on a snapshot.
+
+holds prints the tag of each ZFS user hold on the passed snapshot on a line of
+its own.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			sm, err := cmdCfg.SnapshotManager()
+			if err != nil {
+				return err
+			}
+			name, ok := snapshot.ParseName(args[0])
+			if !ok {
+				return fmt.Errorf("invalid snapshot name: %s", args[0])
+			}
+			tags, err := sm.SnapshotHolds(name)
+			if err != nil {
+				return err
+			}
+			stdout := cmdCfg.Stdout()
+			for _, tag := range tags {
+				fmt.Fprintln(stdout, tag)
+			}
+			return nil
+		},
+	}
+	return holdsCmd
+}
+
+// parseNames parses each of args using snapshot.ParseName.
+func parseNames(args []string) ([]snapshot.Name, error) {
+	names := make([]snapshot.Name, len(args))
+	for i, arg := range args {
+		name, ok := snapshot.ParseName(arg)
+		if !ok {
+			return nil, fmt.Errorf("invalid snapshot name: %s", arg)
+		}
+		names[i] = name
+	}
+	return names, nil
+}
@@ -0,0 +1,104 @@
+package cmd_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/fhofherr/zsm/internal/cmd"
+	"github.com/fhofherr/zsm/internal/snapshot"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHold(t *testing.T) {
+	tests := []cmd.TestCase{
+		{
+			Name: "hold with default tag",
+			MakeArgs: func(t *testing.T) []string {
+				return []string{"hold", "zsm_test@2020-04-10T09:45:58.564585005Z"}
+			},
+			MakeMSM: func(t *testing.T) *snapshot.MockManager {
+				name := snapshot.MustParseName(t, "zsm_test@2020-04-10T09:45:58.564585005Z")
+
+				sm := &snapshot.MockManager{}
+				sm.On("HoldSnapshots", "zsm", []snapshot.Name{name}).Return(nil)
+				return sm
+			},
+		},
+		{
+			Name: "hold several snapshots",
+			MakeArgs: func(t *testing.T) []string {
+				return []string{
+					"hold", "--tag", "investigation",
+					"zsm_test@2020-04-10T09:45:58.564585005Z",
+					"zsm_test/fs_1@2020-04-10T09:45:58.564585005Z",
+				}
+			},
+			MakeMSM: func(t *testing.T) *snapshot.MockManager {
+				names := []snapshot.Name{
+					snapshot.MustParseName(t, "zsm_test@2020-04-10T09:45:58.564585005Z"),
+					snapshot.MustParseName(t, "zsm_test/fs_1@2020-04-10T09:45:58.564585005Z"),
+				}
+
+				sm := &snapshot.MockManager{}
+				sm.On("HoldSnapshots", "investigation", names).Return(nil)
+				return sm
+			},
+		},
+		{
+			Name: "invalid snapshot name",
+			MakeArgs: func(t *testing.T) []string {
+				return []string{"hold", "zsm_test@2020-04-10T09:45:58.564585005Z", "zsm_test@manual"}
+			},
+			MakeMSM: func(t *testing.T) *snapshot.MockManager {
+				return &snapshot.MockManager{}
+			},
+			ExpectedErr: errors.New("invalid snapshot name: zsm_test@manual"),
+		},
+	}
+
+	cmd.RunTests(t, tests)
+}
+
+func TestRelease(t *testing.T) {
+	tests := []cmd.TestCase{
+		{
+			Name: "release",
+			MakeArgs: func(t *testing.T) []string {
+				return []string{"release", "--tag", "investigation", "zsm_test@2020-04-10T09:45:58.564585005Z"}
+			},
+			MakeMSM: func(t *testing.T) *snapshot.MockManager {
+				name := snapshot.MustParseName(t, "zsm_test@2020-04-10T09:45:58.564585005Z")
+
+				sm := &snapshot.MockManager{}
+				sm.On("ReleaseSnapshots", "investigation", []snapshot.Name{name}).Return(nil)
+				return sm
+			},
+		},
+	}
+
+	cmd.RunTests(t, tests)
+}
+
+func TestHolds(t *testing.T) {
+	tests := []cmd.TestCase{
+		{
+			Name: "print holds",
+			MakeArgs: func(t *testing.T) []string {
+				return []string{"holds", "zsm_test@2020-04-10T09:45:58.564585005Z"}
+			},
+			MakeMSM: func(t *testing.T) *snapshot.MockManager {
+				name := snapshot.MustParseName(t, "zsm_test@2020-04-10T09:45:58.564585005Z")
+
+				sm := &snapshot.MockManager{}
+				sm.On("SnapshotHolds", name).Return([]string{"investigation", "zsm"}, nil)
+				return sm
+			},
+			AssertOutput: func(t *testing.T, stdout, stderr string) {
+				assert.Equal(t, "investigation\nzsm\n", stdout)
+				assert.Empty(t, stderr)
+			},
+		},
+	}
+
+	cmd.RunTests(t, tests)
+}
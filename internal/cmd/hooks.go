@@ -0,0 +1,82 @@
+package cmd
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/fhofherr/zsm/internal/snapshot"
+	"github.com/spf13/viper"
+)
+
+// hookConfig represents a single hook of the hooks settings.
+type hookConfig struct {
+	Command           string        `mapstructure:"command"`
+	Timeout           time.Duration `mapstructure:"timeout"`
+	OnError           string        `mapstructure:"on_error"`
+	FileSystems       []string      `mapstructure:"file_systems"`
+	ConsistencyGroups []string      `mapstructure:"consistency_groups"`
+}
+
+// hooksConfig represents the hooks settings of a single command.
+type hooksConfig struct {
+	Pre  []hookConfig `mapstructure:"pre"`
+	Post []hookConfig `mapstructure:"post"`
+}
+
+// Values of the on_error setting of a hook.
+const (
+	hookOnErrorAbort    = "abort"
+	hookOnErrorContinue = "continue"
+)
+
+// readHooks reads the hooks stored under key. The second return value is
+// false if no hooks are configured.
+func readHooks(v *viper.Viper, key string) (snapshot.Hooks, bool, error) {
+	var hc hooksConfig
+
+	if err := v.UnmarshalKey(key, &hc); err != nil {
+		return snapshot.Hooks{}, false, fmt.Errorf("%s: %w", key, err)
+	}
+	pre, err := convertHooks(key+".pre", hc.Pre)
+	if err != nil {
+		return snapshot.Hooks{}, false, err
+	}
+	post, err := convertHooks(key+".post", hc.Post)
+	if err != nil {
+		return snapshot.Hooks{}, false, err
+	}
+	return snapshot.Hooks{Pre: pre, Post: post}, len(pre)+len(post) > 0, nil
+}
+
+func convertHooks(key string, hcs []hookConfig) ([]snapshot.Hook, error) {
+	var hooks []snapshot.Hook
+
+	for i, hc := range hcs {
+		if hc.Command == "" {
+			return nil, fmt.Errorf("%s[%d]: command missing", key, i)
+		}
+		if hc.Timeout < 0 {
+			return nil, fmt.Errorf("%s[%d]: negative timeout: %s", key, i, hc.Timeout)
+		}
+		h := snapshot.Hook{
+			Command:           hc.Command,
+			Timeout:           hc.Timeout,
+			FileSystems:       hc.FileSystems,
+			ConsistencyGroups: hc.ConsistencyGroups,
+		}
+		switch hc.OnError {
+		case "", hookOnErrorAbort:
+		case hookOnErrorContinue:
+			h.ContinueOnError = true
+		default:
+			return nil, fmt.Errorf("%s[%d]: invalid value for on_error: %s", key, i, hc.OnError)
+		}
+		for _, fs := range hc.FileSystems {
+			if _, err := snapshot.ParsePattern(fs); err != nil {
+				return nil, fmt.Errorf("%s[%d]: %w", key, i, err)
+			}
+		}
+		hooks = append(hooks, h)
+	}
+	return hooks, nil
+}
@@ -1,19 +1,38 @@
 package cmd
 
 import (
+	"encoding/json"
 	"fmt"
+	"time"
 
+	"github.com/fhofherr/zsm/internal/snapshot"
 	"github.com/spf13/cobra"
 )
 
+// listEntry is the jsonl representation of a snapshot printed by list.
+type listEntry struct {
+	snapshot.Name
+	Expires *time.Time `json:"expires,omitempty"`
+}
+
 func newListCommand(cmdCfg *zsmCommandConfig) *cobra.Command {
-	var outType string
+	var (
+		outType   string
+		bookmarks bool
+	)
 
 	listCmd := &cobra.Command{
 		Use:   "list",
 		Short: "List all snapshots managed by zsm.",
 		Long: `List all snapshots managed by zsm.
 
+Snapshots created using zsm create --expire-in or --expire-at are followed by
+their expiry.
+
+The --bookmarks option lists the bookmarks created by zsm bookmark, zsm send,
+and zsm pull instead of snapshots. The jsonl format prints the name of the
+bookmarked snapshot.
+
 The --output option allows to switch the output format of list. The currently
 supported values are text and jsonl. The jsonl format prints one json document
 per line (see http://jsonlines.org/) and is meant for easy programmatic
@@ -23,18 +42,35 @@ consumption.`,
 			if err != nil {
 				return err
 			}
+			if bookmarks {
+				return listBookmarks(cmdCfg, sm, outType)
+			}
 			names, err := sm.ListSnapshots()
 			if err != nil {
 				return err
 			}
+			expiries, err := sm.Expiries()
+			if err != nil {
+				return err
+			}
 
 			stdout := cmdCfg.Stdout()
+			enc := json.NewEncoder(stdout)
 			for _, name := range names {
+				expires, ok := expiries[name]
 				switch outType {
 				case "text":
+					if ok {
+						fmt.Fprintf(stdout, "%s\t%s\n", name, expires.Format(time.RFC3339))
+						continue
+					}
 					fmt.Fprintln(stdout, name)
 				case "jsonl":
-					name.ToJSONW(stdout) // nolint: errcheck
+					entry := listEntry{Name: name}
+					if ok {
+						entry.Expires = &expires
+					}
+					enc.Encode(entry) // nolint: errcheck
 				default:
 					return fmt.Errorf("unsupported output format: %s", outType)
 				}
@@ -45,6 +81,29 @@ consumption.`,
 
 	listCmd.Flags().StringVarP(&outType, "output", "o", "text",
 		"Change the output format of list. Supported values: text, jsonl.")
+	listCmd.Flags().BoolVar(&bookmarks, "bookmarks", false, "List bookmarks instead of snapshots.")
 
 	return listCmd
 }
+
+// listBookmarks prints the bookmarks created by zsm in the format outType.
+func listBookmarks(cmdCfg *zsmCommandConfig, sm SnapshotManager, outType string) error {
+	names, err := sm.ListBookmarks()
+	if err != nil {
+		return err
+	}
+
+	stdout := cmdCfg.Stdout()
+	enc := json.NewEncoder(stdout)
+	for _, name := range names {
+		switch outType {
+		case "text":
+			fmt.Fprintln(stdout, name.Bookmark())
+		case "jsonl":
+			enc.Encode(name) // nolint: errcheck
+		default:
+			return fmt.Errorf("unsupported output format: %s", outType)
+		}
+	}
+	return nil
+}
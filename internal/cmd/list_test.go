@@ -3,6 +3,7 @@ package cmd_test
 import (
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/fhofherr/zsm/internal/cmd"
 	"github.com/fhofherr/zsm/internal/snapshot"
@@ -23,12 +24,15 @@ func TestList(t *testing.T) {
 				}
 				sm := &snapshot.MockManager{}
 				sm.On("ListSnapshots").Return(sns, nil)
+				sm.On("Expiries").Return(map[snapshot.Name]time.Time{
+					sns[0]: time.Date(2020, 4, 24, 0, 0, 0, 0, time.UTC),
+				}, nil)
 
 				return sm
 			},
 			AssertOutput: func(t *testing.T, stdout, stderr string) {
 				expected := []string{
-					"zfs_test@2020-04-10T09:45:58.564585005Z",
+					"zfs_test@2020-04-10T09:45:58.564585005Z\t2020-04-24T00:00:00Z",
 					"zfs_test@2020-04-10T09:44:58.564585005Z",
 				}
 				actual := strings.Split(strings.TrimSpace(stdout), "\n")
@@ -48,6 +52,9 @@ func TestList(t *testing.T) {
 				}
 				sm := &snapshot.MockManager{}
 				sm.On("ListSnapshots").Return(sns, nil)
+				sm.On("Expiries").Return(map[snapshot.Name]time.Time{
+					sns[0]: time.Date(2020, 4, 24, 0, 0, 0, 0, time.UTC),
+				}, nil)
 
 				return sm
 			},
@@ -66,6 +73,24 @@ func TestList(t *testing.T) {
 					actual = append(actual, name)
 				}
 				assert.Equal(t, expected, actual)
+				assert.Contains(t, stdout, `"expires":"2020-04-24T00:00:00Z"`)
+				assert.Empty(t, stderr)
+			},
+		},
+		{
+			Name: "bookmarks",
+			MakeArgs: func(t *testing.T) []string {
+				return []string{"list", "--bookmarks"}
+			},
+			MakeMSM: func(t *testing.T) *snapshot.MockManager {
+				sm := &snapshot.MockManager{}
+				sm.On("ListBookmarks").Return([]snapshot.Name{
+					snapshot.MustParseName(t, "zfs_test@2020-04-10T09:45:58.564585005Z"),
+				}, nil)
+				return sm
+			},
+			AssertOutput: func(t *testing.T, stdout, stderr string) {
+				assert.Equal(t, "zfs_test#2020-04-10T09:45:58.564585005Z\n", stdout)
 				assert.Empty(t, stderr)
 			},
 		},
@@ -0,0 +1,104 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/fhofherr/zsm/internal/config"
+	"github.com/fhofherr/zsm/internal/snapshot"
+	"github.com/spf13/cobra"
+)
+
+func newPullCommand(cmdCfg *zsmCommandConfig) *cobra.Command {
+	var abortResume bool
+
+	pullCmd := &cobra.Command{
+		Use:   "pull <SOURCE> <TARGET_FS> [SOURCE_FS]",
+		Short: "Pull snapshots from <SOURCE> into the local <TARGET_FS>.",
+		Long: `Pull snapshots from <SOURCE> into the local <TARGET_FS>.
+
+<SOURCE> must be of the form <USER>@<HOST>[:PORT]. A SSH server must listen
+on HOST at PORT and USER must be allowed to log in using key-based authentication.
+Additionally USER must be allowed to execute zsm list, zsm guid, zsm send-stream,
+zsm hold, zsm release, zsm holds, and zsm bookmark on HOST.
+
+If <TARGET_FS> has no snapshot for a file system of <SOURCE>, pull transmits all
+available snapshots. Otherwise pull transmits only snapshots which are newer than
+the last available snapshot in <TARGET_FS>. pull does not perform any kind of
+clean up on <SOURCE>.
+
+If [SOURCE_FS] is specified only snapshots from [SOURCE_FS] will be transmitted.
+
+The file systems passed to --exclude may be shell-style globs, e.g.
+tank/docker/*, or regular expressions prefixed with re:.
+
+While pulling a snapshot pull holds it and the snapshot it is sent
+incrementally to on <SOURCE> using the zsm-send tag. Additionally pull keeps a
+hold tagged zsm-base:<LOCAL_HOST>:<TARGET_FS> on the newest snapshot of each
+file system on <SOURCE> which has been pulled, where <LOCAL_HOST> is the host
+name of the local host. This keeps zsm clean on <SOURCE> from destroying the
+snapshots required for the next incremental pull.
+
+After each successful transfer pull creates a bookmark of the pulled snapshot
+on <SOURCE>. If <SOURCE> and <TARGET_FS> have no snapshot in common any more,
+pull transmits the newest snapshot incrementally to the newest bookmark whose
+snapshot <TARGET_FS> still has. The snapshots in between are skipped.
+
+Interrupted transfers are resumed before any other snapshots are pulled. If an
+interrupted transfer can't be resumed, e.g. because the snapshot it sent has
+been destroyed on <SOURCE>, --abort-resume discards the partially received
+data in <TARGET_FS> instead.
+
+Commands configured using the snapshots.pull.hooks setting are executed before
+and after transferring snapshots. See zsm create --help for the format.
+
+In contrast to send, pull does not require the hosts that are backed up to hold
+any credentials for the backup host.`,
+		Args: cobra.RangeArgs(2, 3),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var transferOpts []snapshot.TransferOption
+
+			sm, err := cmdCfg.SnapshotManager()
+			if err != nil {
+				return err
+			}
+			hostname, err := os.Hostname()
+			if err != nil {
+				return fmt.Errorf("local host name: %w", err)
+			}
+			transferOpts = append(transferOpts, snapshot.TransferDestination(hostname))
+			if len(args) == 3 {
+				transferOpts = append(transferOpts, snapshot.TransferFileSystem(args[2]))
+			}
+			if abortResume {
+				transferOpts = append(transferOpts, snapshot.AbortResume())
+			}
+			excludes := cmdCfg.V.GetStringSlice(config.SnapshotsPullExcludeFileSystems)
+			for _, e := range excludes {
+				transferOpts = append(transferOpts, snapshot.TransferExcludeFileSystem(e))
+			}
+			hooks, ok, err := readHooks(cmdCfg.V, config.SnapshotsPullHooks)
+			if err != nil {
+				return err
+			}
+			if ok {
+				transferOpts = append(transferOpts, snapshot.TransferHooks(hooks))
+			}
+
+			host, err := cmdCfg.RemoteHost(args[0])
+			if err != nil {
+				return err
+			}
+			defer host.Close()
+
+			return snapshot.Transfer(args[1], sm, host, transferOpts...)
+		},
+	}
+	pullCmd.Flags().StringSliceP("exclude", "e", nil,
+		"File systems to exclude when pulling snapshots.")
+	cmdCfg.V.BindPFlag(config.SnapshotsPullExcludeFileSystems, pullCmd.Flags().Lookup("exclude"))
+	pullCmd.Flags().BoolVar(&abortResume, "abort-resume", false,
+		"Abort interrupted transfers instead of resuming them.")
+
+	return pullCmd
+}
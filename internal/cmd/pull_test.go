@@ -0,0 +1,122 @@
+package cmd_test
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/fhofherr/zsm/internal/cmd"
+	"github.com/fhofherr/zsm/internal/snapshot"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestPull(t *testing.T) {
+	now := time.Now().UTC()
+	remote := []snapshot.Name{
+		{FileSystem: "zsm_test", Timestamp: now},
+		{FileSystem: "zsm_test/fs_1", Timestamp: now},
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		t.Fatal(err)
+	}
+	baseTag := snapshot.BaseHoldTag(hostname, "target_fs")
+
+	tests := []cmd.TestCase{
+		{
+			Name: "pull all file systems",
+			MakeArgs: func(t *testing.T) []string {
+				return []string{"pull", "zsm@prod.example.com", "target_fs"}
+			},
+			MakeMSM: func(t *testing.T) *snapshot.MockManager {
+				sm := &snapshot.MockManager{}
+				sm.On("ListSnapshots").Return([]snapshot.Name(nil), nil)
+				sm.On("ResumeTokens", "target_fs").Return(map[string]string(nil), nil)
+				for _, n := range remote {
+					sm.On("ReceiveSnapshot", "target_fs", n, mock.AnythingOfType("*io.PipeReader")).Return(nil)
+				}
+				return sm
+			},
+			MakeRemoteMSM: func(t *testing.T) *snapshot.MockManager {
+				sm := &snapshot.MockManager{}
+				sm.On("ListSnapshots").Return(remote, nil)
+				for _, n := range remote {
+					sm.On("SendSnapshot", n, mock.AnythingOfType("*io.PipeWriter")).Return(nil)
+				}
+				expectHolds(sm, baseTag, remote...)
+				expectBookmarks(sm, remote...)
+				return sm
+			},
+			AssertRemoteMSM: func(t *testing.T, msm *snapshot.MockManager) {
+				assert.Equal(t, "zsm@prod.example.com", msm.Dest)
+			},
+		},
+		{
+			Name: "pull source file system",
+			MakeArgs: func(t *testing.T) []string {
+				return []string{"pull", "zsm@prod.example.com", "target_fs", "zsm_test/fs_1"}
+			},
+			MakeMSM: func(t *testing.T) *snapshot.MockManager {
+				sm := &snapshot.MockManager{}
+				sm.On("ListSnapshots").Return([]snapshot.Name(nil), nil)
+				sm.On("ResumeTokens", "target_fs").Return(map[string]string(nil), nil)
+				sm.On("ReceiveSnapshot", "target_fs", remote[1], mock.AnythingOfType("*io.PipeReader")).Return(nil)
+				return sm
+			},
+			MakeRemoteMSM: func(t *testing.T) *snapshot.MockManager {
+				sm := &snapshot.MockManager{}
+				sm.On("ListSnapshots").Return(remote, nil)
+				sm.On("SendSnapshot", remote[1], mock.AnythingOfType("*io.PipeWriter")).Return(nil)
+				expectHolds(sm, baseTag, remote[1])
+				expectBookmarks(sm, remote[1])
+				return sm
+			},
+		},
+		{
+			Name: "abort interrupted transfer",
+			MakeArgs: func(t *testing.T) []string {
+				return []string{"pull", "--abort-resume", "zsm@prod.example.com", "target_fs", "zsm_test/fs_1"}
+			},
+			MakeMSM: func(t *testing.T) *snapshot.MockManager {
+				sm := &snapshot.MockManager{}
+				sm.On("ListSnapshots").Return([]snapshot.Name(nil), nil)
+				sm.On("ResumeTokens", "target_fs").Return(map[string]string{"zsm_test/fs_1": "1-e604ea4bf-e0"}, nil)
+				sm.On("AbortReceive", "target_fs", "zsm_test/fs_1").Return(nil)
+				sm.On("ReceiveSnapshot", "target_fs", remote[1], mock.AnythingOfType("*io.PipeReader")).Return(nil)
+				return sm
+			},
+			MakeRemoteMSM: func(t *testing.T) *snapshot.MockManager {
+				sm := &snapshot.MockManager{}
+				sm.On("ListSnapshots").Return(remote, nil)
+				sm.On("SendSnapshot", remote[1], mock.AnythingOfType("*io.PipeWriter")).Return(nil)
+				expectHolds(sm, baseTag, remote[1])
+				expectBookmarks(sm, remote[1])
+				return sm
+			},
+		},
+		{
+			Name: "exclude file systems",
+			MakeArgs: func(t *testing.T) []string {
+				return []string{"pull", "-e", "zsm_test", "zsm@prod.example.com", "target_fs"}
+			},
+			MakeMSM: func(t *testing.T) *snapshot.MockManager {
+				sm := &snapshot.MockManager{}
+				sm.On("ListSnapshots").Return([]snapshot.Name(nil), nil)
+				sm.On("ResumeTokens", "target_fs").Return(map[string]string(nil), nil)
+				sm.On("ReceiveSnapshot", "target_fs", remote[1], mock.AnythingOfType("*io.PipeReader")).Return(nil)
+				return sm
+			},
+			MakeRemoteMSM: func(t *testing.T) *snapshot.MockManager {
+				sm := &snapshot.MockManager{}
+				sm.On("ListSnapshots").Return(remote, nil)
+				sm.On("SendSnapshot", remote[1], mock.AnythingOfType("*io.PipeWriter")).Return(nil)
+				expectHolds(sm, baseTag, remote[1])
+				expectBookmarks(sm, remote[1])
+				return sm
+			},
+		},
+	}
+
+	cmd.RunTests(t, tests)
+}
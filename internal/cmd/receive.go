@@ -1,6 +1,7 @@
 package cmd
 
 import (
+	"errors"
 	"fmt"
 	"os"
 
@@ -9,6 +10,8 @@ import (
 )
 
 func newReceiveCommand(cmdCfg *zsmCommandConfig) *cobra.Command {
+	var resume, abort bool
+
 	receiveCommand := &cobra.Command{
 		Use:   "receive <TARGET FILE SYSTEM> <SNAPSHOT>",
 		Short: "Receive a snapshot from a remote host.",
@@ -16,7 +19,17 @@ func newReceiveCommand(cmdCfg *zsmCommandConfig) *cobra.Command {
 
 The <SNAPSHOT> is stored in the passed <TARGET FILE SYSTEM>. Care must be taken
 that the target file system is excluded when calling create. Otherwise additional
-snapshots of <TARGET FILE SYSTEM> will be created.`,
+snapshots of <TARGET FILE SYSTEM> will be created.
+
+If the receive is interrupted, the partially received data is kept. If --resume
+is passed, receive resumes the interrupted receive. In this case the second
+argument is the name of the file system below <TARGET FILE SYSTEM> the
+interrupted receive was writing to.
+
+If the interrupted receive can't be resumed, e.g. because the snapshot it
+received was destroyed on the sending host, --abort discards the partially
+received data. The second argument is the name of the file system as for
+--resume. Afterwards the file system accepts regular receives again.`,
 		Args: cobra.ExactArgs(2),
 		RunE: func(cmd *cobra.Command, args []string) error {
 			sm, err := cmdCfg.SnapshotManager()
@@ -24,6 +37,15 @@ snapshots of <TARGET FILE SYSTEM> will be created.`,
 				return err
 			}
 			targetFS := args[0]
+			if resume && abort {
+				return errors.New("--resume and --abort are mutually exclusive")
+			}
+			if abort {
+				return sm.AbortReceive(targetFS, args[1])
+			}
+			if resume {
+				return sm.ResumeReceive(targetFS, args[1], os.Stdin)
+			}
 			name, ok := snapshot.ParseName(args[1])
 			if !ok {
 				return fmt.Errorf("invalid snapshot name: %s", args[1])
@@ -31,5 +53,10 @@ snapshots of <TARGET FILE SYSTEM> will be created.`,
 			return sm.ReceiveSnapshot(targetFS, name, os.Stdin)
 		},
 	}
+	receiveCommand.Flags().BoolVar(&resume, "resume", false,
+		"Resume an interrupted receive into the passed file system.")
+	receiveCommand.Flags().BoolVar(&abort, "abort", false,
+		"Discard the partially received data of an interrupted receive into the passed file system.")
+
 	return receiveCommand
 }
@@ -1,6 +1,7 @@
 package cmd_test
 
 import (
+	"errors"
 	"os"
 	"testing"
 
@@ -23,6 +24,38 @@ func TestReceive(t *testing.T) {
 				return sm
 			},
 		},
+		{
+			Name: "resume receive",
+			MakeArgs: func(t *testing.T) []string {
+				return []string{"receive", "--resume", "target_fs", "zsm_test/fs_1"}
+			},
+			MakeMSM: func(t *testing.T) *snapshot.MockManager {
+				sm := &snapshot.MockManager{}
+				sm.On("ResumeReceive", "target_fs", "zsm_test/fs_1", os.Stdin).Return(nil)
+				return sm
+			},
+		},
+		{
+			Name: "abort receive",
+			MakeArgs: func(t *testing.T) []string {
+				return []string{"receive", "--abort", "target_fs", "zsm_test/fs_1"}
+			},
+			MakeMSM: func(t *testing.T) *snapshot.MockManager {
+				sm := &snapshot.MockManager{}
+				sm.On("AbortReceive", "target_fs", "zsm_test/fs_1").Return(nil)
+				return sm
+			},
+		},
+		{
+			Name: "resume and abort",
+			MakeArgs: func(t *testing.T) []string {
+				return []string{"receive", "--resume", "--abort", "target_fs", "zsm_test/fs_1"}
+			},
+			MakeMSM: func(t *testing.T) *snapshot.MockManager {
+				return &snapshot.MockManager{}
+			},
+			ExpectedErr: errors.New("--resume and --abort are mutually exclusive"),
+		},
 	}
 
 	cmd.RunTests(t, tests)
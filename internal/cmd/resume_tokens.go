@@ -0,0 +1,44 @@
+package cmd
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/spf13/cobra"
+)
+
+func newResumeTokensCommand(cmdCfg *zsmCommandConfig) *cobra.Command {
+	resumeTokensCmd := &cobra.Command{
+		Use:   "resume-tokens <TARGET FILE SYSTEM>",
+		Short: "Print the tokens of all interrupted receives.",
+		Long: `Print the tokens of all interrupted receives below <TARGET FILE SYSTEM>.
+
+Each line contains the name of a file system relative to <TARGET FILE SYSTEM>
+and the token required to resume the interrupted receive, separated by a tab.
+zsm send calls resume-tokens on the remote host to resume interrupted
+transfers.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			sm, err := cmdCfg.SnapshotManager()
+			if err != nil {
+				return err
+			}
+			tokens, err := sm.ResumeTokens(args[0])
+			if err != nil {
+				return err
+			}
+			fileSystems := make([]string, 0, len(tokens))
+			for fs := range tokens {
+				fileSystems = append(fileSystems, fs)
+			}
+			sort.Strings(fileSystems)
+
+			stdout := cmdCfg.Stdout()
+			for _, fs := range fileSystems {
+				fmt.Fprintf(stdout, "%s\t%s\n", fs, tokens[fs])
+			}
+			return nil
+		},
+	}
+	return resumeTokensCmd
+}
@@ -0,0 +1,34 @@
+package cmd_test
+
+import (
+	"testing"
+
+	"github.com/fhofherr/zsm/internal/cmd"
+	"github.com/fhofherr/zsm/internal/snapshot"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResumeTokens(t *testing.T) {
+	tests := []cmd.TestCase{
+		{
+			Name: "print resume tokens",
+			MakeArgs: func(t *testing.T) []string {
+				return []string{"resume-tokens", "target_fs"}
+			},
+			MakeMSM: func(t *testing.T) *snapshot.MockManager {
+				sm := &snapshot.MockManager{}
+				sm.On("ResumeTokens", "target_fs").Return(map[string]string{
+					"zsm_test/fs_2": "1-bbbb",
+					"zsm_test/fs_1": "1-aaaa",
+				}, nil)
+				return sm
+			},
+			AssertOutput: func(t *testing.T, stdout, stderr string) {
+				assert.Equal(t, "zsm_test/fs_1\t1-aaaa\nzsm_test/fs_2\t1-bbbb\n", stdout)
+				assert.Empty(t, stderr)
+			},
+		},
+	}
+
+	cmd.RunTests(t, tests)
+}
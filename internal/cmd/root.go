@@ -1,8 +1,13 @@
 package cmd
 
 import (
+	"fmt"
+	"strings"
+
 	"github.com/fhofherr/zsm/internal/config"
+	"github.com/fhofherr/zsm/internal/snapshot"
 	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
 )
 
 func newRootCmd(cmdCfg *zsmCommandConfig) *cobra.Command {
@@ -20,7 +25,10 @@ func newRootCmd(cmdCfg *zsmCommandConfig) *cobra.Command {
 			} else {
 				err = config.Read(cmdCfg.V)
 			}
-			return err
+			if err != nil {
+				return err
+			}
+			return validatePatterns(cmdCfg.V)
 		},
 	}
 
@@ -29,6 +37,52 @@ func newRootCmd(cmdCfg *zsmCommandConfig) *cobra.Command {
 	rootCmd.PersistentFlags().
 		String("zfs-cmd", config.DefaultZFSCmd, "Full path to zfs executable")
 	cmdCfg.V.BindPFlag(config.ZFSCmd, rootCmd.PersistentFlags().Lookup("zfs-cmd"))
+	rootCmd.PersistentFlags().
+		String("auth-key-file", config.DefaultSSHAuthKeyFile,
+			"File containing the private key used to log in on remote hosts")
+	cmdCfg.V.BindPFlag(config.SSHAuthKeyFile, rootCmd.PersistentFlags().Lookup("auth-key-file"))
+	rootCmd.PersistentFlags().
+		String("known-hosts-file", config.DefaultSSHKnownHostsFile,
+			"File containing the known keys of remote hosts")
+	cmdCfg.V.BindPFlag(config.SSHKnownHostsFile, rootCmd.PersistentFlags().Lookup("known-hosts-file"))
+	rootCmd.PersistentFlags().
+		String("remote-zsm", config.DefaultRemoteZSMCmd, "Path to the zsm executable on remote hosts")
+	cmdCfg.V.BindPFlag(config.RemoteZSMCmd, rootCmd.PersistentFlags().Lookup("remote-zsm"))
+	rootCmd.PersistentFlags().
+		StringSlice("dataset-types", config.DefaultSnapshotsDatasetTypes,
+			"Types of datasets to create snapshots of: filesystem, volume, or both")
+	cmdCfg.V.BindPFlag(config.SnapshotsDatasetTypes, rootCmd.PersistentFlags().Lookup("dataset-types"))
 
 	return rootCmd
 }
+
+// validatePatterns checks the file system patterns of all settings
+// accepting them. This rejects invalid patterns as soon as the settings are
+// read, regardless of the command using them.
+func validatePatterns(v *viper.Viper) error {
+	for _, ex := range []struct {
+		Key    string
+		Prefix string
+	}{
+		{Key: config.SnapshotsCreateExcludeFileSystems, Prefix: excludeOnlyPrefix},
+		{Key: config.SnapshotsSendExcludeFileSystems},
+		{Key: config.SnapshotsPullExcludeFileSystems},
+	} {
+		for _, e := range v.GetStringSlice(ex.Key) {
+			if _, err := snapshot.ParsePattern(strings.TrimPrefix(e, ex.Prefix)); err != nil {
+				return fmt.Errorf("%s: %w", ex.Key, err)
+			}
+		}
+	}
+	for _, key := range []string{
+		config.SnapshotsCreateHooks,
+		config.SnapshotsCleanHooks,
+		config.SnapshotsSendHooks,
+		config.SnapshotsPullHooks,
+	} {
+		if _, _, err := readHooks(v, key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
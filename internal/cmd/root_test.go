@@ -1,11 +1,13 @@
 package cmd_test
 
 import (
+	"errors"
 	"testing"
 
 	"github.com/fhofherr/zsm/internal/cmd"
 	"github.com/fhofherr/zsm/internal/snapshot"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
 )
 
 func TestRootCommand(t *testing.T) {
@@ -17,7 +19,8 @@ func TestRootCommand(t *testing.T) {
 			},
 			MakeMSM: func(t *testing.T) *snapshot.MockManager {
 				sm := &snapshot.MockManager{}
-				sm.On("CreateSnapshots").Return(nil)
+				sm.On("CreateSnapshots", mock.AnythingOfType("snapshot.CreateOption")).Return([]string(nil), nil)
+				sm.ExpectCreateOptions(snapshot.CreateBestEffort())
 				return sm
 			},
 			AssertMSM: func(t *testing.T, msm *snapshot.MockManager) {
@@ -32,13 +35,56 @@ func TestRootCommand(t *testing.T) {
 			},
 			MakeMSM: func(t *testing.T) *snapshot.MockManager {
 				sm := &snapshot.MockManager{}
-				sm.On("CreateSnapshots").Return(nil)
+				sm.On("CreateSnapshots", mock.AnythingOfType("snapshot.CreateOption")).Return([]string(nil), nil)
+				sm.ExpectCreateOptions(snapshot.CreateBestEffort())
 				return sm
 			},
 			AssertMSM: func(t *testing.T, msm *snapshot.MockManager) {
 				assert.Equal(t, "another/path/to/zfs", msm.ZFS)
 			},
 		},
+		{
+			Name: "set dataset types",
+			MakeArgs: func(t *testing.T) []string {
+				return []string{"--dataset-types", "filesystem,volume", "create"}
+			},
+			MakeMSM: func(t *testing.T) *snapshot.MockManager {
+				sm := &snapshot.MockManager{}
+				sm.On("CreateSnapshots", mock.AnythingOfType("snapshot.CreateOption")).Return([]string(nil), nil)
+				sm.ExpectCreateOptions(snapshot.CreateBestEffort())
+				return sm
+			},
+			AssertMSM: func(t *testing.T, msm *snapshot.MockManager) {
+				assert.Equal(t, []string{"filesystem", "volume"}, msm.DatasetTypes)
+			},
+		},
+		{
+			Name: "invalid send exclude pattern",
+			MakeArgs: func(t *testing.T) []string {
+				cfgFile := cmd.ConfigFile(t, "config.yaml")
+				return []string{"--config-file", cfgFile, "create"}
+			},
+			MakeMSM: func(t *testing.T) *snapshot.MockManager {
+				return &snapshot.MockManager{}
+			},
+			ExpectedErr: errors.New(
+				`snapshots.send.exclude_file_systems: invalid pattern "zsm_test/[fs": syntax error in pattern`,
+			),
+		},
+		{
+			Name: "invalid pull hook pattern",
+			MakeArgs: func(t *testing.T) []string {
+				cfgFile := cmd.ConfigFile(t, "config.yaml")
+				return []string{"--config-file", cfgFile, "clean"}
+			},
+			MakeMSM: func(t *testing.T) *snapshot.MockManager {
+				return &snapshot.MockManager{}
+			},
+			ExpectedErr: errors.New(
+				"snapshots.pull.hooks.pre[0]: invalid pattern \"re:zsm_test/(db\": " +
+					"error parsing regexp: missing closing ): `^(?:zsm_test/(db)$`",
+			),
+		},
 	}
 	cmd.RunTests(t, tests)
 }
@@ -2,10 +2,13 @@ package cmd
 
 import (
 	"github.com/fhofherr/zsm/internal/config"
+	"github.com/fhofherr/zsm/internal/snapshot"
 	"github.com/spf13/cobra"
 )
 
 func newSendCommand(cmdCfg *zsmCommandConfig) *cobra.Command {
+	var abortResume bool
+
 	sendCmd := &cobra.Command{
 		Use:   "send <DESTINATION> <TARGET_FS> [SOURCE_FS]",
 		Short: "Send snapshots to the <TARGET_FS> at <DESTINATION>.",
@@ -13,7 +16,9 @@ func newSendCommand(cmdCfg *zsmCommandConfig) *cobra.Command {
 
 <DESTINATION> must be of the form <USER>@<HOST>[:PORT]. A SSH server must listen
 on HOST at PORT and USER must be allowed to log in using key-based authentication.
-Additionally USER must be allowed to execute zsm receive on HOST.
+Additionally USER must be allowed to execute zsm list, zsm guid,
+zsm resume-tokens, and zsm receive on HOST. send runs zsm receive with the
+--resume and --abort options as well.
 
 If <DESTINATION> has no snapshot for a source file system, send transmits all
 available snapshots. Otherwise send transmits only snapshots which are newer than
@@ -21,15 +26,73 @@ the last available snapshot on <DESTINATION>. send does not perform any kind of
 clean up on the <DESTINATION>. The administrators of <DESTINATION> are
 responsible for that.
 
-If [SOURCE_FS] is specified only snapshots from [SOURCE_FS] will be transmitted.`,
+If [SOURCE_FS] is specified only snapshots from [SOURCE_FS] will be transmitted.
+
+The file systems passed to --exclude may be shell-style globs, e.g.
+tank/docker/*, or regular expressions prefixed with re:.
+
+While sending a snapshot send holds it and the snapshot it is sent
+incrementally to using the zsm-send tag. Additionally send keeps a hold tagged
+zsm-base:<DESTINATION>:<TARGET_FS> on the newest snapshot of each file system
+which <DESTINATION> is known to have. This keeps zsm clean from destroying the
+snapshots required for the next incremental send. Use zsm release to remove
+the hold once <DESTINATION> is no longer used.
+
+After each successful transfer send creates a bookmark of the sent snapshot.
+If the source and <DESTINATION> have no snapshot in common any more, send
+transmits the newest snapshot incrementally to the newest bookmark whose
+snapshot <DESTINATION> still has. The snapshots in between are skipped.
+
+Interrupted transfers are resumed before any other snapshots are sent. If an
+interrupted transfer can't be resumed, e.g. because the snapshot it sent has
+been destroyed, --abort-resume discards the partially received data on
+<DESTINATION> instead.
+
+Commands configured using the snapshots.send.hooks setting are executed before
+and after transferring snapshots. See zsm create --help for the format.
+
+The private key used to log in on HOST is read from --auth-key-file. The key
+of HOST must be listed in --known-hosts-file.`,
 		Args: cobra.RangeArgs(2, 3),
 		RunE: func(cmd *cobra.Command, args []string) error {
-			panic("implement me")
+			transferOpts := []snapshot.TransferOption{snapshot.TransferDestination(args[0])}
+
+			sm, err := cmdCfg.SnapshotManager()
+			if err != nil {
+				return err
+			}
+			if len(args) == 3 {
+				transferOpts = append(transferOpts, snapshot.TransferFileSystem(args[2]))
+			}
+			if abortResume {
+				transferOpts = append(transferOpts, snapshot.AbortResume())
+			}
+			excludes := cmdCfg.V.GetStringSlice(config.SnapshotsSendExcludeFileSystems)
+			for _, e := range excludes {
+				transferOpts = append(transferOpts, snapshot.TransferExcludeFileSystem(e))
+			}
+			hooks, ok, err := readHooks(cmdCfg.V, config.SnapshotsSendHooks)
+			if err != nil {
+				return err
+			}
+			if ok {
+				transferOpts = append(transferOpts, snapshot.TransferHooks(hooks))
+			}
+
+			host, err := cmdCfg.RemoteHost(args[0])
+			if err != nil {
+				return err
+			}
+			defer host.Close()
+
+			return snapshot.Transfer(args[1], host, sm, transferOpts...)
 		},
 	}
 	sendCmd.Flags().StringSliceP("exclude", "e", nil,
 		"File systems to exclude when sending snapshots.")
 	cmdCfg.V.BindPFlag(config.SnapshotsSendExcludeFileSystems, sendCmd.Flags().Lookup("exclude"))
+	sendCmd.Flags().BoolVar(&abortResume, "abort-resume", false,
+		"Abort interrupted transfers instead of resuming them.")
 
 	return sendCmd
 }
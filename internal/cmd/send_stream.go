@@ -0,0 +1,66 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/fhofherr/zsm/internal/snapshot"
+	"github.com/spf13/cobra"
+)
+
+func newSendStreamCommand(cmdCfg *zsmCommandConfig) *cobra.Command {
+	var reference, resumeToken string
+
+	sendStreamCmd := &cobra.Command{
+		Use:   "send-stream [SNAPSHOT]",
+		Short: "Write the data of a snapshot to stdout.",
+		Long: `Write the data of a snapshot to stdout.
+
+send-stream is called by zsm pull on the remote host. It is usually not
+necessary to call it directly.
+
+If --reference is passed only the data changed between the reference snapshot
+and [SNAPSHOT] is written. The reference may be a bookmark created by zsm
+bookmark. Only [SNAPSHOT] itself is written in this case.
+
+If --resume-token is passed, send-stream writes the remaining data of an
+interrupted transfer. [SNAPSHOT] must not be passed in this case.`,
+		Args: cobra.RangeArgs(0, 1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var sendOpts []snapshot.SendOption
+
+			sm, err := cmdCfg.SnapshotManager()
+			if err != nil {
+				return err
+			}
+			if resumeToken != "" {
+				if len(args) > 0 {
+					return fmt.Errorf("snapshot name and --resume-token are mutually exclusive")
+				}
+				return sm.ResumeSend(resumeToken, cmdCfg.Stdout())
+			}
+			if len(args) == 0 {
+				return fmt.Errorf("snapshot name missing")
+			}
+			name, ok := snapshot.ParseName(args[0])
+			if !ok {
+				return fmt.Errorf("invalid snapshot name: %s", args[0])
+			}
+			if ref, ok := snapshot.ParseBookmark(reference); ok {
+				sendOpts = append(sendOpts, snapshot.BookmarkReference(ref))
+			} else if reference != "" {
+				ref, ok := snapshot.ParseName(reference)
+				if !ok {
+					return fmt.Errorf("invalid reference snapshot name: %s", reference)
+				}
+				sendOpts = append(sendOpts, snapshot.Reference(ref))
+			}
+			return sm.SendSnapshot(name, cmdCfg.Stdout(), sendOpts...)
+		},
+	}
+	sendStreamCmd.Flags().StringVarP(&reference, "reference", "r", "",
+		"Write only data changed since the reference snapshot or bookmark.")
+	sendStreamCmd.Flags().StringVar(&resumeToken, "resume-token", "",
+		"Write the remaining data of the interrupted transfer identified by the token.")
+
+	return sendStreamCmd
+}
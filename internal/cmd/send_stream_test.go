@@ -0,0 +1,76 @@
+package cmd_test
+
+import (
+	"testing"
+
+	"github.com/fhofherr/zsm/internal/cmd"
+	"github.com/fhofherr/zsm/internal/snapshot"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestSendStream(t *testing.T) {
+	tests := []cmd.TestCase{
+		{
+			Name: "send snapshot",
+			MakeArgs: func(t *testing.T) []string {
+				return []string{"send-stream", "zsm_test@2020-04-10T09:45:58.564585005Z"}
+			},
+			MakeMSM: func(t *testing.T) *snapshot.MockManager {
+				name := snapshot.MustParseName(t, "zsm_test@2020-04-10T09:45:58.564585005Z")
+
+				sm := &snapshot.MockManager{}
+				sm.On("SendSnapshot", name, mock.Anything).Return(nil)
+				return sm
+			},
+		},
+		{
+			Name: "send snapshot with reference",
+			MakeArgs: func(t *testing.T) []string {
+				return []string{
+					"send-stream", "zsm_test@2020-04-10T09:45:58.564585005Z",
+					"--reference", "zsm_test@2020-04-10T09:44:58.564585005Z",
+				}
+			},
+			MakeMSM: func(t *testing.T) *snapshot.MockManager {
+				name := snapshot.MustParseName(t, "zsm_test@2020-04-10T09:45:58.564585005Z")
+				ref := snapshot.MustParseName(t, "zsm_test@2020-04-10T09:44:58.564585005Z")
+
+				sm := &snapshot.MockManager{}
+				sm.On("SendSnapshot", name, mock.Anything, mock.AnythingOfType("snapshot.SendOption")).Return(nil)
+				sm.ExpectSendOptions(snapshot.Reference(ref))
+				return sm
+			},
+		},
+		{
+			Name: "send snapshot with bookmark as reference",
+			MakeArgs: func(t *testing.T) []string {
+				return []string{
+					"send-stream", "zsm_test@2020-04-10T09:45:58.564585005Z",
+					"--reference", "zsm_test#2020-04-10T09:44:58.564585005Z",
+				}
+			},
+			MakeMSM: func(t *testing.T) *snapshot.MockManager {
+				name := snapshot.MustParseName(t, "zsm_test@2020-04-10T09:45:58.564585005Z")
+				ref := snapshot.MustParseName(t, "zsm_test@2020-04-10T09:44:58.564585005Z")
+
+				sm := &snapshot.MockManager{}
+				sm.On("SendSnapshot", name, mock.Anything, mock.AnythingOfType("snapshot.SendOption")).Return(nil)
+				sm.ExpectSendOptions(snapshot.BookmarkReference(ref))
+				return sm
+			},
+		},
+		{
+			Name: "resume send",
+			MakeArgs: func(t *testing.T) []string {
+				return []string{"send-stream", "--resume-token", "1-e604ea4bf-e0-789c63a2"}
+			},
+			MakeMSM: func(t *testing.T) *snapshot.MockManager {
+				sm := &snapshot.MockManager{}
+				sm.On("ResumeSend", "1-e604ea4bf-e0-789c63a2", mock.Anything).Return(nil)
+				return sm
+			},
+		},
+	}
+
+	cmd.RunTests(t, tests)
+}
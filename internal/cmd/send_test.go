@@ -0,0 +1,194 @@
+package cmd_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/fhofherr/zsm/internal/cmd"
+	"github.com/fhofherr/zsm/internal/snapshot"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestSend(t *testing.T) {
+	now := time.Now().UTC()
+	local := []snapshot.Name{
+		{FileSystem: "zsm_test", Timestamp: now},
+		{FileSystem: "zsm_test/fs_1", Timestamp: now},
+		{FileSystem: "zsm_test/fs_2", Timestamp: now},
+	}
+
+	tests := []cmd.TestCase{
+		{
+			Name: "send all file systems",
+			MakeArgs: func(t *testing.T) []string {
+				return []string{"send", "zsm@backup.example.com", "target_fs"}
+			},
+			MakeMSM: func(t *testing.T) *snapshot.MockManager {
+				sm := &snapshot.MockManager{}
+				sm.On("ListSnapshots").Return(local, nil)
+				for _, n := range local {
+					sm.On("SendSnapshot", n, mock.AnythingOfType("*io.PipeWriter")).Return(nil)
+				}
+				expectHolds(sm, snapshot.BaseHoldTag("zsm@backup.example.com", "target_fs"), local...)
+				expectBookmarks(sm, local...)
+				return sm
+			},
+			MakeRemoteMSM: func(t *testing.T) *snapshot.MockManager {
+				sm := &snapshot.MockManager{}
+				sm.On("ListSnapshots").Return([]snapshot.Name(nil), nil)
+				sm.On("ResumeTokens", "target_fs").Return(map[string]string(nil), nil)
+				for _, n := range local {
+					sm.On("ReceiveSnapshot", "target_fs", n, mock.AnythingOfType("*io.PipeReader")).Return(nil)
+				}
+				return sm
+			},
+			AssertRemoteMSM: func(t *testing.T, msm *snapshot.MockManager) {
+				assert.Equal(t, "zsm@backup.example.com", msm.Dest)
+			},
+		},
+		{
+			Name: "send source file system",
+			MakeArgs: func(t *testing.T) []string {
+				return []string{"send", "zsm@backup.example.com", "target_fs", "zsm_test/fs_1"}
+			},
+			MakeMSM: func(t *testing.T) *snapshot.MockManager {
+				sm := &snapshot.MockManager{}
+				sm.On("ListSnapshots").Return(local, nil)
+				sm.On("SendSnapshot", local[1], mock.AnythingOfType("*io.PipeWriter")).Return(nil)
+				expectHolds(sm, snapshot.BaseHoldTag("zsm@backup.example.com", "target_fs"), local[1])
+				expectBookmarks(sm, local[1])
+				return sm
+			},
+			MakeRemoteMSM: func(t *testing.T) *snapshot.MockManager {
+				sm := &snapshot.MockManager{}
+				sm.On("ListSnapshots").Return([]snapshot.Name(nil), nil)
+				sm.On("ResumeTokens", "target_fs").Return(map[string]string(nil), nil)
+				sm.On("ReceiveSnapshot", "target_fs", local[1], mock.AnythingOfType("*io.PipeReader")).Return(nil)
+				return sm
+			},
+		},
+		{
+			Name: "abort interrupted transfer",
+			MakeArgs: func(t *testing.T) []string {
+				return []string{"send", "--abort-resume", "zsm@backup.example.com", "target_fs", "zsm_test/fs_1"}
+			},
+			MakeMSM: func(t *testing.T) *snapshot.MockManager {
+				sm := &snapshot.MockManager{}
+				sm.On("ListSnapshots").Return(local, nil)
+				sm.On("SendSnapshot", local[1], mock.AnythingOfType("*io.PipeWriter")).Return(nil)
+				expectHolds(sm, snapshot.BaseHoldTag("zsm@backup.example.com", "target_fs"), local[1])
+				expectBookmarks(sm, local[1])
+				return sm
+			},
+			MakeRemoteMSM: func(t *testing.T) *snapshot.MockManager {
+				sm := &snapshot.MockManager{}
+				sm.On("ListSnapshots").Return([]snapshot.Name(nil), nil)
+				sm.On("ResumeTokens", "target_fs").Return(map[string]string{"zsm_test/fs_1": "1-e604ea4bf-e0"}, nil)
+				sm.On("AbortReceive", "target_fs", "zsm_test/fs_1").Return(nil)
+				sm.On("ReceiveSnapshot", "target_fs", local[1], mock.AnythingOfType("*io.PipeReader")).Return(nil)
+				return sm
+			},
+		},
+		{
+			Name: "exclude file systems",
+			MakeArgs: func(t *testing.T) []string {
+				return []string{"send", "-e", "zsm_test", "--exclude", "zsm_test/fs_2",
+					"zsm@backup.example.com", "target_fs"}
+			},
+			MakeMSM: func(t *testing.T) *snapshot.MockManager {
+				sm := &snapshot.MockManager{}
+				sm.On("ListSnapshots").Return(local, nil)
+				sm.On("SendSnapshot", local[1], mock.AnythingOfType("*io.PipeWriter")).Return(nil)
+				expectHolds(sm, snapshot.BaseHoldTag("zsm@backup.example.com", "target_fs"), local[1])
+				expectBookmarks(sm, local[1])
+				return sm
+			},
+			MakeRemoteMSM: func(t *testing.T) *snapshot.MockManager {
+				sm := &snapshot.MockManager{}
+				sm.On("ListSnapshots").Return([]snapshot.Name(nil), nil)
+				sm.On("ResumeTokens", "target_fs").Return(map[string]string(nil), nil)
+				sm.On("ReceiveSnapshot", "target_fs", local[1], mock.AnythingOfType("*io.PipeReader")).Return(nil)
+				return sm
+			},
+		},
+		{
+			Name: "exclude file systems matching pattern",
+			MakeArgs: func(t *testing.T) []string {
+				return []string{"send", "-e", "zsm_test/fs_*", "zsm@backup.example.com", "target_fs"}
+			},
+			MakeMSM: func(t *testing.T) *snapshot.MockManager {
+				sm := &snapshot.MockManager{}
+				sm.On("ListSnapshots").Return(local, nil)
+				sm.On("SendSnapshot", local[0], mock.AnythingOfType("*io.PipeWriter")).Return(nil)
+				expectHolds(sm, snapshot.BaseHoldTag("zsm@backup.example.com", "target_fs"), local[0])
+				expectBookmarks(sm, local[0])
+				return sm
+			},
+			MakeRemoteMSM: func(t *testing.T) *snapshot.MockManager {
+				sm := &snapshot.MockManager{}
+				sm.On("ListSnapshots").Return([]snapshot.Name(nil), nil)
+				sm.On("ResumeTokens", "target_fs").Return(map[string]string(nil), nil)
+				sm.On("ReceiveSnapshot", "target_fs", local[0], mock.AnythingOfType("*io.PipeReader")).Return(nil)
+				return sm
+			},
+		},
+		{
+			Name: "invalid exclude pattern",
+			MakeArgs: func(t *testing.T) []string {
+				return []string{"send", "-e", "zsm_test/[fs", "zsm@backup.example.com", "target_fs"}
+			},
+			MakeMSM: func(t *testing.T) *snapshot.MockManager {
+				return &snapshot.MockManager{}
+			},
+			ExpectedErr: errors.New(
+				`snapshots.send.exclude_file_systems: invalid pattern "zsm_test/[fs": syntax error in pattern`,
+			),
+		},
+		{
+			Name: "config file",
+			MakeArgs: func(t *testing.T) []string {
+				cfgFile := cmd.ConfigFile(t, "config.yaml")
+				return []string{"--config-file", cfgFile, "send", "zsm@backup.example.com", "target_fs"}
+			},
+			MakeMSM: func(t *testing.T) *snapshot.MockManager {
+				sm := &snapshot.MockManager{}
+				sm.On("ListSnapshots").Return(local, nil)
+				sm.On("SendSnapshot", local[0], mock.AnythingOfType("*io.PipeWriter")).Return(nil)
+				expectHolds(sm, snapshot.BaseHoldTag("zsm@backup.example.com", "target_fs"), local[0])
+				expectBookmarks(sm, local[0])
+				return sm
+			},
+			MakeRemoteMSM: func(t *testing.T) *snapshot.MockManager {
+				sm := &snapshot.MockManager{}
+				sm.On("ListSnapshots").Return([]snapshot.Name(nil), nil)
+				sm.On("ResumeTokens", "target_fs").Return(map[string]string(nil), nil)
+				sm.On("ReceiveSnapshot", "target_fs", local[0], mock.AnythingOfType("*io.PipeReader")).Return(nil)
+				return sm
+			},
+		},
+	}
+
+	cmd.RunTests(t, tests)
+}
+
+// expectBookmarks registers the calls Transfer makes to bookmark the
+// snapshots it sends.
+func expectBookmarks(sm *snapshot.MockManager, names ...snapshot.Name) {
+	sm.On("ListBookmarks").Return([]snapshot.Name(nil), nil)
+	for _, n := range names {
+		sm.On("BookmarkSnapshot", n).Return(nil)
+	}
+}
+
+// expectHolds registers the calls Transfer makes to hold the snapshots it
+// sends and to move the base hold to them.
+func expectHolds(sm *snapshot.MockManager, baseTag string, names ...snapshot.Name) {
+	for _, n := range names {
+		sm.On("SnapshotHolds", n).Return([]string(nil), nil)
+		sm.On("HoldSnapshots", snapshot.SendHoldTag, []snapshot.Name{n}).Return(nil)
+		sm.On("ReleaseSnapshots", snapshot.SendHoldTag, []snapshot.Name{n}).Return(nil)
+		sm.On("HoldSnapshots", baseTag, []snapshot.Name{n}).Return(nil)
+	}
+}
@@ -8,6 +8,7 @@ import (
 
 	"github.com/fhofherr/zsm/internal/config"
 	"github.com/fhofherr/zsm/internal/snapshot"
+	"github.com/stretchr/testify/assert"
 )
 
 // TestCase tests the zsm command.
@@ -17,6 +18,15 @@ type TestCase struct {
 	MakeMSM      func(t *testing.T) *snapshot.MockManager
 	AssertMSM    func(t *testing.T, msm *snapshot.MockManager)
 	AssertOutput func(t *testing.T, stdout, stderr string)
+
+	// ExpectedErr is the error the command is expected to fail with. The
+	// command is expected to succeed if ExpectedErr is nil.
+	ExpectedErr error
+
+	// MakeRemoteMSM creates the mock used in place of a remote host. It is
+	// optional for commands not connecting to a remote host.
+	MakeRemoteMSM   func(t *testing.T) *snapshot.MockManager
+	AssertRemoteMSM func(t *testing.T, msm *snapshot.MockManager)
 }
 
 func (tt *TestCase) run(t *testing.T) {
@@ -36,24 +46,40 @@ func (tt *TestCase) run(t *testing.T) {
 	msm := tt.MakeMSM(t)
 	msm.Test(t)
 
+	remoteMSM := &snapshot.MockManager{}
+	if tt.MakeRemoteMSM != nil {
+		remoteMSM = tt.MakeRemoteMSM(t)
+	}
+	remoteMSM.Test(t)
+
 	smf := mockSnapshotManagerFactory(msm)
+	rhf := mockRemoteHostFactory(remoteMSM)
 	zsmCmd := NewZSMCommand(
 		WithSnapshotManagerFactory(smf),
+		WithRemoteHostFactory(rhf),
 		WithStdout(&stdout),
 		WithStderr(&stderr),
 	)
 
 	zsmCmd.SetArgs(tt.MakeArgs(t))
-	if err := zsmCmd.Execute(); err != nil {
+	err := zsmCmd.Execute()
+	if tt.ExpectedErr != nil {
+		assert.EqualError(t, err, tt.ExpectedErr.Error())
+	} else if err != nil {
 		t.Errorf("zsm failed: %v", err)
 	}
 	msm.AssertExpectations(t)
 	msm.AssertCreateOptions(t)
+	msm.AssertCleanOptions(t)
 	msm.AssertSendOptions(t)
+	remoteMSM.AssertExpectations(t)
 
 	if tt.AssertMSM != nil {
 		tt.AssertMSM(t, msm)
 	}
+	if tt.AssertRemoteMSM != nil {
+		tt.AssertRemoteMSM(t, remoteMSM)
+	}
 	if tt.AssertOutput != nil {
 		tt.AssertOutput(t, stdout.String(), stderr.String())
 	}
@@ -81,6 +107,22 @@ func ConfigFile(t *testing.T, name string) string {
 func mockSnapshotManagerFactory(msm *snapshot.MockManager) SnapshotManagerFactory {
 	return func(cfg *zsmCommandConfig) (SnapshotManager, error) {
 		msm.ZFS = cfg.V.GetString(config.ZFSCmd)
+		msm.DatasetTypes = cfg.V.GetStringSlice(config.SnapshotsDatasetTypes)
 		return msm, nil
 	}
 }
+
+type mockRemoteHost struct {
+	*snapshot.MockManager
+}
+
+func (h mockRemoteHost) Close() error {
+	return nil
+}
+
+func mockRemoteHostFactory(msm *snapshot.MockManager) RemoteHostFactory {
+	return func(cfg *zsmCommandConfig, dest string) (RemoteHost, error) {
+		msm.Dest = dest
+		return mockRemoteHost{msm}, nil
+	}
+}
@@ -4,8 +4,10 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"time"
 
 	"github.com/fhofherr/zsm/internal/config"
+	"github.com/fhofherr/zsm/internal/remote"
 	"github.com/fhofherr/zsm/internal/snapshot"
 	"github.com/fhofherr/zsm/internal/zfs"
 	"github.com/spf13/cobra"
@@ -14,10 +16,24 @@ import (
 
 // SnapshotManager represents a type that is capable of managing zfs snapshots.
 type SnapshotManager interface {
-	CreateSnapshots(...snapshot.CreateOption) error
-	CleanSnapshots(snapshot.BucketConfig) error
+	CreateSnapshots(...snapshot.CreateOption) ([]string, error)
+	CleanSnapshots(snapshot.BucketConfigResolver, ...snapshot.CleanOption) ([]snapshot.Name, error)
+	PlanClean(snapshot.BucketConfigResolver, ...snapshot.CleanOption) ([]snapshot.CleanPlan, error)
 	ListSnapshots() ([]snapshot.Name, error)
+	ListBookmarks() ([]snapshot.Name, error)
+	BookmarkSnapshot(snapshot.Name) error
+	BookmarkGUID(snapshot.Name) (uint64, error)
+	HoldSnapshots(string, ...snapshot.Name) error
+	ReleaseSnapshots(string, ...snapshot.Name) error
+	SnapshotHolds(snapshot.Name) ([]string, error)
+	Expiries() (map[snapshot.Name]time.Time, error)
 	ReceiveSnapshot(string, snapshot.Name, io.Reader) error
+	SendSnapshot(snapshot.Name, io.Writer, ...snapshot.SendOption) error
+	SnapshotGUID(snapshot.Name) (uint64, error)
+	ResumeTokens(string) (map[string]string, error)
+	ResumeReceive(string, string, io.Reader) error
+	AbortReceive(string, string) error
+	ResumeSend(string, io.Writer) error
 }
 
 // SnapshotManagerFactory creates a SnapshotManager from SnapshotManagerConfig.
@@ -32,15 +48,62 @@ func defaultSnapshotManagerFactory(cfg *zsmCommandConfig) (SnapshotManager, erro
 	if err != nil {
 		return nil, fmt.Errorf("default snapshot manager factory: %w", err)
 	}
+	datasetTypes, err := zfs.ParseDatasetTypes(cfg.V.GetStringSlice(config.SnapshotsDatasetTypes)...)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", config.SnapshotsDatasetTypes, err)
+	}
 	return &snapshot.Manager{
-		ZFS: zfsCmd,
+		ZFS:          zfsCmd,
+		DatasetTypes: datasetTypes,
 	}, nil
 }
 
+// RemoteHost represents a remote host zsm exchanges snapshots with.
+type RemoteHost interface {
+	snapshot.ListerReceiver
+	snapshot.Sender
+	snapshot.ResumableSender
+	snapshot.Holder
+	snapshot.Bookmarker
+	Close() error
+}
+
+// RemoteHostFactory connects to the remote host at dest.
+//
+// dest has the form <USER>@<HOST>[:PORT].
+type RemoteHostFactory func(cfg *zsmCommandConfig, dest string) (RemoteHost, error)
+
+func defaultRemoteHostFactory(cfg *zsmCommandConfig, dest string) (RemoteHost, error) {
+	user, addr, err := remote.ParseDestination(dest)
+	if err != nil {
+		return nil, err
+	}
+	authKey, err := remote.ReadAuthKey(cfg.V.GetString(config.SSHAuthKeyFile))
+	if err != nil {
+		return nil, err
+	}
+	hostKeyCallback, err := remote.ReadKnownHosts(cfg.V.GetString(config.SSHKnownHostsFile))
+	if err != nil {
+		return nil, err
+	}
+	host := &remote.Host{
+		User:            user,
+		Addr:            addr,
+		AuthKey:         authKey,
+		HostKeyCallback: hostKeyCallback,
+		RemoteZSM:       cfg.V.GetString(config.RemoteZSMCmd),
+	}
+	if err := host.Dial(); err != nil {
+		return nil, fmt.Errorf("%s: %w", dest, err)
+	}
+	return host, nil
+}
+
 type zsmCommandConfig struct {
-	smFactory SnapshotManagerFactory
-	stdout    io.Writer
-	stderr    io.Writer
+	smFactory         SnapshotManagerFactory
+	remoteHostFactory RemoteHostFactory
+	stdout            io.Writer
+	stderr            io.Writer
 
 	V *viper.Viper
 }
@@ -57,6 +120,18 @@ func (c *zsmCommandConfig) SnapshotManager() (SnapshotManager, error) {
 	return sm, nil
 }
 
+func (c *zsmCommandConfig) RemoteHost(dest string) (RemoteHost, error) {
+	remoteHostFactory := c.remoteHostFactory
+	if remoteHostFactory == nil {
+		remoteHostFactory = defaultRemoteHostFactory
+	}
+	host, err := remoteHostFactory(c, dest)
+	if err != nil {
+		return nil, fmt.Errorf("connect to remote host: %w", err)
+	}
+	return host, nil
+}
+
 func (c *zsmCommandConfig) Stdout() io.Writer {
 	if c.stdout == nil {
 		return os.Stdout
@@ -82,6 +157,14 @@ func WithSnapshotManagerFactory(smf SnapshotManagerFactory) ZSMCommandOption {
 	}
 }
 
+// WithRemoteHostFactory tells NewZSMCommand to use the passed
+// RemoteHostFactory instead of a default value.
+func WithRemoteHostFactory(rhf RemoteHostFactory) ZSMCommandOption {
+	return func(o *zsmCommandConfig) {
+		o.remoteHostFactory = rhf
+	}
+}
+
 // WithStdout sets the standard output used by zsm.
 func WithStdout(stdout io.Writer) ZSMCommandOption {
 	return func(o *zsmCommandConfig) {
@@ -108,11 +191,19 @@ func NewZSMCommand(opts ...ZSMCommandOption) *cobra.Command {
 	}
 
 	rootCmd := newRootCmd(cmdCfg)
+	rootCmd.AddCommand(newBookmarkCommand(cmdCfg))
 	rootCmd.AddCommand(newCreateCommand(cmdCfg))
+	rootCmd.AddCommand(newGUIDCommand(cmdCfg))
+	rootCmd.AddCommand(newHoldCommand(cmdCfg))
+	rootCmd.AddCommand(newHoldsCommand(cmdCfg))
 	rootCmd.AddCommand(newCleanCommand(cmdCfg))
 	rootCmd.AddCommand(newListCommand(cmdCfg))
 	rootCmd.AddCommand(newReceiveCommand(cmdCfg))
+	rootCmd.AddCommand(newReleaseCommand(cmdCfg))
+	rootCmd.AddCommand(newResumeTokensCommand(cmdCfg))
 	rootCmd.AddCommand(newSendCommand(cmdCfg))
+	rootCmd.AddCommand(newSendStreamCommand(cmdCfg))
+	rootCmd.AddCommand(newPullCommand(cmdCfg))
 	rootCmd.AddCommand(newVersionCommand(cmdCfg))
 
 	return rootCmd
@@ -7,8 +7,24 @@ const (
 	ZFSCmd        = "zfs.cmd"
 	DefaultZFSCmd = "/sbin/zfs"
 
+	SSHAuthKeyFile        = "ssh.auth_key_file"
+	DefaultSSHAuthKeyFile = "~/.ssh/id_rsa"
+
+	SSHKnownHostsFile        = "ssh.known_hosts_file"
+	DefaultSSHKnownHostsFile = "~/.ssh/known_hosts"
+
+	RemoteZSMCmd        = "remote.zsm_cmd"
+	DefaultRemoteZSMCmd = "zsm"
+
 	SnapshotsCreateExcludeFileSystems = "snapshots.create.exclude_file_systems"
+	SnapshotsCreateConsistencyGroups  = "snapshots.create.consistency_groups"
+	SnapshotsCreateSkipUnchanged      = "snapshots.create.skip_unchanged"
+	SnapshotsCreateHooks              = "snapshots.create.hooks"
+	SnapshotsCleanHooks               = "snapshots.clean.hooks"
 	SnapshotsSendExcludeFileSystems   = "snapshots.send.exclude_file_systems"
+	SnapshotsSendHooks                = "snapshots.send.hooks"
+	SnapshotsPullExcludeFileSystems   = "snapshots.pull.exclude_file_systems"
+	SnapshotsPullHooks                = "snapshots.pull.hooks"
 
 	SnapshotsKeepMinute        = "snapshots.keep.minute"
 	DefaultSnapshotsKeepMinute = 60
@@ -27,8 +43,39 @@ const (
 
 	SnapshotsKeepYear        = "snapshots.keep.year"
 	DefaultSnapshotsKeepYear = 5
+
+	SnapshotsKeepBuckets = "snapshots.keep.buckets"
+
+	SnapshotsKeepWithin = "snapshots.keep.within"
+	SnapshotsKeepMaxAge = "snapshots.keep.max_age"
+
+	SnapshotsKeepCalendar        = "snapshots.keep.calendar"
+	DefaultSnapshotsKeepCalendar = "sliding"
+
+	SnapshotsKeepTimeZone        = "snapshots.keep.time_zone"
+	DefaultSnapshotsKeepTimeZone = "Local"
+
+	SnapshotsPolicies = "snapshots.policies"
+
+	SnapshotsDatasetTypes = "snapshots.dataset_types"
+
+	SnapshotsCreateBestEffort        = "snapshots.create.best_effort"
+	DefaultSnapshotsCreateBestEffort = true
+
+	SnapshotsCleanBestEffort        = "snapshots.clean.best_effort"
+	DefaultSnapshotsCleanBestEffort = true
 )
 
+// DefaultSnapshotsDatasetTypes are the types of datasets zsm creates
+// snapshots of by default.
+var DefaultSnapshotsDatasetTypes = []string{"filesystem"}
+
 func setDefaults(v *viper.Viper) {
 	v.SetDefault(ZFSCmd, DefaultZFSCmd)
+	v.SetDefault(SSHAuthKeyFile, DefaultSSHAuthKeyFile)
+	v.SetDefault(SSHKnownHostsFile, DefaultSSHKnownHostsFile)
+	v.SetDefault(RemoteZSMCmd, DefaultRemoteZSMCmd)
+	v.SetDefault(SnapshotsDatasetTypes, DefaultSnapshotsDatasetTypes)
+	v.SetDefault(SnapshotsCreateBestEffort, DefaultSnapshotsCreateBestEffort)
+	v.SetDefault(SnapshotsCleanBestEffort, DefaultSnapshotsCleanBestEffort)
 }
@@ -5,12 +5,39 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"net"
+	"strconv"
+	"strings"
 	"sync"
 
 	"github.com/fhofherr/zsm/internal/snapshot"
 	gossh "golang.org/x/crypto/ssh"
 )
 
+// DefaultPort is the port used if a destination does not specify a port.
+const DefaultPort = "22"
+
+// ParseDestination parses a destination of the form <USER>@<HOST>[:PORT].
+//
+// It returns the user and the address of the destination. If the destination
+// does not specify a port DefaultPort is used.
+func ParseDestination(dest string) (string, string, error) {
+	idx := strings.LastIndex(dest, "@")
+	if idx < 1 || idx == len(dest)-1 {
+		return "", "", fmt.Errorf("invalid destination: %s", dest)
+	}
+	user, hostPort := dest[:idx], dest[idx+1:]
+	host, port, err := net.SplitHostPort(hostPort)
+	if err != nil {
+		// hostPort does not contain a port. Use the default port.
+		host, port = hostPort, DefaultPort
+	}
+	if host == "" || port == "" {
+		return "", "", fmt.Errorf("invalid destination: %s", dest)
+	}
+	return user, net.JoinHostPort(host, port), nil
+}
+
 // Host represents a remote host on which ZSM is installed.
 type Host struct {
 	User    string
@@ -18,6 +45,10 @@ type Host struct {
 	AuthKey gossh.Signer
 	HostKey gossh.PublicKey
 
+	// HostKeyCallback is used to verify the key of the remote host. If it is
+	// nil the remote host must present HostKey.
+	HostKeyCallback gossh.HostKeyCallback
+
 	RemoteZSM string
 
 	client *gossh.Client
@@ -33,12 +64,16 @@ func (h *Host) Dial() error {
 		// already connected
 		return nil
 	}
+	hostKeyCallback := h.HostKeyCallback
+	if hostKeyCallback == nil {
+		hostKeyCallback = gossh.FixedHostKey(h.HostKey)
+	}
 	config := &gossh.ClientConfig{
 		User: h.User,
 		Auth: []gossh.AuthMethod{
 			gossh.PublicKeys(h.AuthKey),
 		},
-		HostKeyCallback: gossh.FixedHostKey(h.HostKey),
+		HostKeyCallback: hostKeyCallback,
 	}
 	client, err := gossh.Dial("tcp", h.Addr, config)
 	if err != nil {
@@ -78,15 +113,31 @@ func (h *Host) Close() error {
 
 // ListSnapshots lists all snapshots available on the remote host.
 func (h *Host) ListSnapshots() ([]snapshot.Name, error) {
-	var (
-		stdout   bytes.Buffer
-		parseBuf bytes.Buffer
-	)
+	var stdout bytes.Buffer
+
+	zsmListCmd := h.zsmCommand("list", "-o", "jsonl")
+	if err := h.runRemoteZSM("list", zsmListCmd, &stdout, nil); err != nil {
+		return nil, err
+	}
+	return parseNamesJSONL(&stdout)
+}
+
+// ListBookmarks returns the names of all snapshots zsm created a bookmark of
+// on the remote host.
+func (h *Host) ListBookmarks() ([]snapshot.Name, error) {
+	var stdout bytes.Buffer
 
-	zsmListCmd := fmt.Sprintf("%s list -o jsonl", h.RemoteZSM)
-	if err := h.runRemoteZSM(zsmListCmd, &stdout, nil); err != nil {
+	zsmListCmd := h.zsmCommand("list", "--bookmarks", "-o", "jsonl")
+	if err := h.runRemoteZSM("list", zsmListCmd, &stdout, nil); err != nil {
 		return nil, err
 	}
+	return parseNamesJSONL(&stdout)
+}
+
+// parseNamesJSONL parses the names printed by zsm list -o jsonl.
+func parseNamesJSONL(stdout *bytes.Buffer) ([]snapshot.Name, error) {
+	var parseBuf bytes.Buffer
+
 	// Bail out if the remote side has no snapshots.
 	if stdout.Len() == 0 {
 		return nil, nil
@@ -110,6 +161,93 @@ func (h *Host) ListSnapshots() ([]snapshot.Name, error) {
 	return names, nil
 }
 
+// SnapshotGUID returns the guid of the snapshot with the passed name on the
+// remote host.
+func (h *Host) SnapshotGUID(name snapshot.Name) (uint64, error) {
+	return h.guid(name.String())
+}
+
+// BookmarkGUID returns the guid of the bookmark of the snapshot with the
+// passed name on the remote host.
+func (h *Host) BookmarkGUID(name snapshot.Name) (uint64, error) {
+	return h.guid(name.Bookmark())
+}
+
+// guid returns the guid of the snapshot or bookmark with name on the remote
+// host.
+func (h *Host) guid(name string) (uint64, error) {
+	var stdout bytes.Buffer
+
+	zsmGUIDCmd := h.zsmCommand("guid", name)
+	if err := h.runRemoteZSM("guid", zsmGUIDCmd, &stdout, nil); err != nil {
+		return 0, err
+	}
+	value := strings.TrimSpace(stdout.String())
+	guid, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("remote zsm: invalid guid: %s", value)
+	}
+	return guid, nil
+}
+
+// BookmarkSnapshot creates a bookmark of the snapshot with the passed name on
+// the remote host.
+func (h *Host) BookmarkSnapshot(name snapshot.Name) error {
+	zsmBookmarkCmd := h.zsmCommand("bookmark", name.String())
+	if err := h.runRemoteZSM("bookmark", zsmBookmarkCmd, nil, nil); err != nil {
+		return err
+	}
+	return nil
+}
+
+// HoldSnapshots places a user hold with tag on each of the snapshots with
+// the passed names on the remote host.
+func (h *Host) HoldSnapshots(tag string, names ...snapshot.Name) error {
+	zsmHoldCmd := h.zsmCommand("hold", append([]string{"--tag", tag}, nameStrings(names)...)...)
+	if err := h.runRemoteZSM("hold", zsmHoldCmd, nil, nil); err != nil {
+		return err
+	}
+	return nil
+}
+
+// ReleaseSnapshots removes the user hold with tag from each of the snapshots
+// with the passed names on the remote host.
+func (h *Host) ReleaseSnapshots(tag string, names ...snapshot.Name) error {
+	zsmReleaseCmd := h.zsmCommand("release", append([]string{"--tag", tag}, nameStrings(names)...)...)
+	if err := h.runRemoteZSM("release", zsmReleaseCmd, nil, nil); err != nil {
+		return err
+	}
+	return nil
+}
+
+// SnapshotHolds returns the tags of all user holds on the snapshot with the
+// passed name on the remote host.
+func (h *Host) SnapshotHolds(name snapshot.Name) ([]string, error) {
+	var stdout bytes.Buffer
+
+	zsmHoldsCmd := h.zsmCommand("holds", name.String())
+	if err := h.runRemoteZSM("holds", zsmHoldsCmd, &stdout, nil); err != nil {
+		return nil, err
+	}
+	var tags []string
+	for _, line := range strings.Split(stdout.String(), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		tags = append(tags, line)
+	}
+	return tags, nil
+}
+
+func nameStrings(names []snapshot.Name) []string {
+	ss := make([]string, len(names))
+	for i, n := range names {
+		ss[i] = n.String()
+	}
+	return ss
+}
+
 // ReceiveSnapshot lets the remote host receive a snapshot with the passed name.
 //
 // The snapshot data is read from r. It is written to targetFS with the name
@@ -122,14 +260,117 @@ func (h *Host) ListSnapshots() ([]snapshot.Name, error) {
 //
 // The remote host then writes the snapshot to target_fs/zsm_test@2020-04-10T09:45:58.564585005Z
 func (h *Host) ReceiveSnapshot(targetFS string, name snapshot.Name, r io.Reader) error {
-	zsmRecvCmd := fmt.Sprintf("%s receive %s %s", h.RemoteZSM, targetFS, name)
-	if err := h.runRemoteZSM(zsmRecvCmd, nil, r); err != nil {
+	zsmRecvCmd := h.zsmCommand("receive", targetFS, name.String())
+	if err := h.runRemoteZSM("receive", zsmRecvCmd, nil, r); err != nil {
+		return err
+	}
+	return nil
+}
+
+// SendSnapshot lets the remote host write the snapshot with the passed name
+// to w.
+//
+// By passing the snapshot.Reference option only data changed between the
+// passed reference and name is written to w.
+func (h *Host) SendSnapshot(name snapshot.Name, w io.Writer, opts ...snapshot.SendOption) error {
+	args := []string{name.String()}
+	if ref, ok := snapshot.ReferenceOf(opts...); ok {
+		args = append(args, "--reference", ref.String())
+	}
+	if ref, ok := snapshot.BookmarkReferenceOf(opts...); ok {
+		args = append(args, "--reference", ref.Bookmark())
+	}
+	zsmSendCmd := h.zsmCommand("send-stream", args...)
+	if err := h.runRemoteZSM("send-stream", zsmSendCmd, w, nil); err != nil {
 		return err
 	}
 	return nil
 }
 
-func (h *Host) runRemoteZSM(cmd string, stdout io.Writer, stdin io.Reader) error {
+// ResumeTokens returns the tokens of all interrupted receives below targetFS
+// on the remote host.
+//
+// The keys of the returned map are the names of the file systems relative to
+// targetFS.
+func (h *Host) ResumeTokens(targetFS string) (map[string]string, error) {
+	var stdout bytes.Buffer
+
+	zsmTokensCmd := h.zsmCommand("resume-tokens", targetFS)
+	if err := h.runRemoteZSM("resume-tokens", zsmTokensCmd, &stdout, nil); err != nil {
+		return nil, err
+	}
+	tokens := make(map[string]string)
+	for _, line := range strings.Split(stdout.String(), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("remote zsm: invalid resume token: %s", line)
+		}
+		tokens[fields[0]] = fields[1]
+	}
+	return tokens, nil
+}
+
+// ResumeReceive lets the remote host resume an interrupted receive into the
+// file system fs below targetFS.
+//
+// The data read from r must have been created by calling ResumeSend with the
+// resume token of the file system.
+func (h *Host) ResumeReceive(targetFS, fs string, r io.Reader) error {
+	zsmRecvCmd := h.zsmCommand("receive", "--resume", targetFS, fs)
+	if err := h.runRemoteZSM("receive", zsmRecvCmd, nil, r); err != nil {
+		return err
+	}
+	return nil
+}
+
+// AbortReceive lets the remote host discard the partially received state of
+// the interrupted receive into the file system fs below targetFS.
+func (h *Host) AbortReceive(targetFS, fs string) error {
+	zsmRecvCmd := h.zsmCommand("receive", "--abort", targetFS, fs)
+	if err := h.runRemoteZSM("receive", zsmRecvCmd, nil, nil); err != nil {
+		return err
+	}
+	return nil
+}
+
+// ResumeSend lets the remote host write the remaining data of an interrupted
+// send to w.
+func (h *Host) ResumeSend(token string, w io.Writer) error {
+	zsmSendCmd := h.zsmCommand("send-stream", "--resume-token", token)
+	if err := h.runRemoteZSM("send-stream", zsmSendCmd, w, nil); err != nil {
+		return err
+	}
+	return nil
+}
+
+// zsmCommand returns the command line which calls the sub command subCmd of
+// the remote zsm with args. The remote shell splits the command line into
+// words, which is why zsmCommand quotes every word.
+func (h *Host) zsmCommand(subCmd string, args ...string) string {
+	words := make([]string, 0, len(args)+2)
+	words = append(words, shellQuote(h.RemoteZSM), subCmd)
+	for _, arg := range args {
+		words = append(words, shellQuote(arg))
+	}
+	return strings.Join(words, " ")
+}
+
+// shellQuote quotes s for a POSIX shell. Words consisting only of characters
+// without special meaning to the shell are returned as they are.
+func shellQuote(s string) string {
+	if s != "" && strings.Trim(s, shellSafeChars) == "" {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+const shellSafeChars = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789@%+=:,./_-"
+
+func (h *Host) runRemoteZSM(subCmd, cmd string, stdout io.Writer, stdin io.Reader) error {
 	var stderr bytes.Buffer
 
 	sess, err := h.newSession()
@@ -148,7 +389,7 @@ func (h *Host) runRemoteZSM(cmd string, stdout io.Writer, stdin io.Reader) error
 			return err
 		}
 		return &Error{
-			SubCommand: "list",
+			SubCommand: subCmd,
 			ExitCode:   exitErr.ExitStatus(),
 			Stderr:     stderr.String(),
 		}
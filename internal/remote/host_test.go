@@ -2,6 +2,7 @@ package remote_test
 
 import (
 	"bytes"
+	"io/ioutil"
 	"testing"
 	"time"
 
@@ -169,3 +170,569 @@ func TestHost_ReceiveSnapshot(t *testing.T) {
 
 	remote.RunTests(t, tests)
 }
+
+func TestParseDestination(t *testing.T) {
+	tests := []struct {
+		name         string
+		dest         string
+		expectedUser string
+		expectedAddr string
+		errMsg       string
+	}{
+		{
+			name:         "user and host",
+			dest:         "zsm@backup.example.com",
+			expectedUser: "zsm",
+			expectedAddr: "backup.example.com:22",
+		},
+		{
+			name:         "user host and port",
+			dest:         "zsm@backup.example.com:2222",
+			expectedUser: "zsm",
+			expectedAddr: "backup.example.com:2222",
+		},
+		{
+			name:         "ipv6 host and port",
+			dest:         "zsm@[::1]:2222",
+			expectedUser: "zsm",
+			expectedAddr: "[::1]:2222",
+		},
+		{
+			name:   "missing user",
+			dest:   "backup.example.com",
+			errMsg: "invalid destination: backup.example.com",
+		},
+		{
+			name:   "missing host",
+			dest:   "zsm@",
+			errMsg: "invalid destination: zsm@",
+		},
+		{
+			name:   "missing port",
+			dest:   "zsm@backup.example.com:",
+			errMsg: "invalid destination: zsm@backup.example.com:",
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			user, addr, err := remote.ParseDestination(tt.dest)
+			if tt.errMsg != "" {
+				assert.EqualError(t, err, tt.errMsg)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expectedUser, user)
+			assert.Equal(t, tt.expectedAddr, addr)
+		})
+	}
+}
+
+func TestHost_SendSnapshot(t *testing.T) {
+	tests := []remote.TestCase{
+		{
+			Name: "send snapshot data",
+			Call: func(t *testing.T, host *remote.Host) error {
+				var out bytes.Buffer
+
+				if err := host.Dial(); err != nil {
+					t.Fatal(err)
+				}
+				defer host.Close()
+
+				name := snapshot.MustParseName(t, "zsm_test@2020-04-10T09:45:58.564585005Z")
+				if err := host.SendSnapshot(name, &out); err != nil {
+					return err
+				}
+				assert.Equal(t, "this is the snapshot data", out.String())
+				return nil
+			},
+			ZSMCommand: []string{
+				"/path/to/remote/zsm",
+				"send-stream",
+				"zsm_test@2020-04-10T09:45:58.564585005Z",
+			},
+			Stdout: func(t *testing.T) []byte {
+				return []byte("this is the snapshot data")
+			},
+		},
+		{
+			Name: "send snapshot data with reference",
+			Call: func(t *testing.T, host *remote.Host) error {
+				var out bytes.Buffer
+
+				if err := host.Dial(); err != nil {
+					t.Fatal(err)
+				}
+				defer host.Close()
+
+				name := snapshot.MustParseName(t, "zsm_test@2020-04-10T09:45:58.564585005Z")
+				ref := snapshot.MustParseName(t, "zsm_test@2020-04-10T09:44:58.564585005Z")
+				if err := host.SendSnapshot(name, &out, snapshot.Reference(ref)); err != nil {
+					return err
+				}
+				assert.Equal(t, "this is the snapshot data", out.String())
+				return nil
+			},
+			ZSMCommand: []string{
+				"/path/to/remote/zsm",
+				"send-stream",
+				"zsm_test@2020-04-10T09:45:58.564585005Z",
+				"--reference",
+				"zsm_test@2020-04-10T09:44:58.564585005Z",
+			},
+			Stdout: func(t *testing.T) []byte {
+				return []byte("this is the snapshot data")
+			},
+		},
+		{
+			Name: "send snapshot data with bookmark as reference",
+			Call: func(t *testing.T, host *remote.Host) error {
+				if err := host.Dial(); err != nil {
+					t.Fatal(err)
+				}
+				defer host.Close()
+
+				name := snapshot.MustParseName(t, "zsm_test@2020-04-10T09:45:58.564585005Z")
+				ref := snapshot.MustParseName(t, "zsm_test@2020-04-10T09:44:58.564585005Z")
+				return host.SendSnapshot(name, ioutil.Discard, snapshot.BookmarkReference(ref))
+			},
+			ZSMCommand: []string{
+				"/path/to/remote/zsm",
+				"send-stream",
+				"zsm_test@2020-04-10T09:45:58.564585005Z",
+				"--reference",
+				"zsm_test#2020-04-10T09:44:58.564585005Z",
+			},
+		},
+		{
+			Name: "remote host returns error",
+			Call: func(t *testing.T, host *remote.Host) error {
+				if err := host.Dial(); err != nil {
+					t.Fatal(err)
+				}
+				defer host.Close()
+
+				name := snapshot.MustParseName(t, "zsm_test@2020-04-10T09:45:58.564585005Z")
+				return host.SendSnapshot(name, ioutil.Discard)
+			},
+			ZSMExitCode: 10,
+			Stderr: func(t *testing.T) []byte {
+				return []byte("remote zsm send-stream wrote this to stderr")
+			},
+		},
+	}
+
+	remote.RunTests(t, tests)
+}
+
+func TestHost_SnapshotGUID(t *testing.T) {
+	tests := []remote.TestCase{
+		{
+			Name: "get guid",
+			Call: func(t *testing.T, host *remote.Host) error {
+				if err := host.Dial(); err != nil {
+					t.Fatal(err)
+				}
+				defer host.Close()
+
+				name := snapshot.MustParseName(t, "zsm_test@2020-04-10T09:45:58.564585005Z")
+				guid, err := host.SnapshotGUID(name)
+				if err != nil {
+					return err
+				}
+				assert.Equal(t, uint64(6917529027641081856), guid)
+				return nil
+			},
+			ZSMCommand: []string{
+				"/path/to/remote/zsm",
+				"guid",
+				"zsm_test@2020-04-10T09:45:58.564585005Z",
+			},
+			Stdout: func(t *testing.T) []byte {
+				return []byte("6917529027641081856\n")
+			},
+		},
+		{
+			Name: "remote host returns error",
+			Call: func(t *testing.T, host *remote.Host) error {
+				if err := host.Dial(); err != nil {
+					t.Fatal(err)
+				}
+				defer host.Close()
+
+				name := snapshot.MustParseName(t, "zsm_test@2020-04-10T09:45:58.564585005Z")
+				_, err := host.SnapshotGUID(name)
+				return err
+			},
+			ZSMExitCode: 1,
+			Stderr: func(t *testing.T) []byte {
+				return []byte("remote zsm guid wrote this to stderr")
+			},
+		},
+	}
+
+	remote.RunTests(t, tests)
+}
+
+func TestHost_ResumeTokens(t *testing.T) {
+	tests := []remote.TestCase{
+		{
+			Name: "get resume tokens",
+			Call: func(t *testing.T, host *remote.Host) error {
+				if err := host.Dial(); err != nil {
+					t.Fatal(err)
+				}
+				defer host.Close()
+
+				tokens, err := host.ResumeTokens("target_fs")
+				if err != nil {
+					return err
+				}
+				assert.Equal(t, map[string]string{"zsm_test/fs_1": "1-e604ea4bf-e0-789c63a2"}, tokens)
+				return nil
+			},
+			ZSMCommand: []string{"/path/to/remote/zsm", "resume-tokens", "target_fs"},
+			Stdout: func(t *testing.T) []byte {
+				return []byte("zsm_test/fs_1\t1-e604ea4bf-e0-789c63a2\n")
+			},
+		},
+		{
+			Name: "remote host returns error",
+			Call: func(t *testing.T, host *remote.Host) error {
+				if err := host.Dial(); err != nil {
+					t.Fatal(err)
+				}
+				defer host.Close()
+
+				_, err := host.ResumeTokens("target_fs")
+				return err
+			},
+			ZSMExitCode: 1,
+			Stderr: func(t *testing.T) []byte {
+				return []byte("remote zsm resume-tokens wrote this to stderr")
+			},
+		},
+	}
+
+	remote.RunTests(t, tests)
+}
+
+func TestHost_ResumeReceive(t *testing.T) {
+	tests := []remote.TestCase{
+		{
+			Name: "resume receive",
+			Call: func(t *testing.T, host *remote.Host) error {
+				if err := host.Dial(); err != nil {
+					t.Fatal(err)
+				}
+				defer host.Close()
+
+				data := bytes.NewReader([]byte("remaining snapshot data"))
+				return host.ResumeReceive("target_fs", "zsm_test/fs_1", data)
+			},
+			ZSMCommand: []string{"/path/to/remote/zsm", "receive", "--resume", "target_fs", "zsm_test/fs_1"},
+			Stdin: func(t *testing.T) []byte {
+				return []byte("remaining snapshot data")
+			},
+		},
+	}
+
+	remote.RunTests(t, tests)
+}
+
+func TestHost_AbortReceive(t *testing.T) {
+	tests := []remote.TestCase{
+		{
+			Name: "abort receive",
+			Call: func(t *testing.T, host *remote.Host) error {
+				if err := host.Dial(); err != nil {
+					t.Fatal(err)
+				}
+				defer host.Close()
+
+				return host.AbortReceive("target_fs", "zsm_test/fs_1")
+			},
+			ZSMCommand: []string{"/path/to/remote/zsm", "receive", "--abort", "target_fs", "zsm_test/fs_1"},
+		},
+		{
+			Name: "remote host returns error",
+			Call: func(t *testing.T, host *remote.Host) error {
+				if err := host.Dial(); err != nil {
+					t.Fatal(err)
+				}
+				defer host.Close()
+
+				return host.AbortReceive("target_fs", "zsm_test/fs_1")
+			},
+			ZSMExitCode: 1,
+			Stderr: func(t *testing.T) []byte {
+				return []byte("remote zsm receive wrote this to stderr")
+			},
+		},
+	}
+
+	remote.RunTests(t, tests)
+}
+
+func TestHost_ResumeSend(t *testing.T) {
+	tests := []remote.TestCase{
+		{
+			Name: "resume send",
+			Call: func(t *testing.T, host *remote.Host) error {
+				var out bytes.Buffer
+
+				if err := host.Dial(); err != nil {
+					t.Fatal(err)
+				}
+				defer host.Close()
+
+				if err := host.ResumeSend("1-e604ea4bf-e0-789c63a2", &out); err != nil {
+					return err
+				}
+				assert.Equal(t, "remaining snapshot data", out.String())
+				return nil
+			},
+			ZSMCommand: []string{"/path/to/remote/zsm", "send-stream", "--resume-token", "1-e604ea4bf-e0-789c63a2"},
+			Stdout: func(t *testing.T) []byte {
+				return []byte("remaining snapshot data")
+			},
+		},
+	}
+
+	remote.RunTests(t, tests)
+}
+
+func TestHost_HoldSnapshots(t *testing.T) {
+	tests := []remote.TestCase{
+		{
+			Name: "hold snapshots",
+			Call: func(t *testing.T, host *remote.Host) error {
+				if err := host.Dial(); err != nil {
+					t.Fatal(err)
+				}
+				defer host.Close()
+
+				return host.HoldSnapshots("zsm-send",
+					snapshot.MustParseName(t, "zsm_test@2020-04-10T09:45:58.564585005Z"),
+					snapshot.MustParseName(t, "zsm_test/fs_1@2020-04-10T09:45:58.564585005Z"),
+				)
+			},
+			ZSMCommand: []string{
+				"/path/to/remote/zsm",
+				"hold",
+				"--tag",
+				"zsm-send",
+				"zsm_test@2020-04-10T09:45:58.564585005Z",
+				"zsm_test/fs_1@2020-04-10T09:45:58.564585005Z",
+			},
+		},
+		{
+			Name: "quote tag",
+			Call: func(t *testing.T, host *remote.Host) error {
+				if err := host.Dial(); err != nil {
+					t.Fatal(err)
+				}
+				defer host.Close()
+
+				name := snapshot.MustParseName(t, "zsm_test@2020-04-10T09:45:58.564585005Z")
+				return host.HoldSnapshots("zsm-base:it's a; $(target)", name)
+			},
+			ZSMCommand: []string{
+				"/path/to/remote/zsm",
+				"hold",
+				"--tag",
+				"zsm-base:it's a; $(target)",
+				"zsm_test@2020-04-10T09:45:58.564585005Z",
+			},
+		},
+		{
+			Name: "remote host returns error",
+			Call: func(t *testing.T, host *remote.Host) error {
+				if err := host.Dial(); err != nil {
+					t.Fatal(err)
+				}
+				defer host.Close()
+
+				name := snapshot.MustParseName(t, "zsm_test@2020-04-10T09:45:58.564585005Z")
+				return host.HoldSnapshots("zsm-send", name)
+			},
+			ZSMExitCode: 1,
+			Stderr: func(t *testing.T) []byte {
+				return []byte("remote zsm hold wrote this to stderr")
+			},
+		},
+	}
+
+	remote.RunTests(t, tests)
+}
+
+func TestHost_ReleaseSnapshots(t *testing.T) {
+	tests := []remote.TestCase{
+		{
+			Name: "release snapshots",
+			Call: func(t *testing.T, host *remote.Host) error {
+				if err := host.Dial(); err != nil {
+					t.Fatal(err)
+				}
+				defer host.Close()
+
+				name := snapshot.MustParseName(t, "zsm_test@2020-04-10T09:45:58.564585005Z")
+				return host.ReleaseSnapshots("zsm-base:target_fs", name)
+			},
+			ZSMCommand: []string{
+				"/path/to/remote/zsm",
+				"release",
+				"--tag",
+				"zsm-base:target_fs",
+				"zsm_test@2020-04-10T09:45:58.564585005Z",
+			},
+		},
+	}
+
+	remote.RunTests(t, tests)
+}
+
+func TestHost_SnapshotHolds(t *testing.T) {
+	tests := []remote.TestCase{
+		{
+			Name: "list holds",
+			Call: func(t *testing.T, host *remote.Host) error {
+				if err := host.Dial(); err != nil {
+					t.Fatal(err)
+				}
+				defer host.Close()
+
+				name := snapshot.MustParseName(t, "zsm_test@2020-04-10T09:45:58.564585005Z")
+				tags, err := host.SnapshotHolds(name)
+				if err != nil {
+					return err
+				}
+				assert.Equal(t, []string{"zsm-base:target_fs", "zsm-send"}, tags)
+				return nil
+			},
+			ZSMCommand: []string{"/path/to/remote/zsm", "holds", "zsm_test@2020-04-10T09:45:58.564585005Z"},
+			Stdout: func(t *testing.T) []byte {
+				return []byte("zsm-base:target_fs\nzsm-send\n")
+			},
+		},
+		{
+			Name: "no holds",
+			Call: func(t *testing.T, host *remote.Host) error {
+				if err := host.Dial(); err != nil {
+					t.Fatal(err)
+				}
+				defer host.Close()
+
+				name := snapshot.MustParseName(t, "zsm_test@2020-04-10T09:45:58.564585005Z")
+				tags, err := host.SnapshotHolds(name)
+				if err != nil {
+					return err
+				}
+				assert.Empty(t, tags)
+				return nil
+			},
+			ZSMCommand: []string{"/path/to/remote/zsm", "holds", "zsm_test@2020-04-10T09:45:58.564585005Z"},
+		},
+	}
+
+	remote.RunTests(t, tests)
+}
+
+func TestHost_ListBookmarks(t *testing.T) {
+	tests := []remote.TestCase{
+		{
+			Name: "list bookmarks",
+			Call: func(t *testing.T, host *remote.Host) error {
+				if err := host.Dial(); err != nil {
+					t.Fatal(err)
+				}
+				defer host.Close()
+
+				names, err := host.ListBookmarks()
+				if err != nil {
+					return err
+				}
+				expected := []snapshot.Name{snapshot.MustParseName(t, "zsm_test@2020-04-10T09:45:58.564585005Z")}
+				assert.Equal(t, expected, names)
+				return nil
+			},
+			ZSMCommand: []string{"/path/to/remote/zsm", "list", "--bookmarks", "-o", "jsonl"},
+			Stdout: func(t *testing.T) []byte {
+				name := snapshot.MustParseName(t, "zsm_test@2020-04-10T09:45:58.564585005Z")
+				bs, err := name.ToJSON()
+				if err != nil {
+					t.Fatal(err)
+				}
+				return bs
+			},
+		},
+	}
+
+	remote.RunTests(t, tests)
+}
+
+func TestHost_BookmarkSnapshot(t *testing.T) {
+	tests := []remote.TestCase{
+		{
+			Name: "bookmark snapshot",
+			Call: func(t *testing.T, host *remote.Host) error {
+				if err := host.Dial(); err != nil {
+					t.Fatal(err)
+				}
+				defer host.Close()
+
+				name := snapshot.MustParseName(t, "zsm_test@2020-04-10T09:45:58.564585005Z")
+				return host.BookmarkSnapshot(name)
+			},
+			ZSMCommand: []string{"/path/to/remote/zsm", "bookmark", "zsm_test@2020-04-10T09:45:58.564585005Z"},
+		},
+		{
+			Name: "remote host returns error",
+			Call: func(t *testing.T, host *remote.Host) error {
+				if err := host.Dial(); err != nil {
+					t.Fatal(err)
+				}
+				defer host.Close()
+
+				name := snapshot.MustParseName(t, "zsm_test@2020-04-10T09:45:58.564585005Z")
+				return host.BookmarkSnapshot(name)
+			},
+			ZSMExitCode: 1,
+			Stderr: func(t *testing.T) []byte {
+				return []byte("remote zsm bookmark wrote this to stderr")
+			},
+		},
+	}
+
+	remote.RunTests(t, tests)
+}
+
+func TestHost_BookmarkGUID(t *testing.T) {
+	tests := []remote.TestCase{
+		{
+			Name: "get guid of bookmark",
+			Call: func(t *testing.T, host *remote.Host) error {
+				if err := host.Dial(); err != nil {
+					t.Fatal(err)
+				}
+				defer host.Close()
+
+				name := snapshot.MustParseName(t, "zsm_test@2020-04-10T09:45:58.564585005Z")
+				guid, err := host.BookmarkGUID(name)
+				if err != nil {
+					return err
+				}
+				assert.Equal(t, uint64(6917529027641081856), guid)
+				return nil
+			},
+			ZSMCommand: []string{"/path/to/remote/zsm", "guid", "zsm_test#2020-04-10T09:45:58.564585005Z"},
+			Stdout: func(t *testing.T) []byte {
+				return []byte("6917529027641081856\n")
+			},
+		},
+	}
+
+	remote.RunTests(t, tests)
+}
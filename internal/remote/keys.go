@@ -0,0 +1,61 @@
+package remote
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os/user"
+	"path/filepath"
+	"strings"
+
+	gossh "golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// ReadAuthKey reads the private key used to authenticate against a remote
+// host from file.
+//
+// The key must not be protected by a passphrase. If file starts with ~ it is
+// replaced by the home directory of the current user.
+func ReadAuthKey(file string) (gossh.Signer, error) {
+	file, err := expandHome(file)
+	if err != nil {
+		return nil, fmt.Errorf("read auth key: %w", err)
+	}
+	bs, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("read auth key: %w", err)
+	}
+	signer, err := gossh.ParsePrivateKey(bs)
+	if err != nil {
+		return nil, fmt.Errorf("read auth key %s: %w", file, err)
+	}
+	return signer, nil
+}
+
+// ReadKnownHosts creates a gossh.HostKeyCallback which verifies the keys of
+// remote hosts against the entries in the known_hosts file.
+//
+// If file starts with ~ it is replaced by the home directory of the current
+// user.
+func ReadKnownHosts(file string) (gossh.HostKeyCallback, error) {
+	file, err := expandHome(file)
+	if err != nil {
+		return nil, fmt.Errorf("read known hosts: %w", err)
+	}
+	cb, err := knownhosts.New(file)
+	if err != nil {
+		return nil, fmt.Errorf("read known hosts: %w", err)
+	}
+	return cb, nil
+}
+
+func expandHome(file string) (string, error) {
+	if !strings.HasPrefix(file, "~") {
+		return file, nil
+	}
+	usr, err := user.Current()
+	if err != nil {
+		return "", fmt.Errorf("determine current user: %w", err)
+	}
+	return filepath.Join(usr.HomeDir, file[1:]), nil
+}
@@ -1,8 +1,11 @@
 package snapshot
 
 import (
+	"encoding/json"
 	"fmt"
+	"io"
 	"sort"
+	"strings"
 	"time"
 )
 
@@ -11,31 +14,46 @@ import (
 // Interval represents the interval between two consecutive snapshots.
 type Interval int
 
-func (i Interval) exceeded(a, b time.Time) bool {
-	if b.Before(a) {
-		a, b = b, a
-	}
+// Period returns the Period of i.
+func (i Interval) Period() Period {
 	switch i {
 	case Minute:
-		return b.Sub(a) >= time.Minute
+		return Period{Duration: time.Minute}
 	case Hour:
-		return b.Sub(a) >= time.Hour
+		return Period{Duration: time.Hour}
 	case Day:
-		return b.Sub(a) >= 24*time.Hour
+		return Period{Duration: 24 * time.Hour}
 	case Week:
-		return b.Sub(a) >= 7*24*time.Hour
+		return Period{Duration: 7 * 24 * time.Hour}
 	case Month:
-		x := a.AddDate(0, 1, 0)
-		return b.Equal(x) || b.After(x)
+		return Period{Months: 1}
 	case Year:
-		x := a.AddDate(1, 0, 0)
-		return b.Equal(x) || b.After(x)
+		return Period{Months: 12}
 	default:
 		msg := fmt.Sprintf("programming error: unknown interval: %s", i)
 		panic(msg)
 	}
 }
 
+// MarshalText converts the interval to its lower case name.
+func (i Interval) MarshalText() ([]byte, error) {
+	if i < Minute || i >= nIntervals {
+		return nil, fmt.Errorf("marshal interval: unknown interval: %d", i)
+	}
+	return []byte(strings.ToLower(i.String())), nil
+}
+
+// UnmarshalText parses the lower case name of an interval.
+func (i *Interval) UnmarshalText(text []byte) error {
+	for iv := Minute; iv < nIntervals; iv++ {
+		if strings.EqualFold(iv.String(), string(text)) {
+			*i = iv
+			return nil
+		}
+	}
+	return fmt.Errorf("unmarshal interval: unknown interval: %s", text)
+}
+
 // Intervals in which snapshots can be kept.
 const (
 	Minute Interval = iota
@@ -59,7 +77,123 @@ const (
 // snapshots at least a minute apart.
 type BucketConfig [nIntervals]int
 
-func (b BucketConfig) createBuckets() []*bucket {
+// ResolveBucketConfig returns b for all file systems.
+func (b BucketConfig) ResolveBucketConfig(fs string) BucketConfig {
+	return b
+}
+
+// ResolveCalendar returns nil for all file systems.
+func (b BucketConfig) ResolveCalendar(fs string) *Calendar {
+	return nil
+}
+
+// ResolveCustomBuckets returns nil for all file systems.
+func (b BucketConfig) ResolveCustomBuckets(fs string) []CustomBucket {
+	return nil
+}
+
+// Calendar configures calendar aligned buckets.
+//
+// By default the snapshots in a bucket are at least the bucket's interval
+// apart. A calendar aligned bucket instead keeps a single snapshot per
+// calendar minute, hour, day, ISO week, month, or year in Location. This
+// keeps the daily snapshots from drifting over the day.
+type Calendar struct {
+	// Location is the time zone of the calendar. A nil Location selects
+	// UTC.
+	Location *time.Location
+
+	// Last makes the buckets keep the last snapshot of each period instead
+	// of the first.
+	Last bool
+}
+
+func (c *Calendar) location() *time.Location {
+	if c.Location == nil {
+		return time.UTC
+	}
+	return c.Location
+}
+
+// BucketConfigResolver determines the BucketConfig, Calendar, and
+// CustomBuckets used for cleaning the snapshots of a file system. A nil
+// Calendar selects buckets which are not calendar aligned.
+type BucketConfigResolver interface {
+	ResolveBucketConfig(fs string) BucketConfig
+	ResolveCalendar(fs string) *Calendar
+	ResolveCustomBuckets(fs string) []CustomBucket
+}
+
+// Policies resolves the BucketConfig for each file system to the BucketConfig
+// of the file system's nearest ancestor in FileSystems. A file system is
+// considered to be its own nearest ancestor. Default is used for file systems
+// without any ancestor in FileSystems.
+//
+// The Calendar and the CustomBuckets of each file system are resolved from
+// Calendars and DefaultCalendar, and from CustomBuckets and
+// DefaultCustomBuckets the same way.
+type Policies struct {
+	Default     BucketConfig
+	FileSystems map[string]BucketConfig
+
+	DefaultCalendar *Calendar
+	Calendars       map[string]*Calendar
+
+	DefaultCustomBuckets []CustomBucket
+	CustomBuckets        map[string][]CustomBucket
+}
+
+// ResolveBucketConfig returns the BucketConfig of the nearest ancestor of fs.
+func (p Policies) ResolveBucketConfig(fs string) BucketConfig {
+	if ancestor, ok := nearestAncestor(fs, func(a string) bool {
+		_, ok := p.FileSystems[a]
+		return ok
+	}); ok {
+		return p.FileSystems[ancestor]
+	}
+	return p.Default
+}
+
+// ResolveCalendar returns the Calendar of the nearest ancestor of fs.
+func (p Policies) ResolveCalendar(fs string) *Calendar {
+	if ancestor, ok := nearestAncestor(fs, func(a string) bool {
+		_, ok := p.Calendars[a]
+		return ok
+	}); ok {
+		return p.Calendars[ancestor]
+	}
+	return p.DefaultCalendar
+}
+
+// ResolveCustomBuckets returns the CustomBuckets of the nearest ancestor of
+// fs.
+func (p Policies) ResolveCustomBuckets(fs string) []CustomBucket {
+	if ancestor, ok := nearestAncestor(fs, func(a string) bool {
+		_, ok := p.CustomBuckets[a]
+		return ok
+	}); ok {
+		return p.CustomBuckets[ancestor]
+	}
+	return p.DefaultCustomBuckets
+}
+
+// nearestAncestor returns the nearest ancestor of fs for which found returns
+// true. The second return value is false if there is no such ancestor.
+func nearestAncestor(fs string, found func(string) bool) (string, bool) {
+	fs = strings.TrimPrefix(fs, "/")
+	for {
+		if found(fs) {
+			return fs, true
+		}
+		idx := strings.LastIndex(fs, "/")
+		if idx < 0 {
+			return "", false
+		}
+		fs = fs[:idx]
+	}
+}
+
+func (b BucketConfig) createBuckets(custom []CustomBucket) []*bucket {
 	var buckets []*bucket
 
 	for i := Minute; i < nIntervals; i++ {
@@ -71,6 +205,16 @@ func (b BucketConfig) createBuckets() []*bucket {
 			Size:     b[i],
 		})
 	}
+	for _, cb := range custom {
+		if cb.Count == 0 {
+			continue
+		}
+		p := cb.Period
+		buckets = append(buckets, &bucket{
+			Custom: &p,
+			Size:   cb.Count,
+		})
+	}
 	return buckets
 }
 
@@ -78,6 +222,54 @@ type bucket struct {
 	Interval Interval
 	Size     int
 	Elements []Name
+
+	// Custom is the period of a bucket created for a CustomBucket. Interval
+	// is ignored if Custom is set.
+	Custom *Period
+
+	// picked contains the snapshots a calendar aligned bucket keeps. It is
+	// nil for buckets which are not calendar aligned.
+	picked map[Name]bool
+}
+
+// pickCalendar makes b a calendar aligned bucket. It picks the first or last
+// snapshot of each of the b.Size most recent calendar periods among names.
+// names must be sorted from the newest to the oldest snapshot.
+func (b *bucket) pickCalendar(names []Name, cal *Calendar) {
+	p := b.period()
+	var (
+		prev    string
+		last    Name
+		periods int
+	)
+
+	b.picked = make(map[Name]bool)
+	for i, name := range names {
+		period := p.calendarPeriod(name.Timestamp, cal.location())
+		if i > 0 && period == prev {
+			if !cal.Last {
+				// Replace the snapshot picked for the period with the
+				// older snapshot.
+				delete(b.picked, last)
+				b.picked[name] = true
+				last = name
+			}
+			continue
+		}
+		if periods == b.Size {
+			return
+		}
+		periods++
+		prev, last = period, name
+		b.picked[name] = true
+	}
+}
+
+func (b *bucket) period() Period {
+	if b.Custom != nil {
+		return *b.Custom
+	}
+	return b.Interval.Period()
 }
 
 // Add tries to add the snapshot with Name sn to the bucket, if it fits.
@@ -89,7 +281,16 @@ type bucket struct {
 // to distribute multiple snapshots across multiple buckets and be sure, that
 // each bucket is filled with the correct values, the snapshots **must** be
 // sorted according to their timestamps first.
+//
+// Calendar aligned buckets accept only the snapshots they picked.
 func (b *bucket) Add(sn Name) bool {
+	if b.picked != nil {
+		if !b.picked[sn] {
+			return false
+		}
+		b.Elements = append(b.Elements, sn)
+		return true
+	}
 	if len(b.Elements) == b.Size {
 		return false
 	}
@@ -97,7 +298,7 @@ func (b *bucket) Add(sn Name) bool {
 		b.Elements = make([]Name, 0, b.Size)
 	}
 	prev := len(b.Elements) - 1
-	if prev > -1 && !b.Interval.exceeded(b.Elements[prev].Timestamp, sn.Timestamp) {
+	if prev > -1 && !b.period().exceeded(b.Elements[prev].Timestamp, sn.Timestamp) {
 		return false
 	}
 	b.Elements = append(b.Elements, sn)
@@ -110,8 +311,23 @@ func (b *bucket) Add(sn Name) bool {
 // All snapshot names must belong to the same file system. If this is not the
 // case clean panics.
 func clean(cfg BucketConfig, names []Name) ([]Name, []Name) {
+	retained, reject := plan(cfg, nil, nil, names)
+	if retained == nil {
+		return nil, reject
+	}
+	keep := make([]Name, len(retained))
+	for i, r := range retained {
+		keep[i] = r.Name
+	}
+	return keep, reject
+}
+
+// plan works like clean. Additionally it records the intervals each kept
+// snapshot fills. The buckets for custom are created in addition to those
+// for cfg. If cal is not nil, the buckets are calendar aligned.
+func plan(cfg BucketConfig, custom []CustomBucket, cal *Calendar, names []Name) ([]Retained, []Name) {
 	var (
-		keep   []Name
+		keep   []Retained
 		reject []Name
 	)
 
@@ -133,7 +349,12 @@ func clean(cfg BucketConfig, names []Name) ([]Name, []Name) {
 	// snapshots according to their file systems.
 	fs := names[0].FileSystem
 
-	buckets := cfg.createBuckets()
+	buckets := cfg.createBuckets(custom)
+	if cal != nil {
+		for _, b := range buckets {
+			b.pickCalendar(names, cal)
+		}
+	}
 	for _, name := range names {
 		if name.FileSystem != fs {
 			msg := fmt.Sprintf("programming error: name has file system %s; expected %s", name.FileSystem, fs)
@@ -143,16 +364,158 @@ func clean(cfg BucketConfig, names []Name) ([]Name, []Name) {
 		// In case we don't have any buckets we don't want to add name to
 		// rejects.
 		ok := len(buckets) == 0 || false
+		var (
+			intervals []Interval
+			periods   []Period
+		)
 		for _, b := range buckets {
-			if b.Add(name) {
-				ok = true
+			if !b.Add(name) {
+				continue
+			}
+			ok = true
+			if b.Custom != nil {
+				periods = append(periods, *b.Custom)
+			} else {
+				intervals = append(intervals, b.Interval)
 			}
 		}
 		if ok {
-			keep = append(keep, name)
+			keep = append(keep, Retained{Name: name, Intervals: intervals, Periods: periods})
 		} else {
 			reject = append(reject, name)
 		}
 	}
 	return keep, reject
 }
+
+// retention combines all settings determining which snapshots of a single
+// file system are kept.
+type retention struct {
+	Buckets    BucketConfig
+	Custom     []CustomBucket
+	Calendar   *Calendar
+	KeepWithin Period
+	MaxAge     Period
+}
+
+// planRetention works like plan. Additionally it keeps all snapshots taken
+// within ret.KeepWithin before now, and rejects all snapshots taken more than
+// ret.MaxAge before now, regardless of the buckets. A zero KeepWithin or
+// MaxAge is ignored.
+//
+// The newest snapshot is never rejected because of ret.MaxAge. Otherwise a
+// file system which is no longer snapshotted, e.g. because it did not
+// change, would lose all its snapshots.
+func planRetention(ret retention, names []Name, now time.Time) ([]Retained, []Name) {
+	var tooOld []Name
+	if !ret.MaxAge.IsZero() && len(names) > 0 {
+		limit := ret.MaxAge.Before(now)
+		newest := names[0]
+		for _, name := range names[1:] {
+			if name.Timestamp.After(newest.Timestamp) {
+				newest = name
+			}
+		}
+		young := names[:0:0]
+		for _, name := range names {
+			if name != newest && name.Timestamp.Before(limit) {
+				tooOld = append(tooOld, name)
+				continue
+			}
+			young = append(young, name)
+		}
+		names = young
+	}
+
+	keep, reject := plan(ret.Buckets, ret.Custom, ret.Calendar, names)
+	if !ret.KeepWithin.IsZero() {
+		limit := ret.KeepWithin.Before(now)
+		for i := range keep {
+			keep[i].Within = !keep[i].Name.Timestamp.Before(limit)
+		}
+		remaining := reject[:0:0]
+		for _, name := range reject {
+			if name.Timestamp.Before(limit) {
+				remaining = append(remaining, name)
+				continue
+			}
+			keep = append(keep, Retained{Name: name, Within: true})
+		}
+		reject = remaining
+	}
+	if len(tooOld) > 0 {
+		reject = append(reject, tooOld...)
+	}
+	sortRetained(keep)
+	sortNames(reject)
+	return keep, reject
+}
+
+// planExpiring adds the expiring snapshots to keep or reject depending on
+// whether they expired at now. Both keep and reject remain sorted from the
+// newest to the oldest snapshot.
+func planExpiring(
+	keep []Retained, reject, expiring []Name, expiries map[Name]time.Time, now time.Time,
+) ([]Retained, []Name) {
+	if len(expiring) == 0 {
+		return keep, reject
+	}
+	for _, name := range expiring {
+		expires := expiries[name]
+		if expires.After(now) {
+			keep = append(keep, Retained{Name: name, Expires: &expires})
+			continue
+		}
+		reject = append(reject, name)
+	}
+	sortRetained(keep)
+	sortNames(reject)
+	return keep, reject
+}
+
+// sortRetained sorts keep from the newest to the oldest snapshot.
+func sortRetained(keep []Retained) {
+	sort.SliceStable(keep, func(i, j int) bool {
+		return keep[i].Name.Timestamp.After(keep[j].Name.Timestamp)
+	})
+}
+
+// sortNames sorts names from the newest to the oldest snapshot.
+func sortNames(names []Name) {
+	sort.SliceStable(names, func(i, j int) bool {
+		return names[i].Timestamp.After(names[j].Timestamp)
+	})
+}
+
+// Retained represents a snapshot kept when cleaning snapshots.
+//
+// Intervals contains the intervals whose buckets the snapshot fills. It is
+// empty if the snapshot is kept because no buckets are configured at all, or
+// because it has not yet expired. Expires is only set for snapshots with the
+// PropertyExpires user property. Within is true if the snapshot was taken
+// within the window passed using KeepWithin.
+type Retained struct {
+	Name      Name       `json:"name"`
+	Intervals []Interval `json:"intervals"`
+	Periods   []Period   `json:"periods,omitempty"`
+	Expires   *time.Time `json:"expires,omitempty"`
+	Within    bool       `json:"within,omitempty"`
+}
+
+// CleanPlan describes which snapshots of a single file system are kept and
+// which are rejected when cleaning snapshots. Held contains the rejected
+// snapshots which can't be destroyed because they have a user hold.
+type CleanPlan struct {
+	FileSystem string     `json:"fileSystem"`
+	Keep       []Retained `json:"keep"`
+	Reject     []Name     `json:"reject"`
+	Held       []Name     `json:"held,omitempty"`
+}
+
+// ToJSONW converts the plan to a JSON representation and writes it to w.
+func (p CleanPlan) ToJSONW(w io.Writer) error {
+	if err := json.NewEncoder(w).Encode(p); err != nil {
+		return fmt.Errorf("clean plan to JSON: %w", err)
+	}
+	return nil
+}
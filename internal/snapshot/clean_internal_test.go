@@ -105,7 +105,7 @@ func TestClean(t *testing.T) {
 		{
 			name: "keep all snapshots on empty config",
 			testData: func() ([]Name, []Name, []Name) {
-				end := Name{"zfs_test", time.Now().UTC()}
+				end := Name{FileSystem: "zfs_test", Timestamp: time.Now().UTC()}
 				in := FakeNames(t, end, Minute, 5)
 				keep := in
 				return in, keep, nil
@@ -119,13 +119,13 @@ func TestClean(t *testing.T) {
 					keep   []Name
 					reject []Name
 				)
-				end := Name{"zfs_test", time.Now().UTC()}
+				end := Name{FileSystem: "zfs_test", Timestamp: time.Now().UTC()}
 
 				in := FakeNames(t, end, Minute, 5)
 				keep = append(keep, in[2:5]...)
 				reject = append(reject, in[0:2]...)
 
-				oneHourAgo := Name{end.FileSystem, end.Timestamp.Add(-time.Hour)}
+				oneHourAgo := Name{FileSystem: end.FileSystem, Timestamp: end.Timestamp.Add(-time.Hour)}
 				in = append(in, oneHourAgo)
 				keep = append(keep, oneHourAgo)
 
@@ -166,3 +166,326 @@ func TestClean_PanicsOnDivergentFileSystems(t *testing.T) {
 		clean(BucketConfig{}, names)
 	})
 }
+
+func TestPlan_RecordsIntervals(t *testing.T) {
+	end := Name{
+		Timestamp:  MustParseTime(t, time.RFC3339, "2020-04-14T17:11:16Z"),
+		FileSystem: "zsm_test",
+	}
+	names := FakeNames(t, end, Minute, 61)
+	cfg := BucketConfig{Minute: 2, Hour: 2}
+
+	keep, reject := plan(cfg, nil, nil, names)
+	assert.Equal(t, []Retained{
+		{Name: names[60], Intervals: []Interval{Minute, Hour}},
+		{Name: names[59], Intervals: []Interval{Minute}},
+		{Name: names[0], Intervals: []Interval{Hour}},
+	}, keep)
+	assert.Len(t, reject, 58)
+}
+
+func TestPlan_NoBuckets(t *testing.T) {
+	names := FakeNames(t, Name{FileSystem: "zsm_test", Timestamp: time.Now().UTC()}, Hour, 2)
+
+	keep, reject := plan(BucketConfig{}, nil, nil, names)
+	assert.Equal(t, []Retained{{Name: names[1]}, {Name: names[0]}}, keep)
+	assert.Empty(t, reject)
+}
+
+func TestPlan_Calendar(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	hourly := func(start string, n int) []Name {
+		end := MustParseTime(t, time.RFC3339, start).Add(time.Duration(n-1) * time.Hour)
+		return FakeNames(t, Name{FileSystem: "zsm_test", Timestamp: end}, Hour, n)
+	}
+
+	tests := []struct {
+		name     string
+		cfg      BucketConfig
+		cal      Calendar
+		names    []Name
+		expected []string
+	}{
+		{
+			name: "first snapshot of each day across end of DST",
+			cfg:  BucketConfig{Day: 3},
+			cal:  Calendar{Location: berlin},
+			// Starts at 2020-10-24T00:00:00+02:00 and ends at
+			// 2020-10-26T23:00:00+01:00.
+			names:    hourly("2020-10-23T22:00:00Z", 73),
+			expected: []string{"2020-10-25T23:00:00Z", "2020-10-24T22:00:00Z", "2020-10-23T22:00:00Z"},
+		},
+		{
+			name:     "last snapshot of each day",
+			cfg:      BucketConfig{Day: 2},
+			cal:      Calendar{Location: berlin, Last: true},
+			names:    hourly("2020-10-23T22:00:00Z", 73),
+			expected: []string{"2020-10-26T22:00:00Z", "2020-10-25T22:00:00Z"},
+		},
+		{
+			name:     "days in UTC",
+			cfg:      BucketConfig{Day: 2},
+			cal:      Calendar{},
+			names:    hourly("2020-10-23T22:00:00Z", 73),
+			expected: []string{"2020-10-26T00:00:00Z", "2020-10-25T00:00:00Z"},
+		},
+		{
+			name: "repeated hour at end of DST",
+			cfg:  BucketConfig{Hour: 2},
+			cal:  Calendar{Location: berlin},
+			names: FakeNames(t, Name{
+				FileSystem: "zsm_test",
+				Timestamp:  MustParseTime(t, time.RFC3339, "2020-10-25T01:30:00Z"),
+			}, Minute, 91),
+			expected: []string{"2020-10-25T01:00:00Z", "2020-10-25T00:00:00Z"},
+		},
+		{
+			name: "ISO weeks",
+			cfg:  BucketConfig{Week: 2},
+			cal:  Calendar{Location: time.UTC},
+			names: FakeNames(t, Name{
+				FileSystem: "zsm_test",
+				Timestamp:  MustParseTime(t, time.RFC3339, "2021-01-05T12:00:00Z"),
+			}, Day, 9),
+			expected: []string{"2021-01-04T12:00:00Z", "2020-12-28T12:00:00Z"},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			keep, _ := plan(tt.cfg, nil, &tt.cal, ShuffleNamesC(tt.names))
+			actual := make([]string, len(keep))
+			for i, r := range keep {
+				actual[i] = r.Name.Timestamp.Format(time.RFC3339)
+			}
+			assert.Equal(t, tt.expected, actual)
+		})
+	}
+}
+
+func TestPlan_CustomBuckets(t *testing.T) {
+	quarter := Period{Months: 3}
+	minutes := FakeNames(t, Name{
+		FileSystem: "zsm_test",
+		Timestamp:  MustParseTime(t, time.RFC3339, "2020-04-14T17:11:16Z"),
+	}, Minute, 61)
+	months := FakeNames(t, Name{
+		FileSystem: "zsm_test",
+		Timestamp:  MustParseTime(t, time.RFC3339, "2020-12-15T12:00:00Z"),
+	}, Month, 12)
+	hours := FakeNames(t, Name{
+		FileSystem: "zsm_test",
+		Timestamp:  MustParseTime(t, time.RFC3339, "2020-04-14T12:00:00Z"),
+	}, Hour, 12)
+
+	tests := []struct {
+		name     string
+		cfg      BucketConfig
+		custom   []CustomBucket
+		cal      *Calendar
+		names    []Name
+		expected []Retained
+	}{
+		{
+			name:   "quarter hours",
+			custom: []CustomBucket{{Period: Period{Duration: 15 * time.Minute}, Count: 8}},
+			names:  minutes,
+			expected: []Retained{
+				{Name: minutes[60], Periods: []Period{{Duration: 15 * time.Minute}}},
+				{Name: minutes[45], Periods: []Period{{Duration: 15 * time.Minute}}},
+				{Name: minutes[30], Periods: []Period{{Duration: 15 * time.Minute}}},
+				{Name: minutes[15], Periods: []Period{{Duration: 15 * time.Minute}}},
+				{Name: minutes[0], Periods: []Period{{Duration: 15 * time.Minute}}},
+			},
+		},
+		{
+			name:   "combined with intervals",
+			cfg:    BucketConfig{Minute: 1},
+			custom: []CustomBucket{{Period: Period{Duration: 30 * time.Minute}, Count: 2}},
+			names:  minutes,
+			expected: []Retained{
+				{Name: minutes[60], Intervals: []Interval{Minute}, Periods: []Period{{Duration: 30 * time.Minute}}},
+				{Name: minutes[30], Periods: []Period{{Duration: 30 * time.Minute}}},
+			},
+		},
+		{
+			name:   "sliding quarters",
+			custom: []CustomBucket{{Period: quarter, Count: 4}},
+			names:  months,
+			expected: []Retained{
+				{Name: months[11], Periods: []Period{quarter}},
+				{Name: months[8], Periods: []Period{quarter}},
+				{Name: months[5], Periods: []Period{quarter}},
+				{Name: months[2], Periods: []Period{quarter}},
+			},
+		},
+		{
+			name:   "calendar quarters",
+			custom: []CustomBucket{{Period: quarter, Count: 4}},
+			cal:    &Calendar{},
+			names:  months,
+			expected: []Retained{
+				{Name: months[9], Periods: []Period{quarter}},
+				{Name: months[6], Periods: []Period{quarter}},
+				{Name: months[3], Periods: []Period{quarter}},
+				{Name: months[0], Periods: []Period{quarter}},
+			},
+		},
+		{
+			name:   "calendar aligned to midnight",
+			custom: []CustomBucket{{Period: Period{Duration: 6 * time.Hour}, Count: 2}},
+			cal:    &Calendar{},
+			names:  hours,
+			expected: []Retained{
+				{Name: hours[11], Periods: []Period{{Duration: 6 * time.Hour}}},
+				{Name: hours[5], Periods: []Period{{Duration: 6 * time.Hour}}},
+			},
+		},
+		{
+			name:   "empty bucket",
+			custom: []CustomBucket{{Period: quarter}},
+			names:  months[:2],
+			expected: []Retained{
+				{Name: months[1]},
+				{Name: months[0]},
+			},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			keep, _ := plan(tt.cfg, tt.custom, tt.cal, ShuffleNamesC(tt.names))
+			assert.Equal(t, tt.expected, keep)
+		})
+	}
+}
+
+func TestPlanRetention(t *testing.T) {
+	now := MustParseTime(t, time.RFC3339, "2020-04-14T17:30:00Z")
+	end := Name{
+		Timestamp:  MustParseTime(t, time.RFC3339, "2020-04-14T17:00:00Z"),
+		FileSystem: "zsm_test",
+	}
+	names := FakeNames(t, end, Hour, 6)
+	cfg := BucketConfig{Hour: 1, Day: 1}
+
+	t.Run("keep within", func(t *testing.T) {
+		ret := retention{Buckets: cfg, KeepWithin: Period{Duration: 150 * time.Minute}}
+		keep, reject := planRetention(ret, ShuffleNamesC(names), now)
+		assert.Equal(t, []Retained{
+			{Name: names[5], Intervals: []Interval{Hour, Day}, Within: true},
+			{Name: names[4], Within: true},
+			{Name: names[3], Within: true},
+		}, keep)
+		assert.Equal(t, []Name{names[2], names[1], names[0]}, reject)
+	})
+
+	t.Run("max age", func(t *testing.T) {
+		keep, reject := planRetention(retention{MaxAge: Period{Duration: 4 * time.Hour}}, ShuffleNamesC(names), now)
+		assert.Equal(t, []Retained{{Name: names[5]}, {Name: names[4]}, {Name: names[3]}, {Name: names[2]}}, keep)
+		assert.Equal(t, []Name{names[1], names[0]}, reject)
+	})
+
+	t.Run("max age overrides buckets", func(t *testing.T) {
+		ret := retention{
+			Buckets:    BucketConfig{Hour: 10},
+			KeepWithin: Period{Duration: time.Hour},
+			MaxAge:     Period{Duration: 3 * time.Hour},
+		}
+		keep, reject := planRetention(ret, ShuffleNamesC(names), now)
+		assert.Equal(t, []Retained{
+			{Name: names[5], Intervals: []Interval{Hour}, Within: true},
+			{Name: names[4], Intervals: []Interval{Hour}},
+			{Name: names[3], Intervals: []Interval{Hour}},
+		}, keep)
+		assert.Equal(t, []Name{names[2], names[1], names[0]}, reject)
+	})
+
+	t.Run("max age keeps newest snapshot", func(t *testing.T) {
+		ret := retention{Buckets: cfg, MaxAge: Period{Duration: time.Hour}}
+		keep, reject := planRetention(ret, ShuffleNamesC(names), now.Add(24*time.Hour))
+		assert.Equal(t, []Retained{{Name: names[5], Intervals: []Interval{Hour, Day}}}, keep)
+		assert.Equal(t, []Name{names[4], names[3], names[2], names[1], names[0]}, reject)
+	})
+}
+
+func TestInterval_MarshalText(t *testing.T) {
+	for iv := Minute; iv < nIntervals; iv++ {
+		text, err := iv.MarshalText()
+		if !assert.NoError(t, err) {
+			continue
+		}
+		var actual Interval
+		assert.NoError(t, actual.UnmarshalText(text))
+		assert.Equal(t, iv, actual)
+	}
+
+	_, err := nIntervals.MarshalText()
+	assert.Error(t, err)
+
+	var iv Interval
+	assert.Error(t, iv.UnmarshalText([]byte("fortnight")))
+}
+
+func TestPolicies_ResolveBucketConfig(t *testing.T) {
+	policies := Policies{
+		Default: BucketConfig{Minute: 1},
+		FileSystems: map[string]BucketConfig{
+			"zsm_test/db":         {Minute: 60},
+			"zsm_test/db/scratch": {Hour: 2},
+		},
+	}
+	tests := []struct {
+		fs       string
+		expected BucketConfig
+	}{
+		{fs: "zsm_test", expected: BucketConfig{Minute: 1}},
+		{fs: "zsm_test/dbx", expected: BucketConfig{Minute: 1}},
+		{fs: "zsm_test/db", expected: BucketConfig{Minute: 60}},
+		{fs: "/zsm_test/db", expected: BucketConfig{Minute: 60}},
+		{fs: "zsm_test/db/logs", expected: BucketConfig{Minute: 60}},
+		{fs: "zsm_test/db/scratch", expected: BucketConfig{Hour: 2}},
+		{fs: "zsm_test/db/scratch/tmp", expected: BucketConfig{Hour: 2}},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.fs, func(t *testing.T) {
+			assert.Equal(t, tt.expected, policies.ResolveBucketConfig(tt.fs))
+		})
+	}
+}
+
+func TestPolicies_ResolveCalendar(t *testing.T) {
+	def := &Calendar{}
+	db := &Calendar{Last: true}
+	policies := Policies{
+		DefaultCalendar: def,
+		Calendars: map[string]*Calendar{
+			"zsm_test/db":         db,
+			"zsm_test/db/scratch": nil,
+		},
+	}
+	assert.Same(t, def, policies.ResolveCalendar("zsm_test"))
+	assert.Same(t, db, policies.ResolveCalendar("zsm_test/db/logs"))
+	assert.Nil(t, policies.ResolveCalendar("zsm_test/db/scratch/tmp"))
+	assert.Nil(t, BucketConfig{}.ResolveCalendar("zsm_test"))
+}
+
+func TestPolicies_ResolveCustomBuckets(t *testing.T) {
+	def := []CustomBucket{{Period: Period{Months: 3}, Count: 4}}
+	db := []CustomBucket{{Period: Period{Duration: 15 * time.Minute}, Count: 8}}
+	policies := Policies{
+		DefaultCustomBuckets: def,
+		CustomBuckets: map[string][]CustomBucket{
+			"zsm_test/db":         db,
+			"zsm_test/db/scratch": nil,
+		},
+	}
+	assert.Equal(t, def, policies.ResolveCustomBuckets("zsm_test"))
+	assert.Equal(t, db, policies.ResolveCustomBuckets("zsm_test/db/logs"))
+	assert.Nil(t, policies.ResolveCustomBuckets("zsm_test/db/scratch/tmp"))
+	assert.Nil(t, BucketConfig{}.ResolveCustomBuckets("zsm_test"))
+}
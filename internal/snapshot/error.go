@@ -0,0 +1,66 @@
+package snapshot
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/fhofherr/zsm/internal/zfs"
+)
+
+// Failure represents a snapshot which could not be created or destroyed.
+type Failure struct {
+	Name string
+	Err  error
+}
+
+// PartialError is returned by CreateSnapshots and CleanSnapshots in
+// best-effort mode if some snapshots could not be created or destroyed.
+// Succeeded contains the names of the snapshots which were created or
+// destroyed, Failures all others.
+type PartialError struct {
+	Succeeded []string
+	Failures  []Failure
+}
+
+func (e *PartialError) Error() string {
+	var sb strings.Builder
+
+	fmt.Fprintf(&sb, "%d of %d snapshots failed", len(e.Failures), len(e.Succeeded)+len(e.Failures))
+	for _, f := range e.Failures {
+		fmt.Fprintf(&sb, "\n%s: %v", f.Name, f.Err)
+		var zfsErr *zfs.Error
+		if errors.As(f.Err, &zfsErr) && strings.TrimSpace(zfsErr.Stderr) != "" {
+			fmt.Fprintf(&sb, ": %s", strings.TrimSpace(zfsErr.Stderr))
+		}
+	}
+	return sb.String()
+}
+
+// Unwrap returns the error of the first failure.
+func (e *PartialError) Unwrap() error {
+	if len(e.Failures) == 0 {
+		return nil
+	}
+	return e.Failures[0].Err
+}
+
+// succeed records names as succeeded.
+func (e *PartialError) succeed(names ...string) {
+	e.Succeeded = append(e.Succeeded, names...)
+}
+
+// fail records names as failed with err.
+func (e *PartialError) fail(err error, names ...string) {
+	for _, name := range names {
+		e.Failures = append(e.Failures, Failure{Name: name, Err: err})
+	}
+}
+
+// err returns e if it contains at least one failure, and nil otherwise.
+func (e *PartialError) err() error {
+	if len(e.Failures) == 0 {
+		return nil
+	}
+	return e
+}
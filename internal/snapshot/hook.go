@@ -0,0 +1,239 @@
+package snapshot
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Environment variables passed to hooks in addition to the environment of
+// zsm.
+const (
+	// HookEnvOperation contains the operation the hook is run for, i.e.
+	// create, clean, or transfer.
+	HookEnvOperation = "ZSM_OPERATION"
+
+	// HookEnvStage contains pre for pre hooks and post for post hooks.
+	HookEnvStage = "ZSM_HOOK"
+
+	// HookEnvDatasets contains the space separated names of the datasets
+	// the hook applies to.
+	HookEnvDatasets = "ZSM_DATASETS"
+
+	// HookEnvTimestamp contains the RFC3339 timestamp of the snapshots
+	// created by CreateSnapshots. It is not set for other operations.
+	HookEnvTimestamp = "ZSM_TIMESTAMP"
+
+	// HookEnvLabel contains the label of the snapshots created or cleaned.
+	// It is not set if the snapshots have no label.
+	HookEnvLabel = "ZSM_LABEL"
+
+	// HookEnvStatus is passed to post hooks only. It contains success if
+	// the operation succeeded, and failure otherwise.
+	HookEnvStatus = "ZSM_STATUS"
+)
+
+// Hook is a shell command executed before or after an operation on a set of
+// datasets.
+//
+// By default a hook applies to all datasets of the operation. Setting
+// FileSystems or ConsistencyGroups restricts the hook to the datasets matching
+// any of the patterns, or being members of any of the consistency groups. A
+// restricted hook is not executed if none of the datasets of the operation
+// match. Only CreateSnapshots knows about consistency groups.
+type Hook struct {
+	// Command is executed using /bin/sh -c. Its output is written to the
+	// stderr of zsm.
+	Command string
+
+	// Timeout is the maximum duration Command may take. Command and all
+	// processes it started are killed if it exceeds Timeout. A zero
+	// Timeout disables the timeout.
+	Timeout time.Duration
+
+	// ContinueOnError makes the operation continue if a pre hook fails.
+	// By default a failing pre hook aborts the operation. Failing post
+	// hooks never abort anything.
+	ContinueOnError bool
+
+	FileSystems       []string
+	ConsistencyGroups []string
+}
+
+// Hooks contains the hooks executed before and after an operation.
+//
+// The Pre hooks are executed in order before the operation. If any of them
+// fails the remaining Pre hooks are not executed and the operation is aborted,
+// unless the failing hook has ContinueOnError set. The Post hooks are executed
+// in order after the operation. They are always executed, even if the
+// operation or one of the Pre hooks fails.
+type Hooks struct {
+	Pre  []Hook
+	Post []Hook
+}
+
+// hookEnv contains the values of the environment variables passed to hooks.
+type hookEnv struct {
+	Operation string
+	Timestamp time.Time
+	Label     string
+}
+
+func (e hookEnv) vars(stage string, datasets []string) []string {
+	vars := []string{
+		HookEnvOperation + "=" + e.Operation,
+		HookEnvStage + "=" + stage,
+		HookEnvDatasets + "=" + strings.Join(datasets, " "),
+	}
+	if !e.Timestamp.IsZero() {
+		vars = append(vars, HookEnvTimestamp+"="+e.Timestamp.Format(time.RFC3339Nano))
+	}
+	if e.Label != "" {
+		vars = append(vars, HookEnvLabel+"="+e.Label)
+	}
+	return vars
+}
+
+// datasets returns the datasets among datasets h applies to. groups maps the
+// names of consistency groups to their members.
+func (h Hook) datasets(datasets []string, groups map[string][]string) ([]string, error) {
+	if len(h.FileSystems) == 0 && len(h.ConsistencyGroups) == 0 {
+		return datasets, nil
+	}
+	ps, err := parsePatterns(h.FileSystems)
+	if err != nil {
+		return nil, fmt.Errorf("hook %q: %w", h.Command, err)
+	}
+	members := make(map[string]bool)
+	for _, name := range h.ConsistencyGroups {
+		for _, fs := range groups[name] {
+			members[fs] = true
+		}
+	}
+
+	var matching []string
+	for _, fs := range datasets {
+		if ps.Match(fs) || members[fs] {
+			matching = append(matching, fs)
+		}
+	}
+	return matching, nil
+}
+
+// checkConsistencyGroups returns an error if any of the hooks in hs refers to
+// a consistency group not contained in groups.
+func (hs Hooks) checkConsistencyGroups(groups map[string][]string) error {
+	for _, h := range append(append([]Hook(nil), hs.Pre...), hs.Post...) {
+		for _, name := range h.ConsistencyGroups {
+			if _, ok := groups[name]; !ok {
+				return fmt.Errorf("hook %q: unknown consistency group: %s", h.Command, name)
+			}
+		}
+	}
+	return nil
+}
+
+// run executes the hook with the environment of zsm extended by vars.
+func (h Hook) run(vars []string) error {
+	cmd := exec.Command("/bin/sh", "-c", h.Command)
+	cmd.Env = append(os.Environ(), vars...)
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	// Start the hook in a process group of its own. This allows to kill all
+	// processes started by the hook if it times out.
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("hook %q: %w", h.Command, err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+	var timeout <-chan time.Time
+	if h.Timeout > 0 {
+		timer := time.NewTimer(h.Timeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case err := <-done:
+		if err != nil {
+			return fmt.Errorf("hook %q: %w", h.Command, err)
+		}
+		return nil
+	case <-timeout:
+		syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL) // nolint: errcheck
+		<-done
+		return fmt.Errorf("hook %q: timed out after %s", h.Command, h.Timeout)
+	}
+}
+
+// runHooks runs all hooks of hs around op.
+//
+// The datasets passed to each hook are determined using datasets and groups.
+// If there are no datasets, neither the hooks nor op are executed.
+func runHooks(hs Hooks, env hookEnv, datasets []string, groups map[string][]string, op func() error) error {
+	if len(datasets) == 0 {
+		return nil
+	}
+	// Determine the datasets of all hooks first. This ensures we don't
+	// start executing any hook if the configuration of one of them is
+	// invalid.
+	pre, err := hookDatasets(hs.Pre, datasets, groups)
+	if err != nil {
+		return err
+	}
+	post, err := hookDatasets(hs.Post, datasets, groups)
+	if err != nil {
+		return err
+	}
+
+	err = runPreHooks(hs.Pre, env, pre)
+	if err == nil {
+		err = op()
+	}
+
+	status := "success"
+	if err != nil {
+		status = "failure"
+	}
+	for i, h := range hs.Post {
+		if len(post[i]) == 0 {
+			continue
+		}
+		vars := append(env.vars("post", post[i]), HookEnvStatus+"="+status)
+		if postErr := h.run(vars); postErr != nil && err == nil {
+			err = fmt.Errorf("post %w", postErr)
+		}
+	}
+	return err
+}
+
+func hookDatasets(hooks []Hook, datasets []string, groups map[string][]string) ([][]string, error) {
+	result := make([][]string, len(hooks))
+	for i, h := range hooks {
+		ds, err := h.datasets(datasets, groups)
+		if err != nil {
+			return nil, err
+		}
+		result[i] = ds
+	}
+	return result, nil
+}
+
+func runPreHooks(hooks []Hook, env hookEnv, datasets [][]string) error {
+	for i, h := range hooks {
+		if len(datasets[i]) == 0 {
+			continue
+		}
+		if err := h.run(env.vars("pre", datasets[i])); err != nil && !h.ContinueOnError {
+			return fmt.Errorf("pre %w", err)
+		}
+	}
+	return nil
+}
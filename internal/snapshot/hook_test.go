@@ -0,0 +1,228 @@
+package snapshot_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fhofherr/zsm/internal/snapshot"
+	"github.com/fhofherr/zsm/internal/zfs"
+	"github.com/fhofherr/zsm/internal/zfs/memzfs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestManager_CreateSnapshots_Hooks(t *testing.T) {
+	tests := []struct {
+		name        string
+		hooks       func(log string) snapshot.Hooks
+		groups      []snapshot.CreateOption
+		expectedLog []string
+		expectedErr string
+		created     bool
+	}{
+		{
+			name: "run pre and post hooks",
+			hooks: func(log string) snapshot.Hooks {
+				return snapshot.Hooks{
+					Pre:  []snapshot.Hook{{Command: logHook(log, "$ZSM_OPERATION $ZSM_HOOK $ZSM_DATASETS")}},
+					Post: []snapshot.Hook{{Command: logHook(log, "$ZSM_HOOK $ZSM_STATUS")}},
+				}
+			},
+			expectedLog: []string{
+				"create pre zsm_test zsm_test/db zsm_test/db/logs zsm_test/vm",
+				"post success",
+			},
+			created: true,
+		},
+		{
+			name: "failing pre hook aborts",
+			hooks: func(log string) snapshot.Hooks {
+				return snapshot.Hooks{
+					Pre: []snapshot.Hook{
+						{Command: "exit 3"},
+						{Command: logHook(log, "not executed")},
+					},
+					Post: []snapshot.Hook{{Command: logHook(log, "$ZSM_HOOK $ZSM_STATUS")}},
+				}
+			},
+			expectedLog: []string{"post failure"},
+			expectedErr: `create snapshot: pre hook "exit 3": exit status 3`,
+		},
+		{
+			name: "continue on failing pre hook",
+			hooks: func(log string) snapshot.Hooks {
+				return snapshot.Hooks{
+					Pre: []snapshot.Hook{
+						{Command: "exit 3", ContinueOnError: true},
+						{Command: logHook(log, "$ZSM_HOOK")},
+					},
+				}
+			},
+			expectedLog: []string{"pre"},
+			created:     true,
+		},
+		{
+			name: "pre hook times out",
+			hooks: func(log string) snapshot.Hooks {
+				return snapshot.Hooks{
+					Pre:  []snapshot.Hook{{Command: "sleep 10", Timeout: 50 * time.Millisecond}},
+					Post: []snapshot.Hook{{Command: logHook(log, "$ZSM_HOOK $ZSM_STATUS")}},
+				}
+			},
+			expectedLog: []string{"post failure"},
+			expectedErr: `create snapshot: pre hook "sleep 10": timed out after 50ms`,
+		},
+		{
+			name: "failing post hook",
+			hooks: func(log string) snapshot.Hooks {
+				return snapshot.Hooks{
+					Post: []snapshot.Hook{
+						{Command: "exit 1"},
+						{Command: logHook(log, "$ZSM_HOOK $ZSM_STATUS")},
+					},
+				}
+			},
+			expectedLog: []string{"post success"},
+			expectedErr: `create snapshot: post hook "exit 1": exit status 1`,
+			created:     true,
+		},
+		{
+			name: "restrict hooks to file systems and consistency groups",
+			hooks: func(log string) snapshot.Hooks {
+				return snapshot.Hooks{
+					Pre: []snapshot.Hook{
+						{Command: logHook(log, "vm $ZSM_DATASETS"), FileSystems: []string{"zsm_test/vm"}},
+						{Command: logHook(log, "db $ZSM_DATASETS"), ConsistencyGroups: []string{"db"}},
+						{Command: logHook(log, "not executed"), FileSystems: []string{"zsm_test/unknown"}},
+					},
+				}
+			},
+			groups: []snapshot.CreateOption{
+				snapshot.ConsistencyGroup("db", "zsm_test/db", "zsm_test/db/logs"),
+			},
+			expectedLog: []string{
+				"vm zsm_test/vm",
+				"db zsm_test/db zsm_test/db/logs",
+			},
+			created: true,
+		},
+		{
+			name: "unknown consistency group",
+			hooks: func(log string) snapshot.Hooks {
+				return snapshot.Hooks{
+					Pre: []snapshot.Hook{{Command: "true", ConsistencyGroups: []string{"db"}}},
+				}
+			},
+			expectedErr: `create snapshot: hook "true": unknown consistency group: db`,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			log, cleanup := hookLogFile(t)
+			defer cleanup()
+
+			z := memzfs.New("zsm_test")
+			for _, fs := range []string{"zsm_test/db", "zsm_test/db/logs", "zsm_test/vm"} {
+				require.NoError(t, z.CreateFileSystem(fs))
+			}
+			mgr := &snapshot.Manager{ZFS: z}
+			opts := append([]snapshot.CreateOption{snapshot.CreateHooks(tt.hooks(log))}, tt.groups...)
+			_, err := mgr.CreateSnapshots(opts...)
+			if tt.expectedErr != "" {
+				assert.EqualError(t, err, tt.expectedErr)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tt.expectedLog, readHookLog(t, log))
+
+			snapshots, err := z.List(zfs.Snapshot)
+			if tt.created {
+				assert.NoError(t, err)
+				assert.Len(t, snapshots, 4)
+			} else {
+				assert.Empty(t, snapshots)
+			}
+		})
+	}
+}
+
+func TestManager_CreateSnapshots_HookEnvironment(t *testing.T) {
+	log, cleanup := hookLogFile(t)
+	defer cleanup()
+
+	z := memzfs.New("zsm_test")
+	mgr := &snapshot.Manager{ZFS: z}
+	_, err := mgr.CreateSnapshots(snapshot.CreateHooks(snapshot.Hooks{
+		Pre: []snapshot.Hook{{Command: logHook(log, "$ZSM_DATASETS@$ZSM_TIMESTAMP")}},
+	}))
+	require.NoError(t, err)
+
+	snapshots, err := z.List(zfs.Snapshot)
+	require.NoError(t, err)
+	assert.Equal(t, snapshots, readHookLog(t, log))
+}
+
+func TestManager_CleanSnapshots_Hooks(t *testing.T) {
+	log, cleanup := hookLogFile(t)
+	defer cleanup()
+
+	z := memzfs.New("zsm_test")
+	require.NoError(t, z.CreateFileSystem("zsm_test/db"))
+	ts := time.Now().UTC().Add(-24 * time.Hour)
+	createHourlySnapshots(t, z, ts, 1, "zsm_test")
+	createHourlySnapshots(t, z, ts, 3, "zsm_test/db")
+
+	mgr := &snapshot.Manager{ZFS: z}
+	_, err := mgr.CleanSnapshots(snapshot.BucketConfig{snapshot.Hour: 1}, snapshot.CleanHooks(snapshot.Hooks{
+		Pre:  []snapshot.Hook{{Command: logHook(log, "$ZSM_OPERATION $ZSM_HOOK $ZSM_DATASETS")}},
+		Post: []snapshot.Hook{{Command: logHook(log, "$ZSM_OPERATION $ZSM_HOOK $ZSM_STATUS")}},
+	}))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"clean pre zsm_test/db", "clean post success"}, readHookLog(t, log))
+}
+
+func TestTransfer_Hooks(t *testing.T) {
+	log, cleanup := hookLogFile(t)
+	defer cleanup()
+
+	srcZFS := memzfs.New("zsm_test")
+	require.NoError(t, srcZFS.CreateFileSystem("zsm_test/db"))
+	dstZFS := memzfs.New("target_fs")
+	createHourlySnapshots(t, srcZFS, time.Now().UTC().Add(-time.Hour), 1, "zsm_test", "zsm_test/db")
+
+	src := &snapshot.Manager{ZFS: srcZFS}
+	dst := &snapshot.Manager{ZFS: dstZFS}
+	err := snapshot.Transfer("target_fs", dst, src, snapshot.TransferHooks(snapshot.Hooks{
+		Pre:  []snapshot.Hook{{Command: logHook(log, "$ZSM_OPERATION $ZSM_HOOK $ZSM_DATASETS")}},
+		Post: []snapshot.Hook{{Command: logHook(log, "$ZSM_OPERATION $ZSM_HOOK $ZSM_STATUS")}},
+	}))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"transfer pre zsm_test zsm_test/db", "transfer post success"}, readHookLog(t, log))
+	assertInSync(t, srcZFS, dstZFS, "zsm_test/db", "target_fs/zsm_test/db")
+}
+
+// logHook returns a hook command which appends msg to the file log.
+func logHook(log, msg string) string {
+	return `echo "` + msg + `" >> ` + log
+}
+
+func hookLogFile(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "zsm-test-hooks-")
+	require.NoError(t, err)
+	return filepath.Join(dir, "log"), func() { os.RemoveAll(dir) }
+}
+
+func readHookLog(t *testing.T, log string) []string {
+	bs, err := ioutil.ReadFile(log)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	require.NoError(t, err)
+	return strings.Split(strings.TrimSpace(string(bs)), "\n")
+}
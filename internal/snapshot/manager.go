@@ -4,6 +4,8 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"path"
+	"sort"
 	"strings"
 	"time"
 
@@ -13,11 +15,22 @@ import (
 // ZFSAdapter represents a type which is capable on performing calls to ZFS
 // on the underlying system.
 type ZFSAdapter interface {
-	CreateSnapshot(string) error
+	CreateSnapshots(map[string]string, ...string) error
 	List(zfs.ListType) ([]string, error)
 	Destroy(string) error
-	Receive(string, io.Reader) error
+	DestroySnapshots(...string) error
+	Bookmark(string, string) error
+	Hold(string, ...string) error
+	Release(string, ...string) error
+	Holds(string) ([]string, error)
+	Receive(string, bool, io.Reader) error
+	AbortReceive(string) error
 	Send(string, string, io.Writer) error
+	SendResume(string, io.Writer) error
+	GUID(string) (uint64, error)
+	Written(string) (uint64, error)
+	ResumeTokens(string) (map[string]string, error)
+	Properties(zfs.ListType, ...string) (map[string]map[string]string, error)
 }
 
 // CreateOption modifies the way CreateSnapshot creates a snapshot of one
@@ -26,27 +39,170 @@ type CreateOption func(*createOpts)
 
 type createOpts struct {
 	FileSystems         []string
+	Recursive           bool
 	ExcludedFileSystems map[string]bool
+	ExcludedNodes       map[string]bool
+	ConsistencyGroups   map[string][]string
+	SkipUnchanged       bool
+	Hooks               Hooks
+	Label               string
+	Expires             time.Time
+	BestEffort          bool
 }
 
-// FromFileSystem makes CreateSnapshot create a snapshot of only the passed
-// file system. If FileSystem is passed multiple times to CreateSnapshot it
-// creates snapshots of all the passed file systems.
-func FromFileSystem(fsName string) CreateOption {
+// createSelection contains the parsed patterns of createOpts.
+type createSelection struct {
+	FileSystems   patterns
+	Excluded      patterns
+	ExcludedNodes patterns
+}
+
+func (o *createOpts) selection() (createSelection, error) {
+	var (
+		sel createSelection
+		err error
+	)
+
+	if sel.FileSystems, err = parsePatterns(o.FileSystems); err != nil {
+		return sel, err
+	}
+	if sel.Excluded, err = parsePatternSet(o.ExcludedFileSystems); err != nil {
+		return sel, err
+	}
+	if sel.ExcludedNodes, err = parsePatternSet(o.ExcludedNodes); err != nil {
+		return sel, err
+	}
+	return sel, nil
+}
+
+// excluded returns true if fs, or any of its ancestors, is excluded.
+func (s createSelection) excluded(fs string) bool {
+	return s.ExcludedNodes.Match(fs) || s.Excluded.MatchSubtree(fs)
+}
+
+// FromFileSystem makes CreateSnapshot create a snapshot of only the file
+// systems matching the passed Pattern. If FileSystem is passed multiple times
+// to CreateSnapshot it creates snapshots of all the file systems matching any
+// of the patterns. Pass Recursive to include the descendants of the file
+// systems.
+func FromFileSystem(pattern string) CreateOption {
 	return func(o *createOpts) {
-		o.FileSystems = append(o.FileSystems, fsName)
+		o.FileSystems = append(o.FileSystems, pattern)
 	}
 }
 
-// ExcludeFileSystem marks the passed file System as excluded from creating
-// snapshots.
-func ExcludeFileSystem(fsName string) CreateOption {
+// Recursive makes CreateSnapshot create snapshots of all descendants of the
+// file systems passed using FromFileSystem as well.
+func Recursive() CreateOption {
+	return func(o *createOpts) {
+		o.Recursive = true
+	}
+}
+
+// ExcludeFileSystem marks the file systems matching the passed Pattern and
+// all their descendants as excluded from creating snapshots.
+func ExcludeFileSystem(pattern string) CreateOption {
 	return func(o *createOpts) {
 		if o.ExcludedFileSystems == nil {
 			o.ExcludedFileSystems = make(map[string]bool)
 		}
-		fsName = strings.TrimPrefix(fsName, "/")
-		o.ExcludedFileSystems[fsName] = true
+		pattern = strings.TrimPrefix(pattern, "/")
+		o.ExcludedFileSystems[pattern] = true
+	}
+}
+
+// ExcludeFileSystemOnly marks the file systems matching the passed Pattern
+// as excluded from creating snapshots. Unlike ExcludeFileSystem it does not
+// exclude the descendants of the file systems.
+func ExcludeFileSystemOnly(pattern string) CreateOption {
+	return func(o *createOpts) {
+		if o.ExcludedNodes == nil {
+			o.ExcludedNodes = make(map[string]bool)
+		}
+		pattern = strings.TrimPrefix(pattern, "/")
+		o.ExcludedNodes[pattern] = true
+	}
+}
+
+// ConsistencyGroup makes CreateSnapshot snapshot the passed file systems
+// together. If CreateSnapshot creates a snapshot of any of the file systems, it
+// creates snapshots of all of them using a single atomic call to ZFS.
+//
+// A file system may only be a member of a single consistency group. None of
+// the members may be excluded.
+func ConsistencyGroup(name string, fileSystems ...string) CreateOption {
+	return func(o *createOpts) {
+		if o.ConsistencyGroups == nil {
+			o.ConsistencyGroups = make(map[string][]string)
+		}
+		for _, fs := range fileSystems {
+			fs = strings.TrimPrefix(fs, "/")
+			o.ConsistencyGroups[name] = append(o.ConsistencyGroups[name], fs)
+		}
+	}
+}
+
+// SkipUnchanged makes CreateSnapshot skip datasets which did not change
+// since their newest snapshot created by zsm. Datasets without any snapshot
+// created by zsm are never skipped. The PropertySkipUnchanged user property
+// of a dataset takes precedence over SkipUnchanged.
+//
+// Changes are detected using the written@snapname property of the dataset,
+// where snapname is the newest snapshot created by zsm with the same label.
+// Snapshots with other labels, expiring snapshots, and snapshots not created
+// by zsm do not hide changes.
+func SkipUnchanged() CreateOption {
+	return func(o *createOpts) {
+		o.SkipUnchanged = true
+	}
+}
+
+// CreateHooks adds hooks executed before and after CreateSnapshots creates
+// snapshots. If CreateHooks is passed multiple times, the hooks of all calls
+// are executed in the order they were passed.
+//
+// Hooks restricted to consistency groups apply to the members of the groups
+// passed using ConsistencyGroup. Restricting a hook to an unknown consistency
+// group is an error.
+func CreateHooks(hs Hooks) CreateOption {
+	return func(o *createOpts) {
+		o.Hooks.Pre = append(o.Hooks.Pre, hs.Pre...)
+		o.Hooks.Post = append(o.Hooks.Post, hs.Post...)
+	}
+}
+
+// CreateLabel makes CreateSnapshot create snapshots labelled with label. See
+// ValidateLabel for valid labels.
+//
+// Labelled snapshots are cleaned separately from snapshots with other or no
+// labels. This allows snapshots created on different schedules to coexist.
+func CreateLabel(label string) CreateOption {
+	return func(o *createOpts) {
+		o.Label = label
+	}
+}
+
+// Expires makes CreateSnapshot create snapshots which expire at ts. The
+// expiry is stored in the PropertyExpires user property of the snapshots.
+// CleanSnapshots ignores the BucketConfig for expiring snapshots. It keeps
+// them until ts and destroys them afterwards.
+//
+// CreateSnapshot never skips unchanged datasets when creating expiring
+// snapshots.
+func Expires(ts time.Time) CreateOption {
+	return func(o *createOpts) {
+		o.Expires = ts
+	}
+}
+
+// CreateBestEffort makes CreateSnapshots attempt to create the snapshots of
+// all datasets, even if creating some of them fails. If a batch of snapshots
+// can't be created, CreateSnapshots creates the snapshots of each dataset or
+// consistency group of the batch separately. Afterwards it returns a
+// *PartialError listing every snapshot it could not create.
+func CreateBestEffort() CreateOption {
+	return func(o *createOpts) {
+		o.BestEffort = true
 	}
 }
 
@@ -55,58 +211,408 @@ type SendOption func(*sendOpts)
 
 type sendOpts struct {
 	Reference Name
+	Bookmark  Name
 }
 
 // Reference sets the name of the reference snapshot when sending snapshots.
 func Reference(name Name) SendOption {
 	return func(opts *sendOpts) {
 		opts.Reference = name
+		opts.Bookmark = Name{}
+	}
+}
+
+// BookmarkReference sets the name of the snapshot whose bookmark is used as
+// reference when sending snapshots. The snapshot itself may no longer exist.
+//
+// BookmarkReference and Reference are mutually exclusive. The option passed
+// last wins.
+func BookmarkReference(name Name) SendOption {
+	return func(opts *sendOpts) {
+		opts.Bookmark = name
+		opts.Reference = Name{}
+	}
+}
+
+// ReferenceOf returns the name of the reference snapshot set by the passed
+// SendOptions. The second return value is false if no reference is set.
+func ReferenceOf(opts ...SendOption) (Name, bool) {
+	var sOpts sendOpts
+
+	for _, opt := range opts {
+		opt(&sOpts)
 	}
+	return sOpts.Reference, sOpts.Reference != Name{}
+}
+
+// BookmarkReferenceOf returns the name of the snapshot whose bookmark is set
+// as reference by the passed SendOptions. The second return value is false if
+// no bookmark is set.
+func BookmarkReferenceOf(opts ...SendOption) (Name, bool) {
+	var sOpts sendOpts
+
+	for _, opt := range opts {
+		opt(&sOpts)
+	}
+	return sOpts.Bookmark, sOpts.Bookmark != Name{}
 }
 
 // Manager manages ZFS snapshots.
 type Manager struct {
 	ZFS ZFSAdapter
+
+	// DatasetTypes selects the types of datasets CreateSnapshots creates
+	// snapshots of. Use zfs.Types to select file systems and volumes.
+	// DatasetTypes defaults to zfs.FileSystem.
+	DatasetTypes zfs.ListType
+}
+
+func (m *Manager) datasetTypes() zfs.ListType {
+	if m.DatasetTypes == "" {
+		return zfs.FileSystem
+	}
+	return m.DatasetTypes
+}
+
+// listDatasets returns the names of all datasets of the types selected by
+// DatasetTypes.
+func (m *Manager) listDatasets() ([]string, error) {
+	datasets, err := m.ZFS.List(m.datasetTypes())
+	if errors.Is(err, zfs.ErrNoOutput) {
+		// There are no datasets of the selected types.
+		return nil, nil
+	}
+	return datasets, err
 }
 
 // CreateSnapshots creates snapshots of the ZFS file system.
 //
-// By default CreateSnapshots creates snapshots of all ZFS file systems
-// available. This behavior can be modified by passing one or more
-// CreateOptions.
-func (m *Manager) CreateSnapshots(opts ...CreateOption) error {
+// By default CreateSnapshots creates snapshots of all ZFS datasets of the
+// types selected by DatasetTypes. This behavior can be modified by passing one or more
+// CreateOptions. File systems which have the PropertySnapshot user property
+// set to false are always excluded.
+//
+// CreateSnapshots passes as many snapshots as possible to a single call to
+// ZFS. The members of a consistency group are always passed to the same
+// call.
+//
+// The hooks passed using CreateHooks are executed before and after the
+// snapshots are created. They are not executed if there is nothing to
+// create.
+//
+// CreateSnapshots returns the datasets it skipped because they did not
+// change (see SkipUnchanged). A consistency group is only skipped if none of
+// its members changed. The newest existing snapshot of a skipped dataset
+// still counts when cleaning snapshots.
+func (m *Manager) CreateSnapshots(opts ...CreateOption) ([]string, error) {
 	if m.ZFS == nil {
-		return errors.New("initialization error: ZFSAdapter nil")
+		return nil, errors.New("initialization error: ZFSAdapter nil")
 	}
 	snapOpts := &createOpts{}
 	for _, opt := range opts {
 		opt(snapOpts)
 	}
 
-	allFileSystems, err := m.ZFS.List(zfs.FileSystem)
+	sel, err := snapOpts.selection()
 	if err != nil {
-		return fmt.Errorf("create snapshot: %w", err)
+		return nil, fmt.Errorf("create snapshot: %w", err)
+	}
+	if err := snapOpts.Hooks.checkConsistencyGroups(snapOpts.ConsistencyGroups); err != nil {
+		return nil, fmt.Errorf("create snapshot: %w", err)
+	}
+	if snapOpts.Label != "" {
+		if err := ValidateLabel(snapOpts.Label); err != nil {
+			return nil, fmt.Errorf("create snapshot: %w", err)
+		}
+	}
+	ts := time.Now().UTC()
+	var snapProps map[string]string
+	if !snapOpts.Expires.IsZero() {
+		if !snapOpts.Expires.After(ts) {
+			return nil, fmt.Errorf("create snapshot: expiry in the past: %s", snapOpts.Expires.Format(time.RFC3339))
+		}
+		snapProps = map[string]string{PropertyExpires: snapOpts.Expires.UTC().Format(time.RFC3339)}
+	}
+
+	allFileSystems, err := m.listDatasets()
+	if err != nil {
+		return nil, fmt.Errorf("create snapshot: %w", err)
 	}
 
-	selectedFileSystems := snapOpts.FileSystems
 	// If no file systems are passed make snapshots of all available file
 	// systems.
-	if len(selectedFileSystems) == 0 {
-		selectedFileSystems = allFileSystems
+	selectedFileSystems := allFileSystems
+	if len(sel.FileSystems) > 0 {
+		selectedFileSystems, err = selectFileSystems(allFileSystems, sel.FileSystems)
+		if err != nil {
+			return nil, err
+		}
 	}
-	if err := selectedFileSystemsKnown(allFileSystems, selectedFileSystems); err != nil {
-		return err
+	if snapOpts.Recursive {
+		selectedFileSystems = withDescendants(allFileSystems, selectedFileSystems)
 	}
-	selectedFileSystems = removeExcludedFileSystems(selectedFileSystems, snapOpts.ExcludedFileSystems)
+	selectedFileSystems = removeFileSystems(selectedFileSystems, sel.excluded)
+	props, err := readCreateProperties(m.ZFS, m.datasetTypes())
+	if err != nil {
+		return nil, fmt.Errorf("create snapshot: %w", err)
+	}
+	selectedFileSystems = removeFileSystems(selectedFileSystems, func(fs string) bool {
+		return props.disabled[fs]
+	})
 
-	ts := time.Now().UTC()
-	for _, fs := range selectedFileSystems {
-		name := Name{FileSystem: fs, Timestamp: ts}
-		if err := m.ZFS.CreateSnapshot(name.String()); err != nil {
-			return fmt.Errorf("create snapshot: %w", err)
+	excluded := func(fs string) bool {
+		return sel.excluded(fs) || props.disabled[fs]
+	}
+	groups, err := groupFileSystems(allFileSystems, selectedFileSystems, snapOpts.ConsistencyGroups, excluded)
+	if err != nil {
+		return nil, fmt.Errorf("create snapshot: %w", err)
+	}
+	groups, skipped, err := m.removeUnchanged(groups, snapOpts.Label, func(fs string) bool {
+		return snapProps == nil && props.skip(fs, snapOpts.SkipUnchanged)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("create snapshot: %w", err)
+	}
+
+	var datasets []string
+	units := make([][]string, len(groups))
+	for i, group := range groups {
+		datasets = append(datasets, group...)
+		units[i] = make([]string, len(group))
+		for j, fs := range group {
+			units[i][j] = Name{FileSystem: fs, Timestamp: ts, Label: snapOpts.Label}.String()
 		}
 	}
-	return nil
+	env := hookEnv{Operation: "create", Timestamp: ts, Label: snapOpts.Label}
+	err = runHooks(snapOpts.Hooks, env, datasets, snapOpts.ConsistencyGroups, func() error {
+		return m.createSnapshots(snapProps, units, snapOpts.BestEffort)
+	})
+	var partialErr *PartialError
+	if errors.As(err, &partialErr) {
+		return skipped, fmt.Errorf("create snapshot: %w", err)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("create snapshot: %w", err)
+	}
+	return skipped, nil
+}
+
+// createSnapshots creates the snapshots in units using as few calls to
+// ZFSAdapter.CreateSnapshots as possible. The snapshots of a unit are always
+// created atomically.
+//
+// If bestEffort is set and a batch can't be created, createSnapshots creates
+// each unit of the batch separately and returns a *PartialError if any of
+// them fails. Otherwise it returns the first error.
+func (m *Manager) createSnapshots(props map[string]string, units [][]string, bestEffort bool) error {
+	var res PartialError
+
+	for _, batch := range batchUnits(units, maxSnapshotBatchLen) {
+		names := flattenUnits(batch)
+		err := m.ZFS.CreateSnapshots(props, names...)
+		switch {
+		case err == nil:
+			res.succeed(names...)
+		case !bestEffort:
+			return err
+		case len(batch) == 1:
+			res.fail(err, names...)
+		default:
+			for _, unit := range batch {
+				if err := m.ZFS.CreateSnapshots(props, unit...); err != nil {
+					res.fail(err, unit...)
+					continue
+				}
+				res.succeed(unit...)
+			}
+		}
+	}
+	return res.err()
+}
+
+// removeUnchanged removes all groups from groups whose members did not change
+// since their newest snapshot created by zsm. Only datasets for which skip
+// returns true and whose newest snapshot with label does not expire are
+// checked for changes. Otherwise the dataset would lose its latest state once
+// the snapshot expires.
+//
+// removeUnchanged returns the remaining groups and the members of the removed
+// groups.
+func (m *Manager) removeUnchanged(
+	groups [][]string, label string, skip func(string) bool,
+) ([][]string, []string, error) {
+	candidates := make(map[string]bool)
+	for _, group := range groups {
+		for _, fs := range group {
+			if skip(fs) {
+				candidates[fs] = true
+			}
+		}
+	}
+	if len(candidates) == 0 {
+		return groups, nil, nil
+	}
+
+	newest := make(map[string]Name)
+	err := m.listSnapshots(func(name Name) {
+		if name.Label != label {
+			return
+		}
+		if n, ok := newest[name.FileSystem]; !ok || name.Timestamp.After(n.Timestamp) {
+			newest[name.FileSystem] = name
+		}
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	expiries, err := readExpiries(m.ZFS)
+	if err != nil {
+		return nil, nil, err
+	}
+	unchanged := func(fs string) (bool, error) {
+		name, ok := newest[fs]
+		if !candidates[fs] || !ok {
+			return false, nil
+		}
+		if _, ok := expiries[name]; ok {
+			return false, nil
+		}
+		n, err := m.ZFS.Written(name.String())
+		if err != nil {
+			return false, err
+		}
+		return n == 0, nil
+	}
+
+	var (
+		remaining [][]string
+		skipped   []string
+	)
+	for _, group := range groups {
+		skipGroup := true
+		for _, fs := range group {
+			ok, err := unchanged(fs)
+			if err != nil {
+				return nil, nil, err
+			}
+			if !ok {
+				skipGroup = false
+				break
+			}
+		}
+		if skipGroup {
+			skipped = append(skipped, group...)
+			continue
+		}
+		remaining = append(remaining, group)
+	}
+	return remaining, skipped, nil
+}
+
+// maxSnapshotBatchLen is the maximum number of bytes the snapshot names passed
+// to a single call to ZFSAdapter.CreateSnapshots may occupy on the command
+// line.
+const maxSnapshotBatchLen = 64 * 1024
+
+// groupFileSystems groups the selected file systems by the passed consistency
+// groups. Each file system not part of a consistency
+// group forms a group of its own.
+//
+// If any member of a consistency group is selected, the whole group is
+// returned. The groups are returned in the order their first member appears
+// in selected.
+func groupFileSystems(
+	all, selected []string, consistencyGroups map[string][]string, excluded func(string) bool,
+) ([][]string, error) {
+	groupOf := make(map[string]string)
+	groupNames := make([]string, 0, len(consistencyGroups))
+	for name := range consistencyGroups {
+		groupNames = append(groupNames, name)
+	}
+	sort.Strings(groupNames)
+	for _, name := range groupNames {
+		members := consistencyGroups[name]
+		if err := selectedFileSystemsKnown(all, members); err != nil {
+			return nil, fmt.Errorf("consistency group %s: %w", name, err)
+		}
+		for _, fs := range members {
+			if other, ok := groupOf[fs]; ok && other != name {
+				return nil, fmt.Errorf("%s: member of consistency groups %s and %s", fs, other, name)
+			}
+			groupOf[fs] = name
+		}
+	}
+
+	var groups [][]string
+	added := make(map[string]bool)
+	for _, fs := range selected {
+		name, ok := groupOf[fs]
+		if !ok {
+			groups = append(groups, []string{fs})
+			continue
+		}
+		if added[name] {
+			continue
+		}
+		for _, member := range consistencyGroups[name] {
+			if excluded(member) {
+				return nil, fmt.Errorf("consistency group %s: excluded file system: %s", name, member)
+			}
+		}
+		groups = append(groups, consistencyGroups[name])
+		added[name] = true
+	}
+	return groups, nil
+}
+
+// batchSnapshots combines the passed units of snapshot names into batches.
+// The combined length of the names within a batch does not exceed maxLen,
+// unless a single unit exceeds maxLen. Such a unit forms a batch of its own,
+// as units are never split.
+func batchSnapshots(units [][]string, maxLen int) [][]string {
+	var batches [][]string
+
+	for _, batch := range batchUnits(units, maxLen) {
+		batches = append(batches, flattenUnits(batch))
+	}
+	return batches
+}
+
+// batchUnits works like batchSnapshots but keeps the units of each batch
+// apart.
+func batchUnits(units [][]string, maxLen int) [][][]string {
+	var (
+		batches  [][][]string
+		batch    [][]string
+		batchLen int
+	)
+
+	for _, unit := range units {
+		unitLen := 0
+		for _, name := range unit {
+			unitLen += len(name) + 1
+		}
+		if len(batch) > 0 && batchLen+unitLen > maxLen {
+			batches = append(batches, batch)
+			batch, batchLen = nil, 0
+		}
+		batch = append(batch, unit)
+		batchLen += unitLen
+	}
+	if len(batch) > 0 {
+		batches = append(batches, batch)
+	}
+	return batches
+}
+
+// flattenUnits returns the names of all units.
+func flattenUnits(units [][]string) []string {
+	var names []string
+
+	for _, unit := range units {
+		names = append(names, unit...)
+	}
+	return names
 }
 
 func selectedFileSystemsKnown(all, selected []string) error {
@@ -122,10 +628,36 @@ func selectedFileSystemsKnown(all, selected []string) error {
 	return nil
 }
 
-func removeExcludedFileSystems(selected []string, excluded map[string]bool) []string {
+// selectFileSystems returns all file systems in all matching any of the
+// patterns. It returns an error if a pattern does not match any file system.
+func selectFileSystems(all []string, ps patterns) ([]string, error) {
+	var selected []string
+
+	matched := make([]bool, len(ps))
+	for _, fs := range all {
+		found := false
+		for i, p := range ps {
+			if p.Match(fs) {
+				matched[i] = true
+				found = true
+			}
+		}
+		if found {
+			selected = append(selected, fs)
+		}
+	}
+	for i, p := range ps {
+		if !matched[i] {
+			return nil, fmt.Errorf("unknown filesystem: %q", p)
+		}
+	}
+	return selected, nil
+}
+
+func removeFileSystems(selected []string, remove func(string) bool) []string {
 	remaining := make([]string, 0, len(selected))
 	for _, fs := range selected {
-		if excluded[fs] {
+		if remove(fs) {
 			continue
 		}
 		remaining = append(remaining, fs)
@@ -133,33 +665,341 @@ func removeExcludedFileSystems(selected []string, excluded map[string]bool) []st
 	return remaining
 }
 
-// CleanSnapshots removes all snapshots outdated according to BucketConfig.
-func (m *Manager) CleanSnapshots(cfg BucketConfig) error {
-	nSnapshots := 0
+// withDescendants returns all file systems in all which are either contained
+// in selected or are descendants of a file system in selected.
+func withDescendants(all, selected []string) []string {
+	var result []string
+
+	for _, fs := range all {
+		for _, sfs := range selected {
+			if fs == sfs || strings.HasPrefix(fs, sfs+"/") {
+				result = append(result, fs)
+				break
+			}
+		}
+	}
+	return result
+}
+
+// CleanOption modifies the way CleanSnapshots removes snapshots.
+type CleanOption func(*cleanOpts)
+
+type cleanOpts struct {
+	Hooks      Hooks
+	Label      string
+	KeepWithin Period
+	MaxAge     Period
+	BestEffort bool
+}
+
+// CleanHooks adds hooks executed before and after CleanSnapshots destroys
+// snapshots. If CleanHooks is passed multiple times, the hooks of all calls
+// are executed in the order they were passed.
+func CleanHooks(hs Hooks) CleanOption {
+	return func(o *cleanOpts) {
+		o.Hooks.Pre = append(o.Hooks.Pre, hs.Pre...)
+		o.Hooks.Post = append(o.Hooks.Post, hs.Post...)
+	}
+}
+
+// CleanLabel makes CleanSnapshots and PlanClean consider only the snapshots
+// labelled with label. By default they consider only snapshots without a
+// label.
+func CleanLabel(label string) CleanOption {
+	return func(o *cleanOpts) {
+		o.Label = label
+	}
+}
+
+// CleanBestEffort makes CleanSnapshots attempt to destroy the outdated
+// snapshots of all file systems, even if destroying some of them fails.
+// Afterwards it returns a *PartialError listing every snapshot it could not
+// destroy.
+func CleanBestEffort() CleanOption {
+	return func(o *cleanOpts) {
+		o.BestEffort = true
+	}
+}
+
+// KeepWithin makes CleanSnapshots keep all snapshots taken within p before
+// it was called, in addition to the snapshots kept according to the
+// BucketConfig.
+func KeepWithin(p Period) CleanOption {
+	return func(o *cleanOpts) {
+		o.KeepWithin = p
+	}
+}
+
+// MaxAge makes CleanSnapshots destroy all snapshots taken more than p before
+// it was called, even if they are kept according to the BucketConfig. MaxAge
+// never destroys the newest snapshot of a file system. MaxAge must not be
+// less than KeepWithin.
+func MaxAge(p Period) CleanOption {
+	return func(o *cleanOpts) {
+		o.MaxAge = p
+	}
+}
+
+// CleanSnapshots removes all snapshots outdated according to the BucketConfig
+// resolved for their file system.
+//
+// The hooks passed using CleanHooks are executed before and after the
+// snapshots are destroyed. They apply to the file systems with outdated
+// snapshots and are not executed if there are none.
+//
+// Outdated snapshots with a user hold can't be destroyed. CleanSnapshots
+// skips them and returns their names instead.
+func (m *Manager) CleanSnapshots(r BucketConfigResolver, opts ...CleanOption) ([]Name, error) {
+	var cOpts cleanOpts
+
+	for _, opt := range opts {
+		opt(&cOpts)
+	}
+	plans, err := m.PlanClean(r, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("clean snapshots: %w", err)
+	}
+
+	var (
+		fileSystems []string
+		held        []Name
+	)
+	for _, p := range plans {
+		if len(p.Reject) > 0 {
+			fileSystems = append(fileSystems, p.FileSystem)
+		}
+		held = append(held, p.Held...)
+	}
+	env := hookEnv{Operation: "clean", Label: cOpts.Label}
+	err = runHooks(cOpts.Hooks, env, fileSystems, nil, func() error {
+		var res PartialError
+		for _, p := range plans {
+			if err := m.destroySnapshots(&res, p.Reject); err != nil && !cOpts.BestEffort {
+				return err
+			}
+		}
+		return res.err()
+	})
+	var partialErr *PartialError
+	if errors.As(err, &partialErr) {
+		return held, fmt.Errorf("clean snapshots: %w", err)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("clean snapshots: %w", err)
+	}
+	return held, nil
+}
+
+// maxDestroyBatchLen is the maximum number of bytes the snapshot names passed
+// to a single call to ZFSAdapter.DestroySnapshots may occupy.
+const maxDestroyBatchLen = 64 * 1024
+
+// destroySnapshots destroys the snapshots with the passed names and records
+// the result in res. All names must belong to the same dataset.
+//
+// The snapshots are destroyed in batches using ZFSAdapter.DestroySnapshots.
+// If a batch can't be destroyed, destroySnapshots destroys its snapshots one
+// at a time. Thus a single snapshot which can't be destroyed does not keep
+// the others from being destroyed. destroySnapshots returns the first error
+// in this case.
+func (m *Manager) destroySnapshots(res *PartialError, names []Name) error {
+	var firstErr error
+
+	units := make([][]string, len(names))
+	for i, n := range names {
+		units[i] = []string{n.String()}
+	}
+	for _, batch := range batchSnapshots(units, maxDestroyBatchLen) {
+		if err := m.ZFS.DestroySnapshots(batch...); err == nil {
+			res.succeed(batch...)
+			continue
+		}
+		for _, name := range batch {
+			if err := m.ZFS.Destroy(name); err != nil {
+				res.fail(err, name)
+				if firstErr == nil {
+					firstErr = err
+				}
+				continue
+			}
+			res.succeed(name)
+		}
+	}
+	return firstErr
+}
+
+// PlanClean determines which snapshots CleanSnapshots would keep and which it
+// would remove according to the BucketConfig resolved for their file system.
+// It does not remove any snapshots.
+//
+// The keep user properties (see KeepProperty) of a file system override the
+// respective values of the resolved BucketConfig.
+//
+// PlanClean considers only the snapshots with the label passed using
+// CleanLabel, or the snapshots without a label if CleanLabel is not passed.
+// It honors KeepWithin and MaxAge. Other options are ignored.
+//
+// Snapshots with the PropertyExpires user property do not count towards the
+// BucketConfig. PlanClean keeps them until they expire and rejects them
+// afterwards.
+//
+// PlanClean returns a CleanPlan for each file system with snapshots managed
+// by zsm. The plans are sorted by file system. The names within each plan are
+// sorted from the newest to the oldest snapshot.
+func (m *Manager) PlanClean(r BucketConfigResolver, opts ...CleanOption) ([]CleanPlan, error) {
+	var cOpts cleanOpts
+
+	for _, opt := range opts {
+		opt(&cOpts)
+	}
+	now := time.Now()
+	if cOpts.KeepWithin.Before(now).After(now) || cOpts.MaxAge.Before(now).After(now) {
+		return nil, errors.New("plan clean: negative time window")
+	}
+	if !cOpts.MaxAge.IsZero() && cOpts.KeepWithin.Before(now).Before(cOpts.MaxAge.Before(now)) {
+		return nil, fmt.Errorf("plan clean: keep within %s exceeds max age %s", cOpts.KeepWithin, cOpts.MaxAge)
+	}
 	names := make(map[string][]Name)
 	err := m.listSnapshots(func(name Name) {
+		if name.Label != cOpts.Label {
+			return
+		}
 		names[name.FileSystem] = append(names[name.FileSystem], name)
-		nSnapshots++
 	})
 	if err != nil {
-		return fmt.Errorf("clean snapshots: %w", err)
+		return nil, fmt.Errorf("plan clean: %w", err)
+	}
+
+	pr, err := newPropertyResolver(m.ZFS, m.datasetTypes(), r)
+	if err != nil {
+		return nil, fmt.Errorf("plan clean: %w", err)
+	}
+	expiries, err := readExpiries(m.ZFS)
+	if err != nil {
+		return nil, fmt.Errorf("plan clean: %w", err)
 	}
 
-	rejects := make([]Name, 0, nSnapshots)
-	for _, ns := range names {
-		_, rjs := clean(cfg, ns)
-		rejects = append(rejects, rjs...)
+	plans := make([]CleanPlan, 0, len(names))
+	for _, fs := range sortedFileSystems(names) {
+		var expiring []Name
+		managed := names[fs][:0:0]
+		for _, name := range names[fs] {
+			if _, ok := expiries[name]; ok {
+				expiring = append(expiring, name)
+				continue
+			}
+			managed = append(managed, name)
+		}
+		keep, reject := planRetention(retention{
+			Buckets:    pr.ResolveBucketConfig(fs),
+			Custom:     pr.ResolveCustomBuckets(fs),
+			Calendar:   pr.ResolveCalendar(fs),
+			KeepWithin: cOpts.KeepWithin,
+			MaxAge:     cOpts.MaxAge,
+		}, managed, now)
+		keep, reject = planExpiring(keep, reject, expiring, expiries, now)
+		plans = append(plans, CleanPlan{
+			FileSystem: fs,
+			Keep:       keep,
+			Reject:     reject,
+		})
 	}
+	if err := m.separateHeld(plans); err != nil {
+		return nil, fmt.Errorf("plan clean: %w", err)
+	}
+	return plans, nil
+}
 
-	for _, rj := range rejects {
-		if err := m.ZFS.Destroy(rj.String()); err != nil {
-			return fmt.Errorf("clean snapshots: %w", err)
+// separateHeld moves the rejected snapshots which have a user hold to the
+// Held snapshots of their plan. It reads the holds only if there are any
+// rejected snapshots.
+func (m *Manager) separateHeld(plans []CleanPlan) error {
+	var n int
+	for _, p := range plans {
+		n += len(p.Reject)
+	}
+	if n == 0 {
+		return nil
+	}
+	held, err := readHeld(m.ZFS)
+	if err != nil {
+		return err
+	}
+	for i := range plans {
+		reject := plans[i].Reject[:0:0]
+		for _, name := range plans[i].Reject {
+			if held[name] {
+				plans[i].Held = append(plans[i].Held, name)
+				continue
+			}
+			reject = append(reject, name)
 		}
+		plans[i].Reject = reject
 	}
+	return nil
+}
 
+// HoldSnapshots places a user hold with tag on each of the snapshots with the
+// passed names. CleanSnapshots does not destroy snapshots with holds.
+func (m *Manager) HoldSnapshots(tag string, names ...Name) error {
+	if tag == "" {
+		return errors.New("hold snapshots: empty tag")
+	}
+	if err := m.ZFS.Hold(tag, nameStrings(names)...); err != nil {
+		return fmt.Errorf("hold snapshots: %w", err)
+	}
 	return nil
 }
 
+// ReleaseSnapshots removes the user hold with tag from each of the snapshots
+// with the passed names.
+func (m *Manager) ReleaseSnapshots(tag string, names ...Name) error {
+	if tag == "" {
+		return errors.New("release snapshots: empty tag")
+	}
+	if err := m.ZFS.Release(tag, nameStrings(names)...); err != nil {
+		return fmt.Errorf("release snapshots: %w", err)
+	}
+	return nil
+}
+
+// SnapshotHolds returns the sorted tags of all user holds on the snapshot
+// with the passed name.
+func (m *Manager) SnapshotHolds(name Name) ([]string, error) {
+	tags, err := m.ZFS.Holds(name.String())
+	if err != nil {
+		return nil, fmt.Errorf("snapshot holds: %w", err)
+	}
+	return tags, nil
+}
+
+func nameStrings(names []Name) []string {
+	ss := make([]string, len(names))
+	for i, name := range names {
+		ss[i] = name.String()
+	}
+	return ss
+}
+
+func containsName(names []Name, name Name) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Expiries returns the expiry of all snapshots managed by zsm which have the
+// PropertyExpires user property set.
+func (m *Manager) Expiries() (map[Name]time.Time, error) {
+	expiries, err := readExpiries(m.ZFS)
+	if err != nil {
+		return nil, fmt.Errorf("expiries: %w", err)
+	}
+	return expiries, nil
+}
+
 // ListSnapshots returns a list of snapshot names managed by zsm.
 func (m *Manager) ListSnapshots() ([]Name, error) {
 	var names []Name
@@ -172,6 +1012,10 @@ func (m *Manager) ListSnapshots() ([]Name, error) {
 
 func (m *Manager) listSnapshots(collect func(Name)) error {
 	snapshots, err := m.ZFS.List(zfs.Snapshot)
+	if errors.Is(err, zfs.ErrNoOutput) {
+		// There are no snapshots at all.
+		return nil
+	}
 	if err != nil {
 		return fmt.Errorf("list snapshots: %w", err)
 	}
@@ -187,11 +1031,70 @@ func (m *Manager) listSnapshots(collect func(Name)) error {
 	return nil
 }
 
+// ListBookmarks returns the names of all snapshots zsm created a bookmark of.
+// The bookmarks are kept when the snapshots are destroyed.
+func (m *Manager) ListBookmarks() ([]Name, error) {
+	bookmarks, err := m.ZFS.List(zfs.Bookmark)
+	if errors.Is(err, zfs.ErrNoOutput) {
+		// There are no bookmarks at all.
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("list bookmarks: %w", err)
+	}
+
+	var names []Name
+	for _, b := range bookmarks {
+		name, ok := ParseBookmark(b)
+		if !ok {
+			// bookmark was not created by us
+			continue
+		}
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+// BookmarkSnapshot creates a bookmark of the snapshot with the passed name.
+//
+// The bookmark can be passed to SendSnapshot using BookmarkReference once the
+// snapshot has been destroyed.
+func (m *Manager) BookmarkSnapshot(name Name) error {
+	if err := m.ZFS.Bookmark(name.String(), name.Bookmark()); err != nil {
+		return fmt.Errorf("bookmark snapshot: %w", err)
+	}
+	return nil
+}
+
+// BookmarkGUID returns the guid of the bookmark of the snapshot with the
+// passed name. It equals the guid of the bookmarked snapshot.
+func (m *Manager) BookmarkGUID(name Name) (uint64, error) {
+	guid, err := m.ZFS.GUID(name.Bookmark())
+	if err != nil {
+		return 0, fmt.Errorf("bookmark guid: %w", err)
+	}
+	return guid, nil
+}
+
+// SnapshotGUID returns the guid of the snapshot with the passed name.
+func (m *Manager) SnapshotGUID(name Name) (uint64, error) {
+	guid, err := m.ZFS.GUID(name.String())
+	if err != nil {
+		return 0, fmt.Errorf("snapshot guid: %w", err)
+	}
+	return guid, nil
+}
+
 // ReceiveSnapshot receives a snapshot with the passed name.
 //
-// It writes the data read from r to the snapshot.  ReceiveSnapshot returns an
-// error if name.FileSystem does not exist, or if a snapshot with the same name
-// already exists.
+// It writes the data read from r to a snapshot of the same name below
+// targetFS. ReceiveSnapshot returns an error if targetFS does not exist, or if
+// a snapshot with the same name already exists below targetFS.
+//
+// Example:
+//
+// Receiving zsm_test@2020-04-10T09:45:58.564585005Z into target_fs creates the
+// snapshot target_fs/zsm_test@2020-04-10T09:45:58.564585005Z.
 func (m *Manager) ReceiveSnapshot(targetFS string, name Name, r io.Reader) error {
 	allFileSystems, err := m.ZFS.List(zfs.FileSystem)
 	if err != nil {
@@ -208,9 +1111,10 @@ func (m *Manager) ReceiveSnapshot(targetFS string, name Name, r io.Reader) error
 		return fmt.Errorf("receive snapshot: missing file system: %s", targetFS)
 	}
 
+	target := name.Below(targetFS)
 	snExists := false
 	err = m.listSnapshots(func(n Name) {
-		if name == n {
+		if target == n {
 			snExists = true
 		}
 	})
@@ -218,19 +1122,62 @@ func (m *Manager) ReceiveSnapshot(targetFS string, name Name, r io.Reader) error
 		return fmt.Errorf("receive snapshot: %w", err)
 	}
 	if snExists {
-		return fmt.Errorf("receive snapshot: exists: %s", name)
+		return fmt.Errorf("receive snapshot: exists: %s", target)
 	}
 
-	if err := m.ZFS.Receive(name.String(), r); err != nil {
+	if err := m.ZFS.Receive(target.String(), true, r); err != nil {
 		return fmt.Errorf("receive snapshot: %w", err)
 	}
 	return nil
 }
 
+// ResumeTokens returns the tokens of all interrupted receives below targetFS.
+//
+// The keys of the returned map are the names of the file systems relative to
+// targetFS.
+func (m *Manager) ResumeTokens(targetFS string) (map[string]string, error) {
+	tokens, err := m.ZFS.ResumeTokens(targetFS)
+	if err != nil {
+		return nil, fmt.Errorf("resume tokens: %w", err)
+	}
+	prefix := strings.TrimSuffix(targetFS, "/") + "/"
+	relTokens := make(map[string]string, len(tokens))
+	for fs, token := range tokens {
+		if !strings.HasPrefix(fs, prefix) {
+			continue
+		}
+		relTokens[strings.TrimPrefix(fs, prefix)] = token
+	}
+	return relTokens, nil
+}
+
+// ResumeReceive resumes an interrupted receive into the file system fs below
+// targetFS.
+//
+// The data read from r must have been created by calling ResumeSend with the
+// resume token of the file system.
+func (m *Manager) ResumeReceive(targetFS, fs string, r io.Reader) error {
+	if err := m.ZFS.Receive(path.Join(targetFS, fs), true, r); err != nil {
+		return fmt.Errorf("resume receive: %w", err)
+	}
+	return nil
+}
+
+// AbortReceive discards the partially received state of the interrupted
+// receive into the file system fs below targetFS. Afterwards the file system
+// accepts regular incremental receives again.
+func (m *Manager) AbortReceive(targetFS, fs string) error {
+	if err := m.ZFS.AbortReceive(path.Join(targetFS, fs)); err != nil {
+		return fmt.Errorf("abort receive: %w", err)
+	}
+	return nil
+}
+
 // SendSnapshot writes the snapshot identified by name to w.
 //
 // By passing the Reference option only data changed between the passed
-// reference and name is written to w.
+// reference and name is written to w. The BookmarkReference option uses the
+// bookmark of the reference instead.
 func (m *Manager) SendSnapshot(name Name, w io.Writer, opts ...SendOption) error {
 	var (
 		snExists, refExists bool
@@ -244,6 +1191,14 @@ func (m *Manager) SendSnapshot(name Name, w io.Writer, opts ...SendOption) error
 	if (sOpts.Reference != Name{}) {
 		ref = sOpts.Reference.String()
 	}
+	if (sOpts.Bookmark != Name{}) {
+		ref = sOpts.Bookmark.Bookmark()
+		bookmarks, err := m.ListBookmarks()
+		if err != nil {
+			return fmt.Errorf("send snapshot: %w", err)
+		}
+		refExists = containsName(bookmarks, sOpts.Bookmark)
+	}
 
 	err := m.listSnapshots(func(n Name) {
 		if n == name {
@@ -264,3 +1219,13 @@ func (m *Manager) SendSnapshot(name Name, w io.Writer, opts ...SendOption) error
 	}
 	return m.ZFS.Send(name.String(), ref, w)
 }
+
+// ResumeSend writes the remaining data of an interrupted send to w.
+//
+// token must be a resume token returned by ResumeTokens on the receiving side.
+func (m *Manager) ResumeSend(token string, w io.Writer) error {
+	if err := m.ZFS.SendResume(token, w); err != nil {
+		return fmt.Errorf("resume send: %w", err)
+	}
+	return nil
+}
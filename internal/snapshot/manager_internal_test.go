@@ -0,0 +1,47 @@
+package snapshot
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBatchSnapshots(t *testing.T) {
+	tests := []struct {
+		name     string
+		units    [][]string
+		maxLen   int
+		expected [][]string
+	}{
+		{
+			name:     "no units",
+			maxLen:   10,
+			expected: nil,
+		},
+		{
+			name:     "all units fit",
+			units:    [][]string{{"a@1"}, {"b@1", "c@1"}},
+			maxLen:   12,
+			expected: [][]string{{"a@1", "b@1", "c@1"}},
+		},
+		{
+			name:     "split between units",
+			units:    [][]string{{"a@1"}, {"b@1", "c@1"}, {"d@1"}},
+			maxLen:   8,
+			expected: [][]string{{"a@1"}, {"b@1", "c@1"}, {"d@1"}},
+		},
+		{
+			name:     "never split units",
+			units:    [][]string{{"a@1", "b@1", "c@1"}, {"d@1"}},
+			maxLen:   4,
+			expected: [][]string{{"a@1", "b@1", "c@1"}, {"d@1"}},
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, batchSnapshots(tt.units, tt.maxLen))
+		})
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"math/rand"
+	"strings"
 	"testing"
 	"time"
 
@@ -12,6 +13,7 @@ import (
 	"github.com/fhofherr/zsm/internal/zfs"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
 )
 
 func TestManager_Initialization(t *testing.T) {
@@ -25,7 +27,8 @@ func TestManager_Initialization(t *testing.T) {
 			name: "CreateSnapshot fails on missing ZFSAdapter",
 			mgr:  &snapshot.Manager{},
 			callMgr: func(mgr *snapshot.Manager) error {
-				return mgr.CreateSnapshots()
+				_, err := mgr.CreateSnapshots()
+				return err
 			},
 			expectedErr: errors.New("initialization error: ZFSAdapter nil"),
 		},
@@ -52,19 +55,13 @@ func TestManager_CreateSnapshots(t *testing.T) {
 		adapter := &snapshot.MockZFSAdapter{}
 		adapter.Test(t)
 		adapter.On("List", zfs.FileSystem).Return(allFileSystems, nil)
+		adapter.On("Properties", zfs.FileSystem, []string{snapshot.PropertySnapshot, snapshot.PropertySkipUnchanged}).
+			Return(map[string]map[string]string{}, nil).Maybe()
+
+		adapter.On("CreateSnapshots", map[string]string(nil), snapshotsOf(t, allFileSystems...)).Return(nil)
 
-		for _, fs := range allFileSystems {
-			fs := fs
-			adapter.On("CreateSnapshot", mock.MatchedBy(func(n interface{}) bool {
-				if name, ok := n.(string); ok {
-					return snapshot.AssertNameFormat(t, fs, name)
-				}
-				t.Errorf("%v is not string", n)
-				return false
-			})).Return(nil)
-		}
 		mgr := &snapshot.Manager{ZFS: adapter}
-		err := mgr.CreateSnapshots()
+		_, err := mgr.CreateSnapshots()
 
 		assert.NoError(t, err)
 		adapter.AssertExpectations(t)
@@ -76,22 +73,18 @@ func TestManager_CreateSnapshots(t *testing.T) {
 		adapter := &snapshot.MockZFSAdapter{}
 		adapter.Test(t)
 		adapter.On("List", zfs.FileSystem).Return(allFileSystems, nil)
+		adapter.On("Properties", zfs.FileSystem, []string{snapshot.PropertySnapshot, snapshot.PropertySkipUnchanged}).
+			Return(map[string]map[string]string{}, nil).Maybe()
+
+		adapter.On("CreateSnapshots", map[string]string(nil), snapshotsOf(t, selectedFileSystems...)).Return(nil)
 
 		opts := make([]snapshot.CreateOption, 0, len(selectedFileSystems))
 		for _, fs := range selectedFileSystems {
-			fs := fs
-			adapter.On("CreateSnapshot", mock.MatchedBy(func(n interface{}) bool {
-				if name, ok := n.(string); ok {
-					return snapshot.AssertNameFormat(t, fs, name)
-				}
-				t.Errorf("%v is not string", n)
-				return false
-			})).Return(nil)
 			opts = append(opts, snapshot.FromFileSystem(fs))
 		}
 
 		mgr := &snapshot.Manager{ZFS: adapter}
-		err := mgr.CreateSnapshots(opts...)
+		_, err := mgr.CreateSnapshots(opts...)
 
 		assert.NoError(t, err)
 		adapter.AssertExpectations(t)
@@ -103,9 +96,11 @@ func TestManager_CreateSnapshots(t *testing.T) {
 		adapter := &snapshot.MockZFSAdapter{}
 		adapter.Test(t)
 		adapter.On("List", zfs.FileSystem).Return(allFileSystems, nil)
+		adapter.On("Properties", zfs.FileSystem, []string{snapshot.PropertySnapshot, snapshot.PropertySkipUnchanged}).
+			Return(map[string]map[string]string{}, nil).Maybe()
 
 		mgr := &snapshot.Manager{ZFS: adapter}
-		err := mgr.CreateSnapshots(snapshot.FromFileSystem(unknownFileSystem))
+		_, err := mgr.CreateSnapshots(snapshot.FromFileSystem(unknownFileSystem))
 
 		assert.EqualError(t, err, fmt.Sprintf("unknown filesystem: %q", unknownFileSystem))
 	})
@@ -119,8 +114,13 @@ func TestManager_CreateSnapshots(t *testing.T) {
 		adapter := &snapshot.MockZFSAdapter{}
 		adapter.Test(t)
 		adapter.On("List", zfs.FileSystem).Return(allFileSystems, nil)
+		adapter.On("Properties", zfs.FileSystem, []string{snapshot.PropertySnapshot, snapshot.PropertySkipUnchanged}).
+			Return(map[string]map[string]string{}, nil).Maybe()
 
-		var opts []snapshot.CreateOption
+		var (
+			opts     []snapshot.CreateOption
+			selected []string
+		)
 		for _, fs := range allFileSystems {
 			if isFileSystemExcluded(excludedFileSystems, fs) {
 				// Sometimes the file systems might be specified with a
@@ -130,21 +130,329 @@ func TestManager_CreateSnapshots(t *testing.T) {
 				opts = append(opts, snapshot.ExcludeFileSystem(fs))
 				continue
 			}
-			fs := fs
-			adapter.On("CreateSnapshot", mock.MatchedBy(func(n interface{}) bool {
-				if name, ok := n.(string); ok {
-					return snapshot.AssertNameFormat(t, fs, name)
-				}
-				t.Errorf("%v is not string", n)
-				return false
-			})).Return(nil)
+			selected = append(selected, fs)
 		}
+		adapter.On("CreateSnapshots", map[string]string(nil), snapshotsOf(t, selected...)).Return(nil)
+
+		mgr := &snapshot.Manager{ZFS: adapter}
+		_, err := mgr.CreateSnapshots(opts...)
+		assert.NoError(t, err)
+		adapter.AssertExpectations(t)
+	})
+
+	t.Run("create snapshots of selected file systems recursively", func(t *testing.T) {
+		adapter := &snapshot.MockZFSAdapter{}
+		adapter.Test(t)
+		adapter.On("List", zfs.FileSystem).Return(allFileSystems, nil)
+		adapter.On("Properties", zfs.FileSystem, []string{snapshot.PropertySnapshot, snapshot.PropertySkipUnchanged}).
+			Return(map[string]map[string]string{}, nil)
+		adapter.On("CreateSnapshots", map[string]string(nil), snapshotsOf(t, allFileSystems[2:]...)).Return(nil)
+
+		mgr := &snapshot.Manager{ZFS: adapter}
+		_, err := mgr.CreateSnapshots(snapshot.FromFileSystem("zsm_test/fs_2"), snapshot.Recursive())
+		assert.NoError(t, err)
+		adapter.AssertExpectations(t)
+	})
+
+	t.Run("exclude whole subtrees", func(t *testing.T) {
+		adapter := &snapshot.MockZFSAdapter{}
+		adapter.Test(t)
+		adapter.On("List", zfs.FileSystem).Return(allFileSystems, nil)
+		adapter.On("Properties", zfs.FileSystem, []string{snapshot.PropertySnapshot, snapshot.PropertySkipUnchanged}).
+			Return(map[string]map[string]string{}, nil)
+		adapter.On("CreateSnapshots", map[string]string(nil), snapshotsOf(t, allFileSystems[:2]...)).Return(nil)
+
+		mgr := &snapshot.Manager{ZFS: adapter}
+		_, err := mgr.CreateSnapshots(snapshot.ExcludeFileSystem("zsm_test/fs_2"))
+		assert.NoError(t, err)
+		adapter.AssertExpectations(t)
+	})
+
+	t.Run("exclude single file system only", func(t *testing.T) {
+		adapter := &snapshot.MockZFSAdapter{}
+		adapter.Test(t)
+		adapter.On("List", zfs.FileSystem).Return(allFileSystems, nil)
+		adapter.On("Properties", zfs.FileSystem, []string{snapshot.PropertySnapshot, snapshot.PropertySkipUnchanged}).
+			Return(map[string]map[string]string{}, nil)
+		adapter.On("CreateSnapshots", map[string]string(nil), snapshotsOf(t, "zsm_test/fs_2/nested_fs_1")).Return(nil)
+
+		mgr := &snapshot.Manager{ZFS: adapter}
+		_, err := mgr.CreateSnapshots(
+			snapshot.FromFileSystem("zsm_test/fs_2"),
+			snapshot.Recursive(),
+			snapshot.ExcludeFileSystemOnly("zsm_test/fs_2"),
+		)
+		assert.NoError(t, err)
+		adapter.AssertExpectations(t)
+	})
+
+	t.Run("select and exclude file systems by pattern", func(t *testing.T) {
+		adapter := &snapshot.MockZFSAdapter{}
+		adapter.Test(t)
+		adapter.On("List", zfs.FileSystem).Return(allFileSystems, nil)
+		adapter.On("Properties", zfs.FileSystem, []string{snapshot.PropertySnapshot, snapshot.PropertySkipUnchanged}).
+			Return(map[string]map[string]string{}, nil)
+		adapter.On("CreateSnapshots", map[string]string(nil), snapshotsOf(t, "zsm_test/fs_1", "zsm_test/fs_2")).
+			Return(nil)
+
+		mgr := &snapshot.Manager{ZFS: adapter}
+		_, err := mgr.CreateSnapshots(
+			snapshot.FromFileSystem("zsm_test/fs_*"),
+			snapshot.FromFileSystem("re:zsm_test/fs_2/.*"),
+			snapshot.ExcludeFileSystemOnly("re:.*/nested_fs_[0-9]"),
+		)
+		assert.NoError(t, err)
+		adapter.AssertExpectations(t)
+	})
+
+	t.Run("pattern matching no file system", func(t *testing.T) {
+		adapter := &snapshot.MockZFSAdapter{}
+		adapter.Test(t)
+		adapter.On("List", zfs.FileSystem).Return(allFileSystems, nil)
+
+		mgr := &snapshot.Manager{ZFS: adapter}
+		_, err := mgr.CreateSnapshots(snapshot.FromFileSystem("zsm_test/fs_3*"))
+		assert.EqualError(t, err, `unknown filesystem: "zsm_test/fs_3*"`)
+		adapter.AssertExpectations(t)
+	})
+
+	t.Run("invalid pattern", func(t *testing.T) {
+		adapter := &snapshot.MockZFSAdapter{}
+		adapter.Test(t)
+
+		mgr := &snapshot.Manager{ZFS: adapter}
+		_, err := mgr.CreateSnapshots(snapshot.ExcludeFileSystem("zsm_test/[fs"))
+		assert.EqualError(t, err, `create snapshot: invalid pattern "zsm_test/[fs": syntax error in pattern`)
+		adapter.AssertExpectations(t)
+	})
+
+	t.Run("ignore file systems with snapshot property false", func(t *testing.T) {
+		adapter := &snapshot.MockZFSAdapter{}
+		adapter.Test(t)
+		adapter.On("List", zfs.FileSystem).Return(allFileSystems, nil)
+		adapter.On("Properties", zfs.FileSystem, []string{snapshot.PropertySnapshot, snapshot.PropertySkipUnchanged}).
+			Return(map[string]map[string]string{
+				"zsm_test/fs_1":             {snapshot.PropertySnapshot: "true"},
+				"zsm_test/fs_2":             {snapshot.PropertySnapshot: "false"},
+				"zsm_test/fs_2/nested_fs_1": {snapshot.PropertySnapshot: "false"},
+			}, nil)
+
+		adapter.On("CreateSnapshots", map[string]string(nil), snapshotsOf(t, allFileSystems[:2]...)).Return(nil)
 
 		mgr := &snapshot.Manager{ZFS: adapter}
-		err := mgr.CreateSnapshots(opts...)
+		_, err := mgr.CreateSnapshots()
 		assert.NoError(t, err)
 		adapter.AssertExpectations(t)
 	})
+
+	t.Run("snapshot consistency groups together", func(t *testing.T) {
+		adapter := &snapshot.MockZFSAdapter{}
+		adapter.Test(t)
+		adapter.On("List", zfs.FileSystem).Return(allFileSystems, nil)
+		adapter.On("Properties", zfs.FileSystem, []string{snapshot.PropertySnapshot, snapshot.PropertySkipUnchanged}).
+			Return(map[string]map[string]string{}, nil)
+		adapter.On("CreateSnapshots", map[string]string(nil),
+			snapshotsOf(t, "zsm_test/fs_2/nested_fs_1", "zsm_test/fs_1")).Return(nil)
+
+		mgr := &snapshot.Manager{ZFS: adapter}
+		_, err := mgr.CreateSnapshots(
+			snapshot.FromFileSystem("zsm_test/fs_1"),
+			snapshot.ConsistencyGroup("app", "zsm_test/fs_2/nested_fs_1", "zsm_test/fs_1"),
+		)
+		assert.NoError(t, err)
+		adapter.AssertExpectations(t)
+	})
+
+	t.Run("consistency group with excluded member", func(t *testing.T) {
+		adapter := &snapshot.MockZFSAdapter{}
+		adapter.Test(t)
+		adapter.On("List", zfs.FileSystem).Return(allFileSystems, nil)
+		adapter.On("Properties", zfs.FileSystem, []string{snapshot.PropertySnapshot, snapshot.PropertySkipUnchanged}).
+			Return(map[string]map[string]string{}, nil)
+
+		mgr := &snapshot.Manager{ZFS: adapter}
+		_, err := mgr.CreateSnapshots(
+			snapshot.ExcludeFileSystem("zsm_test/fs_2"),
+			snapshot.ConsistencyGroup("app", "zsm_test/fs_1", "zsm_test/fs_2"),
+		)
+		assert.EqualError(t, err, "create snapshot: consistency group app: excluded file system: zsm_test/fs_2")
+		adapter.AssertExpectations(t)
+	})
+
+	t.Run("file system in multiple consistency groups", func(t *testing.T) {
+		adapter := &snapshot.MockZFSAdapter{}
+		adapter.Test(t)
+		adapter.On("List", zfs.FileSystem).Return(allFileSystems, nil)
+		adapter.On("Properties", zfs.FileSystem, []string{snapshot.PropertySnapshot, snapshot.PropertySkipUnchanged}).
+			Return(map[string]map[string]string{}, nil)
+
+		mgr := &snapshot.Manager{ZFS: adapter}
+		_, err := mgr.CreateSnapshots(
+			snapshot.ConsistencyGroup("app", "zsm_test/fs_1", "zsm_test/fs_2"),
+			snapshot.ConsistencyGroup("db", "zsm_test/fs_2"),
+		)
+		assert.EqualError(t, err, "create snapshot: zsm_test/fs_2: member of consistency groups app and db")
+		adapter.AssertExpectations(t)
+	})
+
+	t.Run("create snapshots of file systems and volumes", func(t *testing.T) {
+		typ := zfs.Types(zfs.FileSystem, zfs.Volume)
+		datasets := append([]string{"zsm_test/vol_1"}, allFileSystems...)
+
+		adapter := &snapshot.MockZFSAdapter{}
+		adapter.Test(t)
+		adapter.On("List", typ).Return(datasets, nil)
+		adapter.On("Properties", typ, []string{snapshot.PropertySnapshot, snapshot.PropertySkipUnchanged}).
+			Return(map[string]map[string]string{}, nil)
+		adapter.On("CreateSnapshots", map[string]string(nil), snapshotsOf(t, datasets...)).Return(nil)
+
+		mgr := &snapshot.Manager{ZFS: adapter, DatasetTypes: typ}
+		_, err := mgr.CreateSnapshots()
+		assert.NoError(t, err)
+		adapter.AssertExpectations(t)
+	})
+
+	t.Run("skip unchanged file systems", func(t *testing.T) {
+		adapter := &snapshot.MockZFSAdapter{}
+		adapter.Test(t)
+		adapter.On("List", zfs.FileSystem).Return(allFileSystems, nil)
+		adapter.On("List", zfs.Snapshot).Return([]string{
+			"zsm_test@2020-04-10T09:45:58.564585005Z",
+			"zsm_test/fs_1@2020-04-10T09:45:58.564585005Z",
+			"zsm_test/fs_2@2020-04-10T09:45:58.564585005Z",
+			"zsm_test/fs_2/nested_fs_1@2020-04-10T09:45:58.564585005Z",
+		}, nil)
+		adapter.On("Properties", zfs.FileSystem, []string{snapshot.PropertySnapshot, snapshot.PropertySkipUnchanged}).
+			Return(map[string]map[string]string{
+				"zsm_test/fs_1": {snapshot.PropertySkipUnchanged: "false"},
+			}, nil)
+		adapter.On("Written", "zsm_test@2020-04-10T09:45:58.564585005Z").Return(uint64(0), nil)
+		adapter.On("Written", "zsm_test/fs_2@2020-04-10T09:45:58.564585005Z").Return(uint64(0), nil)
+		adapter.On("Written", "zsm_test/fs_2/nested_fs_1@2020-04-10T09:45:58.564585005Z").Return(uint64(4096), nil)
+		adapter.On("Properties", zfs.Snapshot, []string{snapshot.PropertyExpires}).
+			Return(map[string]map[string]string{}, nil)
+		adapter.On("CreateSnapshots", map[string]string(nil), snapshotsOf(t,
+			"zsm_test/fs_1", "zsm_test/fs_2", "zsm_test/fs_2/nested_fs_1",
+		)).Return(nil)
+
+		mgr := &snapshot.Manager{ZFS: adapter}
+		skipped, err := mgr.CreateSnapshots(
+			snapshot.SkipUnchanged(),
+			snapshot.ConsistencyGroup("app", "zsm_test/fs_2", "zsm_test/fs_2/nested_fs_1"),
+		)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"zsm_test"}, skipped)
+		adapter.AssertExpectations(t)
+	})
+
+	t.Run("create expiring snapshots", func(t *testing.T) {
+		expires := time.Now().Add(14 * 24 * time.Hour)
+		props := map[string]string{snapshot.PropertyExpires: expires.UTC().Format(time.RFC3339)}
+
+		adapter := &snapshot.MockZFSAdapter{}
+		adapter.Test(t)
+		adapter.On("List", zfs.FileSystem).Return(allFileSystems, nil)
+		adapter.On("Properties", zfs.FileSystem, []string{snapshot.PropertySnapshot, snapshot.PropertySkipUnchanged}).
+			Return(map[string]map[string]string{}, nil)
+		adapter.On("CreateSnapshots", props, snapshotsOf(t, allFileSystems...)).Return(nil)
+
+		mgr := &snapshot.Manager{ZFS: adapter}
+		// Expiring snapshots are never skipped.
+		_, err := mgr.CreateSnapshots(snapshot.Expires(expires), snapshot.SkipUnchanged())
+		assert.NoError(t, err)
+		adapter.AssertExpectations(t)
+	})
+
+	t.Run("expiry in the past", func(t *testing.T) {
+		adapter := &snapshot.MockZFSAdapter{}
+		adapter.Test(t)
+
+		mgr := &snapshot.Manager{ZFS: adapter}
+		_, err := mgr.CreateSnapshots(snapshot.Expires(time.Date(2020, 4, 10, 0, 0, 0, 0, time.UTC)))
+		assert.EqualError(t, err, "create snapshot: expiry in the past: 2020-04-10T00:00:00Z")
+		adapter.AssertNotCalled(t, "CreateSnapshots", mock.Anything, mock.Anything)
+	})
+
+	t.Run("stop at the first error", func(t *testing.T) {
+		adapter := &snapshot.MockZFSAdapter{}
+		adapter.Test(t)
+		adapter.On("List", zfs.FileSystem).Return(allFileSystems, nil)
+		adapter.On("Properties", zfs.FileSystem, []string{snapshot.PropertySnapshot, snapshot.PropertySkipUnchanged}).
+			Return(map[string]map[string]string{}, nil)
+		adapter.On("CreateSnapshots", map[string]string(nil), snapshotsOf(t, allFileSystems...)).
+			Return(&zfs.Error{SubCommand: "snapshot", ExitCode: 1, Stderr: "dataset is busy\n"})
+
+		mgr := &snapshot.Manager{ZFS: adapter}
+		_, err := mgr.CreateSnapshots()
+		assert.EqualError(t, err, "create snapshot: zfs snapshot: exit code: 1")
+		adapter.AssertNumberOfCalls(t, "CreateSnapshots", 1)
+	})
+
+	t.Run("best effort", func(t *testing.T) {
+		busy := &zfs.Error{SubCommand: "snapshot", ExitCode: 1, Stderr: "dataset is busy\n"}
+		adapter := &snapshot.MockZFSAdapter{}
+		adapter.Test(t)
+		adapter.On("List", zfs.FileSystem).Return(allFileSystems, nil)
+		adapter.On("Properties", zfs.FileSystem, []string{snapshot.PropertySnapshot, snapshot.PropertySkipUnchanged}).
+			Return(map[string]map[string]string{}, nil)
+		adapter.On("CreateSnapshots", map[string]string(nil), snapshotsOf(t, allFileSystems...)).Return(busy).Once()
+		adapter.On("CreateSnapshots", map[string]string(nil), snapshotsOf(t, "zsm_test")).Return(nil).Once()
+		adapter.On("CreateSnapshots", map[string]string(nil), snapshotsOf(t, "zsm_test/fs_1")).Return(busy).Once()
+		adapter.On("CreateSnapshots", map[string]string(nil),
+			snapshotsOf(t, "zsm_test/fs_2", "zsm_test/fs_2/nested_fs_1")).Return(nil).Once()
+
+		mgr := &snapshot.Manager{ZFS: adapter}
+		_, err := mgr.CreateSnapshots(snapshot.CreateBestEffort(), snapshot.ConsistencyGroup("fs_2",
+			"zsm_test/fs_2", "zsm_test/fs_2/nested_fs_1"))
+		var partialErr *snapshot.PartialError
+		require.True(t, errors.As(err, &partialErr))
+		assert.True(t, errors.Is(err, busy))
+		if assert.Len(t, partialErr.Succeeded, 3) {
+			snapshot.AssertNameFormat(t, "zsm_test", partialErr.Succeeded[0])
+			snapshot.AssertNameFormat(t, "zsm_test/fs_2", partialErr.Succeeded[1])
+			snapshot.AssertNameFormat(t, "zsm_test/fs_2/nested_fs_1", partialErr.Succeeded[2])
+		}
+		if assert.Len(t, partialErr.Failures, 1) {
+			snapshot.AssertNameFormat(t, "zsm_test/fs_1", partialErr.Failures[0].Name)
+			assert.Equal(t, busy, partialErr.Failures[0].Err)
+		}
+		adapter.AssertExpectations(t)
+	})
+
+	t.Run("invalid snapshot property", func(t *testing.T) {
+		adapter := &snapshot.MockZFSAdapter{}
+		adapter.Test(t)
+		adapter.On("List", zfs.FileSystem).Return(allFileSystems, nil)
+		adapter.On("Properties", zfs.FileSystem, []string{snapshot.PropertySnapshot, snapshot.PropertySkipUnchanged}).
+			Return(map[string]map[string]string{
+				"zsm_test/fs_2": {snapshot.PropertySnapshot: "maybe"},
+			}, nil)
+
+		mgr := &snapshot.Manager{ZFS: adapter}
+		_, err := mgr.CreateSnapshots()
+		assert.EqualError(t, err,
+			"create snapshot: zsm_test/fs_2: invalid value for com.github.fhofherr.zsm:snapshot: maybe")
+		adapter.AssertExpectations(t)
+	})
+}
+
+// snapshotsOf matches the names passed to CreateSnapshots if they are
+// snapshots of fileSystems in the same order.
+func snapshotsOf(t *testing.T, fileSystems ...string) interface{} {
+	return mock.MatchedBy(func(names []string) bool {
+		if len(names) != len(fileSystems) {
+			return false
+		}
+		for i, name := range names {
+			if !strings.HasPrefix(name, fileSystems[i]+"@") {
+				return false
+			}
+			if !snapshot.AssertNameFormat(t, fileSystems[i], name) {
+				return false
+			}
+		}
+		return true
+	})
 }
 
 func isFileSystemExcluded(excludedFileSystems []string, fs string) bool {
@@ -202,6 +510,56 @@ func TestManager_ListSnapshots(t *testing.T) {
 	}
 }
 
+func TestManager_ListSnapshots_NoSnapshots(t *testing.T) {
+	adapter := &snapshot.MockZFSAdapter{}
+	adapter.On("List", zfs.Snapshot).Return([]string(nil), fmt.Errorf("zfs list: %w", zfs.ErrNoOutput))
+
+	sm := &snapshot.Manager{ZFS: adapter}
+	names, err := sm.ListSnapshots()
+	assert.NoError(t, err)
+	assert.Empty(t, names)
+}
+
+func TestManager_ListBookmarks(t *testing.T) {
+	adapter := &snapshot.MockZFSAdapter{}
+	adapter.Test(t)
+	adapter.On("List", zfs.Bookmark).Return([]string{
+		"zsm_test#2020-04-10T09:45:58.564585005Z",
+		"zsm_test#monday",
+	}, nil)
+
+	sm := &snapshot.Manager{ZFS: adapter}
+	names, err := sm.ListBookmarks()
+	assert.NoError(t, err)
+	assert.Equal(t, []snapshot.Name{snapshot.MustParseName(t, "zsm_test@2020-04-10T09:45:58.564585005Z")}, names)
+	adapter.AssertExpectations(t)
+}
+
+func TestManager_ListBookmarks_NoBookmarks(t *testing.T) {
+	adapter := &snapshot.MockZFSAdapter{}
+	adapter.On("List", zfs.Bookmark).Return([]string(nil), fmt.Errorf("zfs list: %w", zfs.ErrNoOutput))
+
+	sm := &snapshot.Manager{ZFS: adapter}
+	names, err := sm.ListBookmarks()
+	assert.NoError(t, err)
+	assert.Empty(t, names)
+}
+
+func TestManager_BookmarkSnapshot(t *testing.T) {
+	name := snapshot.MustParseName(t, "zsm_test@2020-04-10T09:45:58.564585005Z")
+	adapter := &snapshot.MockZFSAdapter{}
+	adapter.Test(t)
+	adapter.On("Bookmark", name.String(), "zsm_test#2020-04-10T09:45:58.564585005Z").Return(nil)
+	adapter.On("GUID", "zsm_test#2020-04-10T09:45:58.564585005Z").Return(uint64(42), nil)
+
+	sm := &snapshot.Manager{ZFS: adapter}
+	assert.NoError(t, sm.BookmarkSnapshot(name))
+	guid, err := sm.BookmarkGUID(name)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(42), guid)
+	adapter.AssertExpectations(t)
+}
+
 func TestManager_CleanSnapshots(t *testing.T) {
 	allSnapshots := []string{
 		"zsm_test@2020-04-10T09:45:58.564585005Z",
@@ -226,17 +584,254 @@ func TestManager_CleanSnapshots(t *testing.T) {
 	adapter := &snapshot.MockZFSAdapter{}
 	adapter.Test(t)
 	adapter.On("List", zfs.Snapshot).Return(allSnapshots, nil)
-	adapter.On("Destroy", "zsm_test@2020-04-10T09:43:58.564585005Z").Return(nil)
-	adapter.On("Destroy", "zsm_test@2020-04-10T07:44:58.564585005Z").Return(nil)
-	adapter.On("Destroy", "zsm_test/fs_1@2020-04-10T09:43:58.564585005Z").Return(nil)
-	adapter.On("Destroy", "zsm_test/fs_1@2020-04-10T07:44:58.564585005Z").Return(nil)
+	adapter.On("Properties", zfs.FileSystem, mock.Anything).Return(map[string]map[string]string{}, nil)
+	adapter.On("Properties", zfs.Snapshot, []string{snapshot.PropertyExpires}).
+		Return(map[string]map[string]string{}, nil)
+	adapter.On("Properties", zfs.Snapshot, []string{"userrefs"}).Return(map[string]map[string]string{
+		"zsm_test@2020-04-10T09:45:58.564585005Z": {"userrefs": "1"},
+		"zsm_test@2020-04-10T07:44:58.564585005Z": {"userrefs": "2"}, // outdated but held
+		"zsm_test@2020-04-10T09:43:58.564585005Z": {"userrefs": "0"},
+	}, nil)
+	adapter.On("DestroySnapshots", []string{"zsm_test@2020-04-10T09:43:58.564585005Z"}).Return(nil)
+	adapter.On("DestroySnapshots", []string{
+		"zsm_test/fs_1@2020-04-10T09:43:58.564585005Z",
+		"zsm_test/fs_1@2020-04-10T07:44:58.564585005Z",
+	}).Return(nil)
 
 	mgr := &snapshot.Manager{ZFS: adapter}
-	err := mgr.CleanSnapshots(cfg)
+	held, err := mgr.CleanSnapshots(cfg)
 	assert.NoError(t, err)
+	assert.Equal(t, []snapshot.Name{snapshot.MustParseName(t, "zsm_test@2020-04-10T07:44:58.564585005Z")}, held)
+	adapter.AssertNotCalled(t, "Destroy", mock.Anything)
 	adapter.AssertExpectations(t)
 }
 
+func TestManager_CleanSnapshots_DestroyOneByOne(t *testing.T) {
+	allSnapshots := []string{
+		"zsm_test@2020-04-10T09:45:58.564585005Z",
+		"zsm_test@2020-04-10T09:44:58.564585005Z",
+		"zsm_test@2020-04-10T09:43:58.564585005Z",
+		"zsm_test@2020-04-10T09:42:58.564585005Z",
+	}
+	cfg := snapshot.BucketConfig{snapshot.Minute: 1}
+
+	adapter := &snapshot.MockZFSAdapter{}
+	adapter.Test(t)
+	adapter.On("List", zfs.Snapshot).Return(allSnapshots, nil)
+	adapter.On("Properties", zfs.FileSystem, mock.Anything).Return(map[string]map[string]string{}, nil)
+	adapter.On("Properties", zfs.Snapshot, mock.Anything).Return(map[string]map[string]string{}, nil)
+	adapter.On("DestroySnapshots", allSnapshots[1:]).Return(errors.New("dataset is busy"))
+	adapter.On("Destroy", allSnapshots[1]).Return(nil)
+	// The snapshot has been held after PlanClean checked for holds.
+	adapter.On("Destroy", allSnapshots[2]).Return(errors.New("dataset is busy"))
+	adapter.On("Destroy", allSnapshots[3]).Return(nil)
+
+	mgr := &snapshot.Manager{ZFS: adapter}
+	_, err := mgr.CleanSnapshots(cfg)
+	assert.EqualError(t, err, "clean snapshots: dataset is busy")
+	adapter.AssertExpectations(t)
+}
+
+func TestManager_CleanSnapshots_BestEffort(t *testing.T) {
+	allSnapshots := []string{
+		"zsm_test@2020-04-10T09:45:58.564585005Z",
+		"zsm_test@2020-04-10T09:44:58.564585005Z",
+		"zsm_test/fs_1@2020-04-10T09:45:58.564585005Z",
+		"zsm_test/fs_1@2020-04-10T09:44:58.564585005Z",
+	}
+	busy := &zfs.Error{SubCommand: "destroy", ExitCode: 1, Stderr: "dataset is busy\n"}
+	cfg := snapshot.BucketConfig{snapshot.Minute: 1}
+
+	newAdapter := func(t *testing.T) *snapshot.MockZFSAdapter {
+		adapter := &snapshot.MockZFSAdapter{}
+		adapter.Test(t)
+		adapter.On("List", zfs.Snapshot).Return(allSnapshots, nil)
+		adapter.On("Properties", zfs.FileSystem, mock.Anything).Return(map[string]map[string]string{}, nil)
+		adapter.On("Properties", zfs.Snapshot, mock.Anything).Return(map[string]map[string]string{}, nil)
+		adapter.On("DestroySnapshots", allSnapshots[1:2]).Return(busy)
+		adapter.On("Destroy", allSnapshots[1]).Return(busy)
+		return adapter
+	}
+
+	t.Run("stop at the first error", func(t *testing.T) {
+		adapter := newAdapter(t)
+
+		mgr := &snapshot.Manager{ZFS: adapter}
+		_, err := mgr.CleanSnapshots(cfg)
+		assert.EqualError(t, err, "clean snapshots: zfs destroy: exit code: 1")
+		adapter.AssertExpectations(t)
+		adapter.AssertNotCalled(t, "DestroySnapshots", allSnapshots[3:])
+	})
+
+	t.Run("best effort", func(t *testing.T) {
+		adapter := newAdapter(t)
+		adapter.On("DestroySnapshots", allSnapshots[3:]).Return(nil)
+
+		mgr := &snapshot.Manager{ZFS: adapter}
+		_, err := mgr.CleanSnapshots(cfg, snapshot.CleanBestEffort())
+		assert.EqualError(t, err, "clean snapshots: 1 of 2 snapshots failed\n"+
+			"zsm_test@2020-04-10T09:44:58.564585005Z: zfs destroy: exit code: 1: dataset is busy")
+		assert.Equal(t, &snapshot.PartialError{
+			Succeeded: allSnapshots[3:],
+			Failures:  []snapshot.Failure{{Name: allSnapshots[1], Err: busy}},
+		}, errors.Unwrap(err))
+		adapter.AssertExpectations(t)
+	})
+}
+
+func TestManager_PlanClean(t *testing.T) {
+	allSnapshots := []string{
+		"zsm_test/fs_1@2020-04-10T09:44:58.564585005Z",
+		"zsm_test/fs_1@2020-04-10T07:44:58.564585005Z",
+		"zsm_test@2020-04-10T09:45:58.564585005Z",
+		"zsm_test@2020-04-10T09:44:58.564585005Z",
+		"zsm_test@2020-04-10T09:43:58.564585005Z",
+		"zsm_test/fs_1@2020-04-10T09:45:58.564585005Z",
+		"zsm_test/fs_1@2020-04-10T09:43:58.564585005Z",
+		"zsm_test/fs_1@2020-04-10T08:44:58.564585005Z",
+		"zsm_test@2020-04-10T08:44:58.564585005Z",
+		"zsm_test@2020-04-10T07:44:58.564585005Z",
+	}
+	cfg := snapshot.BucketConfig{snapshot.Minute: 2, snapshot.Hour: 2}
+	expected := []snapshot.CleanPlan{
+		{
+			FileSystem: "zsm_test",
+			Keep: []snapshot.Retained{
+				{
+					Name:      snapshot.MustParseName(t, "zsm_test@2020-04-10T09:45:58.564585005Z"),
+					Intervals: []snapshot.Interval{snapshot.Minute, snapshot.Hour},
+				},
+				{
+					Name:      snapshot.MustParseName(t, "zsm_test@2020-04-10T09:44:58.564585005Z"),
+					Intervals: []snapshot.Interval{snapshot.Minute},
+				},
+				{
+					Name:      snapshot.MustParseName(t, "zsm_test@2020-04-10T08:44:58.564585005Z"),
+					Intervals: []snapshot.Interval{snapshot.Hour},
+				},
+			},
+			Reject: []snapshot.Name{
+				snapshot.MustParseName(t, "zsm_test@2020-04-10T09:43:58.564585005Z"),
+				snapshot.MustParseName(t, "zsm_test@2020-04-10T07:44:58.564585005Z"),
+			},
+		},
+		{
+			FileSystem: "zsm_test/fs_1",
+			Keep: []snapshot.Retained{
+				{
+					Name:      snapshot.MustParseName(t, "zsm_test/fs_1@2020-04-10T09:45:58.564585005Z"),
+					Intervals: []snapshot.Interval{snapshot.Minute, snapshot.Hour},
+				},
+				{
+					Name:      snapshot.MustParseName(t, "zsm_test/fs_1@2020-04-10T09:44:58.564585005Z"),
+					Intervals: []snapshot.Interval{snapshot.Minute},
+				},
+				{
+					Name:      snapshot.MustParseName(t, "zsm_test/fs_1@2020-04-10T08:44:58.564585005Z"),
+					Intervals: []snapshot.Interval{snapshot.Hour},
+				},
+			},
+			Reject: []snapshot.Name{
+				snapshot.MustParseName(t, "zsm_test/fs_1@2020-04-10T09:43:58.564585005Z"),
+				snapshot.MustParseName(t, "zsm_test/fs_1@2020-04-10T07:44:58.564585005Z"),
+			},
+		},
+	}
+
+	adapter := &snapshot.MockZFSAdapter{}
+	adapter.Test(t)
+	adapter.On("List", zfs.Snapshot).Return(allSnapshots, nil)
+	adapter.On("Properties", zfs.FileSystem, mock.Anything).Return(map[string]map[string]string{}, nil)
+	adapter.On("Properties", zfs.Snapshot, []string{snapshot.PropertyExpires}).
+		Return(map[string]map[string]string{}, nil)
+	adapter.On("Properties", zfs.Snapshot, []string{"userrefs"}).Return(map[string]map[string]string{}, nil)
+
+	mgr := &snapshot.Manager{ZFS: adapter}
+	plans, err := mgr.PlanClean(cfg)
+	assert.NoError(t, err)
+	assert.Equal(t, expected, plans)
+	// PlanClean must never destroy any snapshots.
+	adapter.AssertNotCalled(t, "Destroy", mock.Anything)
+	adapter.AssertNotCalled(t, "DestroySnapshots", mock.Anything)
+	adapter.AssertExpectations(t)
+}
+
+func TestManager_PlanClean_Policies(t *testing.T) {
+	allSnapshots := []string{
+		"zsm_test@2020-04-10T09:45:58.564585005Z",
+		"zsm_test@2020-04-10T09:44:58.564585005Z",
+		"zsm_test/scratch@2020-04-10T09:45:58.564585005Z",
+		"zsm_test/scratch@2020-04-10T09:44:58.564585005Z",
+	}
+	policies := snapshot.Policies{
+		Default: snapshot.BucketConfig{snapshot.Minute: 2},
+		FileSystems: map[string]snapshot.BucketConfig{
+			"zsm_test/scratch": {snapshot.Minute: 1},
+		},
+	}
+
+	adapter := &snapshot.MockZFSAdapter{}
+	adapter.Test(t)
+	adapter.On("List", zfs.Snapshot).Return(allSnapshots, nil)
+	adapter.On("Properties", zfs.FileSystem, mock.Anything).Return(map[string]map[string]string{}, nil)
+	adapter.On("Properties", zfs.Snapshot, []string{snapshot.PropertyExpires}).
+		Return(map[string]map[string]string{}, nil)
+	adapter.On("Properties", zfs.Snapshot, []string{"userrefs"}).Return(map[string]map[string]string{}, nil)
+
+	mgr := &snapshot.Manager{ZFS: adapter}
+	plans, err := mgr.PlanClean(policies)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Len(t, plans, 2)
+	assert.Len(t, plans[0].Keep, 2)
+	assert.Empty(t, plans[0].Reject)
+	assert.Equal(t, []snapshot.Name{
+		snapshot.MustParseName(t, "zsm_test/scratch@2020-04-10T09:44:58.564585005Z"),
+	}, plans[1].Reject)
+}
+
+func TestManager_PlanClean_Properties(t *testing.T) {
+	allSnapshots := []string{
+		"zsm_test@2020-04-10T09:45:58.564585005Z",
+		"zsm_test@2020-04-10T08:45:58.564585005Z",
+		"zsm_test/db@2020-04-10T09:45:58.564585005Z",
+		"zsm_test/db@2020-04-10T08:45:58.564585005Z",
+	}
+	cfg := snapshot.BucketConfig{snapshot.Hour: 1}
+
+	adapter := &snapshot.MockZFSAdapter{}
+	adapter.Test(t)
+	adapter.On("List", zfs.Snapshot).Return(allSnapshots, nil)
+	adapter.On("Properties", zfs.FileSystem, mock.Anything).Return(map[string]map[string]string{
+		"zsm_test/db": {snapshot.KeepProperty(snapshot.Hour): "48"},
+	}, nil)
+	adapter.On("Properties", zfs.Snapshot, []string{snapshot.PropertyExpires}).
+		Return(map[string]map[string]string{}, nil)
+	adapter.On("Properties", zfs.Snapshot, []string{"userrefs"}).Return(map[string]map[string]string{}, nil)
+
+	mgr := &snapshot.Manager{ZFS: adapter}
+	plans, err := mgr.PlanClean(cfg)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Len(t, plans, 2)
+	assert.Len(t, plans[0].Reject, 1)
+	assert.Len(t, plans[1].Keep, 2)
+	assert.Empty(t, plans[1].Reject)
+
+	adapter = &snapshot.MockZFSAdapter{}
+	adapter.Test(t)
+	adapter.On("List", zfs.Snapshot).Return(allSnapshots, nil)
+	adapter.On("Properties", zfs.FileSystem, mock.Anything).Return(map[string]map[string]string{
+		"zsm_test/db": {snapshot.KeepProperty(snapshot.Hour): "-1"},
+	}, nil)
+
+	mgr = &snapshot.Manager{ZFS: adapter}
+	_, err = mgr.PlanClean(cfg)
+	assert.EqualError(t, err, "plan clean: zsm_test/db: invalid value for com.github.fhofherr.zsm:keep-hour: -1")
+}
+
 func TestManager_ReceiveSnapshot(t *testing.T) {
 	var (
 		in           bytes.Buffer
@@ -253,7 +848,7 @@ func TestManager_ReceiveSnapshot(t *testing.T) {
 	adapter.Test(t)
 	adapter.On("List", zfs.FileSystem).Return(fileSystems, nil)
 	adapter.On("List", zfs.Snapshot).Return(allSnapshots, nil)
-	adapter.On("Receive", name.String(), &in).Return(nil)
+	adapter.On("Receive", "target_fs/"+name.String(), true, &in).Return(nil)
 
 	sm := &snapshot.Manager{ZFS: adapter}
 	err := sm.ReceiveSnapshot(fileSystems[0], name, &in)
@@ -281,8 +876,8 @@ func TestManager_ReceiveSnapshot_Errors(t *testing.T) {
 			targetFS:     "target_fs",
 			snapshot:     snapshot.MustParseName(t, "zsm_test@2020-04-10T09:45:58.564585005Z"),
 			fileSystems:  []string{"target_fs"},
-			allSnapshots: []string{"zsm_test@2020-04-10T09:45:58.564585005Z"},
-			expectedErr:  fmt.Errorf("receive snapshot: exists: zsm_test@2020-04-10T09:45:58.564585005Z"),
+			allSnapshots: []string{"target_fs/zsm_test@2020-04-10T09:45:58.564585005Z"},
+			expectedErr:  fmt.Errorf("receive snapshot: exists: target_fs/zsm_test@2020-04-10T09:45:58.564585005Z"),
 		},
 	}
 
@@ -304,6 +899,49 @@ func TestManager_ReceiveSnapshot_Errors(t *testing.T) {
 	}
 }
 
+func TestManager_ResumeTokens(t *testing.T) {
+	adapter := &snapshot.MockZFSAdapter{}
+	adapter.Test(t)
+	adapter.On("ResumeTokens", "target_fs").Return(map[string]string{
+		"target_fs":                "1-aaaa",
+		"target_fs/zsm_test/fs_1":  "1-bbbb",
+		"target_fs/zsm_test/fs_2":  "1-cccc",
+		"target_fs_other/zsm_test": "1-dddd",
+	}, nil)
+
+	sm := &snapshot.Manager{ZFS: adapter}
+	tokens, err := sm.ResumeTokens("target_fs")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"zsm_test/fs_1": "1-bbbb", "zsm_test/fs_2": "1-cccc"}, tokens)
+	adapter.AssertExpectations(t)
+}
+
+func TestManager_ResumeReceive(t *testing.T) {
+	var in bytes.Buffer
+
+	adapter := &snapshot.MockZFSAdapter{}
+	adapter.Test(t)
+	adapter.On("Receive", "target_fs/zsm_test/fs_1", true, &in).Return(nil)
+
+	sm := &snapshot.Manager{ZFS: adapter}
+	err := sm.ResumeReceive("target_fs", "zsm_test/fs_1", &in)
+	assert.NoError(t, err)
+	adapter.AssertExpectations(t)
+}
+
+func TestManager_ResumeSend(t *testing.T) {
+	var out bytes.Buffer
+
+	adapter := &snapshot.MockZFSAdapter{}
+	adapter.Test(t)
+	adapter.On("SendResume", "1-aaaa", &out).Return(nil)
+
+	sm := &snapshot.Manager{ZFS: adapter}
+	err := sm.ResumeSend("1-aaaa", &out)
+	assert.NoError(t, err)
+	adapter.AssertExpectations(t)
+}
+
 func TestManager_SendSnapshot(t *testing.T) {
 	type testCase struct {
 		name string
@@ -351,6 +989,35 @@ func TestManager_SendSnapshot(t *testing.T) {
 				return sm.SendSnapshot(tt.sn, &tt.out, snapshot.Reference(tt.ref))
 			},
 		},
+		{
+			name: "with bookmark as reference",
+			sn:   snapshot.Name{FileSystem: "zsm_test", Timestamp: time.Now().UTC()},
+			ref:  snapshot.Name{FileSystem: "zsm_test", Timestamp: time.Now().UTC().Add(-time.Hour)},
+			mock: func(t *testing.T, tt *testCase, a *snapshot.MockZFSAdapter) {
+				a.On("List", zfs.Snapshot).Return([]string{tt.sn.String()}, nil)
+				a.On("List", zfs.Bookmark).Return([]string{tt.ref.Bookmark()}, nil)
+				a.On("Send", tt.sn.String(), tt.ref.Bookmark(), &tt.out).Return(nil)
+			},
+			call: func(t *testing.T, tt *testCase, sm *snapshot.Manager) error {
+				return sm.SendSnapshot(tt.sn, &tt.out, snapshot.BookmarkReference(tt.ref))
+			},
+		},
+		{
+			name: "bookmark does not exist",
+			sn:   snapshot.Name{FileSystem: "zsm_test", Timestamp: time.Now().UTC()},
+			ref:  snapshot.Name{FileSystem: "zsm_test", Timestamp: time.Now().UTC().Add(-time.Hour)},
+			mock: func(t *testing.T, tt *testCase, a *snapshot.MockZFSAdapter) {
+				a.On("List", zfs.Snapshot).Return([]string{tt.sn.String(), tt.ref.String()}, nil)
+				a.On("List", zfs.Bookmark).Return([]string(nil), fmt.Errorf("zfs list: %w", zfs.ErrNoOutput))
+			},
+			call: func(t *testing.T, tt *testCase, sm *snapshot.Manager) error {
+				err := sm.SendSnapshot(tt.sn, &tt.out, snapshot.BookmarkReference(tt.ref))
+				if !assert.EqualError(t, err, fmt.Sprintf("send snapshot: reference does not exist: %s", tt.sn)) {
+					return err
+				}
+				return nil
+			},
+		},
 		{
 			name: "reference does not exist",
 			sn:   snapshot.Name{FileSystem: "zsm_test", Timestamp: time.Now().UTC()},
@@ -5,6 +5,8 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"path"
+	"regexp"
 	"strings"
 	"time"
 )
@@ -13,10 +15,29 @@ import (
 // snapshots.
 const TimestampFormat = time.RFC3339Nano
 
+// LabelPrefix precedes the label of a labelled snapshot.
+const LabelPrefix = "zsm_"
+
+var labelRegexp = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+
+// ValidateLabel returns an error if label can't be used as the label of a
+// snapshot. A label must start with a letter or digit and may only contain
+// letters, digits, and the characters _, ., and -.
+func ValidateLabel(label string) error {
+	if !labelRegexp.MatchString(label) {
+		return fmt.Errorf("invalid label: %q", label)
+	}
+	return nil
+}
+
 // Name represents a named snapshot created by zsm.
+//
+// Snapshots without a label are named FILE_SYSTEM@TIMESTAMP. Labelled
+// snapshots are named FILE_SYSTEM@zsm_LABEL_TIMESTAMP.
 type Name struct {
 	FileSystem string    `json:"fileSystem"`
 	Timestamp  time.Time `json:"timestamp"`
+	Label      string    `json:"label,omitempty"`
 }
 
 // ParseName parses a string representing a snapshot into a Name.
@@ -28,11 +49,33 @@ func ParseName(name string) (Name, bool) {
 	if parts[0] == "" {
 		return Name{}, false
 	}
-	ts, err := time.Parse(TimestampFormat, parts[1])
+	var label string
+	tsStr := parts[1]
+	if strings.HasPrefix(tsStr, LabelPrefix) {
+		rest := strings.TrimPrefix(tsStr, LabelPrefix)
+		idx := strings.LastIndex(rest, "_")
+		if idx < 0 {
+			return Name{}, false
+		}
+		label, tsStr = rest[:idx], rest[idx+1:]
+		if ValidateLabel(label) != nil {
+			return Name{}, false
+		}
+	}
+	ts, err := time.Parse(TimestampFormat, tsStr)
 	if err != nil {
 		return Name{}, false
 	}
-	return Name{FileSystem: parts[0], Timestamp: ts}, true
+	return Name{FileSystem: parts[0], Label: label, Timestamp: ts}, true
+}
+
+// ParseBookmark parses a string representing a bookmark created by zsm into
+// the Name of the bookmarked snapshot.
+func ParseBookmark(bookmark string) (Name, bool) {
+	if strings.Contains(bookmark, "@") {
+		return Name{}, false
+	}
+	return ParseName(strings.Replace(bookmark, "#", "@", 1))
 }
 
 // ParseNameJSON parses a JSON representation of a name.
@@ -46,9 +89,42 @@ func ParseNameJSON(data []byte) (Name, error) {
 }
 
 func (n Name) String() string {
+	if n.Label != "" {
+		return fmt.Sprintf("%s@%s%s_%s", n.FileSystem, LabelPrefix, n.Label, n.Timestamp.Format(TimestampFormat))
+	}
 	return fmt.Sprintf("%s@%s", n.FileSystem, n.Timestamp.Format(TimestampFormat))
 }
 
+// Bookmark returns the name of the bookmark of the snapshot n. The bookmark
+// is named like the snapshot with the @ replaced by a #.
+func (n Name) Bookmark() string {
+	return strings.Replace(n.String(), "@", "#", 1)
+}
+
+// Below returns a copy of n with its file system moved below parentFS.
+func (n Name) Below(parentFS string) Name {
+	return Name{
+		FileSystem: path.Join(parentFS, n.FileSystem),
+		Label:      n.Label,
+		Timestamp:  n.Timestamp,
+	}
+}
+
+// RelativeTo returns a copy of n with the prefix parentFS removed from its file
+// system. The second return value is false if n is not a snapshot of a file
+// system below parentFS.
+func (n Name) RelativeTo(parentFS string) (Name, bool) {
+	prefix := strings.TrimSuffix(parentFS, "/") + "/"
+	if !strings.HasPrefix(n.FileSystem, prefix) || n.FileSystem == prefix {
+		return Name{}, false
+	}
+	return Name{
+		FileSystem: strings.TrimPrefix(n.FileSystem, prefix),
+		Label:      n.Label,
+		Timestamp:  n.Timestamp,
+	}, true
+}
+
 // ToJSON converts the name to a JSON representation.
 func (n Name) ToJSON() ([]byte, error) {
 	var bs bytes.Buffer
@@ -26,6 +26,34 @@ func TestParseName(t *testing.T) {
 			},
 			expectedOk: true,
 		},
+		{
+			name:        "labelled snapshot",
+			snapNameStr: fmt.Sprintf("%s@zsm_pre-deploy_%s", "zsm_test/fs_1", nowUTC.Format(snapshot.TimestampFormat)),
+			expected: snapshot.Name{
+				FileSystem: "zsm_test/fs_1",
+				Label:      "pre-deploy",
+				Timestamp:  nowUTC,
+			},
+			expectedOk: true,
+		},
+		{
+			name:        "label containing underscores",
+			snapNameStr: fmt.Sprintf("%s@zsm_every_hour_%s", "zsm_test/fs_1", nowUTC.Format(snapshot.TimestampFormat)),
+			expected: snapshot.Name{
+				FileSystem: "zsm_test/fs_1",
+				Label:      "every_hour",
+				Timestamp:  nowUTC,
+			},
+			expectedOk: true,
+		},
+		{
+			name:        "missing label",
+			snapNameStr: fmt.Sprintf("%s@zsm__%s", "zsm_test/fs_1", nowUTC.Format(snapshot.TimestampFormat)),
+		},
+		{
+			name:        "label without timestamp",
+			snapNameStr: "zsm_test@zsm_hourly",
+		},
 		{
 			name:        "invalid timestamp",
 			snapNameStr: "zsm_test@tuesday",
@@ -75,3 +103,52 @@ func TestName_String(t *testing.T) {
 	assert.True(t, ok, "nameStr cannot be parsed")
 	assert.Equal(t, name, parsed)
 }
+
+func TestName_String_Label(t *testing.T) {
+	name := snapshot.Name{
+		FileSystem: "zsm_test/fs_1",
+		Label:      "hourly",
+		Timestamp:  snapshot.MustParseTime(t, snapshot.TimestampFormat, "2020-04-10T09:45:58.564585005Z"),
+	}
+	assert.Equal(t, "zsm_test/fs_1@zsm_hourly_2020-04-10T09:45:58.564585005Z", name.String())
+
+	parsed, ok := snapshot.ParseName(name.String())
+	assert.True(t, ok)
+	assert.Equal(t, name, parsed)
+}
+
+func TestName_Bookmark(t *testing.T) {
+	name := snapshot.MustParseName(t, "zsm_test/fs_1@zsm_hourly_2020-04-10T09:45:58.564585005Z")
+	assert.Equal(t, "zsm_test/fs_1#zsm_hourly_2020-04-10T09:45:58.564585005Z", name.Bookmark())
+
+	parsed, ok := snapshot.ParseBookmark(name.Bookmark())
+	assert.True(t, ok)
+	assert.Equal(t, name, parsed)
+
+	for _, s := range []string{name.String(), "zsm_test/fs_1#monday", "zsm_test/fs_1#a@2020-04-10T09:45:58Z"} {
+		_, ok := snapshot.ParseBookmark(s)
+		assert.False(t, ok, s)
+	}
+}
+
+func TestValidateLabel(t *testing.T) {
+	for _, label := range []string{"hourly", "pre-deploy", "v1.2_rc"} {
+		assert.NoError(t, snapshot.ValidateLabel(label), label)
+	}
+	for _, label := range []string{"", "-hourly", "pre deploy", "a@b", "a/b", "a:b"} {
+		assert.Error(t, snapshot.ValidateLabel(label), label)
+	}
+}
+
+func TestName_Below(t *testing.T) {
+	name := snapshot.MustParseName(t, "zsm_test/fs_1@2020-04-10T09:45:58.564585005Z")
+	below := name.Below("target_fs")
+	assert.Equal(t, "target_fs/zsm_test/fs_1@2020-04-10T09:45:58.564585005Z", below.String())
+
+	rel, ok := below.RelativeTo("target_fs/")
+	assert.True(t, ok)
+	assert.Equal(t, name, rel)
+
+	_, ok = name.RelativeTo("target_fs")
+	assert.False(t, ok)
+}
@@ -0,0 +1,104 @@
+package snapshot
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// RegexpPrefix marks a Pattern as a regular expression.
+const RegexpPrefix = "re:"
+
+// Pattern matches the names of file systems.
+//
+// A Pattern is either a shell-style glob as understood by path.Match, or a
+// regular expression prefixed with RegexpPrefix. Just like in a shell, the
+// wildcards of a glob do not match the / separating a file system from its
+// children. A regular expression has to match the whole name of a file
+// system. A Pattern without any special characters matches just the file
+// system of the same name.
+type Pattern struct {
+	raw  string
+	glob string
+	re   *regexp.Regexp
+}
+
+// ParsePattern parses a Pattern from s. Leading slashes of globs are
+// removed.
+func ParsePattern(s string) (Pattern, error) {
+	if strings.HasPrefix(s, RegexpPrefix) {
+		re, err := regexp.Compile("^(?:" + strings.TrimPrefix(s, RegexpPrefix) + ")$")
+		if err != nil {
+			return Pattern{}, fmt.Errorf("invalid pattern %q: %w", s, err)
+		}
+		return Pattern{raw: s, re: re}, nil
+	}
+	glob := strings.TrimPrefix(s, "/")
+	if _, err := path.Match(glob, ""); err != nil {
+		return Pattern{}, fmt.Errorf("invalid pattern %q: %w", s, err)
+	}
+	return Pattern{raw: s, glob: glob}, nil
+}
+
+// Match returns true if the pattern matches the file system fs.
+func (p Pattern) Match(fs string) bool {
+	if p.re != nil {
+		return p.re.MatchString(fs)
+	}
+	ok, _ := path.Match(p.glob, fs)
+	return ok
+}
+
+// String returns the pattern as passed to ParsePattern.
+func (p Pattern) String() string {
+	return p.raw
+}
+
+// patterns is a set of Patterns. It matches a file system if any of its
+// members does.
+type patterns []Pattern
+
+func parsePatterns(ss []string) (patterns, error) {
+	ps := make(patterns, 0, len(ss))
+	for _, s := range ss {
+		p, err := ParsePattern(s)
+		if err != nil {
+			return nil, err
+		}
+		ps = append(ps, p)
+	}
+	return ps, nil
+}
+
+func parsePatternSet(set map[string]bool) (patterns, error) {
+	ss := make([]string, 0, len(set))
+	for s := range set {
+		ss = append(ss, s)
+	}
+	return parsePatterns(ss)
+}
+
+func (ps patterns) Match(fs string) bool {
+	for _, p := range ps {
+		if p.Match(fs) {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchSubtree returns true if any pattern matches fs or one of its
+// ancestors.
+func (ps patterns) MatchSubtree(fs string) bool {
+	for {
+		if ps.Match(fs) {
+			return true
+		}
+		idx := strings.LastIndex(fs, "/")
+		if idx < 0 {
+			return false
+		}
+		fs = fs[:idx]
+	}
+}
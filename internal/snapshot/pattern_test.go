@@ -0,0 +1,78 @@
+package snapshot_test
+
+import (
+	"testing"
+
+	"github.com/fhofherr/zsm/internal/snapshot"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPattern_Match(t *testing.T) {
+	tests := []struct {
+		name     string
+		pattern  string
+		fs       string
+		expected bool
+	}{
+		{
+			name:     "literal name",
+			pattern:  "zsm_test/fs_1",
+			fs:       "zsm_test/fs_1",
+			expected: true,
+		},
+		{
+			name:    "literal name does not match children",
+			pattern: "zsm_test/fs_1",
+			fs:      "zsm_test/fs_1/nested_fs_1",
+		},
+		{
+			name:     "leading slash",
+			pattern:  "/zsm_test/fs_1",
+			fs:       "zsm_test/fs_1",
+			expected: true,
+		},
+		{
+			name:     "glob",
+			pattern:  "zsm_test/docker/*",
+			fs:       "zsm_test/docker/1f2e3d",
+			expected: true,
+		},
+		{
+			name:    "glob does not match separator",
+			pattern: "zsm_test/*",
+			fs:      "zsm_test/docker/1f2e3d",
+		},
+		{
+			name:     "regular expression",
+			pattern:  "re:zsm_test/home/[a-z]+",
+			fs:       "zsm_test/home/alice",
+			expected: true,
+		},
+		{
+			name:    "regular expression matches whole name",
+			pattern: "re:home",
+			fs:      "zsm_test/home",
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := snapshot.ParsePattern(tt.pattern)
+			if !assert.NoError(t, err) {
+				return
+			}
+			assert.Equal(t, tt.expected, p.Match(tt.fs))
+			assert.Equal(t, tt.pattern, p.String())
+		})
+	}
+}
+
+func TestParsePattern_Invalid(t *testing.T) {
+	_, err := snapshot.ParsePattern("zsm_test/[fs")
+	assert.EqualError(t, err, `invalid pattern "zsm_test/[fs": syntax error in pattern`)
+
+	_, err = snapshot.ParsePattern("re:zsm_test/(fs")
+	assert.EqualError(t, err,
+		"invalid pattern \"re:zsm_test/(fs\": error parsing regexp: missing closing ): `^(?:zsm_test/(fs)$`")
+}
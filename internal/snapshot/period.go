@@ -0,0 +1,196 @@
+package snapshot
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Period is the length of the interval of a bucket. It is either a fixed
+// Duration or a number of calendar Months.
+type Period struct {
+	Duration time.Duration
+	Months   int
+}
+
+// periodUnits contains the units of periods not supported by
+// time.ParseDuration.
+var periodUnits = []struct {
+	Suffix string
+	Period func(n int) Period
+}{
+	{Suffix: "mo", Period: func(n int) Period { return Period{Months: n} }},
+	{Suffix: "y", Period: func(n int) Period { return Period{Months: 12 * n} }},
+	{Suffix: "w", Period: func(n int) Period { return Period{Duration: time.Duration(n) * 7 * 24 * time.Hour} }},
+	{Suffix: "d", Period: func(n int) Period { return Period{Duration: time.Duration(n) * 24 * time.Hour} }},
+}
+
+// ParsePeriod parses a period like 15m, 6h, 1d, 2w, 3mo, or 1y.
+//
+// The units d and w denote days of 24 hours and weeks of 7 days. The units
+// mo and y denote calendar months and years. They can't be combined with
+// other units. All other periods are parsed using time.ParseDuration.
+func ParsePeriod(s string) (Period, error) {
+	for _, u := range periodUnits {
+		if !strings.HasSuffix(s, u.Suffix) {
+			continue
+		}
+		n, err := strconv.Atoi(strings.TrimSuffix(s, u.Suffix))
+		if err != nil || n <= 0 {
+			return Period{}, fmt.Errorf("invalid period: %s", s)
+		}
+		return u.Period(n), nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d <= 0 {
+		return Period{}, fmt.Errorf("invalid period: %s", s)
+	}
+	return Period{Duration: d}, nil
+}
+
+func (p Period) String() string {
+	const (
+		day  = 24 * time.Hour
+		week = 7 * day
+	)
+
+	switch {
+	case p.Months > 0 && p.Months%12 == 0:
+		return fmt.Sprintf("%dy", p.Months/12)
+	case p.Months > 0:
+		return fmt.Sprintf("%dmo", p.Months)
+	case p.Duration > 0 && p.Duration%week == 0:
+		return fmt.Sprintf("%dw", p.Duration/week)
+	case p.Duration > 0 && p.Duration%day == 0:
+		return fmt.Sprintf("%dd", p.Duration/day)
+	case p.Duration > 0 && p.Duration%time.Hour == 0:
+		return fmt.Sprintf("%dh", p.Duration/time.Hour)
+	case p.Duration > 0 && p.Duration%time.Minute == 0:
+		return fmt.Sprintf("%dm", p.Duration/time.Minute)
+	default:
+		return p.Duration.String()
+	}
+}
+
+// MarshalText converts the period to the format accepted by ParsePeriod.
+func (p Period) MarshalText() ([]byte, error) {
+	return []byte(p.String()), nil
+}
+
+// UnmarshalText parses the period using ParsePeriod.
+func (p *Period) UnmarshalText(text []byte) error {
+	parsed, err := ParsePeriod(string(text))
+	if err != nil {
+		return fmt.Errorf("unmarshal period: %w", err)
+	}
+	*p = parsed
+	return nil
+}
+
+// After returns the time p after ts. Months are added to the date of ts, see
+// time.Time.AddDate.
+func (p Period) After(ts time.Time) time.Time {
+	return ts.AddDate(0, p.Months, 0).Add(p.Duration)
+}
+
+// Before returns the time p before ts. Months are subtracted from the date
+// of ts, see time.Time.AddDate.
+func (p Period) Before(ts time.Time) time.Time {
+	return ts.AddDate(0, -p.Months, 0).Add(-p.Duration)
+}
+
+// IsZero returns true if p has neither a Duration nor Months.
+func (p Period) IsZero() bool {
+	return p.Duration == 0 && p.Months == 0
+}
+
+// exceeded returns true if a and b are at least p apart.
+func (p Period) exceeded(a, b time.Time) bool {
+	if b.Before(a) {
+		a, b = b, a
+	}
+	if p.Months > 0 {
+		x := a.AddDate(0, p.Months, 0)
+		return b.Equal(x) || b.After(x)
+	}
+	return b.Sub(a) >= p.Duration
+}
+
+// calendarEpoch is the Monday calendar periods of days and weeks are
+// aligned to.
+var calendarEpoch = time.Date(1970, 1, 5, 0, 0, 0, 0, time.UTC)
+
+// calendarPeriod returns a key identifying the calendar period of length p
+// ts falls into in loc.
+//
+// Periods of months are aligned to the start of the year, e.g. 3mo to
+// quarters. Periods shorter than a day are aligned to midnight, longer
+// periods to Mondays. Thus 1w denotes ISO weeks. The keys of periods shorter
+// than a day contain the UTC offset. This keeps the hour repeated when
+// daylight saving time ends apart from the preceding hour.
+func (p Period) calendarPeriod(ts time.Time, loc *time.Location) string {
+	ts = ts.In(loc)
+	if p.Months > 0 {
+		return strconv.Itoa(floorDiv(ts.Year()*12+int(ts.Month())-1, p.Months))
+	}
+	// Measure the wall clock time since calendarEpoch. This makes days
+	// 24 hours long even if daylight saving time starts or ends.
+	date := time.Date(ts.Year(), ts.Month(), ts.Day(), 0, 0, 0, 0, time.UTC)
+	days := int64(date.Sub(calendarEpoch) / (24 * time.Hour))
+	clock := time.Duration(ts.Hour())*time.Hour + time.Duration(ts.Minute())*time.Minute +
+		time.Duration(ts.Second())*time.Second + time.Duration(ts.Nanosecond())
+	wall := time.Duration(days)*24*time.Hour + clock
+	key := strconv.FormatInt(floorDiv64(int64(wall), int64(p.Duration)), 10)
+	if p.Duration < 24*time.Hour {
+		key += ts.Format("-07:00")
+	}
+	return key
+}
+
+func floorDiv(a, b int) int {
+	return int(floorDiv64(int64(a), int64(b)))
+}
+
+func floorDiv64(a, b int64) int64 {
+	q := a / b
+	if a%b != 0 && (a < 0) != (b < 0) {
+		q--
+	}
+	return q
+}
+
+// CustomBucket keeps Count snapshots at least Period apart. Unlike the
+// buckets of a BucketConfig it may use any Period.
+type CustomBucket struct {
+	Period Period
+	Count  int
+}
+
+// ParseCustomBuckets parses a comma separated list of buckets of the form
+// period:count, e.g. 15m:8, 6h:4, 1d:14, 3mo:4. See ParsePeriod for the
+// format of the periods. Empty elements are ignored.
+func ParseCustomBuckets(s string) ([]CustomBucket, error) {
+	var buckets []CustomBucket
+
+	for _, elem := range strings.Split(s, ",") {
+		elem = strings.TrimSpace(elem)
+		if elem == "" {
+			continue
+		}
+		idx := strings.LastIndex(elem, ":")
+		if idx < 0 {
+			return nil, fmt.Errorf("invalid bucket: %s", elem)
+		}
+		p, err := ParsePeriod(elem[:idx])
+		if err != nil {
+			return nil, fmt.Errorf("invalid bucket: %s: %w", elem, err)
+		}
+		n, err := strconv.Atoi(elem[idx+1:])
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid bucket: %s: invalid count", elem)
+		}
+		buckets = append(buckets, CustomBucket{Period: p, Count: n})
+	}
+	return buckets, nil
+}
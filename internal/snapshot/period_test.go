@@ -0,0 +1,89 @@
+package snapshot_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/fhofherr/zsm/internal/snapshot"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParsePeriod(t *testing.T) {
+	tests := []struct {
+		text     string
+		expected snapshot.Period
+		str      string
+	}{
+		{text: "15m", expected: snapshot.Period{Duration: 15 * time.Minute}, str: "15m"},
+		{text: "90m", expected: snapshot.Period{Duration: 90 * time.Minute}, str: "90m"},
+		{text: "6h", expected: snapshot.Period{Duration: 6 * time.Hour}, str: "6h"},
+		{text: "1h30m", expected: snapshot.Period{Duration: 90 * time.Minute}, str: "90m"},
+		{text: "1d", expected: snapshot.Period{Duration: 24 * time.Hour}, str: "1d"},
+		{text: "14d", expected: snapshot.Period{Duration: 14 * 24 * time.Hour}, str: "2w"},
+		{text: "2w", expected: snapshot.Period{Duration: 14 * 24 * time.Hour}, str: "2w"},
+		{text: "3mo", expected: snapshot.Period{Months: 3}, str: "3mo"},
+		{text: "24mo", expected: snapshot.Period{Months: 24}, str: "2y"},
+		{text: "1y", expected: snapshot.Period{Months: 12}, str: "1y"},
+		{text: "30s", expected: snapshot.Period{Duration: 30 * time.Second}, str: "30s"},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.text, func(t *testing.T) {
+			actual, err := snapshot.ParsePeriod(tt.text)
+			if !assert.NoError(t, err) {
+				return
+			}
+			assert.Equal(t, tt.expected, actual)
+			assert.Equal(t, tt.str, actual.String())
+
+			text, err := actual.MarshalText()
+			assert.NoError(t, err)
+			var unmarshaled snapshot.Period
+			assert.NoError(t, unmarshaled.UnmarshalText(text))
+			assert.Equal(t, actual, unmarshaled)
+		})
+	}
+}
+
+func TestParsePeriod_Invalid(t *testing.T) {
+	for _, text := range []string{"", "mo", "0d", "-1w", "1.5y", "1d2h", "0s", "-5m", "fortnight"} {
+		_, err := snapshot.ParsePeriod(text)
+		assert.Errorf(t, err, "text: %q", text)
+	}
+}
+
+func TestPeriod_AfterBefore(t *testing.T) {
+	ts := time.Date(2020, 1, 31, 12, 0, 0, 0, time.UTC)
+
+	assert.Equal(t, time.Date(2021, 1, 31, 12, 0, 0, 0, time.UTC), snapshot.Period{Months: 12}.After(ts))
+	assert.Equal(t, time.Date(2019, 1, 31, 12, 0, 0, 0, time.UTC), snapshot.Period{Months: 12}.Before(ts))
+	// 2020 is a leap year.
+	assert.Equal(t, time.Date(2021, 1, 30, 12, 0, 0, 0, time.UTC),
+		snapshot.Period{Duration: 365 * 24 * time.Hour}.After(ts))
+	assert.Equal(t, time.Date(2020, 1, 29, 12, 0, 0, 0, time.UTC),
+		snapshot.Period{Duration: 2 * 24 * time.Hour}.Before(ts))
+	assert.True(t, snapshot.Period{}.IsZero())
+	assert.False(t, snapshot.Period{Months: 1}.IsZero())
+}
+
+func TestParseCustomBuckets(t *testing.T) {
+	actual, err := snapshot.ParseCustomBuckets("15m:8, 6h:4,1d:14 ,3mo:4,")
+	assert.NoError(t, err)
+	assert.Equal(t, []snapshot.CustomBucket{
+		{Period: snapshot.Period{Duration: 15 * time.Minute}, Count: 8},
+		{Period: snapshot.Period{Duration: 6 * time.Hour}, Count: 4},
+		{Period: snapshot.Period{Duration: 24 * time.Hour}, Count: 14},
+		{Period: snapshot.Period{Months: 3}, Count: 4},
+	}, actual)
+
+	actual, err = snapshot.ParseCustomBuckets("")
+	assert.NoError(t, err)
+	assert.Empty(t, actual)
+}
+
+func TestParseCustomBuckets_Invalid(t *testing.T) {
+	for _, text := range []string{"15m", "15m:", "15m:-1", "15x:8", ":8", "1d:8,3mo"} {
+		_, err := snapshot.ParseCustomBuckets(text)
+		assert.Errorf(t, err, "text: %q", text)
+	}
+}
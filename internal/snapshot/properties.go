@@ -0,0 +1,214 @@
+package snapshot
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fhofherr/zsm/internal/zfs"
+)
+
+// User properties honored by zsm.
+//
+// Like all ZFS user properties they are inherited by the descendants of the
+// file system they are set on. They take precedence over zsm's configuration.
+const (
+	// PropertyPrefix is the common prefix of all user properties honored by
+	// zsm.
+	PropertyPrefix = "com.github.fhofherr.zsm:"
+
+	// PropertySnapshot controls if CreateSnapshots creates snapshots of a
+	// file system. Setting it to false excludes the file system.
+	PropertySnapshot = PropertyPrefix + "snapshot"
+
+	// PropertySkipUnchanged controls if CreateSnapshots skips a dataset
+	// which did not change since its newest snapshot. It overrides the
+	// SkipUnchanged option in both directions.
+	PropertySkipUnchanged = PropertyPrefix + "skip-unchanged"
+
+	// PropertyExpires contains the RFC3339 timestamp at which a snapshot
+	// expires. Unlike the other properties it is set on the snapshot itself
+	// by CreateSnapshots. CleanSnapshots keeps a snapshot with
+	// PropertyExpires until it expires and destroys it afterwards.
+	PropertyExpires = PropertyPrefix + "expires"
+)
+
+// propertyUserRefs is the native ZFS property containing the number of user
+// holds on a snapshot.
+const propertyUserRefs = "userrefs"
+
+// KeepProperty returns the name of the user property which overrides the
+// number of snapshots kept for interval i, e.g.
+// com.github.fhofherr.zsm:keep-hour for Hour.
+func KeepProperty(i Interval) string {
+	return PropertyPrefix + "keep-" + strings.ToLower(i.String())
+}
+
+func keepProperties() []string {
+	props := make([]string, 0, nIntervals)
+	for i := Minute; i < nIntervals; i++ {
+		props = append(props, KeepProperty(i))
+	}
+	return props
+}
+
+// createProperties contains the values of the user properties affecting
+// CreateSnapshots.
+type createProperties struct {
+	// disabled contains all datasets which have PropertySnapshot set to
+	// false.
+	disabled map[string]bool
+
+	// skipUnchanged contains the value of PropertySkipUnchanged for all
+	// datasets on which it is set.
+	skipUnchanged map[string]bool
+}
+
+// readCreateProperties reads the user properties affecting CreateSnapshots
+// for all datasets of typ.
+func readCreateProperties(adapter ZFSAdapter, typ zfs.ListType) (createProperties, error) {
+	cp := createProperties{
+		disabled:      make(map[string]bool),
+		skipUnchanged: make(map[string]bool),
+	}
+
+	props, err := adapter.Properties(typ, PropertySnapshot, PropertySkipUnchanged)
+	if err != nil {
+		return cp, err
+	}
+	for fs, values := range props {
+		if v, ok := values[PropertySnapshot]; ok {
+			enabled, err := strconv.ParseBool(v)
+			if err != nil {
+				return cp, fmt.Errorf("%s: invalid value for %s: %s", fs, PropertySnapshot, v)
+			}
+			if !enabled {
+				cp.disabled[fs] = true
+			}
+		}
+		if v, ok := values[PropertySkipUnchanged]; ok {
+			skip, err := strconv.ParseBool(v)
+			if err != nil {
+				return cp, fmt.Errorf("%s: invalid value for %s: %s", fs, PropertySkipUnchanged, v)
+			}
+			cp.skipUnchanged[fs] = skip
+		}
+	}
+	return cp, nil
+}
+
+// skip returns true if unchanged snapshots of fs should be skipped. The
+// value of PropertySkipUnchanged takes precedence over def.
+func (cp createProperties) skip(fs string, def bool) bool {
+	if skip, ok := cp.skipUnchanged[fs]; ok {
+		return skip
+	}
+	return def
+}
+
+// readExpiries returns the values of PropertyExpires of all snapshots
+// managed by zsm.
+func readExpiries(adapter ZFSAdapter) (map[Name]time.Time, error) {
+	props, err := adapter.Properties(zfs.Snapshot, PropertyExpires)
+	if err != nil {
+		return nil, err
+	}
+	expiries := make(map[Name]time.Time)
+	for s, values := range props {
+		v, ok := values[PropertyExpires]
+		if !ok {
+			continue
+		}
+		name, ok := ParseName(s)
+		if !ok {
+			// snapshot was not created by us
+			continue
+		}
+		ts, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return nil, fmt.Errorf("%s: invalid value for %s: %s", s, PropertyExpires, v)
+		}
+		expiries[name] = ts
+	}
+	return expiries, nil
+}
+
+// readHeld returns all snapshots managed by zsm which have at least one user
+// hold.
+func readHeld(adapter ZFSAdapter) (map[Name]bool, error) {
+	props, err := adapter.Properties(zfs.Snapshot, propertyUserRefs)
+	if err != nil {
+		return nil, err
+	}
+	held := make(map[Name]bool)
+	for s, values := range props {
+		v, ok := values[propertyUserRefs]
+		if !ok {
+			continue
+		}
+		name, ok := ParseName(s)
+		if !ok {
+			// snapshot was not created by us
+			continue
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, fmt.Errorf("%s: invalid value for %s: %s", s, propertyUserRefs, v)
+		}
+		if n > 0 {
+			held[name] = true
+		}
+	}
+	return held, nil
+}
+
+// propertyResolver overrides the BucketConfig resolved by r with the values
+// of the keep properties of each file system.
+type propertyResolver struct {
+	r    BucketConfigResolver
+	keep map[string]map[Interval]int
+}
+
+func newPropertyResolver(adapter ZFSAdapter, typ zfs.ListType, r BucketConfigResolver) (propertyResolver, error) {
+	pr := propertyResolver{r: r, keep: make(map[string]map[Interval]int)}
+
+	props, err := adapter.Properties(typ, keepProperties()...)
+	if err != nil {
+		return pr, err
+	}
+	for fs, values := range props {
+		for i := Minute; i < nIntervals; i++ {
+			prop := KeepProperty(i)
+			v, ok := values[prop]
+			if !ok {
+				continue
+			}
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				return pr, fmt.Errorf("%s: invalid value for %s: %s", fs, prop, v)
+			}
+			if pr.keep[fs] == nil {
+				pr.keep[fs] = make(map[Interval]int)
+			}
+			pr.keep[fs][i] = n
+		}
+	}
+	return pr, nil
+}
+
+func (p propertyResolver) ResolveCalendar(fs string) *Calendar {
+	return p.r.ResolveCalendar(fs)
+}
+
+func (p propertyResolver) ResolveCustomBuckets(fs string) []CustomBucket {
+	return p.r.ResolveCustomBuckets(fs)
+}
+
+func (p propertyResolver) ResolveBucketConfig(fs string) BucketConfig {
+	cfg := p.r.ResolveBucketConfig(fs)
+	for i, n := range p.keep[fs] {
+		cfg[i] = n
+	}
+	return cfg
+}
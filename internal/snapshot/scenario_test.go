@@ -0,0 +1,446 @@
+package snapshot_test
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/fhofherr/zsm/internal/snapshot"
+	"github.com/fhofherr/zsm/internal/zfs"
+	"github.com/fhofherr/zsm/internal/zfs/memzfs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var _ snapshot.ZFSAdapter = &memzfs.ZFS{}
+
+func TestScenario_TransferBetweenHosts(t *testing.T) {
+	srcZFS := memzfs.New("zsm_test")
+	require.NoError(t, srcZFS.CreateFileSystem("zsm_test/fs_1"))
+	dstZFS := memzfs.New("target_fs")
+
+	src := &snapshot.Manager{ZFS: srcZFS}
+	dst := &snapshot.Manager{ZFS: dstZFS}
+
+	ts := time.Now().UTC().Add(-24 * time.Hour)
+	ts = createHourlySnapshots(t, srcZFS, ts, 5, "zsm_test", "zsm_test/fs_1")
+
+	// Initial transfer sends the latest snapshot of each file system.
+	require.NoError(t, snapshot.Transfer("target_fs", dst, src))
+	assertInSync(t, srcZFS, dstZFS, "zsm_test", "target_fs/zsm_test")
+	assertInSync(t, srcZFS, dstZFS, "zsm_test/fs_1", "target_fs/zsm_test/fs_1")
+
+	// Both hosts prune differently. There is still a common snapshot.
+	ts = createHourlySnapshots(t, srcZFS, ts, 5, "zsm_test", "zsm_test/fs_1")
+	_, err := src.CleanSnapshots(snapshot.BucketConfig{snapshot.Hour: 6})
+	require.NoError(t, err)
+	require.NoError(t, snapshot.Transfer("target_fs", dst, src))
+	assertInSync(t, srcZFS, dstZFS, "zsm_test/fs_1", "target_fs/zsm_test/fs_1")
+
+	_, err = dst.CleanSnapshots(snapshot.BucketConfig{snapshot.Hour: 1})
+	require.NoError(t, err)
+	createHourlySnapshots(t, srcZFS, ts, 2, "zsm_test", "zsm_test/fs_1")
+	require.NoError(t, snapshot.Transfer("target_fs", dst, src))
+	assertInSync(t, srcZFS, dstZFS, "zsm_test", "target_fs/zsm_test")
+	assertInSync(t, srcZFS, dstZFS, "zsm_test/fs_1", "target_fs/zsm_test/fs_1")
+
+	snapshots, err := dstZFS.List(zfs.Snapshot)
+	require.NoError(t, err)
+	assert.Len(t, snapshots, 6)
+}
+
+func TestScenario_ResumeInterruptedTransfer(t *testing.T) {
+	srcZFS := memzfs.New("zsm_test")
+	dstZFS := memzfs.New("target_fs")
+
+	src := &snapshot.Manager{ZFS: srcZFS}
+	dst := &snapshot.Manager{ZFS: dstZFS}
+
+	ts := time.Now().UTC().Add(-24 * time.Hour)
+	ts = createHourlySnapshots(t, srcZFS, ts, 1, "zsm_test")
+	require.NoError(t, snapshot.Transfer("target_fs", dst, src))
+
+	createHourlySnapshots(t, srcZFS, ts, 3, "zsm_test")
+	srcZFS.InterruptSend(256)
+	assert.Error(t, snapshot.Transfer("target_fs", dst, src))
+
+	tokens, err := dst.ResumeTokens("target_fs")
+	require.NoError(t, err)
+	assert.Contains(t, tokens, "zsm_test")
+
+	require.NoError(t, snapshot.Transfer("target_fs", dst, src))
+	assertInSync(t, srcZFS, dstZFS, "zsm_test", "target_fs/zsm_test")
+
+	tokens, err = dst.ResumeTokens("target_fs")
+	require.NoError(t, err)
+	assert.Empty(t, tokens)
+}
+
+func TestScenario_AbortInterruptedTransfer(t *testing.T) {
+	srcZFS := memzfs.New("zsm_test")
+	dstZFS := memzfs.New("target_fs")
+
+	src := &snapshot.Manager{ZFS: srcZFS}
+	dst := &snapshot.Manager{ZFS: dstZFS}
+
+	ts := time.Now().UTC().Add(-24 * time.Hour)
+	ts = createHourlySnapshots(t, srcZFS, ts, 1, "zsm_test")
+	require.NoError(t, snapshot.Transfer("target_fs", dst, src))
+
+	ts = createHourlySnapshots(t, srcZFS, ts, 1, "zsm_test")
+	srcZFS.InterruptSend(128)
+	assert.Error(t, snapshot.Transfer("target_fs", dst, src))
+	tokens, err := dst.ResumeTokens("target_fs")
+	require.NoError(t, err)
+	require.Contains(t, tokens, "zsm_test")
+
+	// The snapshot the interrupted transfer was sending no longer exists.
+	// Thus the transfer can't be resumed.
+	interrupted := snapshot.Name{FileSystem: "zsm_test", Timestamp: ts}
+	require.NoError(t, srcZFS.Destroy(interrupted.String()))
+	createHourlySnapshots(t, srcZFS, ts, 1, "zsm_test")
+	assert.Error(t, snapshot.Transfer("target_fs", dst, src))
+
+	require.NoError(t, snapshot.Transfer("target_fs", dst, src, snapshot.AbortResume()))
+	assertInSync(t, srcZFS, dstZFS, "zsm_test", "target_fs/zsm_test")
+	tokens, err = dst.ResumeTokens("target_fs")
+	require.NoError(t, err)
+	assert.Empty(t, tokens)
+}
+
+func TestScenario_TransferFromBookmark(t *testing.T) {
+	srcZFS := memzfs.New("zsm_test")
+	dstZFS := memzfs.New("target_fs")
+
+	src := &snapshot.Manager{ZFS: srcZFS}
+	dst := &snapshot.Manager{ZFS: dstZFS}
+
+	ts := time.Now().UTC().Add(-24 * time.Hour)
+	ts = createHourlySnapshots(t, srcZFS, ts, 3, "zsm_test")
+	require.NoError(t, snapshot.Transfer("target_fs", dst, src))
+	sent := snapshot.Name{FileSystem: "zsm_test", Timestamp: ts}
+	bookmarks, err := src.ListBookmarks()
+	require.NoError(t, err)
+	assert.Equal(t, []snapshot.Name{sent}, bookmarks)
+
+	// Destroy the only snapshot both hosts have in common.
+	createHourlySnapshots(t, srcZFS, ts, 2, "zsm_test")
+	require.NoError(t, src.ReleaseSnapshots(snapshot.BaseHoldTag("", "target_fs"), sent))
+	_, err = src.CleanSnapshots(snapshot.BucketConfig{snapshot.Hour: 1})
+	require.NoError(t, err)
+	names, err := src.ListSnapshots()
+	require.NoError(t, err)
+	require.Len(t, names, 1)
+
+	require.NoError(t, snapshot.Transfer("target_fs", dst, src))
+	assertInSync(t, srcZFS, dstZFS, "zsm_test", "target_fs/zsm_test")
+	snapshots, err := dstZFS.List(zfs.Snapshot)
+	require.NoError(t, err)
+	assert.Len(t, snapshots, 2)
+	bookmarks, err = src.ListBookmarks()
+	require.NoError(t, err)
+	assert.Equal(t, []snapshot.Name{sent, names[0]}, bookmarks)
+}
+
+func TestScenario_CreateBestEffort(t *testing.T) {
+	z := memzfs.New("zsm_test")
+	require.NoError(t, z.CreateFileSystem("zsm_test/fs_1"))
+	require.NoError(t, z.CreateFileSystem("zsm_test/fs_2"))
+	mgr := &snapshot.Manager{ZFS: z}
+
+	// The batch fails, and so does the first dataset when retried on its own.
+	z.InjectError("snapshot", "cannot create snapshots: dataset is busy")
+	z.InjectError("snapshot", "cannot create snapshot 'zsm_test': dataset is busy")
+	_, err := mgr.CreateSnapshots(snapshot.CreateBestEffort())
+	var partialErr *snapshot.PartialError
+	require.True(t, errors.As(err, &partialErr))
+	assert.Contains(t, err.Error(), "1 of 3 snapshots failed")
+	assert.Contains(t, err.Error(), "cannot create snapshot 'zsm_test': dataset is busy")
+	assert.Len(t, partialErr.Succeeded, 2)
+
+	names, err := mgr.ListSnapshots()
+	require.NoError(t, err)
+	if assert.Len(t, names, 2) {
+		assert.Equal(t, "zsm_test/fs_1", names[0].FileSystem)
+		assert.Equal(t, "zsm_test/fs_2", names[1].FileSystem)
+	}
+}
+
+func TestScenario_CleanDestroysInBatches(t *testing.T) {
+	z := memzfs.New("zsm_test")
+	createHourlySnapshots(t, z, time.Now().UTC().Add(-24*time.Hour), 5, "zsm_test")
+	mgr := &snapshot.Manager{ZFS: z}
+
+	// The batch fails. The snapshots are destroyed one at a time instead.
+	z.InjectError("destroy", "cannot destroy snapshots: out of space")
+	_, err := mgr.CleanSnapshots(snapshot.BucketConfig{snapshot.Hour: 2})
+	require.NoError(t, err)
+	names, err := mgr.ListSnapshots()
+	require.NoError(t, err)
+	assert.Len(t, names, 2)
+
+	createHourlySnapshots(t, z, names[1].Timestamp, 3, "zsm_test")
+	_, err = mgr.CleanSnapshots(snapshot.BucketConfig{snapshot.Hour: 1})
+	require.NoError(t, err)
+	names, err = mgr.ListSnapshots()
+	require.NoError(t, err)
+	assert.Len(t, names, 1)
+}
+
+func TestScenario_UserProperties(t *testing.T) {
+	z := memzfs.New("zsm_test")
+	for _, fs := range []string{"zsm_test/db", "zsm_test/db/logs", "zsm_test/scratch"} {
+		require.NoError(t, z.CreateFileSystem(fs))
+	}
+	require.NoError(t, z.SetProperty("zsm_test/db", snapshot.KeepProperty(snapshot.Hour), "4"))
+	require.NoError(t, z.SetProperty("zsm_test/scratch", snapshot.PropertySnapshot, "false"))
+
+	mgr := &snapshot.Manager{ZFS: z}
+	_, err := mgr.CreateSnapshots()
+	require.NoError(t, err)
+	ts := time.Now().UTC().Add(-24 * time.Hour)
+	createHourlySnapshots(t, z, ts, 5, "zsm_test", "zsm_test/db", "zsm_test/db/logs")
+	_, err = mgr.CleanSnapshots(snapshot.BucketConfig{snapshot.Hour: 2})
+	require.NoError(t, err)
+
+	names, err := mgr.ListSnapshots()
+	require.NoError(t, err)
+	count := make(map[string]int)
+	for _, n := range names {
+		count[n.FileSystem]++
+	}
+	// zsm_test/db/logs inherits the keep-hour property of zsm_test/db.
+	assert.Equal(t, map[string]int{"zsm_test": 2, "zsm_test/db": 4, "zsm_test/db/logs": 4}, count)
+}
+
+func TestScenario_SkipUnchanged(t *testing.T) {
+	z := memzfs.New("zsm_test")
+	for _, fs := range []string{"zsm_test/db", "zsm_test/media", "zsm_test/new"} {
+		require.NoError(t, z.CreateFileSystem(fs))
+	}
+	require.NoError(t, z.SetProperty("zsm_test/media", snapshot.PropertySkipUnchanged, "false"))
+
+	ts := time.Now().UTC().Add(-24 * time.Hour)
+	createHourlySnapshots(t, z, ts, 2, "zsm_test", "zsm_test/db", "zsm_test/media")
+	require.NoError(t, z.Write("zsm_test/db", []byte("changed")))
+
+	mgr := &snapshot.Manager{ZFS: z}
+	skipped, err := mgr.CreateSnapshots(snapshot.SkipUnchanged())
+	require.NoError(t, err)
+	// zsm_test/media disables skipping. zsm_test/new has no snapshots yet.
+	assert.Equal(t, []string{"zsm_test"}, skipped)
+
+	_, err = mgr.CleanSnapshots(snapshot.BucketConfig{snapshot.Minute: 1})
+	require.NoError(t, err)
+	names, err := mgr.ListSnapshots()
+	require.NoError(t, err)
+	count := make(map[string]int)
+	for _, n := range names {
+		count[n.FileSystem]++
+	}
+	// The existing snapshot of the skipped file system is kept.
+	assert.Equal(t, map[string]int{"zsm_test": 1, "zsm_test/db": 1, "zsm_test/media": 1, "zsm_test/new": 1}, count)
+}
+
+func TestScenario_SkipUnchangedAfterOtherSnapshots(t *testing.T) {
+	z := memzfs.New("zsm_test")
+	for _, fs := range []string{"zsm_test/db", "zsm_test/media"} {
+		require.NoError(t, z.CreateFileSystem(fs))
+	}
+	ts := time.Now().UTC().Add(-24 * time.Hour)
+	createHourlySnapshots(t, z, ts, 2, "zsm_test", "zsm_test/db", "zsm_test/media")
+	require.NoError(t, z.Write("zsm_test/db", []byte("changed")))
+	require.NoError(t, z.Write("zsm_test/media", []byte("changed")))
+
+	mgr := &snapshot.Manager{ZFS: z}
+	expires := time.Now().Add(24 * time.Hour)
+	_, err := mgr.CreateSnapshots(
+		snapshot.FromFileSystem("zsm_test/db"), snapshot.CreateLabel("pre-deploy"), snapshot.Expires(expires))
+	require.NoError(t, err)
+	require.NoError(t, z.CreateSnapshot("zsm_test/media@manual"))
+
+	skipped, err := mgr.CreateSnapshots(snapshot.SkipUnchanged())
+	require.NoError(t, err)
+	// Both file systems changed since their newest snapshot without a label.
+	assert.Equal(t, []string{"zsm_test"}, skipped)
+}
+
+func TestScenario_Labels(t *testing.T) {
+	z := memzfs.New("zsm_test")
+	ts := time.Now().UTC().Add(-24 * time.Hour)
+	for i := 0; i < 3; i++ {
+		ts = ts.Add(time.Hour)
+		for _, label := range []string{"", "hourly", "pre-deploy"} {
+			name := snapshot.Name{FileSystem: "zsm_test", Timestamp: ts, Label: label}
+			require.NoError(t, z.CreateSnapshot(name.String()))
+		}
+	}
+
+	mgr := &snapshot.Manager{ZFS: z}
+	_, err := mgr.CreateSnapshots(snapshot.CreateLabel("hourly"))
+	require.NoError(t, err)
+	_, err = mgr.CleanSnapshots(snapshot.BucketConfig{snapshot.Hour: 1}, snapshot.CleanLabel("hourly"))
+	require.NoError(t, err)
+
+	names, err := mgr.ListSnapshots()
+	require.NoError(t, err)
+	count := make(map[string]int)
+	for _, n := range names {
+		count[n.Label]++
+	}
+	// Only the hourly snapshots are cleaned. The snapshot created last
+	// fills the only hourly bucket.
+	assert.Equal(t, map[string]int{"": 3, "hourly": 1, "pre-deploy": 3}, count)
+}
+
+func TestScenario_Expiry(t *testing.T) {
+	z := memzfs.New("zsm_test")
+	ts := createHourlySnapshots(t, z, time.Now().UTC().Add(-24*time.Hour), 3, "zsm_test")
+
+	// An ad-hoc snapshot which already expired, e.g. because it was created
+	// before an upgrade long ago.
+	expired := snapshot.Name{FileSystem: "zsm_test", Timestamp: ts.Add(-30 * time.Minute)}
+	require.NoError(t, z.CreateSnapshot(expired.String()))
+	require.NoError(t, z.SetProperty(expired.String(), snapshot.PropertyExpires, ts.Format(time.RFC3339)))
+
+	mgr := &snapshot.Manager{ZFS: z}
+	expires := time.Now().Add(14 * 24 * time.Hour).Truncate(time.Second)
+	_, err := mgr.CreateSnapshots(snapshot.Expires(expires))
+	require.NoError(t, err)
+
+	plans, err := mgr.PlanClean(snapshot.BucketConfig{snapshot.Hour: 1})
+	require.NoError(t, err)
+	require.Len(t, plans, 1)
+	// The expiring snapshot does not fill the hourly bucket. Thus the newest
+	// snapshot created by createHourlySnapshots is kept as well.
+	require.Len(t, plans[0].Keep, 2)
+	assert.Equal(t, expires.UTC(), *plans[0].Keep[0].Expires)
+	assert.Empty(t, plans[0].Keep[0].Intervals)
+	assert.Equal(t, ts, plans[0].Keep[1].Name.Timestamp)
+	assert.Nil(t, plans[0].Keep[1].Expires)
+	assert.Contains(t, plans[0].Reject, expired)
+
+	_, err = mgr.CleanSnapshots(snapshot.BucketConfig{snapshot.Hour: 1})
+	require.NoError(t, err)
+	expiries, err := mgr.Expiries()
+	require.NoError(t, err)
+	require.Len(t, expiries, 1)
+	for _, e := range expiries {
+		assert.Equal(t, expires.UTC(), e)
+	}
+	names, err := mgr.ListSnapshots()
+	require.NoError(t, err)
+	assert.Len(t, names, 2)
+}
+
+func TestScenario_TimeWindows(t *testing.T) {
+	z := memzfs.New("zsm_test")
+	ts := createHourlySnapshots(t, z, time.Now().UTC().Add(-20*time.Hour), 20, "zsm_test")
+
+	mgr := &snapshot.Manager{ZFS: z}
+	plans, err := mgr.PlanClean(snapshot.BucketConfig{snapshot.Day: 1},
+		snapshot.KeepWithin(snapshot.Period{Duration: 5*time.Hour + 30*time.Minute}),
+		snapshot.MaxAge(snapshot.Period{Duration: 12 * time.Hour}))
+	require.NoError(t, err)
+	require.Len(t, plans, 1)
+	// The snapshots of the last 5.5 hours are kept. The newest of them fills
+	// the daily bucket.
+	assert.Len(t, plans[0].Keep, 6)
+	assert.Equal(t, ts, plans[0].Keep[0].Name.Timestamp)
+	assert.Equal(t, []snapshot.Interval{snapshot.Day}, plans[0].Keep[0].Intervals)
+	assert.Len(t, plans[0].Reject, 14)
+
+	_, err = mgr.PlanClean(snapshot.BucketConfig{},
+		snapshot.KeepWithin(snapshot.Period{Duration: 2 * time.Hour}),
+		snapshot.MaxAge(snapshot.Period{Duration: time.Hour}))
+	assert.EqualError(t, err, "plan clean: keep within 2h exceeds max age 1h")
+
+	_, err = mgr.PlanClean(snapshot.BucketConfig{},
+		snapshot.KeepWithin(snapshot.Period{Months: 13}), snapshot.MaxAge(snapshot.Period{Months: 12}))
+	assert.EqualError(t, err, "plan clean: keep within 13mo exceeds max age 1y")
+}
+
+func TestScenario_Holds(t *testing.T) {
+	z := memzfs.New("zsm_test")
+	start := time.Now().UTC().Add(-24 * time.Hour)
+	createHourlySnapshots(t, z, start, 3, "zsm_test")
+	oldest := snapshot.Name{FileSystem: "zsm_test", Timestamp: start.Add(time.Hour)}
+
+	mgr := &snapshot.Manager{ZFS: z}
+	require.NoError(t, mgr.HoldSnapshots("investigation", oldest))
+	tags, err := mgr.SnapshotHolds(oldest)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"investigation"}, tags)
+
+	plans, err := mgr.PlanClean(snapshot.BucketConfig{snapshot.Hour: 1})
+	require.NoError(t, err)
+	require.Len(t, plans, 1)
+	assert.Equal(t, []snapshot.Name{oldest}, plans[0].Held)
+	assert.NotContains(t, plans[0].Reject, oldest)
+
+	// The held snapshot does not make clean fail.
+	held, err := mgr.CleanSnapshots(snapshot.BucketConfig{snapshot.Hour: 1})
+	require.NoError(t, err)
+	assert.Equal(t, []snapshot.Name{oldest}, held)
+	names, err := mgr.ListSnapshots()
+	require.NoError(t, err)
+	assert.Len(t, names, 2)
+
+	require.NoError(t, mgr.ReleaseSnapshots("investigation", oldest))
+	held, err = mgr.CleanSnapshots(snapshot.BucketConfig{snapshot.Hour: 1})
+	require.NoError(t, err)
+	assert.Empty(t, held)
+	names, err = mgr.ListSnapshots()
+	require.NoError(t, err)
+	assert.Len(t, names, 1)
+
+	assert.Error(t, mgr.HoldSnapshots("", oldest))
+}
+
+// createHourlySnapshots creates n snapshots an hour apart for each file system
+// in fileSystems. The first snapshot is created an hour after ts. It writes
+// some data before each snapshot and returns the timestamp of the last
+// snapshot.
+func createHourlySnapshots(t *testing.T, z *memzfs.ZFS, ts time.Time, n int, fileSystems ...string) time.Time {
+	t.Helper()
+
+	for i := 0; i < n; i++ {
+		ts = ts.Add(time.Hour)
+		for _, fs := range fileSystems {
+			name := snapshot.Name{FileSystem: fs, Timestamp: ts}
+			require.NoError(t, z.Write(fs, []byte(fmt.Sprintf("%s: data of %s", fs, name))))
+			require.NoError(t, z.CreateSnapshot(name.String()))
+		}
+	}
+	return ts
+}
+
+// assertInSync asserts that srcFS and dstFS share the latest snapshot of
+// srcFS and that the contents of both file systems are equal.
+func assertInSync(t *testing.T, srcZFS, dstZFS *memzfs.ZFS, srcFS, dstFS string) {
+	t.Helper()
+
+	snapshots, err := srcZFS.List(zfs.Snapshot)
+	require.NoError(t, err)
+
+	var latest snapshot.Name
+	for _, s := range snapshots {
+		if n, ok := snapshot.ParseName(s); ok && n.FileSystem == srcFS {
+			latest = n
+		}
+	}
+	require.NotEqual(t, "", latest.FileSystem, "no snapshots of %s", srcFS)
+
+	srcGUID, err := srcZFS.GUID(latest.String())
+	require.NoError(t, err)
+	dstGUID, err := dstZFS.GUID(snapshot.Name{FileSystem: dstFS, Timestamp: latest.Timestamp}.String())
+	require.NoError(t, err)
+	assert.Equal(t, srcGUID, dstGUID)
+
+	srcData, err := srcZFS.Read(srcFS)
+	require.NoError(t, err)
+	dstData, err := dstZFS.Read(dstFS)
+	require.NoError(t, err)
+	assert.Equal(t, srcData, dstData)
+}
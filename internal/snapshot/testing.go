@@ -16,9 +16,11 @@ type MockZFSAdapter struct {
 	mock.Mock
 }
 
-// CreateSnapshot registers a mock call to zfs snapshot
-func (m *MockZFSAdapter) CreateSnapshot(name string) error {
-	args := m.Called(name)
+// CreateSnapshots registers a mock call to zfs snapshot.
+//
+// The names are passed to Called as a single slice after props.
+func (m *MockZFSAdapter) CreateSnapshots(props map[string]string, names ...string) error {
+	args := m.Called(props, names)
 	return args.Error(0)
 }
 
@@ -34,18 +36,88 @@ func (m *MockZFSAdapter) Destroy(name string) error {
 	return args.Error(0)
 }
 
+// DestroySnapshots registers a call to zfs destroy for several snapshots.
+//
+// The names are passed to Called as a single slice.
+func (m *MockZFSAdapter) DestroySnapshots(names ...string) error {
+	args := m.Called(names)
+	return args.Error(0)
+}
+
+// Bookmark registers a call to zfs bookmark.
+func (m *MockZFSAdapter) Bookmark(snapshot, bookmark string) error {
+	args := m.Called(snapshot, bookmark)
+	return args.Error(0)
+}
+
+// Hold registers a call to zfs hold.
+func (m *MockZFSAdapter) Hold(tag string, names ...string) error {
+	args := m.Called(tag, names)
+	return args.Error(0)
+}
+
+// Release registers a call to zfs release.
+func (m *MockZFSAdapter) Release(tag string, names ...string) error {
+	args := m.Called(tag, names)
+	return args.Error(0)
+}
+
+// Holds registers a call to zfs holds.
+func (m *MockZFSAdapter) Holds(name string) ([]string, error) {
+	args := m.Called(name)
+	return args.Get(0).([]string), args.Error(1)
+}
+
 // Receive registers a call to zfs receive.
-func (m *MockZFSAdapter) Receive(name string, r io.Reader) error {
-	args := m.Called(name, r)
+func (m *MockZFSAdapter) Receive(name string, resumable bool, r io.Reader) error {
+	args := m.Called(name, resumable, r)
 	return args.Error(0)
 }
 
+// AbortReceive registers a call to zfs receive -A.
+func (m *MockZFSAdapter) AbortReceive(name string) error {
+	args := m.Called(name)
+	return args.Error(0)
+}
+
+// SendResume registers a call to zfs send -t.
+func (m *MockZFSAdapter) SendResume(token string, w io.Writer) error {
+	args := m.Called(token, w)
+	return args.Error(0)
+}
+
+// ResumeTokens registers a call to zfs get receive_resume_token.
+func (m *MockZFSAdapter) ResumeTokens(name string) (map[string]string, error) {
+	args := m.Called(name)
+	return args.Get(0).(map[string]string), args.Error(1)
+}
+
+// Properties registers a call to zfs get for multiple properties.
+//
+// The properties are passed to Called as a single slice.
+func (m *MockZFSAdapter) Properties(typ zfs.ListType, props ...string) (map[string]map[string]string, error) {
+	args := m.Called(typ, props)
+	return args.Get(0).(map[string]map[string]string), args.Error(1)
+}
+
 // Send registers a call to zfs send.
 func (m *MockZFSAdapter) Send(name, ref string, w io.Writer) error {
 	args := m.Called(name, ref, w)
 	return args.Error(0)
 }
 
+// GUID registers a call to zfs get guid.
+func (m *MockZFSAdapter) GUID(name string) (uint64, error) {
+	args := m.Called(name)
+	return args.Get(0).(uint64), args.Error(1)
+}
+
+// Written registers a call to zfs get written@snapname.
+func (m *MockZFSAdapter) Written(name string) (uint64, error) {
+	args := m.Called(name)
+	return args.Get(0).(uint64), args.Error(1)
+}
+
 // AssertNameFormat asserts that the passed snapName has the expected format
 // for a snapshot of a filesystem with name fsName.
 func AssertNameFormat(t *testing.T, fsName, snapName string) bool {
@@ -69,6 +141,7 @@ func FakeNames(t *testing.T, end Name, delta Interval, n int) []Name {
 		names[i] = Name{
 			FileSystem: end.FileSystem,
 			Timestamp:  ts,
+			Label:      end.Label,
 		}
 		switch delta {
 		case Minute:
@@ -156,22 +229,33 @@ type MockManager struct {
 
 	ZFS string
 
+	// DatasetTypes is set to the dataset types configured when creating
+	// the MockManager.
+	DatasetTypes []string
+
+	// Dest is set to the destination passed when connecting to the
+	// MockManager acting as a remote host.
+	Dest string
+
 	expectedCreateOpts createOpts
 	actualCreateOpts   createOpts
 
+	expectedCleanOpts cleanOpts
+	actualCleanOpts   cleanOpts
+
 	expectedSendOpts sendOpts
 	actualSendOpts   sendOpts
 }
 
 // CreateSnapshots registers a call to CreateSnapshots.
-func (m *MockManager) CreateSnapshots(opts ...CreateOption) error {
+func (m *MockManager) CreateSnapshots(opts ...CreateOption) ([]string, error) {
 	callArgs := make([]interface{}, len(opts))
 	for i, opt := range opts {
 		callArgs[i] = opt
 		opt(&m.actualCreateOpts)
 	}
 	args := m.Called(callArgs...)
-	return args.Error(0)
+	return args.Get(0).([]string), args.Error(1)
 }
 
 // ExpectCreateOptions sets the CreateOptions expected when CreateSnapshot is called.
@@ -187,17 +271,89 @@ func (m *MockManager) AssertCreateOptions(t *testing.T) bool {
 }
 
 // CleanSnapshots registers a call to CleanSnapshots.
-func (m *MockManager) CleanSnapshots(cfg BucketConfig) error {
-	args := m.Called(cfg)
+func (m *MockManager) CleanSnapshots(r BucketConfigResolver, opts ...CleanOption) ([]Name, error) {
+	callArgs := []interface{}{r}
+	for _, opt := range opts {
+		callArgs = append(callArgs, opt)
+		opt(&m.actualCleanOpts)
+	}
+	args := m.Called(callArgs...)
+	return args.Get(0).([]Name), args.Error(1)
+}
+
+// ExpectCleanOptions sets the CleanOptions expected when CleanSnapshots is
+// called.
+func (m *MockManager) ExpectCleanOptions(opts ...CleanOption) {
+	for _, opt := range opts {
+		opt(&m.expectedCleanOpts)
+	}
+}
+
+// AssertCleanOptions asserts that the expected clean options were actually
+// passed.
+func (m *MockManager) AssertCleanOptions(t *testing.T) bool {
+	return assert.Equal(t, m.expectedCleanOpts, m.actualCleanOpts)
+}
+
+// PlanClean registers a call to PlanClean.
+func (m *MockManager) PlanClean(r BucketConfigResolver, opts ...CleanOption) ([]CleanPlan, error) {
+	callArgs := []interface{}{r}
+	for _, opt := range opts {
+		callArgs = append(callArgs, opt)
+		opt(&m.actualCleanOpts)
+	}
+	args := m.Called(callArgs...)
+	return args.Get(0).([]CleanPlan), args.Error(1)
+}
+
+// HoldSnapshots registers a call to HoldSnapshots.
+func (m *MockManager) HoldSnapshots(tag string, names ...Name) error {
+	args := m.Called(tag, names)
+	return args.Error(0)
+}
+
+// ReleaseSnapshots registers a call to ReleaseSnapshots.
+func (m *MockManager) ReleaseSnapshots(tag string, names ...Name) error {
+	args := m.Called(tag, names)
 	return args.Error(0)
 }
 
+// SnapshotHolds registers a call to SnapshotHolds.
+func (m *MockManager) SnapshotHolds(name Name) ([]string, error) {
+	args := m.Called(name)
+	return args.Get(0).([]string), args.Error(1)
+}
+
 // ListSnapshots registers a call to ListSnapshots.
 func (m *MockManager) ListSnapshots() ([]Name, error) {
 	args := m.Called()
 	return args.Get(0).([]Name), args.Error(1)
 }
 
+// ListBookmarks registers a call to ListBookmarks.
+func (m *MockManager) ListBookmarks() ([]Name, error) {
+	args := m.Called()
+	return args.Get(0).([]Name), args.Error(1)
+}
+
+// BookmarkSnapshot registers a call to BookmarkSnapshot.
+func (m *MockManager) BookmarkSnapshot(name Name) error {
+	args := m.Called(name)
+	return args.Error(0)
+}
+
+// BookmarkGUID registers a call to BookmarkGUID.
+func (m *MockManager) BookmarkGUID(name Name) (uint64, error) {
+	args := m.Called(name)
+	return args.Get(0).(uint64), args.Error(1)
+}
+
+// Expiries registers a call to Expiries.
+func (m *MockManager) Expiries() (map[Name]time.Time, error) {
+	args := m.Called()
+	return args.Get(0).(map[Name]time.Time), args.Error(1)
+}
+
 // ReceiveSnapshot registers a call to ReceiveSnapshot.
 func (m *MockManager) ReceiveSnapshot(targetFS string, name Name, r io.Reader) error {
 	args := m.Called(targetFS, name, r)
@@ -215,6 +371,36 @@ func (m *MockManager) SendSnapshot(name Name, w io.Writer, opts ...SendOption) e
 	return args.Error(0)
 }
 
+// ResumeTokens registers a call to ResumeTokens.
+func (m *MockManager) ResumeTokens(targetFS string) (map[string]string, error) {
+	args := m.Called(targetFS)
+	return args.Get(0).(map[string]string), args.Error(1)
+}
+
+// ResumeReceive registers a call to ResumeReceive.
+func (m *MockManager) ResumeReceive(targetFS, fs string, r io.Reader) error {
+	args := m.Called(targetFS, fs, r)
+	return args.Error(0)
+}
+
+// AbortReceive registers a call to AbortReceive.
+func (m *MockManager) AbortReceive(targetFS, fs string) error {
+	args := m.Called(targetFS, fs)
+	return args.Error(0)
+}
+
+// ResumeSend registers a call to ResumeSend.
+func (m *MockManager) ResumeSend(token string, w io.Writer) error {
+	args := m.Called(token, w)
+	return args.Error(0)
+}
+
+// SnapshotGUID registers a call to SnapshotGUID.
+func (m *MockManager) SnapshotGUID(name Name) (uint64, error) {
+	args := m.Called(name)
+	return args.Get(0).(uint64), args.Error(1)
+}
+
 // ExpectSendOptions sets the SendOptions expected when SendSnapshot is called.
 func (m *MockManager) ExpectSendOptions(opts ...SendOption) {
 	for _, opt := range opts {
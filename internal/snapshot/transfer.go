@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"io"
 	"sort"
+	"strings"
 )
 
 // Lister defines the ListSnapshots method.
@@ -21,22 +22,217 @@ type Sender interface {
 	SendSnapshot(Name, io.Writer, ...SendOption) error
 }
 
-// ListerReceiver defines a type that can list all snapshots known to it and
-// can receive additional snapshots.
+// GUIDGetter defines the SnapshotGUID method.
+type GUIDGetter interface {
+	SnapshotGUID(Name) (uint64, error)
+}
+
+// ResumableReceiver defines the methods required to resume or abort an
+// interrupted receive.
+type ResumableReceiver interface {
+	ResumeTokens(string) (map[string]string, error)
+	ResumeReceive(string, string, io.Reader) error
+	AbortReceive(string, string) error
+}
+
+// ResumableSender defines the ResumeSend method.
+type ResumableSender interface {
+	ResumeSend(string, io.Writer) error
+}
+
+// Holder defines the methods required to place and release user holds on
+// snapshots.
+type Holder interface {
+	HoldSnapshots(string, ...Name) error
+	ReleaseSnapshots(string, ...Name) error
+	SnapshotHolds(Name) ([]string, error)
+}
+
+// Bookmarker defines the methods required to create and use bookmarks of
+// snapshots.
+type Bookmarker interface {
+	BookmarkSnapshot(Name) error
+	ListBookmarks() ([]Name, error)
+	BookmarkGUID(Name) (uint64, error)
+}
+
+// ListerReceiver defines a type that can list all snapshots known to it,
+// determine their guids, and can receive additional snapshots.
 type ListerReceiver interface {
 	Lister
+	GUIDGetter
 	Receiver
+	ResumableReceiver
 }
 
-// ListerSender defines a type that can list all snapshots known to it and
-// can send snapshots.
+// ListerSender defines a type that can list all snapshots known to it,
+// determine their guids, can send snapshots, hold them while doing so, and
+// bookmark them afterwards.
 type ListerSender interface {
 	Lister
+	GUIDGetter
 	Sender
+	ResumableSender
+	Holder
+	Bookmarker
+}
+
+// SendHoldTag is the tag of the holds Transfer places on the snapshots it
+// sends and on their reference for the duration of the send.
+const SendHoldTag = "zsm-send"
+
+// BaseHoldTag returns the tag of the hold Transfer keeps on the newest
+// snapshot of each file system which is known to exist below targetFS on
+// the destination dest. dest may be empty.
+func BaseHoldTag(dest, targetFS string) string {
+	if dest == "" {
+		return "zsm-base:" + targetFS
+	}
+	return "zsm-base:" + dest + ":" + targetFS
+}
+
+// TransferOption modifies the way Transfer selects the snapshots to transfer.
+type TransferOption func(*transferOpts)
+
+type transferOpts struct {
+	FileSystems         []string
+	ExcludedFileSystems map[string]bool
+	Hooks               Hooks
+	Destination         string
+	AbortResume         bool
+}
+
+// transferSelection contains the parsed patterns of transferOpts.
+type transferSelection struct {
+	FileSystems patterns
+	Excluded    patterns
+}
+
+func (o *transferOpts) selection() (transferSelection, error) {
+	var (
+		sel transferSelection
+		err error
+	)
+
+	if sel.FileSystems, err = parsePatterns(o.FileSystems); err != nil {
+		return sel, err
+	}
+	if sel.Excluded, err = parsePatternSet(o.ExcludedFileSystems); err != nil {
+		return sel, err
+	}
+	return sel, nil
+}
+
+func (s transferSelection) selected(fs string) bool {
+	if s.Excluded.Match(fs) {
+		return false
+	}
+	return len(s.FileSystems) == 0 || s.FileSystems.Match(fs)
+}
+
+// TransferFileSystem makes Transfer transfer only snapshots of the file
+// systems matching the passed Pattern. If TransferFileSystem is passed
+// multiple times to Transfer it transfers the snapshots of all the file
+// systems matching any of the patterns.
+func TransferFileSystem(pattern string) TransferOption {
+	return func(o *transferOpts) {
+		pattern = strings.TrimPrefix(pattern, "/")
+		o.FileSystems = append(o.FileSystems, pattern)
+	}
+}
+
+// TransferExcludeFileSystem marks the file systems matching the passed
+// Pattern as excluded from transferring snapshots.
+func TransferExcludeFileSystem(pattern string) TransferOption {
+	return func(o *transferOpts) {
+		if o.ExcludedFileSystems == nil {
+			o.ExcludedFileSystems = make(map[string]bool)
+		}
+		pattern = strings.TrimPrefix(pattern, "/")
+		o.ExcludedFileSystems[pattern] = true
+	}
+}
+
+// TransferHooks adds hooks executed before and after Transfer transfers
+// snapshots. The hooks apply to the selected file systems with snapshots on
+// src. If TransferHooks is passed multiple times, the hooks of all calls are
+// executed in the order they were passed.
+func TransferHooks(hs Hooks) TransferOption {
+	return func(o *transferOpts) {
+		o.Hooks.Pre = append(o.Hooks.Pre, hs.Pre...)
+		o.Hooks.Post = append(o.Hooks.Post, hs.Post...)
+	}
+}
+
+// TransferDestination sets the name of dst used in the tag of the hold
+// Transfer keeps on the newest snapshot dst has of each file system. See
+// BaseHoldTag. Pass a distinct name for each destination receiving
+// snapshots below the same target file system.
+func TransferDestination(dest string) TransferOption {
+	return func(o *transferOpts) {
+		o.Destination = dest
+	}
+}
+
+// AbortResume makes Transfer abort all interrupted transfers of the selected
+// file systems instead of resuming them. The partially received data is
+// discarded on dst. Afterwards Transfer sends the snapshots as if the
+// interrupted transfers never happened.
+//
+// An interrupted transfer can't be resumed once the snapshot it sent was
+// destroyed on src. AbortResume allows to recover from this.
+func AbortResume() TransferOption {
+	return func(o *transferOpts) {
+		o.AbortResume = true
+	}
 }
 
 // Transfer transfers all snapshots not already known on dst from src to dst.
-func Transfer(targetFS string, dst ListerReceiver, src ListerSender) error {
+//
+// The snapshots are stored below targetFS on dst. Only snapshots dst lists
+// below targetFS are considered to be already known on dst.
+//
+// For each file system Transfer determines the newest snapshot src and dst
+// have in common. A snapshot is considered to be common if it has the same
+// name and the same guid on src and dst. Transfer then sends all snapshots
+// newer than the common snapshot incrementally. If dst has no snapshots for a
+// file system, Transfer sends the newest snapshot of src. If dst has
+// snapshots for a file system but none of them is shared with src, Transfer
+// returns an error.
+//
+// Before determining the snapshots to transfer, Transfer resumes all
+// interrupted transfers of the selected file systems to dst, or aborts them
+// if AbortResume is passed.
+//
+// The file systems are transferred in lexical order. This ensures that parent
+// file systems are transferred before their children.
+//
+// The hooks passed using TransferHooks are executed before resuming any
+// transfers and after all snapshots are transferred.
+//
+// Transfer places a hold with SendHoldTag on the sent snapshot and its
+// reference on src for the duration of the send. This keeps a concurrent
+// CleanSnapshots from destroying them. Additionally Transfer keeps a hold with
+// BaseHoldTag on the newest snapshot of each file system dst is known to
+// have. It moves the hold to the sent snapshot after each successful send.
+// Thus the snapshot required for the next incremental send is never
+// destroyed on src.
+//
+// After each successful send Transfer creates a bookmark of the sent snapshot
+// on src. If src and dst have no snapshot in common, Transfer sends the newest
+// snapshot incrementally to the newest bookmark whose snapshot still exists on
+// dst. In this case the snapshots between the bookmark and the newest
+// snapshot are not transferred.
+func Transfer(targetFS string, dst ListerReceiver, src ListerSender, opts ...TransferOption) error {
+	var tOpts transferOpts
+
+	for _, opt := range opts {
+		opt(&tOpts)
+	}
+	sel, err := tOpts.selection()
+	if err != nil {
+		return fmt.Errorf("transfer: %w", err)
+	}
 	local, err := src.ListSnapshots()
 	if err != nil {
 		return fmt.Errorf("transfer: list src snapshots: %w", err)
@@ -48,46 +244,330 @@ func Transfer(targetFS string, dst ListerReceiver, src ListerSender) error {
 	if len(local) == 0 && len(remote) == 0 {
 		return nil
 	}
+	bookmarks, err := src.ListBookmarks()
+	if err != nil {
+		return fmt.Errorf("transfer: list src bookmarks: %w", err)
+	}
 
 	localGrouped := groupByFS(local)
-	remoteGrouped := groupByFS(remote)
-	for fs, localNames := range localGrouped {
-		localNames := localNames
+	fileSystems := make([]string, 0, len(localGrouped))
+	for _, fs := range sortedFileSystems(localGrouped) {
+		if sel.selected(fs) {
+			fileSystems = append(fileSystems, fs)
+		}
+	}
+	err = runHooks(tOpts.Hooks, hookEnv{Operation: "transfer"}, fileSystems, nil, func() error {
+		tag := BaseHoldTag(tOpts.Destination, targetFS)
+		return transferFileSystems(
+			targetFS, tag, tOpts.AbortResume, dst, src, fileSystems, localGrouped, groupByFS(bookmarks), remote,
+		)
+	})
+	if err != nil {
+		return fmt.Errorf("transfer: %w", err)
+	}
+	return nil
+}
+
+// transferFileSystems transfers the snapshots in localGrouped of all
+// fileSystems from src to dst. bookmarksGrouped contains the bookmarks listed
+// by src, remote the snapshots listed by dst. tag is the tag of the hold on
+// the newest snapshot known to exist on dst. If abort is true interrupted
+// transfers are aborted instead of resumed.
+func transferFileSystems(
+	targetFS, tag string, abort bool, dst ListerReceiver, src ListerSender,
+	fileSystems []string, localGrouped, bookmarksGrouped map[string][]Name, remote []Name,
+) error {
+	resumed, err := resumeTransfers(targetFS, dst, src, fileSystems, abort)
+	if err != nil {
+		return err
+	}
+	if resumed {
+		// The resumed transfers added snapshots to dst.
+		remote, err = dst.ListSnapshots()
+		if err != nil {
+			return fmt.Errorf("list dst snapshots: %w", err)
+		}
+	}
+
+	remoteGrouped := groupByFS(relativeTo(targetFS, remote))
+	for _, fs := range fileSystems {
+		localNames := localGrouped[fs]
 		sort.Slice(localNames, func(i, j int) bool {
 			return localNames[i].Timestamp.Before(localNames[j].Timestamp)
 		})
-		remoteNames, ok := remoteGrouped[fs]
+		err := transferFileSystem(targetFS, tag, dst, src, localNames, remoteGrouped[fs], bookmarksGrouped[fs])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// transferFileSystem transfers the newest of localNames from src to dst.
+// remoteNames contains the snapshots dst has of the same file system,
+// bookmarks the bookmarks src has.
+//
+// localNames must be sorted from the oldest to the newest snapshot. All names
+// in remoteNames must be relative to targetFS.
+func transferFileSystem(
+	targetFS, tag string, dst ListerReceiver, src ListerSender, localNames, remoteNames, bookmarks []Name,
+) error {
+	latest := localNames[len(localNames)-1]
+	if len(remoteNames) == 0 {
+		// The destination has no snapshots for fs. Just transfer
+		// everything we have.
+		if err := transferHeld(targetFS, dst, src, latest); err != nil {
+			return err
+		}
+		return finishTransfer(src, tag, latest, localNames, bookmarks)
+	}
+	base, ok, err := findCommonBase(targetFS, dst, src, localNames, remoteNames)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		bm, ok, err := findCommonBookmark(targetFS, dst, src, latest, bookmarks, remoteNames)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("%s: no common snapshot with %s", latest.FileSystem, targetFS)
+		}
+		if err := transferHeld(targetFS, dst, src, latest, BookmarkReference(bm)); err != nil {
+			return err
+		}
+		return finishTransfer(src, tag, latest, localNames, bookmarks)
+	}
+	if base != latest {
+		if err := transferHeld(targetFS, dst, src, latest, Reference(base)); err != nil {
+			return err
+		}
+	}
+	// If the destination was up-to-date, finishTransfer just makes sure the
+	// hold and the bookmark exist.
+	return finishTransfer(src, tag, latest, localNames, bookmarks)
+}
+
+// finishTransfer moves the hold with tag from the other snapshots in
+// localNames to latest using moveHold. Additionally it creates a bookmark of
+// latest unless bookmarks already contains it.
+func finishTransfer(src ListerSender, tag string, latest Name, localNames, bookmarks []Name) error {
+	if err := moveHold(src, tag, latest, localNames); err != nil {
+		return err
+	}
+	if containsName(bookmarks, latest) {
+		return nil
+	}
+	if err := src.BookmarkSnapshot(latest); err != nil {
+		return fmt.Errorf("bookmark %s: %w", latest.FileSystem, err)
+	}
+	return nil
+}
+
+// transferHeld transfers n from src to dst like transfer. n and the
+// reference snapshot set by opts are held on src using SendHoldTag for the
+// duration of the transfer. Bookmarks can't be held.
+func transferHeld(targetFS string, dst Receiver, src ListerSender, n Name, opts ...SendOption) error {
+	held := []Name{n}
+	if ref, ok := ReferenceOf(opts...); ok {
+		held = append(held, ref)
+	}
+	// A hold left behind by an earlier transfer which did not finish is
+	// reused. It is released along with the holds placed by transferHeld.
+	// Otherwise it would keep the snapshot from ever being destroyed.
+	if _, err := holdMissing(src, SendHoldTag, held...); err != nil {
+		return err
+	}
+	err := transfer(targetFS, dst, src, n, opts...)
+	if rerr := src.ReleaseSnapshots(SendHoldTag, held...); rerr != nil && err == nil {
+		return fmt.Errorf("release %s: %w", n.FileSystem, rerr)
+	}
+	return err
+}
+
+// moveHold places a hold with tag on next, unless it already has one. It then
+// releases the hold with tag from all other snapshots in localNames.
+//
+// The hold is searched for in all of localNames, not only in the snapshots
+// dst has. If dst lost the snapshot with the hold, e.g. because it was
+// pruned, the hold would otherwise keep the snapshot forever.
+func moveHold(src Holder, tag string, next Name, localNames []Name) error {
+	acquired, err := holdMissing(src, tag, next)
+	if err != nil || len(acquired) == 0 {
+		return err
+	}
+	var stale []Name
+	for _, n := range localNames {
+		if n == next {
+			continue
+		}
+		tags, err := src.SnapshotHolds(n)
+		if err != nil {
+			return fmt.Errorf("holds %s: %w", n, err)
+		}
+		if containsString(tags, tag) {
+			stale = append(stale, n)
+		}
+	}
+	if len(stale) == 0 {
+		return nil
+	}
+	if err := src.ReleaseSnapshots(tag, stale...); err != nil {
+		return fmt.Errorf("release %s: %w", next.FileSystem, err)
+	}
+	return nil
+}
+
+// holdMissing places a hold with tag on all names without such a hold. It
+// returns the names it placed the hold on.
+func holdMissing(src Holder, tag string, names ...Name) ([]Name, error) {
+	var missing []Name
+
+	for _, n := range names {
+		tags, err := src.SnapshotHolds(n)
+		if err != nil {
+			return nil, fmt.Errorf("holds %s: %w", n, err)
+		}
+		if !containsString(tags, tag) {
+			missing = append(missing, n)
+		}
+	}
+	if len(missing) == 0 {
+		return nil, nil
+	}
+	if err := src.HoldSnapshots(tag, missing...); err != nil {
+		return nil, fmt.Errorf("hold %s: %w", missing[0].FileSystem, err)
+	}
+	return missing, nil
+}
+
+func containsString(ss []string, s string) bool {
+	for _, v := range ss {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// resumeTransfers resumes all interrupted transfers of fileSystems to dst.
+// It returns true if at least one transfer was resumed. If abort is true the
+// interrupted transfers are aborted instead.
+func resumeTransfers(
+	targetFS string, dst ResumableReceiver, src ResumableSender, fileSystems []string, abort bool,
+) (bool, error) {
+	tokens, err := dst.ResumeTokens(targetFS)
+	if err != nil {
+		return false, fmt.Errorf("dst resume tokens: %w", err)
+	}
+	resumed := false
+	for _, fs := range fileSystems {
+		token, ok := tokens[fs]
 		if !ok {
-			// The destination has no snapshots for fs. Just transfer
-			// everything we have.
-			if err := transfer(targetFS, dst, src, localNames[len(localNames)-1]); err != nil {
-				return fmt.Errorf("transfer: %w", err)
+			continue
+		}
+		if abort {
+			if err := dst.AbortReceive(targetFS, fs); err != nil {
+				return resumed, fmt.Errorf("abort %s: %w", fs, err)
 			}
 			continue
 		}
-		// We assume that all snapshots on destination are sent by this source.
-		if len(remoteNames) == len(localNames) {
-			// If remote and local have the same number of snapshots, we assume
-			// that remote is up-to date. We continue with the next file system.
+		fs := fs
+		err := pipe(
+			func(w io.Writer) error { return src.ResumeSend(token, w) },
+			func(r io.Reader) error { return dst.ResumeReceive(targetFS, fs, r) },
+		)
+		if err != nil {
+			return resumed, fmt.Errorf("resume %s: %w", fs, err)
+		}
+		resumed = true
+	}
+	return resumed, nil
+}
+
+// findCommonBase finds the newest snapshot in localNames which also exists in
+// remoteNames and has the same guid on src and dst. The second return value
+// is false if there is no such snapshot.
+//
+// localNames must be sorted from the oldest to the newest snapshot. All names
+// in remoteNames must be relative to targetFS.
+func findCommonBase(targetFS string, dst, src GUIDGetter, localNames, remoteNames []Name) (Name, bool, error) {
+	remoteSet := make(map[Name]bool, len(remoteNames))
+	for _, n := range remoteNames {
+		remoteSet[n] = true
+	}
+	for i := len(localNames) - 1; i >= 0; i-- {
+		n := localNames[i]
+		if !remoteSet[n] {
 			continue
 		}
-		if len(remoteNames) > len(localNames) {
-			// Abort if the remote has more than snapshots local. This
-			// indicates a problem, since all snapshots on remote for the file
-			// system should come from this host.
-			return fmt.Errorf("transfer: dst has more snapshots: %d > %d", len(remoteNames), len(localNames))
+		srcGUID, err := src.SnapshotGUID(n)
+		if err != nil {
+			return Name{}, false, fmt.Errorf("src guid: %w", err)
 		}
-		// There are fewer snapshots on the destination than there are locally.
-		// We need to determine the difference and send the missing snapshots.
-		sort.Slice(remoteNames, func(i, j int) bool {
-			return remoteNames[i].Timestamp.Before(remoteNames[j].Timestamp)
-		})
-		ref := localNames[len(remoteNames)]
-		if err := transfer(targetFS, dst, src, localNames[len(localNames)-1], Reference(ref)); err != nil {
-			return fmt.Errorf("transfer: %w", err)
+		dstGUID, err := dst.SnapshotGUID(n.Below(targetFS))
+		if err != nil {
+			return Name{}, false, fmt.Errorf("dst guid: %w", err)
+		}
+		if srcGUID == dstGUID {
+			return n, true, nil
 		}
 	}
-	return nil
+	return Name{}, false, nil
+}
+
+// findCommonBookmark finds the newest bookmark in bookmarks which is older
+// than latest and whose snapshot exists in remoteNames with the same guid.
+// The second return value is false if there is no such bookmark.
+//
+// All names in remoteNames must be relative to targetFS.
+func findCommonBookmark(
+	targetFS string, dst GUIDGetter, src Bookmarker, latest Name, bookmarks, remoteNames []Name,
+) (Name, bool, error) {
+	candidates := make([]Name, 0, len(bookmarks))
+	for _, b := range bookmarks {
+		if b.Timestamp.Before(latest.Timestamp) && containsName(remoteNames, b) {
+			candidates = append(candidates, b)
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].Timestamp.After(candidates[j].Timestamp)
+	})
+	for _, b := range candidates {
+		srcGUID, err := src.BookmarkGUID(b)
+		if err != nil {
+			return Name{}, false, fmt.Errorf("src bookmark guid: %w", err)
+		}
+		dstGUID, err := dst.SnapshotGUID(b.Below(targetFS))
+		if err != nil {
+			return Name{}, false, fmt.Errorf("dst guid: %w", err)
+		}
+		if srcGUID == dstGUID {
+			return b, true, nil
+		}
+	}
+	return Name{}, false, nil
+}
+
+// relativeTo returns the names of all snapshots below targetFS relative to
+// targetFS. All other names are dropped.
+func relativeTo(targetFS string, names []Name) []Name {
+	rel := make([]Name, 0, len(names))
+	for _, n := range names {
+		if r, ok := n.RelativeTo(targetFS); ok {
+			rel = append(rel, r)
+		}
+	}
+	return rel
+}
+
+func sortedFileSystems(grp map[string][]Name) []string {
+	fss := make([]string, 0, len(grp))
+	for fs := range grp {
+		fss = append(fss, fs)
+	}
+	sort.Strings(fss)
+	return fss
 }
 
 func groupByFS(names []Name) map[string][]Name {
@@ -102,40 +582,57 @@ func groupByFS(names []Name) map[string][]Name {
 }
 
 func transfer(targetFS string, dst Receiver, src Sender, n Name, opts ...SendOption) error {
+	return pipe(
+		func(w io.Writer) error { return src.SendSnapshot(n, w, opts...) },
+		func(r io.Reader) error { return dst.ReceiveSnapshot(targetFS, n, r) },
+	)
+}
+
+// pipe connects send and receive using an io.Pipe. It calls both functions
+// concurrently and waits until both returned.
+func pipe(send func(io.Writer) error, receive func(io.Reader) error) error {
 	r, w := io.Pipe()
 
-	sendErr := send(src, n, w, opts...)
-	recvErr := receive(dst, targetFS, n, r)
+	sendErrC := sendTo(send, w)
+	recvErrC := receiveFrom(receive, r)
 
-	if err := <-sendErr; err != nil {
-		return err
+	// Wait for both sides to finish. Otherwise the receiving side might
+	// still be busy, e.g. saving the state of an interrupted receive, when
+	// pipe returns.
+	sendErr := <-sendErrC
+	recvErr := <-recvErrC
+	if sendErr != nil {
+		return sendErr
 	}
-	if err := <-recvErr; err != nil {
-		return err
-	}
-
-	return nil
+	return recvErr
 }
 
-func send(src Sender, n Name, w io.WriteCloser, opts ...SendOption) <-chan error {
+func sendTo(send func(io.Writer) error, w *io.PipeWriter) <-chan error {
 	errC := make(chan error, 1)
 	go func() {
 		defer close(errC)
-		defer w.Close() // Close w before closing errC => signals EOF to reader
 
-		if err := src.SendSnapshot(n, w, opts...); err != nil {
+		// Close w before closing errC => signals EOF or the error to the
+		// reader.
+		err := send(w)
+		w.CloseWithError(err) // nolint: errcheck
+		if err != nil {
 			errC <- err
 		}
 	}()
 	return errC
 }
 
-func receive(dst Receiver, targetFS string, n Name, r io.Reader) <-chan error {
+func receiveFrom(receive func(io.Reader) error, r *io.PipeReader) <-chan error {
 	errC := make(chan error, 1)
 	go func() {
 		defer close(errC)
 
-		if err := dst.ReceiveSnapshot(targetFS, n, r); err != nil {
+		// Close r once receive is done. Otherwise the sender would block
+		// forever if receive stopped reading early.
+		err := receive(r)
+		r.CloseWithError(err) // nolint: errcheck
+		if err != nil {
 			errC <- err
 		}
 	}()
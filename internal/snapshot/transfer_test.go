@@ -15,6 +15,7 @@ func TestTransfer(t *testing.T) {
 		name        string
 		local       []snapshot.Name
 		remote      []snapshot.Name
+		opts        []snapshot.TransferOption
 		mock        func(t *testing.T, tt *testCase)
 		expectedErr error
 
@@ -51,14 +52,17 @@ func TestTransfer(t *testing.T) {
 			},
 		},
 		{
-			name:   "dst more snapshots than src",
-			local:  snapshot.FakeNames(t, snapshot.Name{FileSystem: "zsm_test", Timestamp: now}, snapshot.Hour, 1),
-			remote: snapshot.FakeNames(t, snapshot.Name{FileSystem: "zsm_test", Timestamp: now}, snapshot.Hour, 2),
+			name:  "dst has more snapshots than src",
+			local: snapshot.FakeNames(t, snapshot.Name{FileSystem: "zsm_test", Timestamp: now}, snapshot.Hour, 1),
+			remote: snapshot.FakeNames(
+				t, snapshot.Name{FileSystem: "target_fs/zsm_test", Timestamp: now}, snapshot.Hour, 2,
+			),
 			mock: func(_ *testing.T, tt *testCase) {
 				tt.src.On("ListSnapshots").Return(tt.local, nil)
 				tt.dst.On("ListSnapshots").Return(tt.remote, nil)
+				mockGUIDs(tt.src, "", tt.local[0])
+				mockGUIDs(tt.dst, tt.targetFS, tt.local[0])
 			},
-			expectedErr: errors.New("transfer: dst has more snapshots: 2 > 1"),
 		},
 		{
 			name: "no snapshots on dst",
@@ -126,15 +130,84 @@ func TestTransfer(t *testing.T) {
 			},
 		},
 		{
-			name:   "dst has all snapshots of src",
-			local:  snapshot.FakeNames(t, snapshot.Name{FileSystem: "zsm_test", Timestamp: now}, snapshot.Day, 5),
-			remote: snapshot.FakeNames(t, snapshot.Name{FileSystem: "zsm_test", Timestamp: now}, snapshot.Day, 5),
+			name:  "ignore dst snapshots outside of target fs",
+			local: []snapshot.Name{{FileSystem: "zsm_test", Timestamp: now}},
+			remote: []snapshot.Name{
+				{FileSystem: "zsm_test", Timestamp: now},
+				{FileSystem: "other_fs/zsm_test", Timestamp: now},
+			},
+			mock: func(t *testing.T, tt *testCase) {
+				tt.src.On("ListSnapshots").Return(tt.local, nil)
+				tt.src.On("SendSnapshot", tt.local[0], mock.AnythingOfType("*io.PipeWriter")).Return(nil)
+
+				tt.dst.On("ListSnapshots").Return(tt.remote, nil)
+				tt.dst.On("ReceiveSnapshot", tt.targetFS, tt.local[0], mock.AnythingOfType("*io.PipeReader")).
+					Return(nil)
+			},
+		},
+		{
+			name: "transfer selected file systems only",
+			local: []snapshot.Name{
+				{FileSystem: "zsm_test", Timestamp: now},
+				{FileSystem: "zsm_test/fs1", Timestamp: now},
+				{FileSystem: "zsm_test/fs2", Timestamp: now},
+			},
+			opts: []snapshot.TransferOption{
+				snapshot.TransferFileSystem("zsm_test/fs1"),
+				snapshot.TransferFileSystem("/zsm_test/fs2"),
+				snapshot.TransferExcludeFileSystem("zsm_test/fs2"),
+			},
+			mock: func(t *testing.T, tt *testCase) {
+				tt.src.On("ListSnapshots").Return(tt.local, nil)
+				tt.src.On("SendSnapshot", tt.local[1], mock.AnythingOfType("*io.PipeWriter")).Return(nil)
+
+				tt.dst.On("ListSnapshots").Return(tt.remote, nil)
+				tt.dst.On("ReceiveSnapshot", tt.targetFS, tt.local[1], mock.AnythingOfType("*io.PipeReader")).
+					Return(nil)
+			},
+		},
+		{
+			name: "exclude file systems matching patterns",
+			local: []snapshot.Name{
+				{FileSystem: "zsm_test", Timestamp: now},
+				{FileSystem: "zsm_test/docker/abc", Timestamp: now},
+				{FileSystem: "zsm_test/home_1", Timestamp: now},
+			},
+			opts: []snapshot.TransferOption{
+				snapshot.TransferExcludeFileSystem("zsm_test/docker/*"),
+				snapshot.TransferExcludeFileSystem("re:zsm_test/home_[0-9]+"),
+			},
+			mock: func(t *testing.T, tt *testCase) {
+				tt.src.On("ListSnapshots").Return(tt.local, nil)
+				tt.src.On("SendSnapshot", tt.local[0], mock.AnythingOfType("*io.PipeWriter")).Return(nil)
+
+				tt.dst.On("ListSnapshots").Return(tt.remote, nil)
+				tt.dst.On("ReceiveSnapshot", tt.targetFS, tt.local[0], mock.AnythingOfType("*io.PipeReader")).
+					Return(nil)
+			},
+		},
+		{
+			name: "invalid pattern",
+			opts: []snapshot.TransferOption{snapshot.TransferExcludeFileSystem("re:(")},
+			mock: func(t *testing.T, tt *testCase) {},
+			expectedErr: errors.New(
+				"transfer: invalid pattern \"re:(\": error parsing regexp: missing closing ): `^(?:()$`",
+			),
+		},
+		{
+			name:  "dst has all snapshots of src",
+			local: snapshot.FakeNames(t, snapshot.Name{FileSystem: "zsm_test", Timestamp: now}, snapshot.Day, 5),
+			remote: snapshot.FakeNames(
+				t, snapshot.Name{FileSystem: "target_fs/zsm_test", Timestamp: now}, snapshot.Day, 5,
+			),
 			mock: func(t *testing.T, tt *testCase) {
 				localShuffled := snapshot.ShuffleNamesC(tt.local)
 				tt.src.On("ListSnapshots").Return(localShuffled, nil)
+				mockGUIDs(tt.src, "", tt.local[4])
 
 				remoteShuffled := snapshot.ShuffleNamesC(tt.remote)
 				tt.dst.On("ListSnapshots").Return(remoteShuffled, nil)
+				mockGUIDs(tt.dst, tt.targetFS, tt.local[4])
 			},
 		},
 		{
@@ -143,7 +216,7 @@ func TestTransfer(t *testing.T) {
 				t, snapshot.Name{FileSystem: "zsm_test", Timestamp: now}, snapshot.Hour, 10,
 			),
 			remote: snapshot.FakeNames(
-				t, snapshot.Name{FileSystem: "zsm_test", Timestamp: now.Add(-5 * time.Hour)},
+				t, snapshot.Name{FileSystem: "target_fs/zsm_test", Timestamp: now.Add(-5 * time.Hour)},
 				snapshot.Hour, 5,
 			),
 			mock: func(t *testing.T, tt *testCase) {
@@ -152,19 +225,209 @@ func TestTransfer(t *testing.T) {
 				tt.src.On("SendSnapshot",
 					tt.local[9], mock.AnythingOfType("*io.PipeWriter"), mock.AnythingOfType("snapshot.SendOption"),
 				).Return(nil)
-				tt.src.ExpectSendOptions(snapshot.Reference(tt.local[5]))
+				tt.src.ExpectSendOptions(snapshot.Reference(tt.local[4]))
+				mockGUIDs(tt.src, "", tt.local[4])
 
 				remoteShuffled := snapshot.ShuffleNamesC(tt.remote)
 				tt.dst.On("ListSnapshots").Return(remoteShuffled, nil)
 				tt.dst.On("ReceiveSnapshot", tt.targetFS, tt.local[9], mock.AnythingOfType("*io.PipeReader")).
 					Return(nil)
+				mockGUIDs(tt.dst, tt.targetFS, tt.local[4])
+			},
+		},
+		{
+			name: "src and dst pruned differently",
+			local: []snapshot.Name{
+				{FileSystem: "zsm_test", Timestamp: now.Add(-5 * time.Hour)},
+				{FileSystem: "zsm_test", Timestamp: now.Add(-3 * time.Hour)},
+				{FileSystem: "zsm_test", Timestamp: now},
+			},
+			remote: []snapshot.Name{
+				{FileSystem: "target_fs/zsm_test", Timestamp: now.Add(-4 * time.Hour)},
+				{FileSystem: "target_fs/zsm_test", Timestamp: now.Add(-3 * time.Hour)},
+				{FileSystem: "target_fs/zsm_test", Timestamp: now.Add(-2 * time.Hour)},
+			},
+			mock: func(t *testing.T, tt *testCase) {
+				tt.src.On("ListSnapshots").Return(tt.local, nil)
+				tt.src.On("SendSnapshot",
+					tt.local[2], mock.AnythingOfType("*io.PipeWriter"), mock.AnythingOfType("snapshot.SendOption"),
+				).Return(nil)
+				tt.src.ExpectSendOptions(snapshot.Reference(tt.local[1]))
+				mockGUIDs(tt.src, "", tt.local[1])
+
+				tt.dst.On("ListSnapshots").Return(tt.remote, nil)
+				tt.dst.On("ReceiveSnapshot", tt.targetFS, tt.local[2], mock.AnythingOfType("*io.PipeReader")).
+					Return(nil)
+				mockGUIDs(tt.dst, tt.targetFS, tt.local[1])
+			},
+		},
+		{
+			name: "skip common name with different guid",
+			local: snapshot.FakeNames(
+				t, snapshot.Name{FileSystem: "zsm_test", Timestamp: now}, snapshot.Hour, 3,
+			),
+			remote: snapshot.FakeNames(
+				t, snapshot.Name{FileSystem: "target_fs/zsm_test", Timestamp: now.Add(-time.Hour)}, snapshot.Hour, 2,
+			),
+			mock: func(t *testing.T, tt *testCase) {
+				tt.src.On("ListSnapshots").Return(tt.local, nil)
+				tt.src.On("SendSnapshot",
+					tt.local[2], mock.AnythingOfType("*io.PipeWriter"), mock.AnythingOfType("snapshot.SendOption"),
+				).Return(nil)
+				tt.src.ExpectSendOptions(snapshot.Reference(tt.local[0]))
+				mockGUIDs(tt.src, "", tt.local[0], tt.local[1])
+
+				tt.dst.On("ListSnapshots").Return(tt.remote, nil)
+				tt.dst.On("ReceiveSnapshot", tt.targetFS, tt.local[2], mock.AnythingOfType("*io.PipeReader")).
+					Return(nil)
+				mockGUIDs(tt.dst, tt.targetFS, tt.local[0])
+				tt.dst.On("SnapshotGUID", tt.local[1].Below(tt.targetFS)).Return(uint64(42), nil)
+			},
+		},
+		{
+			name: "no common snapshot",
+			local: snapshot.FakeNames(
+				t, snapshot.Name{FileSystem: "zsm_test", Timestamp: now}, snapshot.Hour, 3,
+			),
+			remote: snapshot.FakeNames(
+				t, snapshot.Name{FileSystem: "target_fs/zsm_test", Timestamp: now.Add(-3 * time.Hour)},
+				snapshot.Hour, 1,
+			),
+			mock: func(t *testing.T, tt *testCase) {
+				tt.src.On("ListSnapshots").Return(tt.local, nil)
+				tt.dst.On("ListSnapshots").Return(tt.remote, nil)
+			},
+			expectedErr: errors.New("transfer: zsm_test: no common snapshot with target_fs"),
+		},
+		{
+			name: "get guid fails",
+			local: snapshot.FakeNames(
+				t, snapshot.Name{FileSystem: "zsm_test", Timestamp: now}, snapshot.Hour, 3,
+			),
+			remote: snapshot.FakeNames(
+				t, snapshot.Name{FileSystem: "target_fs/zsm_test", Timestamp: now.Add(-2 * time.Hour)},
+				snapshot.Hour, 1,
+			),
+			mock: func(t *testing.T, tt *testCase) {
+				tt.src.On("ListSnapshots").Return(tt.local, nil)
+				mockGUIDs(tt.src, "", tt.local[0])
+
+				tt.dst.On("ListSnapshots").Return(tt.remote, nil)
+				tt.dst.On("SnapshotGUID", tt.local[0].Below(tt.targetFS)).Return(uint64(0), errors.New("guid failed"))
+			},
+			expectedErr: errors.New("transfer: dst guid: guid failed"),
+		},
+		{
+			name: "resume interrupted transfer",
+			local: snapshot.FakeNames(
+				t, snapshot.Name{FileSystem: "zsm_test", Timestamp: now}, snapshot.Hour, 3,
+			),
+			remote: snapshot.FakeNames(
+				t, snapshot.Name{FileSystem: "target_fs/zsm_test", Timestamp: now.Add(-time.Hour)},
+				snapshot.Hour, 2,
+			),
+			mock: func(t *testing.T, tt *testCase) {
+				token := "1-e604ea4bf-e0-789c63a2"
+
+				tt.src.On("ListSnapshots").Return(tt.local, nil)
+				tt.src.On("ResumeSend", token, mock.AnythingOfType("*io.PipeWriter")).Return(nil)
+				mockGUIDs(tt.src, "", tt.local[1])
+				tt.src.On("SendSnapshot",
+					tt.local[2], mock.AnythingOfType("*io.PipeWriter"), mock.AnythingOfType("snapshot.SendOption"),
+				).Return(nil)
+				tt.src.ExpectSendOptions(snapshot.Reference(tt.local[1]))
+
+				// The interrupted transfer was sending tt.local[1]. It is
+				// missing on dst before resuming.
+				tt.dst.On("ListSnapshots").Return(tt.remote[:1], nil).Once()
+				tt.dst.On("ResumeTokens", tt.targetFS).Return(map[string]string{"zsm_test": token}, nil)
+				tt.dst.On("ResumeReceive", tt.targetFS, "zsm_test", mock.AnythingOfType("*io.PipeReader")).
+					Return(nil)
+				tt.dst.On("ListSnapshots").Return(tt.remote, nil).Once()
+				mockGUIDs(tt.dst, tt.targetFS, tt.local[1])
+				tt.dst.On("ReceiveSnapshot", tt.targetFS, tt.local[2], mock.AnythingOfType("*io.PipeReader")).
+					Return(nil)
+			},
+		},
+		{
+			name: "abort interrupted transfer",
+			local: snapshot.FakeNames(
+				t, snapshot.Name{FileSystem: "zsm_test", Timestamp: now}, snapshot.Hour, 3,
+			),
+			remote: snapshot.FakeNames(
+				t, snapshot.Name{FileSystem: "target_fs/zsm_test", Timestamp: now.Add(-2 * time.Hour)},
+				snapshot.Hour, 1,
+			),
+			opts: []snapshot.TransferOption{snapshot.AbortResume()},
+			mock: func(t *testing.T, tt *testCase) {
+				tt.src.On("ListSnapshots").Return(tt.local, nil)
+				mockGUIDs(tt.src, "", tt.local[0])
+				tt.src.On("SendSnapshot",
+					tt.local[2], mock.AnythingOfType("*io.PipeWriter"), mock.AnythingOfType("snapshot.SendOption"),
+				).Return(nil)
+				tt.src.ExpectSendOptions(snapshot.Reference(tt.local[0]))
+
+				tt.dst.On("ListSnapshots").Return(tt.remote, nil)
+				tt.dst.On("ResumeTokens", tt.targetFS).
+					Return(map[string]string{"zsm_test": "1-e604ea4bf-e0-789c63a2"}, nil)
+				tt.dst.On("AbortReceive", tt.targetFS, "zsm_test").Return(nil)
+				mockGUIDs(tt.dst, tt.targetFS, tt.local[0])
+				tt.dst.On("ReceiveSnapshot", tt.targetFS, tt.local[2], mock.AnythingOfType("*io.PipeReader")).
+					Return(nil)
+			},
+		},
+		{
+			name: "ignore resume tokens of unselected file systems",
+			local: []snapshot.Name{
+				{FileSystem: "zsm_test", Timestamp: now},
+				{FileSystem: "zsm_test/fs1", Timestamp: now},
+			},
+			opts: []snapshot.TransferOption{snapshot.TransferExcludeFileSystem("zsm_test/fs1")},
+			mock: func(t *testing.T, tt *testCase) {
+				tt.src.On("ListSnapshots").Return(tt.local, nil)
+				tt.src.On("SendSnapshot", tt.local[0], mock.AnythingOfType("*io.PipeWriter")).Return(nil)
+
+				tt.dst.On("ListSnapshots").Return(tt.remote, nil)
+				tt.dst.On("ResumeTokens", tt.targetFS).
+					Return(map[string]string{"zsm_test/fs1": "1-e604ea4bf-e0-789c63a2"}, nil)
+				tt.dst.On("ReceiveSnapshot", tt.targetFS, tt.local[0], mock.AnythingOfType("*io.PipeReader")).
+					Return(nil)
+			},
+		},
+		{
+			name:  "resume interrupted transfer fails",
+			local: []snapshot.Name{{FileSystem: "zsm_test", Timestamp: now}},
+			mock: func(t *testing.T, tt *testCase) {
+				token := "1-e604ea4bf-e0-789c63a2"
+
+				tt.src.On("ListSnapshots").Return(tt.local, nil)
+				tt.src.On("ResumeSend", token, mock.AnythingOfType("*io.PipeWriter")).
+					Return(errors.New("send failed"))
+
+				tt.dst.On("ListSnapshots").Return(tt.remote, nil)
+				tt.dst.On("ResumeTokens", tt.targetFS).Return(map[string]string{"zsm_test": token}, nil)
+				tt.dst.On("ResumeReceive", tt.targetFS, "zsm_test", mock.AnythingOfType("*io.PipeReader")).
+					Return(nil)
+			},
+			expectedErr: errors.New("transfer: resume zsm_test: send failed"),
+		},
+		{
+			name:  "get resume tokens fails",
+			local: []snapshot.Name{{FileSystem: "zsm_test", Timestamp: now}},
+			mock: func(t *testing.T, tt *testCase) {
+				tt.src.On("ListSnapshots").Return(tt.local, nil)
+
+				tt.dst.On("ListSnapshots").Return(tt.remote, nil)
+				tt.dst.On("ResumeTokens", tt.targetFS).Return(map[string]string(nil), errors.New("get failed"))
 			},
+			expectedErr: errors.New("transfer: dst resume tokens: get failed"),
 		},
 		{
 			name:  "incremental transfer send fails",
 			local: snapshot.FakeNames(t, snapshot.Name{FileSystem: "zsm_test", Timestamp: now}, snapshot.Hour, 3),
 			remote: snapshot.FakeNames(
-				t, snapshot.Name{FileSystem: "zsm_test", Timestamp: now.Add(-3 * time.Hour)}, snapshot.Hour, 1,
+				t, snapshot.Name{FileSystem: "target_fs/zsm_test", Timestamp: now.Add(-2 * time.Hour)},
+				snapshot.Hour, 1,
 			),
 			mock: func(t *testing.T, tt *testCase) {
 				err := errors.New("send failed")
@@ -172,11 +435,13 @@ func TestTransfer(t *testing.T) {
 				tt.src.On("SendSnapshot",
 					tt.local[2], mock.AnythingOfType("*io.PipeWriter"), mock.AnythingOfType("snapshot.SendOption"),
 				).Return(err)
-				tt.src.ExpectSendOptions(snapshot.Reference(tt.local[1]))
+				tt.src.ExpectSendOptions(snapshot.Reference(tt.local[0]))
+				mockGUIDs(tt.src, "", tt.local[0])
 
 				tt.dst.On("ListSnapshots").Return(tt.remote, nil)
 				tt.dst.On("ReceiveSnapshot", tt.targetFS, tt.local[2], mock.AnythingOfType("*io.PipeReader")).
 					Return(nil)
+				mockGUIDs(tt.dst, tt.targetFS, tt.local[0])
 			},
 			expectedErr: errors.New("transfer: send failed"),
 		},
@@ -184,19 +449,22 @@ func TestTransfer(t *testing.T) {
 			name:  "incremental transfer receive fails",
 			local: snapshot.FakeNames(t, snapshot.Name{FileSystem: "zsm_test", Timestamp: now}, snapshot.Hour, 3),
 			remote: snapshot.FakeNames(
-				t, snapshot.Name{FileSystem: "zsm_test", Timestamp: now.Add(-3 * time.Hour)}, snapshot.Hour, 1,
+				t, snapshot.Name{FileSystem: "target_fs/zsm_test", Timestamp: now.Add(-2 * time.Hour)},
+				snapshot.Hour, 1,
 			),
 			mock: func(t *testing.T, tt *testCase) {
 				tt.src.On("ListSnapshots").Return(tt.local, nil)
 				tt.src.On("SendSnapshot",
 					tt.local[2], mock.AnythingOfType("*io.PipeWriter"), mock.AnythingOfType("snapshot.SendOption"),
 				).Return(nil)
-				tt.src.ExpectSendOptions(snapshot.Reference(tt.local[1]))
+				tt.src.ExpectSendOptions(snapshot.Reference(tt.local[0]))
+				mockGUIDs(tt.src, "", tt.local[0])
 
 				err := errors.New("receive failed")
 				tt.dst.On("ListSnapshots").Return(tt.remote, nil)
 				tt.dst.On("ReceiveSnapshot", tt.targetFS, tt.local[2], mock.AnythingOfType("*io.PipeReader")).
 					Return(err)
+				mockGUIDs(tt.dst, tt.targetFS, tt.local[0])
 			},
 			expectedErr: errors.New("transfer: receive failed"),
 		},
@@ -212,8 +480,16 @@ func TestTransfer(t *testing.T) {
 			tt.targetFS = "target_fs"
 
 			tt.mock(t, &tt)
+			// Most test cases don't care about resuming interrupted
+			// transfers. Those that do register their own expectations
+			// in tt.mock, which take precedence.
+			tt.dst.On("ResumeTokens", tt.targetFS).Return(map[string]string(nil), nil).Maybe()
+			// The holds placed on src are covered by TestTransfer_Holds,
+			// the bookmarks by TestTransfer_Bookmarks.
+			allowHolds(tt.src)
+			allowBookmarks(tt.src)
 
-			err := snapshot.Transfer(tt.targetFS, tt.dst, tt.src)
+			err := snapshot.Transfer(tt.targetFS, tt.dst, tt.src, tt.opts...)
 			if tt.expectedErr != nil {
 				assert.EqualError(t, err, tt.expectedErr.Error())
 			} else {
@@ -226,3 +502,347 @@ func TestTransfer(t *testing.T) {
 		})
 	}
 }
+
+func TestTransfer_Holds(t *testing.T) {
+	now := time.Now().UTC()
+	local := snapshot.FakeNames(t, snapshot.Name{FileSystem: "zsm_test", Timestamp: now}, snapshot.Hour, 3)
+	remote := snapshot.FakeNames(t, snapshot.Name{FileSystem: "target_fs/zsm_test", Timestamp: now}, snapshot.Hour, 3)
+	pipeW := mock.AnythingOfType("*io.PipeWriter")
+	pipeR := mock.AnythingOfType("*io.PipeReader")
+	baseTag := snapshot.BaseHoldTag("backup", "target_fs")
+
+	tests := []struct {
+		name        string
+		remote      []snapshot.Name
+		mock        func(src, dst *snapshot.MockManager)
+		expectedErr error
+	}{
+		{
+			name: "initial transfer",
+			mock: func(src, dst *snapshot.MockManager) {
+				src.On("SnapshotHolds", local[2]).Return([]string(nil), nil).Twice()
+				src.On("SnapshotHolds", local[1]).Return([]string(nil), nil)
+				src.On("SnapshotHolds", local[0]).Return([]string(nil), nil)
+				src.On("HoldSnapshots", snapshot.SendHoldTag, []snapshot.Name{local[2]}).Return(nil).Once()
+				src.On("SendSnapshot", local[2], pipeW).Return(nil)
+				src.On("ReleaseSnapshots", snapshot.SendHoldTag, []snapshot.Name{local[2]}).Return(nil)
+				src.On("HoldSnapshots", baseTag, []snapshot.Name{local[2]}).Return(nil).Once()
+
+				dst.On("ReceiveSnapshot", "target_fs", local[2], pipeR).Return(nil)
+			},
+		},
+		{
+			// The destination was wiped after local[0] had been
+			// transferred to it.
+			name: "full transfer releases base hold",
+			mock: func(src, dst *snapshot.MockManager) {
+				src.On("SnapshotHolds", local[2]).Return([]string(nil), nil).Twice()
+				src.On("SnapshotHolds", local[1]).Return([]string(nil), nil)
+				src.On("SnapshotHolds", local[0]).Return([]string{baseTag}, nil)
+				src.On("HoldSnapshots", snapshot.SendHoldTag, []snapshot.Name{local[2]}).Return(nil).Once()
+				src.On("SendSnapshot", local[2], pipeW).Return(nil)
+				src.On("ReleaseSnapshots", snapshot.SendHoldTag, []snapshot.Name{local[2]}).Return(nil)
+				src.On("HoldSnapshots", baseTag, []snapshot.Name{local[2]}).Return(nil).Once()
+				src.On("ReleaseSnapshots", baseTag, []snapshot.Name{local[0]}).Return(nil)
+
+				dst.On("ReceiveSnapshot", "target_fs", local[2], pipeR).Return(nil)
+			},
+		},
+		{
+			name:   "incremental transfer moves base hold",
+			remote: remote[:2],
+			mock: func(src, dst *snapshot.MockManager) {
+				mockGUIDs(src, "", local[1])
+				src.On("SnapshotHolds", local[2]).Return([]string(nil), nil).Twice()
+				src.On("SnapshotHolds", local[1]).Return([]string{baseTag}, nil).Twice()
+				src.On("SnapshotHolds", local[0]).Return([]string(nil), nil)
+				src.On("HoldSnapshots", snapshot.SendHoldTag, []snapshot.Name{local[2], local[1]}).Return(nil)
+				src.On("SendSnapshot", local[2], pipeW, mock.AnythingOfType("snapshot.SendOption")).Return(nil)
+				src.ExpectSendOptions(snapshot.Reference(local[1]))
+				src.On("ReleaseSnapshots", snapshot.SendHoldTag, []snapshot.Name{local[2], local[1]}).Return(nil)
+				src.On("HoldSnapshots", baseTag, []snapshot.Name{local[2]}).Return(nil)
+				src.On("ReleaseSnapshots", baseTag, []snapshot.Name{local[1]}).Return(nil)
+
+				mockGUIDs(dst, "target_fs", local[1])
+				dst.On("ReceiveSnapshot", "target_fs", local[2], pipeR).Return(nil)
+			},
+		},
+		{
+			name:   "up-to-date destination adds missing base hold",
+			remote: remote,
+			mock: func(src, dst *snapshot.MockManager) {
+				mockGUIDs(src, "", local[2])
+				src.On("SnapshotHolds", local[2]).Return([]string{snapshot.SendHoldTag}, nil)
+				src.On("SnapshotHolds", local[1]).Return([]string(nil), nil)
+				src.On("SnapshotHolds", local[0]).Return([]string{baseTag}, nil)
+				src.On("HoldSnapshots", baseTag, []snapshot.Name{local[2]}).Return(nil)
+				src.On("ReleaseSnapshots", baseTag, []snapshot.Name{local[0]}).Return(nil)
+
+				mockGUIDs(dst, "target_fs", local[2])
+			},
+		},
+		{
+			name:   "up-to-date destination with base hold",
+			remote: remote,
+			mock: func(src, dst *snapshot.MockManager) {
+				mockGUIDs(src, "", local[2])
+				src.On("SnapshotHolds", local[2]).Return([]string{baseTag}, nil)
+
+				mockGUIDs(dst, "target_fs", local[2])
+			},
+		},
+		{
+			name:   "send hold left behind is released",
+			remote: remote[:2],
+			mock: func(src, dst *snapshot.MockManager) {
+				mockGUIDs(src, "", local[1])
+				// local[1] still has the hold of an earlier transfer which
+				// did not finish.
+				src.On("SnapshotHolds", local[2]).Return([]string(nil), nil).Twice()
+				src.On("SnapshotHolds", local[1]).Return([]string{snapshot.SendHoldTag, baseTag}, nil).Twice()
+				src.On("SnapshotHolds", local[0]).Return([]string(nil), nil)
+				src.On("HoldSnapshots", snapshot.SendHoldTag, []snapshot.Name{local[2]}).Return(nil)
+				src.On("SendSnapshot", local[2], pipeW, mock.AnythingOfType("snapshot.SendOption")).Return(nil)
+				src.ExpectSendOptions(snapshot.Reference(local[1]))
+				src.On("ReleaseSnapshots", snapshot.SendHoldTag, []snapshot.Name{local[2], local[1]}).Return(nil)
+				src.On("HoldSnapshots", baseTag, []snapshot.Name{local[2]}).Return(nil)
+				src.On("ReleaseSnapshots", baseTag, []snapshot.Name{local[1]}).Return(nil)
+
+				mockGUIDs(dst, "target_fs", local[1])
+				dst.On("ReceiveSnapshot", "target_fs", local[2], pipeR).Return(nil)
+			},
+		},
+		{
+			name:   "failed send releases send hold",
+			remote: remote[:2],
+			mock: func(src, dst *snapshot.MockManager) {
+				mockGUIDs(src, "", local[1])
+				// local[1] still has the hold of an earlier transfer which
+				// did not finish.
+				src.On("SnapshotHolds", local[2]).Return([]string(nil), nil)
+				src.On("SnapshotHolds", local[1]).Return([]string{snapshot.SendHoldTag, baseTag}, nil)
+				src.On("HoldSnapshots", snapshot.SendHoldTag, []snapshot.Name{local[2]}).Return(nil)
+				src.On("SendSnapshot", local[2], pipeW, mock.AnythingOfType("snapshot.SendOption")).
+					Return(errors.New("send failed"))
+				src.ExpectSendOptions(snapshot.Reference(local[1]))
+				src.On("ReleaseSnapshots", snapshot.SendHoldTag, []snapshot.Name{local[2], local[1]}).Return(nil)
+
+				mockGUIDs(dst, "target_fs", local[1])
+				dst.On("ReceiveSnapshot", "target_fs", local[2], pipeR).Return(nil)
+			},
+			expectedErr: errors.New("transfer: send failed"),
+		},
+		{
+			name: "hold fails",
+			mock: func(src, dst *snapshot.MockManager) {
+				src.On("SnapshotHolds", local[2]).Return([]string(nil), nil)
+				src.On("HoldSnapshots", snapshot.SendHoldTag, []snapshot.Name{local[2]}).
+					Return(errors.New("hold failed"))
+			},
+			expectedErr: errors.New("transfer: hold zsm_test: hold failed"),
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			src := &snapshot.MockManager{}
+			src.Test(t)
+			src.On("ListSnapshots").Return(local, nil)
+			dst := &snapshot.MockManager{}
+			dst.Test(t)
+			dst.On("ListSnapshots").Return(tt.remote, nil)
+			dst.On("ResumeTokens", "target_fs").Return(map[string]string(nil), nil)
+			tt.mock(src, dst)
+			allowBookmarks(src)
+
+			err := snapshot.Transfer("target_fs", dst, src, snapshot.TransferDestination("backup"))
+			if tt.expectedErr != nil {
+				assert.EqualError(t, err, tt.expectedErr.Error())
+			} else {
+				assert.NoError(t, err)
+			}
+			src.AssertExpectations(t)
+			src.AssertSendOptions(t)
+			dst.AssertExpectations(t)
+		})
+	}
+}
+
+func TestBaseHoldTag(t *testing.T) {
+	assert.Equal(t, "zsm-base:target_fs", snapshot.BaseHoldTag("", "target_fs"))
+	assert.Equal(t, "zsm-base:zsm@backup.example.com:target_fs",
+		snapshot.BaseHoldTag("zsm@backup.example.com", "target_fs"))
+}
+
+func TestTransfer_Bookmarks(t *testing.T) {
+	now := time.Now().UTC()
+	local := snapshot.FakeNames(t, snapshot.Name{FileSystem: "zsm_test", Timestamp: now}, snapshot.Hour, 3)
+	remote := snapshot.FakeNames(t, snapshot.Name{FileSystem: "target_fs/zsm_test", Timestamp: now}, snapshot.Hour, 3)
+	pipeW := mock.AnythingOfType("*io.PipeWriter")
+	pipeR := mock.AnythingOfType("*io.PipeReader")
+
+	tests := []struct {
+		name        string
+		local       []snapshot.Name
+		remote      []snapshot.Name
+		mock        func(src, dst *snapshot.MockManager)
+		assert      func(t *testing.T, src *snapshot.MockManager)
+		expectedErr error
+	}{
+		{
+			name:  "bookmark sent snapshot",
+			local: local,
+			mock: func(src, dst *snapshot.MockManager) {
+				src.On("ListBookmarks").Return([]snapshot.Name(nil), nil)
+				src.On("SendSnapshot", local[2], pipeW).Return(nil)
+				src.On("BookmarkSnapshot", local[2]).Return(nil)
+
+				dst.On("ReceiveSnapshot", "target_fs", local[2], pipeR).Return(nil)
+			},
+		},
+		{
+			name:   "bookmark exists",
+			local:  local,
+			remote: remote,
+			mock: func(src, dst *snapshot.MockManager) {
+				src.On("ListBookmarks").Return([]snapshot.Name{local[1], local[2]}, nil)
+				mockGUIDs(src, "", local[2])
+
+				mockGUIDs(dst, "target_fs", local[2])
+			},
+			assert: func(t *testing.T, src *snapshot.MockManager) {
+				src.AssertNotCalled(t, "BookmarkSnapshot", mock.Anything)
+			},
+		},
+		{
+			name:   "up-to-date destination adds missing bookmark",
+			local:  local,
+			remote: remote,
+			mock: func(src, dst *snapshot.MockManager) {
+				src.On("ListBookmarks").Return([]snapshot.Name{local[1]}, nil)
+				mockGUIDs(src, "", local[2])
+				src.On("BookmarkSnapshot", local[2]).Return(nil)
+
+				mockGUIDs(dst, "target_fs", local[2])
+			},
+		},
+		{
+			name:   "fall back to newest common bookmark",
+			local:  local[2:],
+			remote: remote[:2],
+			mock: func(src, dst *snapshot.MockManager) {
+				src.On("ListBookmarks").Return([]snapshot.Name{local[0], local[1]}, nil)
+				src.On("BookmarkGUID", local[1]).Return(uint64(local[1].Timestamp.UnixNano()), nil)
+				src.On("SendSnapshot", local[2], pipeW, mock.AnythingOfType("snapshot.SendOption")).Return(nil)
+				src.ExpectSendOptions(snapshot.BookmarkReference(local[1]))
+				src.On("BookmarkSnapshot", local[2]).Return(nil)
+
+				mockGUIDs(dst, "target_fs", local[1])
+				dst.On("ReceiveSnapshot", "target_fs", local[2], pipeR).Return(nil)
+			},
+			assert: func(t *testing.T, src *snapshot.MockManager) {
+				// Bookmarks can't be held.
+				src.AssertCalled(t, "HoldSnapshots", snapshot.SendHoldTag, []snapshot.Name{local[2]})
+			},
+		},
+		{
+			name:   "skip bookmarks with different guid",
+			local:  local[2:],
+			remote: remote[:2],
+			mock: func(src, dst *snapshot.MockManager) {
+				src.On("ListBookmarks").Return([]snapshot.Name{local[1], local[0]}, nil)
+				src.On("BookmarkGUID", local[1]).Return(uint64(1), nil)
+				src.On("BookmarkGUID", local[0]).Return(uint64(local[0].Timestamp.UnixNano()), nil)
+				src.On("SendSnapshot", local[2], pipeW, mock.AnythingOfType("snapshot.SendOption")).Return(nil)
+				src.ExpectSendOptions(snapshot.BookmarkReference(local[0]))
+				src.On("BookmarkSnapshot", local[2]).Return(nil)
+
+				mockGUIDs(dst, "target_fs", local[0], local[1])
+				dst.On("ReceiveSnapshot", "target_fs", local[2], pipeR).Return(nil)
+			},
+		},
+		{
+			name:   "no common snapshot or bookmark",
+			local:  local[2:],
+			remote: remote[:2],
+			mock: func(src, dst *snapshot.MockManager) {
+				src.On("ListBookmarks").Return([]snapshot.Name{local[2]}, nil)
+			},
+			expectedErr: errors.New("transfer: zsm_test: no common snapshot with target_fs"),
+		},
+		{
+			name:  "list bookmarks fails",
+			local: local,
+			mock: func(src, dst *snapshot.MockManager) {
+				src.On("ListBookmarks").Return([]snapshot.Name(nil), errors.New("list failed"))
+			},
+			expectedErr: errors.New("transfer: list src bookmarks: list failed"),
+		},
+		{
+			name:  "bookmark fails",
+			local: local,
+			mock: func(src, dst *snapshot.MockManager) {
+				src.On("ListBookmarks").Return([]snapshot.Name(nil), nil)
+				src.On("SendSnapshot", local[2], pipeW).Return(nil)
+				src.On("BookmarkSnapshot", local[2]).Return(errors.New("bookmark failed"))
+
+				dst.On("ReceiveSnapshot", "target_fs", local[2], pipeR).Return(nil)
+			},
+			expectedErr: errors.New("transfer: bookmark zsm_test: bookmark failed"),
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			src := &snapshot.MockManager{}
+			src.Test(t)
+			src.On("ListSnapshots").Return(tt.local, nil)
+			dst := &snapshot.MockManager{}
+			dst.Test(t)
+			dst.On("ListSnapshots").Return(tt.remote, nil)
+			dst.On("ResumeTokens", "target_fs").Return(map[string]string(nil), nil).Maybe()
+			tt.mock(src, dst)
+			allowHolds(src)
+
+			err := snapshot.Transfer("target_fs", dst, src)
+			if tt.expectedErr != nil {
+				assert.EqualError(t, err, tt.expectedErr.Error())
+			} else {
+				assert.NoError(t, err)
+			}
+			src.AssertExpectations(t)
+			src.AssertSendOptions(t)
+			dst.AssertExpectations(t)
+			if tt.assert != nil {
+				tt.assert(t, src)
+			}
+		})
+	}
+}
+
+// allowBookmarks registers optional calls to the methods of Bookmarker
+// called for every transfer.
+func allowBookmarks(m *snapshot.MockManager) {
+	m.On("ListBookmarks").Return([]snapshot.Name(nil), nil).Maybe()
+	m.On("BookmarkSnapshot", mock.Anything).Return(nil).Maybe()
+}
+
+// allowHolds registers optional calls to all methods of Holder.
+func allowHolds(m *snapshot.MockManager) {
+	m.On("SnapshotHolds", mock.Anything).Return([]string(nil), nil).Maybe()
+	m.On("HoldSnapshots", mock.Anything, mock.Anything).Return(nil).Maybe()
+	m.On("ReleaseSnapshots", mock.Anything, mock.Anything).Return(nil).Maybe()
+}
+
+// mockGUIDs registers calls to SnapshotGUID for all names below parentFS. The
+// returned guid is derived from the timestamp of each name. It is thus the
+// same for a name on src and dst.
+func mockGUIDs(m *snapshot.MockManager, parentFS string, names ...snapshot.Name) {
+	for _, n := range names {
+		guid := uint64(n.Timestamp.UnixNano())
+		if parentFS != "" {
+			n = n.Below(parentFS)
+		}
+		m.On("SnapshotGUID", n).Return(guid, nil)
+	}
+}
@@ -7,6 +7,8 @@ import (
 	"io"
 	"os"
 	"os/exec"
+	"sort"
+	"strconv"
 	"strings"
 )
 
@@ -19,8 +21,51 @@ const (
 
 	// Snapshot causes List to list ZFS snapshots only.
 	Snapshot ListType = "snapshot"
+
+	// Volume causes List to list ZFS volumes only.
+	Volume ListType = "volume"
+
+	// Bookmark causes List to list ZFS bookmarks only.
+	Bookmark ListType = "bookmark"
 )
 
+// Types combines the passed types into a single ListType. Passing the
+// combined type to List lists the objects of all the passed types.
+func Types(typs ...ListType) ListType {
+	ss := make([]string, len(typs))
+	for i, typ := range typs {
+		ss[i] = string(typ)
+	}
+	return ListType(strings.Join(ss, ","))
+}
+
+// Split splits a ListType created by Types into its parts.
+func (t ListType) Split() []ListType {
+	ss := strings.Split(string(t), ",")
+	typs := make([]ListType, len(ss))
+	for i, s := range ss {
+		typs[i] = ListType(s)
+	}
+	return typs
+}
+
+// ParseDatasetTypes parses the passed names of dataset types and combines
+// them using Types. Only FileSystem and Volume are accepted.
+func ParseDatasetTypes(names ...string) (ListType, error) {
+	if len(names) == 0 {
+		return "", errors.New("no dataset types")
+	}
+	typs := make([]ListType, len(names))
+	for i, name := range names {
+		typ := ListType(name)
+		if typ != FileSystem && typ != Volume {
+			return "", fmt.Errorf("invalid dataset type: %s", name)
+		}
+		typs[i] = typ
+	}
+	return Types(typs...), nil
+}
+
 // Adapter wraps the CmdFunc for a zfs executable.
 type Adapter CmdFunc
 
@@ -55,6 +100,10 @@ func New(zfsCmdPath string) (Adapter, error) {
 //     It is up to the caller to re-construct this hierarchical structure if
 //     required.
 //
+// Volume
+//     List returns the names of all volumes in path notation. Volumes are
+//     always leaves of the hierarchy.
+//
 // List returns an error if calling the zfs CmdFunc fails or the output could
 // not be parsed.
 func (z Adapter) List(typ ListType) ([]string, error) {
@@ -80,14 +129,152 @@ func (z Adapter) List(typ ListType) ([]string, error) {
 	return names, nil
 }
 
-// CreateSnapshot creates a snapshot with the name.
+// GUID returns the value of the guid property of the zfs object with name.
+//
+// The guid uniquely identifies a snapshot across pools. Two snapshots on
+// different pools with the same guid contain the same data.
+func (z Adapter) GUID(name string) (uint64, error) {
+	value, err := z.get("guid", name)
+	if err != nil {
+		return 0, err
+	}
+	guid, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("zfs get: invalid guid: %s", value)
+	}
+	return guid, nil
+}
+
+// Written returns the number of bytes written to the dataset of snapshot
+// since snapshot was created. It reads the written@snapname property of the
+// dataset.
+//
+// Unlike the written property it does not depend on the most recent snapshot
+// of the dataset. Snapshots created after snapshot do not change its value.
+func (z Adapter) Written(snapshot string) (uint64, error) {
+	idx := strings.Index(snapshot, "@")
+	if idx < 0 {
+		return 0, fmt.Errorf("zfs get: not a snapshot: %s", snapshot)
+	}
+	value, err := z.get("written"+snapshot[idx:], snapshot[:idx])
+	if err != nil {
+		return 0, err
+	}
+	written, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("zfs get: invalid written: %s", value)
+	}
+	return written, nil
+}
+
+// ResumeTokens returns the receive_resume_token of the file system with name
+// and all its descendants.
+//
+// The returned map contains an entry for each file system with an
+// interrupted resumable receive. The entry maps the name of the file system
+// to its token. File systems without a token are not contained in the map.
+func (z Adapter) ResumeTokens(name string) (map[string]string, error) {
+	var stdout bytes.Buffer
+
+	args := []string{"get", "-H", "-r", "-t", "filesystem,volume", "-o", "name,value", "receive_resume_token", name}
+	if err := z.runCMD(args, nil, &stdout); err != nil {
+		return nil, err
+	}
+	tokens := make(map[string]string)
+	for _, line := range strings.Split(stdout.String(), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		fields := strings.Split(line, "\t")
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("zfs get: invalid line: %s", line)
+		}
+		if fields[1] == "-" || fields[1] == "" {
+			continue
+		}
+		tokens[fields[0]] = fields[1]
+	}
+	return tokens, nil
+}
+
+// Properties returns the values of the properties props for all zfs objects
+// of typ.
+//
+// The returned map maps the name of each object to its properties. Each
+// property is mapped to its value. Properties without a value, i.e.
+// properties for which zfs reports "-", are omitted. User properties are
+// inherited along the dataset tree. Properties inherited from an ancestor
+// are thus reported for the object as well.
+func (z Adapter) Properties(typ ListType, props ...string) (map[string]map[string]string, error) {
+	var stdout bytes.Buffer
+
+	if len(props) == 0 {
+		return nil, errors.New("zfs get: no properties")
+	}
+	args := []string{"get", "-H", "-p", "-t", string(typ), "-o", "name,property,value", strings.Join(props, ",")}
+	if err := z.runCMD(args, nil, &stdout); err != nil {
+		return nil, err
+	}
+	values := make(map[string]map[string]string)
+	for _, line := range strings.Split(stdout.String(), "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		fields := strings.SplitN(line, "\t", 3)
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("zfs get: invalid line: %s", line)
+		}
+		if fields[2] == "-" {
+			continue
+		}
+		if values[fields[0]] == nil {
+			values[fields[0]] = make(map[string]string)
+		}
+		values[fields[0]][fields[1]] = fields[2]
+	}
+	return values, nil
+}
+
+func (z Adapter) get(property, name string) (string, error) {
+	var stdout bytes.Buffer
+
+	if err := z.runCMD([]string{"get", "-H", "-p", "-o", "value", property, name}, nil, &stdout); err != nil {
+		return "", err
+	}
+	value := strings.TrimSpace(stdout.String())
+	if value == "" {
+		return "", fmt.Errorf("zfs get: %w", ErrNoOutput)
+	}
+	return value, nil
+}
+
+// CreateSnapshots creates snapshots with the passed names using a single
+// call to zfs snapshot.
 //
-// As described in the zfs(8) man page name must be of the format
+// As described in the zfs(8) man page each name must be of the format
 // filesystem@snapname or volume@snapname. filesystem must be an existing zfs
 // filesystem, volume an existing zfs volume. snapname will be the name of the
 // snapshot.
-func (z Adapter) CreateSnapshot(name string) error {
-	return z.runCMD([]string{"snapshot", name}, nil, nil)
+//
+// ZFS creates all snapshots atomically. Either all snapshots are created or
+// none at all. The properties props are set on all created snapshots. props
+// may be nil.
+func (z Adapter) CreateSnapshots(props map[string]string, names ...string) error {
+	if len(names) == 0 {
+		return errors.New("zfs snapshot: no snapshots")
+	}
+	keys := make([]string, 0, len(props))
+	for k := range props {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	args := []string{"snapshot"}
+	for _, k := range keys {
+		args = append(args, "-o", k+"="+props[k])
+	}
+	return z.runCMD(append(args, names...), nil, nil)
 }
 
 // Destroy removes the zfs object with name.
@@ -98,9 +285,108 @@ func (z Adapter) Destroy(name string) error {
 	return z.runCMD([]string{"destroy", name}, nil, nil)
 }
 
+// DestroySnapshots destroys the snapshots with the passed names using a
+// single call to zfs destroy. All snapshots must belong to the same dataset.
+//
+// The snapshots are passed to zfs destroy using the comma-separated syntax,
+// e.g. fs@a,b,c. zfs destroys them within a single transaction group. If any
+// of the snapshots can't be destroyed, e.g. because it is held, none of them
+// may be destroyed.
+func (z Adapter) DestroySnapshots(names ...string) error {
+	if len(names) == 0 {
+		return errors.New("zfs destroy: no snapshots")
+	}
+	var dataset string
+	snapNames := make([]string, len(names))
+	for i, name := range names {
+		idx := strings.Index(name, "@")
+		if idx < 0 {
+			return fmt.Errorf("zfs destroy: not a snapshot: %s", name)
+		}
+		if i == 0 {
+			dataset = name[:idx]
+		}
+		if name[:idx] != dataset {
+			return fmt.Errorf("zfs destroy: snapshots of different datasets: %s, %s", dataset, name[:idx])
+		}
+		snapNames[i] = name[idx+1:]
+	}
+	return z.runCMD([]string{"destroy", dataset + "@" + strings.Join(snapNames, ",")}, nil, nil)
+}
+
+// Bookmark creates a bookmark with the name bookmark of the snapshot with
+// name snapshot.
+//
+// A bookmark remembers the guid and the creation of the snapshot but not its
+// data. It can be used as the reference of an incremental Send even after
+// the snapshot has been destroyed.
+func (z Adapter) Bookmark(snapshot, bookmark string) error {
+	return z.runCMD([]string{"bookmark", snapshot, bookmark}, nil, nil)
+}
+
+// Hold places a user hold with tag on each of the snapshots with the passed
+// names using a single call to zfs hold.
+//
+// A snapshot with at least one hold can't be destroyed. zfs hold fails if a
+// snapshot already has a hold with tag.
+func (z Adapter) Hold(tag string, names ...string) error {
+	if len(names) == 0 {
+		return errors.New("zfs hold: no snapshots")
+	}
+	return z.runCMD(append([]string{"hold", tag}, names...), nil, nil)
+}
+
+// Release removes the user hold with tag from each of the snapshots with the
+// passed names using a single call to zfs release.
+func (z Adapter) Release(tag string, names ...string) error {
+	if len(names) == 0 {
+		return errors.New("zfs release: no snapshots")
+	}
+	return z.runCMD(append([]string{"release", tag}, names...), nil, nil)
+}
+
+// Holds returns the sorted tags of all user holds on the snapshot with name.
+func (z Adapter) Holds(name string) ([]string, error) {
+	var stdout bytes.Buffer
+
+	if err := z.runCMD([]string{"holds", "-H", name}, nil, &stdout); err != nil {
+		return nil, err
+	}
+	var tags []string
+	for _, line := range strings.Split(stdout.String(), "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		fields := strings.Split(line, "\t")
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("zfs holds: invalid line: %s", line)
+		}
+		tags = append(tags, fields[1])
+	}
+	sort.Strings(tags)
+	return tags, nil
+}
+
 // Receive receives a named zfs object from r.
-func (z Adapter) Receive(name string, r io.Reader) error {
-	return z.runCMD([]string{"receive", name}, r, nil)
+//
+// If resumable is true, zfs keeps the partially received state if the
+// receive is interrupted. The receive can then be resumed by sending the
+// data returned by SendResume for the receive_resume_token of name's file
+// system.
+func (z Adapter) Receive(name string, resumable bool, r io.Reader) error {
+	args := []string{"receive"}
+	if resumable {
+		args = append(args, "-s")
+	}
+	args = append(args, name)
+	return z.runCMD(args, r, nil)
+}
+
+// AbortReceive discards the partially received state of the file system with
+// name using zfs receive -A. Afterwards the file system accepts streams which
+// do not resume the interrupted receive again.
+func (z Adapter) AbortReceive(name string) error {
+	return z.runCMD([]string{"receive", "-A", name}, nil, nil)
 }
 
 // Send writes the snapshot name to w.
@@ -108,15 +394,30 @@ func (z Adapter) Receive(name string, r io.Reader) error {
 // This also writes all snapshots that have been created before name. If
 // ref is not empty only snapshots between ref and name are written
 // to w.
+//
+// ref may be the name of a bookmark. zfs send does not support sending the
+// snapshots between a bookmark and name. Only the changes between the
+// bookmarked snapshot and name are written to w in this case.
 func (z Adapter) Send(name, ref string, w io.Writer) error {
 	args := []string{"send"}
-	if ref != "" {
+	switch {
+	case strings.Contains(ref, "#"):
+		args = append(args, "-i", ref)
+	case ref != "":
 		args = append(args, "-I", ref)
 	}
 	args = append(args, name)
 	return z.runCMD(args, nil, w)
 }
 
+// SendResume writes the remaining data of an interrupted send to w.
+//
+// token is the receive_resume_token of the file system on the receiving
+// side.
+func (z Adapter) SendResume(token string, w io.Writer) error {
+	return z.runCMD([]string{"send", "-t", token}, nil, w)
+}
+
 func (z Adapter) runCMD(args []string, stdin io.Reader, stdout io.Writer) error {
 	var stderr bytes.Buffer
 
@@ -98,6 +98,21 @@ func TestAdapter_List(t *testing.T) {
 				return bs
 			},
 		},
+		{
+			Name: "list file systems and volumes",
+			Call: func(t *testing.T, a zfs.Adapter) error {
+				datasets, err := a.List(zfs.Types(zfs.FileSystem, zfs.Volume))
+				if err != nil {
+					return err
+				}
+				assert.Equal(t, []string{"zsm_test", "zsm_test/vm_disk_1"}, datasets)
+				return nil
+			},
+			ZFSArgs: []string{"list", "-H", "-t", "filesystem,volume", "-o", "name"},
+			Stdout: func(t *testing.T) []byte {
+				return []byte("zsm_test\nzsm_test/vm_disk_1\n")
+			},
+		},
 		{
 			Name: "list returns no output",
 			Call: func(t *testing.T, a zfs.Adapter) error {
@@ -126,24 +141,226 @@ func TestAdapter_List(t *testing.T) {
 	zfs.RunTests(t, tests, true)
 }
 
-func TestAdapter_CreateSnapshot(t *testing.T) {
+func TestParseDatasetTypes(t *testing.T) {
+	typ, err := zfs.ParseDatasetTypes("filesystem", "volume")
+	assert.NoError(t, err)
+	assert.Equal(t, zfs.Types(zfs.FileSystem, zfs.Volume), typ)
+	assert.Equal(t, []zfs.ListType{zfs.FileSystem, zfs.Volume}, typ.Split())
+
+	_, err = zfs.ParseDatasetTypes("filesystem", "snapshot")
+	assert.EqualError(t, err, "invalid dataset type: snapshot")
+
+	_, err = zfs.ParseDatasetTypes()
+	assert.EqualError(t, err, "no dataset types")
+}
+
+func TestAdapter_GUID(t *testing.T) {
+	tests := []zfs.TestCase{
+		{
+			Name: "get guid",
+			Call: func(t *testing.T, a zfs.Adapter) error {
+				guid, err := a.GUID("zsm_test@2020-04-10T09:45:58.564585005Z")
+				if err != nil {
+					return err
+				}
+				assert.Equal(t, uint64(6917529027641081856), guid)
+				return nil
+			},
+			ZFSArgs: []string{"get", "-H", "-p", "-o", "value", "guid", "zsm_test@2020-04-10T09:45:58.564585005Z"},
+			Stdout: func(t *testing.T) []byte {
+				return []byte("6917529027641081856\n")
+			},
+		},
+		{
+			Name: "get returns no output",
+			Call: func(t *testing.T, a zfs.Adapter) error {
+				_, err := a.GUID("zsm_test@2020-04-10T09:45:58.564585005Z")
+				if !errors.Is(err, zfs.ErrNoOutput) {
+					return err
+				}
+				return nil
+			},
+			ZFSArgs: []string{"get", "-H", "-p", "-o", "value", "guid", "zsm_test@2020-04-10T09:45:58.564585005Z"},
+		},
+		{
+			Name: "get fails",
+			Call: func(t *testing.T, a zfs.Adapter) error {
+				_, err := a.GUID("zsm_test@2020-04-10T09:45:58.564585005Z")
+				return err
+			},
+			ZFSArgs:     []string{"get", "-H", "-p", "-o", "value", "guid", "zsm_test@2020-04-10T09:45:58.564585005Z"},
+			ZFSExitCode: 1,
+			Stderr: func(t *testing.T) []byte {
+				return []byte("cannot open 'zsm_test@2020-04-10T09:45:58.564585005Z': dataset does not exist")
+			},
+		},
+	}
+	zfs.RunTests(t, tests, true)
+}
+
+func TestAdapter_Written(t *testing.T) {
+	tests := []zfs.TestCase{
+		{
+			Name: "get written since snapshot",
+			Call: func(t *testing.T, a zfs.Adapter) error {
+				written, err := a.Written("zsm_test@2020-04-10T09:45:58.564585005Z")
+				if err != nil {
+					return err
+				}
+				assert.Equal(t, uint64(4096), written)
+				return nil
+			},
+			ZFSArgs: []string{"get", "-H", "-p", "-o", "value", "written@2020-04-10T09:45:58.564585005Z", "zsm_test"},
+			Stdout: func(t *testing.T) []byte {
+				return []byte("4096\n")
+			},
+		},
+		{
+			Name: "not a snapshot",
+			Call: func(t *testing.T, a zfs.Adapter) error {
+				_, err := a.Written("zsm_test")
+				assert.EqualError(t, err, "zfs get: not a snapshot: zsm_test")
+				return nil
+			},
+		},
+		{
+			Name: "get fails",
+			Call: func(t *testing.T, a zfs.Adapter) error {
+				_, err := a.Written("zsm_test@2020-04-10T09:45:58.564585005Z")
+				return err
+			},
+			ZFSArgs: []string{
+				"get", "-H", "-p", "-o", "value", "written@2020-04-10T09:45:58.564585005Z", "zsm_test",
+			},
+			ZFSExitCode: 1,
+			Stderr: func(t *testing.T) []byte {
+				return []byte("cannot open 'zsm_test@2020-04-10T09:45:58.564585005Z': dataset does not exist")
+			},
+		},
+	}
+
+	zfs.RunTests(t, tests, true)
+}
+
+func TestAdapter_ResumeTokens(t *testing.T) {
+	zfsArgs := []string{
+		"get", "-H", "-r", "-t", "filesystem,volume", "-o", "name,value", "receive_resume_token", "target_fs",
+	}
 	tests := []zfs.TestCase{
 		{
-			Name: "create snapshot",
+			Name: "get resume tokens",
+			Call: func(t *testing.T, a zfs.Adapter) error {
+				tokens, err := a.ResumeTokens("target_fs")
+				if err != nil {
+					return err
+				}
+				expected := map[string]string{"target_fs/zsm_test/fs_1": "1-e604ea4bf-e0-789c63a2"}
+				assert.Equal(t, expected, tokens)
+				return nil
+			},
+			ZFSArgs: zfsArgs,
+			Stdout: func(t *testing.T) []byte {
+				return []byte("target_fs\t-\n" +
+					"target_fs/zsm_test\t-\n" +
+					"target_fs/zsm_test/fs_1\t1-e604ea4bf-e0-789c63a2\n")
+			},
+		},
+		{
+			Name: "get fails",
 			Call: func(t *testing.T, a zfs.Adapter) error {
-				return a.CreateSnapshot("zsm_test/fs_1@snapshot_name")
+				_, err := a.ResumeTokens("target_fs")
+				return err
+			},
+			ZFSArgs:     zfsArgs,
+			ZFSExitCode: 1,
+			Stderr: func(t *testing.T) []byte {
+				return []byte("cannot open 'target_fs': dataset does not exist")
 			},
-			ZFSArgs: []string{"snapshot", "zsm_test/fs_1@snapshot_name"},
+		},
+	}
+	zfs.RunTests(t, tests, true)
+}
+
+func TestAdapter_Properties(t *testing.T) {
+	zfsArgs := []string{
+		"get", "-H", "-p", "-t", "filesystem", "-o", "name,property,value",
+		"com.github.fhofherr.zsm:snapshot,com.github.fhofherr.zsm:keep-hour",
+	}
+	tests := []zfs.TestCase{
+		{
+			Name: "get properties",
+			Call: func(t *testing.T, a zfs.Adapter) error {
+				props, err := a.Properties(
+					zfs.FileSystem, "com.github.fhofherr.zsm:snapshot", "com.github.fhofherr.zsm:keep-hour",
+				)
+				if err != nil {
+					return err
+				}
+				expected := map[string]map[string]string{
+					"zsm_test/fs_1": {"com.github.fhofherr.zsm:keep-hour": "48"},
+					"zsm_test/fs_2": {
+						"com.github.fhofherr.zsm:snapshot":  "false",
+						"com.github.fhofherr.zsm:keep-hour": "48",
+					},
+				}
+				assert.Equal(t, expected, props)
+				return nil
+			},
+			ZFSArgs: zfsArgs,
+			Stdout: func(t *testing.T) []byte {
+				return []byte("zsm_test\tcom.github.fhofherr.zsm:snapshot\t-\n" +
+					"zsm_test\tcom.github.fhofherr.zsm:keep-hour\t-\n" +
+					"zsm_test/fs_1\tcom.github.fhofherr.zsm:snapshot\t-\n" +
+					"zsm_test/fs_1\tcom.github.fhofherr.zsm:keep-hour\t48\n" +
+					"zsm_test/fs_2\tcom.github.fhofherr.zsm:snapshot\tfalse\n" +
+					"zsm_test/fs_2\tcom.github.fhofherr.zsm:keep-hour\t48\n")
+			},
+		},
+		{
+			Name: "get fails",
+			Call: func(t *testing.T, a zfs.Adapter) error {
+				_, err := a.Properties(
+					zfs.FileSystem, "com.github.fhofherr.zsm:snapshot", "com.github.fhofherr.zsm:keep-hour",
+				)
+				return err
+			},
+			ZFSArgs:     zfsArgs,
+			ZFSExitCode: 1,
+			Stderr: func(t *testing.T) []byte {
+				return []byte("bad property list: invalid property")
+			},
+		},
+	}
+	zfs.RunTests(t, tests, true)
+}
+
+func TestAdapter_CreateSnapshots(t *testing.T) {
+	zfsArgs := []string{"snapshot", "zsm_test/fs_1@snapshot_name", "zsm_test/fs_2@snapshot_name"}
+	tests := []zfs.TestCase{
+		{
+			Name: "create snapshots",
+			Call: func(t *testing.T, a zfs.Adapter) error {
+				return a.CreateSnapshots(nil, "zsm_test/fs_1@snapshot_name", "zsm_test/fs_2@snapshot_name")
+			},
+			ZFSArgs: zfsArgs,
+		},
+		{
+			Name: "create snapshots with properties",
+			Call: func(t *testing.T, a zfs.Adapter) error {
+				props := map[string]string{"user:b": "2", "user:a": "1"}
+				return a.CreateSnapshots(props, "zsm_test/fs_1@snapshot_name")
+			},
+			ZFSArgs: []string{"snapshot", "-o", "user:a=1", "-o", "user:b=2", "zsm_test/fs_1@snapshot_name"},
 		},
 		{
 			Name: "snapshot fails with exit code",
 			Call: func(t *testing.T, a zfs.Adapter) error {
-				return a.CreateSnapshot("zsm_test/fs_1@snapshot_name")
+				return a.CreateSnapshots(nil, "zsm_test/fs_1@snapshot_name", "zsm_test/fs_2@snapshot_name")
 			},
-			ZFSArgs:     []string{"snapshot", "zsm_test/fs_1@snapshot_name"},
-			ZFSExitCode: 10,
+			ZFSArgs:     zfsArgs,
+			ZFSExitCode: 1,
 			Stderr: func(t *testing.T) []byte {
-				return []byte("zfs snapshot wrote this to stderr")
+				return []byte("cannot open 'zsm_test/fs_2': dataset does not exist")
 			},
 		},
 	}
@@ -174,23 +391,187 @@ func TestAdapter_Destroy(t *testing.T) {
 	zfs.RunTests(t, tests, true)
 }
 
+func TestAdapter_DestroySnapshots(t *testing.T) {
+	zfsArgs := []string{"destroy", "zsm_test@snap_1,snap_2,snap_3"}
+	tests := []zfs.TestCase{
+		{
+			Name: "destroy snapshots",
+			Call: func(t *testing.T, a zfs.Adapter) error {
+				return a.DestroySnapshots("zsm_test@snap_1", "zsm_test@snap_2", "zsm_test@snap_3")
+			},
+			ZFSArgs: zfsArgs,
+		},
+		{
+			Name: "destroy fails",
+			Call: func(t *testing.T, a zfs.Adapter) error {
+				return a.DestroySnapshots("zsm_test@snap_1", "zsm_test@snap_2", "zsm_test@snap_3")
+			},
+			ZFSArgs:     zfsArgs,
+			ZFSExitCode: 1,
+			Stderr: func(t *testing.T) []byte {
+				return []byte("cannot destroy snapshot zsm_test@snap_2: dataset is busy")
+			},
+		},
+	}
+	zfs.RunTests(t, tests, true)
+}
+
+func TestAdapter_DestroySnapshots_InvalidNames(t *testing.T) {
+	var a zfs.Adapter
+
+	assert.EqualError(t, a.DestroySnapshots(), "zfs destroy: no snapshots")
+	assert.EqualError(t, a.DestroySnapshots("zsm_test@snap_1", "zsm_test"), "zfs destroy: not a snapshot: zsm_test")
+	assert.EqualError(t, a.DestroySnapshots("zsm_test@snap_1", "zsm_test/fs_1@snap_1"),
+		"zfs destroy: snapshots of different datasets: zsm_test, zsm_test/fs_1")
+}
+
+func TestAdapter_Bookmark(t *testing.T) {
+	zfsArgs := []string{"bookmark", "zsm_test@snap_1", "zsm_test#snap_1"}
+	tests := []zfs.TestCase{
+		{
+			Name: "bookmark snapshot",
+			Call: func(t *testing.T, a zfs.Adapter) error {
+				return a.Bookmark("zsm_test@snap_1", "zsm_test#snap_1")
+			},
+			ZFSArgs: zfsArgs,
+		},
+		{
+			Name: "bookmark fails",
+			Call: func(t *testing.T, a zfs.Adapter) error {
+				return a.Bookmark("zsm_test@snap_1", "zsm_test#snap_1")
+			},
+			ZFSArgs:     zfsArgs,
+			ZFSExitCode: 1,
+			Stderr: func(t *testing.T) []byte {
+				return []byte("cannot create bookmark 'zsm_test#snap_1': bookmark exists")
+			},
+		},
+	}
+	zfs.RunTests(t, tests, true)
+}
+
+func TestAdapter_Hold(t *testing.T) {
+	zfsArgs := []string{"hold", "investigation", "zsm_test@snap_1", "zsm_test/fs_1@snap_1"}
+	tests := []zfs.TestCase{
+		{
+			Name: "hold snapshots",
+			Call: func(t *testing.T, a zfs.Adapter) error {
+				return a.Hold("investigation", "zsm_test@snap_1", "zsm_test/fs_1@snap_1")
+			},
+			ZFSArgs: zfsArgs,
+		},
+		{
+			Name: "hold fails",
+			Call: func(t *testing.T, a zfs.Adapter) error {
+				return a.Hold("investigation", "zsm_test@snap_1", "zsm_test/fs_1@snap_1")
+			},
+			ZFSArgs:     zfsArgs,
+			ZFSExitCode: 1,
+			Stderr: func(t *testing.T) []byte {
+				return []byte("cannot hold snapshot 'zsm_test@snap_1': tag already exists on this dataset")
+			},
+		},
+	}
+	zfs.RunTests(t, tests, true)
+}
+
+func TestAdapter_Release(t *testing.T) {
+	zfsArgs := []string{"release", "investigation", "zsm_test@snap_1"}
+	tests := []zfs.TestCase{
+		{
+			Name: "release snapshots",
+			Call: func(t *testing.T, a zfs.Adapter) error {
+				return a.Release("investigation", "zsm_test@snap_1")
+			},
+			ZFSArgs: zfsArgs,
+		},
+		{
+			Name: "release fails",
+			Call: func(t *testing.T, a zfs.Adapter) error {
+				return a.Release("investigation", "zsm_test@snap_1")
+			},
+			ZFSArgs:     zfsArgs,
+			ZFSExitCode: 1,
+			Stderr: func(t *testing.T) []byte {
+				return []byte("cannot release hold from snapshot 'zsm_test@snap_1': no such tag on this dataset")
+			},
+		},
+	}
+	zfs.RunTests(t, tests, true)
+}
+
+func TestAdapter_Holds(t *testing.T) {
+	zfsArgs := []string{"holds", "-H", "zsm_test@snap_1"}
+	tests := []zfs.TestCase{
+		{
+			Name: "list holds",
+			Call: func(t *testing.T, a zfs.Adapter) error {
+				tags, err := a.Holds("zsm_test@snap_1")
+				if err != nil {
+					return err
+				}
+				assert.Equal(t, []string{"investigation", "zsm"}, tags)
+				return nil
+			},
+			ZFSArgs: zfsArgs,
+			Stdout: func(t *testing.T) []byte {
+				return []byte("zsm_test@snap_1\tzsm\tFri Apr 10 09:45 2020\n" +
+					"zsm_test@snap_1\tinvestigation\tFri Apr 10 10:12 2020\n")
+			},
+		},
+		{
+			Name: "no holds",
+			Call: func(t *testing.T, a zfs.Adapter) error {
+				tags, err := a.Holds("zsm_test@snap_1")
+				assert.Empty(t, tags)
+				return err
+			},
+			ZFSArgs: zfsArgs,
+		},
+		{
+			Name: "holds fails",
+			Call: func(t *testing.T, a zfs.Adapter) error {
+				_, err := a.Holds("zsm_test@snap_1")
+				return err
+			},
+			ZFSArgs:     zfsArgs,
+			ZFSExitCode: 1,
+			Stderr: func(t *testing.T) []byte {
+				return []byte("cannot open 'zsm_test@snap_1': dataset does not exist")
+			},
+		},
+	}
+	zfs.RunTests(t, tests, true)
+}
+
 func TestAdapter_Receive(t *testing.T) {
 	tests := []zfs.TestCase{
 		{
 			Name: "pass stdin to zfs receive",
 			Call: func(t *testing.T, a zfs.Adapter) error {
 				stdin := bytes.NewBuffer([]byte("the caller sent this to zfs"))
-				return a.Receive("some-zfs-object", stdin)
+				return a.Receive("some-zfs-object", false, stdin)
 			},
 			ZFSArgs: []string{"receive", "some-zfs-object"},
 			Stdin: func(t *testing.T) []byte {
 				return []byte("the caller sent this to zfs")
 			},
 		},
+		{
+			Name: "resumable receive",
+			Call: func(t *testing.T, a zfs.Adapter) error {
+				stdin := bytes.NewBuffer([]byte("the caller sent this to zfs"))
+				return a.Receive("some-zfs-object", true, stdin)
+			},
+			ZFSArgs: []string{"receive", "-s", "some-zfs-object"},
+			Stdin: func(t *testing.T) []byte {
+				return []byte("the caller sent this to zfs")
+			},
+		},
 		{
 			Name: "zfs receive fails",
 			Call: func(t *testing.T, a zfs.Adapter) error {
-				return a.Receive("some-zfs-object", nil)
+				return a.Receive("some-zfs-object", false, nil)
 			},
 			ZFSArgs:     []string{"receive", "some-zfs-object"},
 			ZFSExitCode: 10,
@@ -202,6 +583,30 @@ func TestAdapter_Receive(t *testing.T) {
 	zfs.RunTests(t, tests, true)
 }
 
+func TestAdapter_AbortReceive(t *testing.T) {
+	tests := []zfs.TestCase{
+		{
+			Name: "abort receive",
+			Call: func(t *testing.T, a zfs.Adapter) error {
+				return a.AbortReceive("target_fs/zsm_test")
+			},
+			ZFSArgs: []string{"receive", "-A", "target_fs/zsm_test"},
+		},
+		{
+			Name: "zfs receive fails",
+			Call: func(t *testing.T, a zfs.Adapter) error {
+				return a.AbortReceive("target_fs/zsm_test")
+			},
+			ZFSArgs:     []string{"receive", "-A", "target_fs/zsm_test"},
+			ZFSExitCode: 1,
+			Stderr: func(t *testing.T) []byte {
+				return []byte("'target_fs/zsm_test' does not have any resumable receive state to abort")
+			},
+		},
+	}
+	zfs.RunTests(t, tests, true)
+}
+
 func TestAdapter_Send(t *testing.T) {
 	tests := []zfs.TestCase{
 		{
@@ -236,6 +641,43 @@ func TestAdapter_Send(t *testing.T) {
 				return []byte("snapshot data")
 			},
 		},
+		{
+			Name: "send with bookmark as reference",
+			Call: func(t *testing.T, a zfs.Adapter) error {
+				var w bytes.Buffer
+
+				if err := a.Send("fs_1@snapshot_name", "fs_1#reference_name", &w); err != nil {
+					return err
+				}
+				assert.Equal(t, "snapshot data", w.String())
+				return nil
+			},
+			ZFSArgs: []string{"send", "-i", "fs_1#reference_name", "fs_1@snapshot_name"},
+			Stdout: func(t *testing.T) []byte {
+				return []byte("snapshot data")
+			},
+		},
+	}
+	zfs.RunTests(t, tests, true)
+}
+
+func TestAdapter_SendResume(t *testing.T) {
+	tests := []zfs.TestCase{
+		{
+			Name: "send with resume token",
+			Call: func(t *testing.T, a zfs.Adapter) error {
+				var w bytes.Buffer
+				if err := a.SendResume("1-e604ea4bf-e0-789c63a2", &w); err != nil {
+					return err
+				}
+				assert.Equal(t, "remaining snapshot data", w.String())
+				return nil
+			},
+			ZFSArgs: []string{"send", "-t", "1-e604ea4bf-e0-789c63a2"},
+			Stdout: func(t *testing.T) []byte {
+				return []byte("remaining snapshot data")
+			},
+		},
 	}
 	zfs.RunTests(t, tests, true)
 }
@@ -0,0 +1,1088 @@
+// Package memzfs provides an in-memory simulation of the zfs executable.
+//
+// A ZFS keeps track of pools, file systems, volumes, snapshots, bookmarks,
+// holds, and properties.
+// It provides the same methods as zfs.Adapter and can therefore be used
+// wherever an adapter to the real zfs executable is expected. Since it does
+// not require the ZFS kernel module, it is well suited for tests and demos
+// which need to exercise multiple steps in a row, e.g. creating snapshots,
+// cleaning them up, and transferring them to a second simulated host.
+//
+// ZFS reports errors the same way zfs.Adapter does, i.e. as *zfs.Error. The
+// Stderr of the error resembles the message the real zfs executable would
+// print.
+package memzfs
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/fhofherr/zsm/internal/zfs"
+)
+
+// nextGUID is shared by all ZFS instances. This ensures that guids are unique
+// across simulated hosts, just like they are for real pools.
+var nextGUID = rand.New(rand.NewSource(time.Now().UnixNano())).Uint64() >> 1 // nolint: gosec
+
+func newGUID() uint64 {
+	return atomic.AddUint64(&nextGUID, 1)
+}
+
+type snapshot struct {
+	Name  string // the part after the @
+	GUID  uint64
+	TXG   uint64
+	Data  []byte
+	Props map[string]string
+	Holds map[string]bool
+	Total int64 // Total of the dataset when the snapshot was created
+}
+
+// bookmark remembers the guid and the creation of a snapshot.
+type bookmark struct {
+	Name string // the part after the #
+	GUID uint64
+	TXG  uint64
+}
+
+type dataset struct {
+	Name      string
+	Type      zfs.ListType // either zfs.FileSystem or zfs.Volume
+	GUID      uint64
+	Data      []byte
+	Written   int64
+	Total     int64 // bytes written since the dataset was created
+	Props     map[string]string
+	Snapshots []*snapshot // ordered from the oldest to the newest snapshot
+	Bookmarks []*bookmark // ordered by their creation
+}
+
+func (d *dataset) snapshot(name string) *snapshot {
+	for _, s := range d.Snapshots {
+		if s.Name == name {
+			return s
+		}
+	}
+	return nil
+}
+
+func (d *dataset) bookmark(name string) *bookmark {
+	for _, b := range d.Bookmarks {
+		if b.Name == name {
+			return b
+		}
+	}
+	return nil
+}
+
+func (d *dataset) latest() *snapshot {
+	if len(d.Snapshots) == 0 {
+		return nil
+	}
+	return d.Snapshots[len(d.Snapshots)-1]
+}
+
+// ZFS simulates the zfs executable of a single host.
+//
+// The zero value is a host without any pools. All methods are safe for
+// concurrent use.
+type ZFS struct {
+	mu        sync.Mutex
+	datasets  map[string]*dataset
+	tokens    map[string]string // partially received state by file system
+	txg       uint64
+	injected  map[string][]string
+	sendLimit int
+}
+
+// New creates a new simulated host which has a pool for each of the passed
+// names.
+func New(pools ...string) *ZFS {
+	z := &ZFS{}
+	for _, p := range pools {
+		if err := z.CreatePool(p); err != nil {
+			panic(err)
+		}
+	}
+	return z
+}
+
+func (z *ZFS) init() {
+	if z.datasets == nil {
+		z.datasets = make(map[string]*dataset)
+	}
+	if z.tokens == nil {
+		z.tokens = make(map[string]string)
+	}
+}
+
+// CreatePool creates a new pool with name.
+func (z *ZFS) CreatePool(name string) error {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+
+	z.init()
+	if strings.ContainsAny(name, "/@") || name == "" {
+		return fail("create", "cannot create '%s': invalid pool name", name)
+	}
+	if _, ok := z.datasets[name]; ok {
+		return fail("create", "cannot create '%s': pool already exists", name)
+	}
+	z.datasets[name] = newDataset(name, zfs.FileSystem)
+	return nil
+}
+
+// CreateFileSystem creates a new file system with name. The parent of the
+// file system must exist.
+func (z *ZFS) CreateFileSystem(name string) error {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+
+	return z.createDataset("create", name, zfs.FileSystem)
+}
+
+// CreateVolume creates a new volume with name. The parent of the volume must
+// be an existing file system.
+func (z *ZFS) CreateVolume(name string) error {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+
+	return z.createDataset("create", name, zfs.Volume)
+}
+
+func (z *ZFS) createDataset(sub, name string, typ zfs.ListType) error {
+	z.init()
+	if strings.Contains(name, "@") {
+		return fail(sub, "cannot create '%s': invalid character '@' in name", name)
+	}
+	if _, ok := z.datasets[name]; ok {
+		return fail(sub, "cannot create '%s': dataset already exists", name)
+	}
+	idx := strings.LastIndex(name, "/")
+	if idx < 0 {
+		return fail(sub, "cannot create '%s': missing dataset name", name)
+	}
+	parent, ok := z.datasets[name[:idx]]
+	if !ok {
+		return fail(sub, "cannot create '%s': parent does not exist", name)
+	}
+	if parent.Type != zfs.FileSystem {
+		return fail(sub, "cannot create '%s': parent is not a filesystem", name)
+	}
+	z.datasets[name] = newDataset(name, typ)
+	return nil
+}
+
+func newDataset(name string, typ zfs.ListType) *dataset {
+	return &dataset{
+		Name:  name,
+		Type:  typ,
+		GUID:  newGUID(),
+		Props: make(map[string]string),
+	}
+}
+
+// Write simulates writing data to the file system with name.
+//
+// The data replaces the current contents of the file system. The written
+// property of the file system grows by the length of data.
+func (z *ZFS) Write(name string, data []byte) error {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+
+	ds, err := z.dataset("write", name)
+	if err != nil {
+		return err
+	}
+	ds.Data = append([]byte(nil), data...)
+	ds.Written += int64(len(data))
+	ds.Total += int64(len(data))
+	return nil
+}
+
+// Read returns the current contents of the file system or snapshot with name.
+func (z *ZFS) Read(name string) ([]byte, error) {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+
+	if strings.Contains(name, "@") {
+		_, sn, err := z.snapshot("read", name)
+		if err != nil {
+			return nil, err
+		}
+		return append([]byte(nil), sn.Data...), nil
+	}
+	ds, err := z.dataset("read", name)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(nil), ds.Data...), nil
+}
+
+// SetProperty sets the property prop of the dataset or snapshot with name to
+// value.
+func (z *ZFS) SetProperty(name, prop, value string) error {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+
+	props, err := z.props("set", name)
+	if err != nil {
+		return err
+	}
+	props[prop] = value
+	return nil
+}
+
+// Property returns the value of the property prop of the dataset or snapshot
+// with name.
+//
+// Properties which are not set on the object itself are inherited from its
+// closest ancestor they are set on. The second return value is false if prop
+// is neither set on the object nor on any of its ancestors.
+//
+// Property supports the native properties guid, written, and
+// receive_resume_token.
+func (z *ZFS) Property(name, prop string) (string, bool, error) {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+
+	return z.property("get", name, prop)
+}
+
+func (z *ZFS) property(sub, name, prop string) (string, bool, error) {
+	z.init()
+	switch prop {
+	case "guid":
+		guid, err := z.guid(sub, name)
+		if err != nil {
+			return "", false, err
+		}
+		return strconv.FormatUint(guid, 10), true, nil
+	case "written":
+		if strings.Contains(name, "@") {
+			return "0", true, nil
+		}
+		ds, err := z.dataset(sub, name)
+		if err != nil {
+			return "", false, err
+		}
+		return strconv.FormatInt(ds.Written, 10), true, nil
+	case "userrefs":
+		if !strings.Contains(name, "@") {
+			return "-", true, nil
+		}
+		_, sn, err := z.snapshot(sub, name)
+		if err != nil {
+			return "", false, err
+		}
+		return strconv.Itoa(len(sn.Holds)), true, nil
+	case "receive_resume_token":
+		if token, ok := z.tokens[name]; ok {
+			return token, true, nil
+		}
+		return "-", true, nil
+	}
+
+	props, err := z.props(sub, name)
+	if err != nil {
+		return "", false, err
+	}
+	if v, ok := props[prop]; ok {
+		return v, true, nil
+	}
+	// Inherit the property from the closest ancestor.
+	parent := name
+	if idx := strings.Index(parent, "@"); idx > -1 {
+		parent = parent[:idx]
+		if v, ok := z.datasets[parent].Props[prop]; ok {
+			return v, true, nil
+		}
+	}
+	for {
+		idx := strings.LastIndex(parent, "/")
+		if idx < 0 {
+			return "", false, nil
+		}
+		parent = parent[:idx]
+		if v, ok := z.datasets[parent].Props[prop]; ok {
+			return v, true, nil
+		}
+	}
+}
+
+// Properties returns the values of the properties props for all objects of
+// typ. It behaves like zfs.Adapter's Properties.
+func (z *ZFS) Properties(typ zfs.ListType, props ...string) (map[string]map[string]string, error) {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+
+	if err := z.injectedError("get"); err != nil {
+		return nil, err
+	}
+	names, err := z.names("get", typ)
+	if err != nil {
+		return nil, err
+	}
+	values := make(map[string]map[string]string)
+	for _, name := range names {
+		for _, prop := range props {
+			v, ok, err := z.property("get", name, prop)
+			if err != nil {
+				return nil, err
+			}
+			if !ok || v == "-" {
+				continue
+			}
+			if values[name] == nil {
+				values[name] = make(map[string]string)
+			}
+			values[name][prop] = v
+		}
+	}
+	return values, nil
+}
+
+func (z *ZFS) props(sub, name string) (map[string]string, error) {
+	if strings.Contains(name, "@") {
+		_, sn, err := z.snapshot(sub, name)
+		if err != nil {
+			return nil, err
+		}
+		if sn.Props == nil {
+			sn.Props = make(map[string]string)
+		}
+		return sn.Props, nil
+	}
+	ds, err := z.dataset(sub, name)
+	if err != nil {
+		return nil, err
+	}
+	return ds.Props, nil
+}
+
+// Hold places a hold with tag on each of the snapshots with the passed
+// names. A snapshot with at least one hold can't be destroyed.
+func (z *ZFS) Hold(tag string, names ...string) error {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+
+	for _, name := range names {
+		_, sn, err := z.snapshot("hold", name)
+		if err != nil {
+			return err
+		}
+		if sn.Holds[tag] {
+			return fail("hold", "cannot hold snapshot '%s': tag already exists on this dataset", name)
+		}
+		if sn.Holds == nil {
+			sn.Holds = make(map[string]bool)
+		}
+		sn.Holds[tag] = true
+	}
+	return nil
+}
+
+// Release removes the hold with tag from each of the snapshots with the
+// passed names.
+func (z *ZFS) Release(tag string, names ...string) error {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+
+	for _, name := range names {
+		_, sn, err := z.snapshot("release", name)
+		if err != nil {
+			return err
+		}
+		if !sn.Holds[tag] {
+			return fail("release", "cannot release hold from snapshot '%s': no such tag on this dataset", name)
+		}
+		delete(sn.Holds, tag)
+	}
+	return nil
+}
+
+// Holds returns the sorted tags of all holds on the snapshot with name.
+func (z *ZFS) Holds(name string) ([]string, error) {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+
+	_, sn, err := z.snapshot("holds", name)
+	if err != nil {
+		return nil, err
+	}
+	tags := make([]string, 0, len(sn.Holds))
+	for tag := range sn.Holds {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+	return tags, nil
+}
+
+// Bookmark creates a bookmark with the name bmName of the snapshot with the
+// name snapName. Both must belong to the same file system or volume.
+func (z *ZFS) Bookmark(snapName, bmName string) error {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+
+	if err := z.injectedError("bookmark"); err != nil {
+		return err
+	}
+	ds, sn, err := z.snapshot("bookmark", snapName)
+	if err != nil {
+		return err
+	}
+	fsName, part, ok := splitName(bmName, "#")
+	if !ok || fsName != ds.Name {
+		return fail("bookmark", "cannot create bookmark '%s': invalid bookmark name", bmName)
+	}
+	if ds.bookmark(part) != nil {
+		return fail("bookmark", "cannot create bookmark '%s': bookmark exists", bmName)
+	}
+	ds.Bookmarks = append(ds.Bookmarks, &bookmark{Name: part, GUID: sn.GUID, TXG: sn.TXG})
+	return nil
+}
+
+// InjectError makes the next call to subCommand fail with stderr.
+//
+// InjectError may be called multiple times for the same subCommand. The
+// errors are returned in the order they were injected.
+func (z *ZFS) InjectError(subCommand, stderr string) {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+
+	if z.injected == nil {
+		z.injected = make(map[string][]string)
+	}
+	z.injected[subCommand] = append(z.injected[subCommand], stderr)
+}
+
+// InterruptSend makes the next call to Send or SendResume fail after writing
+// n bytes of the stream. This simulates a connection dropped during a
+// transfer.
+func (z *ZFS) InterruptSend(n int) {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+
+	z.sendLimit = n + 1
+}
+
+func (z *ZFS) injectedError(sub string) error {
+	errs := z.injected[sub]
+	if len(errs) == 0 {
+		return nil
+	}
+	z.injected[sub] = errs[1:]
+	return &zfs.Error{SubCommand: sub, ExitCode: 1, Stderr: errs[0]}
+}
+
+// List returns the names of all objects of typ.
+//
+// In contrast to zfs.Adapter List returns the names sorted. Snapshots are
+// sorted by file system first and by their creation second.
+func (z *ZFS) List(typ zfs.ListType) ([]string, error) {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+
+	if err := z.injectedError("list"); err != nil {
+		return nil, err
+	}
+	names, err := z.names("list", typ)
+	if err != nil {
+		return nil, err
+	}
+	if len(names) == 0 {
+		return nil, fmt.Errorf("zfs list: %w", zfs.ErrNoOutput)
+	}
+	return names, nil
+}
+
+// names returns the sorted names of all objects of typ. typ may combine
+// several types.
+func (z *ZFS) names(sub string, typ zfs.ListType) ([]string, error) {
+	want := make(map[zfs.ListType]bool)
+	for _, t := range typ.Split() {
+		switch t {
+		case zfs.FileSystem, zfs.Volume, zfs.Snapshot, zfs.Bookmark:
+			want[t] = true
+		default:
+			return nil, fail(sub, "invalid type '%s'", t)
+		}
+	}
+	var names []string
+	for _, ds := range z.sortedDatasets() {
+		if want[ds.Type] {
+			names = append(names, ds.Name)
+		}
+		if want[zfs.Snapshot] {
+			for _, sn := range ds.Snapshots {
+				names = append(names, ds.Name+"@"+sn.Name)
+			}
+		}
+		if want[zfs.Bookmark] {
+			for _, b := range ds.Bookmarks {
+				names = append(names, ds.Name+"#"+b.Name)
+			}
+		}
+	}
+	return names, nil
+}
+
+func (z *ZFS) sortedDatasets() []*dataset {
+	dss := make([]*dataset, 0, len(z.datasets))
+	for _, ds := range z.datasets {
+		dss = append(dss, ds)
+	}
+	sort.Slice(dss, func(i, j int) bool {
+		return dss[i].Name < dss[j].Name
+	})
+	return dss
+}
+
+// CreateSnapshot creates a snapshot with name.
+func (z *ZFS) CreateSnapshot(name string) error {
+	return z.CreateSnapshots(nil, name)
+}
+
+// CreateSnapshots creates snapshots with the passed names atomically. Just
+// like zfs snapshot it creates none of the snapshots if any of them cannot
+// be created. The properties props are set on all created snapshots.
+func (z *ZFS) CreateSnapshots(props map[string]string, names ...string) error {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+
+	if err := z.injectedError("snapshot"); err != nil {
+		return err
+	}
+	dss := make([]*dataset, len(names))
+	snapNames := make([]string, len(names))
+	seen := make(map[string]bool, len(names))
+	for i, name := range names {
+		fsName, snapName, ok := splitSnapshotName(name)
+		if !ok {
+			return fail("snapshot", "cannot create snapshot '%s': invalid snapshot name", name)
+		}
+		ds, err := z.dataset("snapshot", fsName)
+		if err != nil {
+			return err
+		}
+		if ds.snapshot(snapName) != nil || seen[name] {
+			return fail("snapshot", "cannot create snapshot '%s': dataset already exists", name)
+		}
+		seen[name] = true
+		dss[i] = ds
+		snapNames[i] = snapName
+	}
+	z.txg++
+	for i, ds := range dss {
+		snapProps := make(map[string]string, len(props))
+		for k, v := range props {
+			snapProps[k] = v
+		}
+		ds.Snapshots = append(ds.Snapshots, &snapshot{
+			Name:  snapNames[i],
+			GUID:  newGUID(),
+			TXG:   z.txg,
+			Data:  append([]byte(nil), ds.Data...),
+			Props: snapProps,
+			Total: ds.Total,
+		})
+		ds.Written = 0
+	}
+	return nil
+}
+
+// Destroy destroys the file system or snapshot with name.
+//
+// Just like zfs destroy without any flags Destroy refuses to destroy file
+// systems with children or snapshots, and snapshots with holds.
+func (z *ZFS) Destroy(name string) error {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+
+	if err := z.injectedError("destroy"); err != nil {
+		return err
+	}
+	if strings.Contains(name, "@") {
+		ds, sn, err := z.snapshot("destroy", name)
+		if err != nil {
+			return err
+		}
+		if len(sn.Holds) > 0 {
+			return fail("destroy", "cannot destroy snapshot %s: dataset is busy", name)
+		}
+		for i, s := range ds.Snapshots {
+			if s == sn {
+				ds.Snapshots = append(ds.Snapshots[:i], ds.Snapshots[i+1:]...)
+				break
+			}
+		}
+		return nil
+	}
+	ds, err := z.dataset("destroy", name)
+	if err != nil {
+		return err
+	}
+	if !strings.Contains(name, "/") {
+		return fail("destroy", "cannot destroy '%s': operation does not apply to pools", name)
+	}
+	for other := range z.datasets {
+		if strings.HasPrefix(other, name+"/") {
+			return fail("destroy", "cannot destroy '%s': filesystem has children", name)
+		}
+	}
+	if len(ds.Snapshots) > 0 {
+		return fail("destroy", "cannot destroy '%s': filesystem has children", name)
+	}
+	delete(z.datasets, name)
+	delete(z.tokens, name)
+	return nil
+}
+
+// DestroySnapshots destroys the snapshots with the passed names atomically.
+// All snapshots must belong to the same dataset. Just like zfs destroy
+// fs@a,b,c it destroys none of the snapshots if any of them cannot be
+// destroyed.
+func (z *ZFS) DestroySnapshots(names ...string) error {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+
+	if err := z.injectedError("destroy"); err != nil {
+		return err
+	}
+	var ds *dataset
+	destroy := make(map[*snapshot]bool, len(names))
+	for _, name := range names {
+		snapDS, sn, err := z.snapshot("destroy", name)
+		if err != nil {
+			return err
+		}
+		if ds != nil && snapDS != ds {
+			return fail("destroy", "cannot destroy '%s': snapshots must be of the same dataset", name)
+		}
+		if len(sn.Holds) > 0 {
+			return fail("destroy", "cannot destroy snapshot %s: dataset is busy", name)
+		}
+		ds = snapDS
+		destroy[sn] = true
+	}
+	if ds == nil {
+		return fail("destroy", "could not find any snapshots to destroy; check snapshot names.")
+	}
+	kept := ds.Snapshots[:0]
+	for _, s := range ds.Snapshots {
+		if !destroy[s] {
+			kept = append(kept, s)
+		}
+	}
+	ds.Snapshots = kept
+	return nil
+}
+
+// GUID returns the guid of the file system, snapshot, or bookmark with name.
+func (z *ZFS) GUID(name string) (uint64, error) {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+
+	if err := z.injectedError("get"); err != nil {
+		return 0, err
+	}
+	return z.guid("get", name)
+}
+
+func (z *ZFS) guid(sub, name string) (uint64, error) {
+	if strings.Contains(name, "#") {
+		_, b, err := z.bookmark(sub, name)
+		if err != nil {
+			return 0, err
+		}
+		return b.GUID, nil
+	}
+	if strings.Contains(name, "@") {
+		_, sn, err := z.snapshot(sub, name)
+		if err != nil {
+			return 0, err
+		}
+		return sn.GUID, nil
+	}
+	ds, err := z.dataset(sub, name)
+	if err != nil {
+		return 0, err
+	}
+	return ds.GUID, nil
+}
+
+// Written returns the number of bytes written to the dataset of the snapshot
+// with name since the snapshot was created.
+func (z *ZFS) Written(name string) (uint64, error) {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+
+	if err := z.injectedError("get"); err != nil {
+		return 0, err
+	}
+	ds, sn, err := z.snapshot("get", name)
+	if err != nil {
+		return 0, err
+	}
+	return uint64(ds.Total - sn.Total), nil
+}
+
+// ResumeTokens returns the receive_resume_token of the file system with name
+// and all its descendants which have one.
+func (z *ZFS) ResumeTokens(name string) (map[string]string, error) {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+
+	if err := z.injectedError("get"); err != nil {
+		return nil, err
+	}
+	if _, err := z.dataset("get", name); err != nil {
+		return nil, err
+	}
+	tokens := make(map[string]string)
+	for fs, token := range z.tokens {
+		if fs == name || strings.HasPrefix(fs, name+"/") {
+			tokens[fs] = token
+		}
+	}
+	return tokens, nil
+}
+
+// Send writes a stream containing the snapshot with name to w.
+//
+// If ref is not empty, the stream contains all snapshots after ref up to and
+// including name. Otherwise it contains a full copy of name. If ref is a
+// bookmark the stream contains the changes between the bookmarked snapshot
+// and name only.
+func (z *ZFS) Send(name, ref string, w io.Writer) error {
+	z.mu.Lock()
+	bs, limit, err := z.send(name, ref)
+	z.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return writeStream(w, bs, limit)
+}
+
+func (z *ZFS) send(name, ref string) ([]byte, int, error) {
+	if err := z.injectedError("send"); err != nil {
+		return nil, 0, err
+	}
+	ds, sn, err := z.snapshot("send", name)
+	if err != nil {
+		return nil, 0, err
+	}
+	var records []record
+	switch {
+	case ref == "":
+		records = append(records, record{Type: ds.Type, ToName: sn.Name, ToGUID: sn.GUID, Data: sn.Data})
+	case strings.Contains(ref, "#"):
+		refDS, b, err := z.bookmark("send", ref)
+		if err != nil {
+			return nil, 0, err
+		}
+		if refDS != ds {
+			return nil, 0, fail("send", "incremental source (%s) must be in the same filesystem as %s", ref, name)
+		}
+		if b.TXG >= sn.TXG {
+			return nil, 0, fail("send", "incremental source (%s) must be earlier than %s", ref, name)
+		}
+		records = append(records, record{
+			Type: ds.Type, ToName: sn.Name, ToGUID: sn.GUID, FromGUID: b.GUID, Data: sn.Data,
+		})
+	default:
+		refDS, refSN, err := z.snapshot("send", ref)
+		if err != nil {
+			return nil, 0, err
+		}
+		if refDS != ds {
+			return nil, 0, fail("send", "incremental source (%s) must be in the same filesystem as %s", ref, name)
+		}
+		if refSN.TXG >= sn.TXG {
+			return nil, 0, fail("send", "incremental source (%s) must be earlier than %s", ref, name)
+		}
+		from := refSN
+		for _, s := range ds.Snapshots {
+			if s.TXG <= refSN.TXG || s.TXG > sn.TXG {
+				continue
+			}
+			records = append(records, record{
+				Type: ds.Type, ToName: s.Name, ToGUID: s.GUID, FromGUID: from.GUID, Data: s.Data,
+			})
+			from = s
+		}
+	}
+	return encodeStream(records), z.takeSendLimit(), nil
+}
+
+// SendResume writes the remainder of the interrupted stream identified by
+// token to w.
+func (z *ZFS) SendResume(token string, w io.Writer) error {
+	z.mu.Lock()
+	bs, limit, err := z.sendResume(token)
+	z.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return writeStream(w, bs, limit)
+}
+
+func (z *ZFS) sendResume(token string) ([]byte, int, error) {
+	if err := z.injectedError("send"); err != nil {
+		return nil, 0, err
+	}
+	rt, err := decodeToken(token)
+	if err != nil {
+		return nil, 0, fail("send", "cannot resume send: %v", err)
+	}
+	for _, ds := range z.datasets {
+		for _, sn := range ds.Snapshots {
+			if sn.GUID != rt.ToGUID {
+				continue
+			}
+			rec := record{Type: ds.Type, ToName: sn.Name, ToGUID: sn.GUID, FromGUID: rt.FromGUID, Data: sn.Data}
+			return encodeStream([]record{rec}), z.takeSendLimit(), nil
+		}
+	}
+	return nil, 0, fail("send", "cannot resume send: '%s' used in the initial send no longer exists", rt.ToName)
+}
+
+func (z *ZFS) takeSendLimit() int {
+	limit := z.sendLimit - 1
+	z.sendLimit = 0
+	return limit
+}
+
+func writeStream(w io.Writer, bs []byte, limit int) error {
+	if limit >= 0 && limit < len(bs) {
+		if _, err := w.Write(bs[:limit]); err != nil {
+			return fail("send", "failed to write stream: %v", err)
+		}
+		return fail("send", "failed to write stream: broken pipe")
+	}
+	if _, err := w.Write(bs); err != nil {
+		return fail("send", "failed to write stream: %v", err)
+	}
+	return nil
+}
+
+// Receive reads a stream created by Send or SendResume from r and stores it
+// in name.
+//
+// name may either be a file system or a snapshot. In the latter case the
+// snapshot part of name is ignored and the snapshot names contained in the
+// stream are used.
+//
+// Each snapshot contained in the stream is received on its own. If the stream
+// is interrupted, all snapshots received so far are kept. If resumable is
+// true the partially received snapshot can be completed by receiving the
+// stream returned by SendResume for the file system's receive_resume_token.
+func (z *ZFS) Receive(name string, resumable bool, r io.Reader) error {
+	fsName := name
+	if idx := strings.Index(name, "@"); idx > -1 {
+		fsName = name[:idx]
+	}
+
+	z.mu.Lock()
+	err := z.injectedError("receive")
+	if err == nil {
+		err = z.checkPartialState(fsName)
+	}
+	z.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	dec := newStreamDecoder(r)
+	if err := dec.Header(); err != nil {
+		return fail("receive", "cannot receive: %v", err)
+	}
+	for {
+		rec, partial, err := dec.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			z.mu.Lock()
+			defer z.mu.Unlock()
+
+			if resumable && partial != nil {
+				z.tokens[fsName] = encodeToken(resumeToken{
+					FileSystem: fsName,
+					ToName:     partial.ToName,
+					ToGUID:     partial.ToGUID,
+					FromGUID:   partial.FromGUID,
+				})
+			}
+			return fail("receive", "cannot receive: failed to read from stream: %v", err)
+		}
+		z.mu.Lock()
+		err = z.receiveRecord(fsName, rec)
+		z.mu.Unlock()
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// AbortReceive discards the partially received state of the file system with
+// name.
+func (z *ZFS) AbortReceive(name string) error {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+
+	if err := z.injectedError("receive"); err != nil {
+		return err
+	}
+	if _, err := z.dataset("receive", name); err != nil {
+		return err
+	}
+	if _, ok := z.tokens[name]; !ok {
+		return fail("receive", "'%s' does not have any resumable receive state to abort", name)
+	}
+	delete(z.tokens, name)
+	return nil
+}
+
+func (z *ZFS) checkPartialState(fsName string) error {
+	z.init()
+	token, ok := z.tokens[fsName]
+	if !ok {
+		return nil
+	}
+	// The stream must resume the interrupted receive. This is checked when
+	// receiving the first record.
+	if _, err := decodeToken(token); err != nil {
+		return fail("receive", "cannot receive: invalid partial state of '%s'", fsName)
+	}
+	return nil
+}
+
+func (z *ZFS) receiveRecord(fsName string, rec record) error {
+	if token, ok := z.tokens[fsName]; ok {
+		rt, _ := decodeToken(token)
+		if rt.ToGUID != rec.ToGUID || rt.FromGUID != rec.FromGUID {
+			return fail("receive",
+				"cannot receive new filesystem stream: destination %s contains partially-complete state "+
+					"from \"zfs receive -s\"", fsName)
+		}
+		delete(z.tokens, fsName)
+	}
+
+	ds, exists := z.datasets[fsName]
+	if rec.FromGUID == 0 {
+		if exists {
+			return fail("receive",
+				"cannot receive new filesystem stream: destination '%s' exists\nmust specify -F to overwrite it",
+				fsName)
+		}
+		if err := z.createDataset("receive", fsName, rec.Type); err != nil {
+			return err
+		}
+		ds = z.datasets[fsName]
+	} else {
+		if !exists {
+			return fail("receive", "cannot receive incremental stream: destination '%s' does not exist", fsName)
+		}
+		latest := ds.latest()
+		if latest == nil || latest.GUID != rec.FromGUID {
+			return fail("receive",
+				"cannot receive incremental stream: most recent snapshot of %s does not\nmatch incremental source",
+				fsName)
+		}
+		if ds.Written > 0 {
+			return fail("receive",
+				"cannot receive incremental stream: destination %s has been modified\nsince most recent snapshot",
+				fsName)
+		}
+	}
+	if ds.snapshot(rec.ToName) != nil {
+		return fail("receive", "cannot receive: destination snapshot %s@%s exists", fsName, rec.ToName)
+	}
+	z.txg++
+	ds.Total += int64(len(rec.Data))
+	ds.Snapshots = append(ds.Snapshots, &snapshot{
+		Name:  rec.ToName,
+		GUID:  rec.ToGUID,
+		TXG:   z.txg,
+		Data:  rec.Data,
+		Total: ds.Total,
+	})
+	ds.Data = append([]byte(nil), rec.Data...)
+	ds.Written = 0
+	return nil
+}
+
+func (z *ZFS) dataset(sub, name string) (*dataset, error) {
+	z.init()
+	ds, ok := z.datasets[name]
+	if !ok {
+		return nil, fail(sub, "cannot open '%s': dataset does not exist", name)
+	}
+	return ds, nil
+}
+
+func (z *ZFS) snapshot(sub, name string) (*dataset, *snapshot, error) {
+	fsName, snapName, ok := splitSnapshotName(name)
+	if !ok {
+		return nil, nil, fail(sub, "cannot open '%s': invalid snapshot name", name)
+	}
+	ds, err := z.dataset(sub, fsName)
+	if err != nil {
+		return nil, nil, fail(sub, "cannot open '%s': dataset does not exist", name)
+	}
+	sn := ds.snapshot(snapName)
+	if sn == nil {
+		return nil, nil, fail(sub, "cannot open '%s': dataset does not exist", name)
+	}
+	return ds, sn, nil
+}
+
+func (z *ZFS) bookmark(sub, name string) (*dataset, *bookmark, error) {
+	fsName, bmName, ok := splitName(name, "#")
+	if !ok {
+		return nil, nil, fail(sub, "cannot open '%s': invalid bookmark name", name)
+	}
+	ds, err := z.dataset(sub, fsName)
+	if err != nil {
+		return nil, nil, fail(sub, "cannot open '%s': dataset does not exist", name)
+	}
+	b := ds.bookmark(bmName)
+	if b == nil {
+		return nil, nil, fail(sub, "cannot open '%s': dataset does not exist", name)
+	}
+	return ds, b, nil
+}
+
+func splitSnapshotName(name string) (string, string, bool) {
+	return splitName(name, "@")
+}
+
+// splitName splits the name of a snapshot or bookmark at sep.
+func splitName(name, sep string) (string, string, bool) {
+	parts := strings.Split(name, sep)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", false
+	}
+	return parts[0], parts[1], true
+}
+
+func fail(sub, format string, args ...interface{}) error {
+	var stderr bytes.Buffer
+
+	fmt.Fprintf(&stderr, format, args...)
+	stderr.WriteByte('\n')
+	return &zfs.Error{
+		SubCommand: sub,
+		ExitCode:   1,
+		Stderr:     stderr.String(),
+	}
+}
@@ -0,0 +1,438 @@
+package memzfs_test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/fhofherr/zsm/internal/zfs"
+	"github.com/fhofherr/zsm/internal/zfs/memzfs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestZFS_CreateSnapshot(t *testing.T) {
+	z := memzfs.New("zsm_test")
+	require.NoError(t, z.CreateFileSystem("zsm_test/fs_1"))
+
+	assert.NoError(t, z.CreateSnapshot("zsm_test/fs_1@snap_1"))
+	assertZFSError(t, "cannot create snapshot 'zsm_test/fs_1@snap_1': dataset already exists\n",
+		z.CreateSnapshot("zsm_test/fs_1@snap_1"))
+	assertZFSError(t, "cannot open 'zsm_test/fs_2': dataset does not exist\n",
+		z.CreateSnapshot("zsm_test/fs_2@snap_1"))
+
+	require.NoError(t, z.Write("zsm_test/fs_1", []byte("some data")))
+	assertProperty(t, z, "zsm_test/fs_1", "written", "9")
+	assert.NoError(t, z.CreateSnapshot("zsm_test/fs_1@snap_2"))
+	assertProperty(t, z, "zsm_test/fs_1", "written", "0")
+
+	data, err := z.Read("zsm_test/fs_1@snap_2")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("some data"), data)
+}
+
+func TestZFS_CreateSnapshots(t *testing.T) {
+	z := memzfs.New("zsm_test")
+	require.NoError(t, z.CreateFileSystem("zsm_test/fs_1"))
+
+	assertZFSError(t, "cannot open 'zsm_test/fs_2': dataset does not exist\n",
+		z.CreateSnapshots(nil, "zsm_test@snap_1", "zsm_test/fs_2@snap_1"))
+	_, err := z.List(zfs.Snapshot)
+	assert.True(t, errors.Is(err, zfs.ErrNoOutput), "no snapshot must have been created")
+
+	props := map[string]string{"user:prop": "value"}
+	assert.NoError(t, z.CreateSnapshots(props, "zsm_test@snap_1", "zsm_test/fs_1@snap_1"))
+	snapshots, err := z.List(zfs.Snapshot)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"zsm_test@snap_1", "zsm_test/fs_1@snap_1"}, snapshots)
+	v, ok, err := z.Property("zsm_test/fs_1@snap_1", "user:prop")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "value", v)
+}
+
+func TestZFS_List(t *testing.T) {
+	z := memzfs.New("zsm_test")
+
+	_, err := z.List(zfs.Snapshot)
+	assert.True(t, errors.Is(err, zfs.ErrNoOutput))
+
+	require.NoError(t, z.CreateFileSystem("zsm_test/fs_2"))
+	require.NoError(t, z.CreateFileSystem("zsm_test/fs_1"))
+	require.NoError(t, z.CreateSnapshot("zsm_test/fs_2@b"))
+	require.NoError(t, z.CreateSnapshot("zsm_test/fs_2@a"))
+	require.NoError(t, z.CreateSnapshot("zsm_test/fs_1@c"))
+
+	fileSystems, err := z.List(zfs.FileSystem)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"zsm_test", "zsm_test/fs_1", "zsm_test/fs_2"}, fileSystems)
+
+	snapshots, err := z.List(zfs.Snapshot)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"zsm_test/fs_1@c", "zsm_test/fs_2@b", "zsm_test/fs_2@a"}, snapshots)
+}
+
+func TestZFS_Volumes(t *testing.T) {
+	src := memzfs.New("zsm_test")
+	require.NoError(t, src.CreateVolume("zsm_test/vm_disk_1"))
+	require.NoError(t, src.CreateFileSystem("zsm_test/fs_1"))
+	assertZFSError(t, "cannot create 'zsm_test/vm_disk_1/fs_1': parent is not a filesystem\n",
+		src.CreateFileSystem("zsm_test/vm_disk_1/fs_1"))
+
+	volumes, err := src.List(zfs.Volume)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"zsm_test/vm_disk_1"}, volumes)
+	datasets, err := src.List(zfs.Types(zfs.FileSystem, zfs.Volume))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"zsm_test", "zsm_test/fs_1", "zsm_test/vm_disk_1"}, datasets)
+
+	// Receiving a volume stream creates a volume.
+	var buf bytes.Buffer
+	require.NoError(t, src.CreateSnapshot("zsm_test/vm_disk_1@snap_1"))
+	require.NoError(t, src.Send("zsm_test/vm_disk_1@snap_1", "", &buf))
+	dst := memzfs.New("target_fs")
+	require.NoError(t, dst.Receive("target_fs/vm_disk_1@snap_1", false, &buf))
+	volumes, err = dst.List(zfs.Volume)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"target_fs/vm_disk_1"}, volumes)
+}
+
+func TestZFS_Destroy(t *testing.T) {
+	z := memzfs.New("zsm_test")
+	require.NoError(t, z.CreateFileSystem("zsm_test/fs_1"))
+	require.NoError(t, z.CreateFileSystem("zsm_test/fs_1/nested_fs_1"))
+	require.NoError(t, z.CreateSnapshot("zsm_test/fs_1/nested_fs_1@snap_1"))
+	require.NoError(t, z.Hold("keep", "zsm_test/fs_1/nested_fs_1@snap_1"))
+
+	assertZFSError(t, "cannot destroy 'zsm_test/fs_1': filesystem has children\n", z.Destroy("zsm_test/fs_1"))
+	assertZFSError(t, "cannot destroy snapshot zsm_test/fs_1/nested_fs_1@snap_1: dataset is busy\n",
+		z.Destroy("zsm_test/fs_1/nested_fs_1@snap_1"))
+
+	require.NoError(t, z.Release("keep", "zsm_test/fs_1/nested_fs_1@snap_1"))
+	assert.NoError(t, z.Destroy("zsm_test/fs_1/nested_fs_1@snap_1"))
+	assert.NoError(t, z.Destroy("zsm_test/fs_1/nested_fs_1"))
+	assert.NoError(t, z.Destroy("zsm_test/fs_1"))
+
+	fileSystems, err := z.List(zfs.FileSystem)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"zsm_test"}, fileSystems)
+}
+
+func TestZFS_DestroySnapshots(t *testing.T) {
+	z := memzfs.New("zsm_test")
+	require.NoError(t, z.CreateFileSystem("zsm_test/fs_1"))
+	for _, sn := range []string{"snap_1", "snap_2", "snap_3"} {
+		require.NoError(t, z.CreateSnapshots(nil, "zsm_test@"+sn, "zsm_test/fs_1@"+sn))
+	}
+	require.NoError(t, z.Hold("keep", "zsm_test@snap_2"))
+
+	// Nothing is destroyed if one of the snapshots is held.
+	assertZFSError(t, "cannot destroy snapshot zsm_test@snap_2: dataset is busy\n",
+		z.DestroySnapshots("zsm_test@snap_1", "zsm_test@snap_2"))
+	assertZFSError(t, "cannot destroy 'zsm_test/fs_1@snap_2': snapshots must be of the same dataset\n",
+		z.DestroySnapshots("zsm_test@snap_1", "zsm_test/fs_1@snap_2"))
+
+	require.NoError(t, z.DestroySnapshots("zsm_test@snap_1", "zsm_test@snap_3"))
+	snapshots, err := z.List(zfs.Snapshot)
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"zsm_test@snap_2",
+		"zsm_test/fs_1@snap_1",
+		"zsm_test/fs_1@snap_2",
+		"zsm_test/fs_1@snap_3",
+	}, snapshots)
+}
+
+func TestZFS_Holds(t *testing.T) {
+	z := memzfs.New("zsm_test")
+	require.NoError(t, z.CreateSnapshot("zsm_test@snap_1"))
+
+	assert.NoError(t, z.Hold("b", "zsm_test@snap_1"))
+	assert.NoError(t, z.Hold("a", "zsm_test@snap_1"))
+	assertZFSError(t, "cannot hold snapshot 'zsm_test@snap_1': tag already exists on this dataset\n",
+		z.Hold("a", "zsm_test@snap_1"))
+
+	tags, err := z.Holds("zsm_test@snap_1")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a", "b"}, tags)
+
+	props, err := z.Properties(zfs.Snapshot, "userrefs")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]map[string]string{"zsm_test@snap_1": {"userrefs": "2"}}, props)
+
+	assertZFSError(t, "cannot release hold from snapshot 'zsm_test@snap_1': no such tag on this dataset\n",
+		z.Release("c", "zsm_test@snap_1"))
+}
+
+func TestZFS_Property(t *testing.T) {
+	z := memzfs.New("zsm_test")
+	require.NoError(t, z.CreateFileSystem("zsm_test/fs_1"))
+	require.NoError(t, z.CreateSnapshot("zsm_test/fs_1@snap_1"))
+	require.NoError(t, z.SetProperty("zsm_test", "com.example:prop", "inherited"))
+
+	assertProperty(t, z, "zsm_test/fs_1@snap_1", "com.example:prop", "inherited")
+
+	require.NoError(t, z.SetProperty("zsm_test/fs_1", "com.example:prop", "local"))
+	assertProperty(t, z, "zsm_test/fs_1@snap_1", "com.example:prop", "local")
+	assertProperty(t, z, "zsm_test", "com.example:prop", "inherited")
+
+	_, ok, err := z.Property("zsm_test/fs_1", "com.example:other")
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	guid, err := z.GUID("zsm_test/fs_1@snap_1")
+	require.NoError(t, err)
+	assert.NotZero(t, guid)
+}
+
+func TestZFS_Properties(t *testing.T) {
+	z := memzfs.New("zsm_test")
+	require.NoError(t, z.CreateFileSystem("zsm_test/fs_1"))
+	require.NoError(t, z.CreateFileSystem("zsm_test/fs_2"))
+	require.NoError(t, z.SetProperty("zsm_test/fs_1", "com.example:prop", "inherited"))
+	require.NoError(t, z.CreateFileSystem("zsm_test/fs_1/nested_fs_1"))
+
+	props, err := z.Properties(zfs.FileSystem, "com.example:prop", "com.example:other")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]map[string]string{
+		"zsm_test/fs_1":             {"com.example:prop": "inherited"},
+		"zsm_test/fs_1/nested_fs_1": {"com.example:prop": "inherited"},
+	}, props)
+}
+
+func TestZFS_Written(t *testing.T) {
+	z := memzfs.New("zsm_test")
+	require.NoError(t, z.CreateFileSystem("zsm_test/fs_1"))
+	require.NoError(t, z.CreateSnapshot("zsm_test/fs_1@snap_1"))
+	require.NoError(t, z.Write("zsm_test/fs_1", []byte("some data")))
+	require.NoError(t, z.CreateSnapshot("zsm_test/fs_1@snap_2"))
+	require.NoError(t, z.Write("zsm_test/fs_1", []byte("more")))
+
+	written, err := z.Written("zsm_test/fs_1@snap_1")
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(13), written)
+
+	written, err = z.Written("zsm_test/fs_1@snap_2")
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(4), written)
+
+	_, err = z.Written("zsm_test/fs_1@snap_3")
+	assertZFSError(t, "cannot open 'zsm_test/fs_1@snap_3': dataset does not exist\n", err)
+}
+
+func TestZFS_SendReceive(t *testing.T) {
+	src := memzfs.New("zsm_test")
+	for _, sn := range []string{"snap_1", "snap_2", "snap_3"} {
+		require.NoError(t, src.Write("zsm_test", []byte(sn)))
+		require.NoError(t, src.CreateSnapshot("zsm_test@"+sn))
+	}
+	dst := memzfs.New("target_fs")
+
+	// Full stream
+	var buf bytes.Buffer
+	require.NoError(t, src.Send("zsm_test@snap_1", "", &buf))
+	require.NoError(t, dst.Receive("target_fs/zsm_test@snap_1", false, &buf))
+
+	// Full stream into an existing file system
+	require.NoError(t, src.Send("zsm_test@snap_1", "", &buf))
+	assertZFSError(t, "cannot receive new filesystem stream: destination 'target_fs/zsm_test' exists\n"+
+		"must specify -F to overwrite it\n", dst.Receive("target_fs/zsm_test@snap_1", false, &buf))
+
+	// Incremental stream containing all intermediary snapshots
+	require.NoError(t, src.Send("zsm_test@snap_3", "zsm_test@snap_1", &buf))
+	require.NoError(t, dst.Receive("target_fs/zsm_test@snap_3", false, &buf))
+
+	snapshots, err := dst.List(zfs.Snapshot)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"target_fs/zsm_test@snap_1",
+		"target_fs/zsm_test@snap_2",
+		"target_fs/zsm_test@snap_3",
+	}, snapshots)
+	for _, sn := range []string{"snap_1", "snap_2", "snap_3"} {
+		srcGUID, err := src.GUID("zsm_test@" + sn)
+		require.NoError(t, err)
+		dstGUID, err := dst.GUID("target_fs/zsm_test@" + sn)
+		require.NoError(t, err)
+		assert.Equal(t, srcGUID, dstGUID)
+	}
+	data, err := dst.Read("target_fs/zsm_test")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("snap_3"), data)
+
+	// Incremental stream not matching the most recent snapshot
+	require.NoError(t, src.Send("zsm_test@snap_3", "zsm_test@snap_2", &buf))
+	assertZFSError(t, "cannot receive incremental stream: most recent snapshot of target_fs/zsm_test does not\n"+
+		"match incremental source\n", dst.Receive("target_fs/zsm_test@snap_3", false, &buf))
+
+	// Incremental stream into a modified destination
+	require.NoError(t, src.Write("zsm_test", []byte("snap_4")))
+	require.NoError(t, src.CreateSnapshot("zsm_test@snap_4"))
+	require.NoError(t, dst.Write("target_fs/zsm_test", []byte("modified")))
+	require.NoError(t, src.Send("zsm_test@snap_4", "zsm_test@snap_3", &buf))
+	assertZFSError(t, "cannot receive incremental stream: destination target_fs/zsm_test has been modified\n"+
+		"since most recent snapshot\n", dst.Receive("target_fs/zsm_test@snap_4", false, &buf))
+}
+
+func TestZFS_Bookmarks(t *testing.T) {
+	src := memzfs.New("zsm_test")
+	for _, sn := range []string{"snap_1", "snap_2", "snap_3"} {
+		require.NoError(t, src.Write("zsm_test", []byte(sn)))
+		require.NoError(t, src.CreateSnapshot("zsm_test@"+sn))
+	}
+	dst := memzfs.New("target_fs")
+
+	var buf bytes.Buffer
+	require.NoError(t, src.Send("zsm_test@snap_1", "", &buf))
+	require.NoError(t, dst.Receive("target_fs/zsm_test@snap_1", false, &buf))
+
+	require.NoError(t, src.Bookmark("zsm_test@snap_1", "zsm_test#snap_1"))
+	assertZFSError(t, "cannot create bookmark 'zsm_test#snap_1': bookmark exists\n",
+		src.Bookmark("zsm_test@snap_1", "zsm_test#snap_1"))
+	require.NoError(t, src.Destroy("zsm_test@snap_1"))
+
+	bookmarks, err := src.List(zfs.Bookmark)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"zsm_test#snap_1"}, bookmarks)
+	bmGUID, err := src.GUID("zsm_test#snap_1")
+	require.NoError(t, err)
+	dstGUID, err := dst.GUID("target_fs/zsm_test@snap_1")
+	require.NoError(t, err)
+	assert.Equal(t, dstGUID, bmGUID)
+
+	// An incremental stream from a bookmark contains only the sent snapshot.
+	require.NoError(t, src.Send("zsm_test@snap_3", "zsm_test#snap_1", &buf))
+	require.NoError(t, dst.Receive("target_fs/zsm_test@snap_3", false, &buf))
+	snapshots, err := dst.List(zfs.Snapshot)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"target_fs/zsm_test@snap_1", "target_fs/zsm_test@snap_3"}, snapshots)
+	data, err := dst.Read("target_fs/zsm_test")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("snap_3"), data)
+}
+
+func TestZFS_ResumeReceive(t *testing.T) {
+	src := memzfs.New("zsm_test")
+	require.NoError(t, src.CreateSnapshot("zsm_test@snap_1"))
+	require.NoError(t, src.Write("zsm_test", bytes.Repeat([]byte("x"), 128)))
+	require.NoError(t, src.CreateSnapshot("zsm_test@snap_2"))
+	dst := memzfs.New("target_fs")
+
+	var buf bytes.Buffer
+	require.NoError(t, src.Send("zsm_test@snap_1", "", &buf))
+	require.NoError(t, dst.Receive("target_fs/zsm_test@snap_1", true, &buf))
+
+	src.InterruptSend(96)
+	assert.Error(t, src.Send("zsm_test@snap_2", "zsm_test@snap_1", &buf))
+	assertZFSError(t, "cannot receive: failed to read from stream: unexpected EOF\n",
+		dst.Receive("target_fs/zsm_test@snap_2", true, &buf))
+
+	tokens, err := dst.ResumeTokens("target_fs")
+	require.NoError(t, err)
+	require.Contains(t, tokens, "target_fs/zsm_test")
+	assertProperty(t, dst, "target_fs/zsm_test", "receive_resume_token", tokens["target_fs/zsm_test"])
+
+	// Only the stream resuming the interrupted receive is accepted.
+	buf.Reset()
+	require.NoError(t, src.Send("zsm_test@snap_2", "", &buf))
+	assertZFSError(t, "cannot receive new filesystem stream: destination target_fs/zsm_test contains "+
+		"partially-complete state from \"zfs receive -s\"\n", dst.Receive("target_fs/zsm_test", true, &buf))
+
+	buf.Reset()
+	require.NoError(t, src.SendResume(tokens["target_fs/zsm_test"], &buf))
+	require.NoError(t, dst.Receive("target_fs/zsm_test", true, &buf))
+
+	snapshots, err := dst.List(zfs.Snapshot)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"target_fs/zsm_test@snap_1", "target_fs/zsm_test@snap_2"}, snapshots)
+}
+
+func TestZFS_AbortReceive(t *testing.T) {
+	src := memzfs.New("zsm_test")
+	require.NoError(t, src.CreateSnapshot("zsm_test@snap_1"))
+	require.NoError(t, src.Write("zsm_test", bytes.Repeat([]byte("x"), 128)))
+	require.NoError(t, src.CreateSnapshot("zsm_test@snap_2"))
+	dst := memzfs.New("target_fs")
+
+	var buf bytes.Buffer
+	require.NoError(t, src.Send("zsm_test@snap_1", "", &buf))
+	require.NoError(t, dst.Receive("target_fs/zsm_test@snap_1", true, &buf))
+
+	src.InterruptSend(96)
+	assert.Error(t, src.Send("zsm_test@snap_2", "zsm_test@snap_1", &buf))
+	assert.Error(t, dst.Receive("target_fs/zsm_test@snap_2", true, &buf))
+
+	require.NoError(t, dst.AbortReceive("target_fs/zsm_test"))
+	tokens, err := dst.ResumeTokens("target_fs")
+	require.NoError(t, err)
+	assert.Empty(t, tokens)
+	assertZFSError(t, "'target_fs/zsm_test' does not have any resumable receive state to abort\n",
+		dst.AbortReceive("target_fs/zsm_test"))
+
+	// The file system accepts the incremental stream again.
+	buf.Reset()
+	require.NoError(t, src.Send("zsm_test@snap_2", "zsm_test@snap_1", &buf))
+	require.NoError(t, dst.Receive("target_fs/zsm_test", true, &buf))
+}
+
+func TestZFS_SendResume(t *testing.T) {
+	src := memzfs.New("zsm_test")
+	require.NoError(t, src.Write("zsm_test", bytes.Repeat([]byte("x"), 128)))
+	require.NoError(t, src.CreateSnapshot("zsm_test@snap_1"))
+	dst := memzfs.New("target_fs")
+
+	var buf bytes.Buffer
+	src.InterruptSend(96)
+	assert.Error(t, src.Send("zsm_test@snap_1", "", &buf))
+	assert.Error(t, dst.Receive("target_fs/zsm_test@snap_1", true, &buf))
+
+	tokens, err := dst.ResumeTokens("target_fs")
+	require.NoError(t, err)
+	token := tokens["target_fs/zsm_test"]
+	require.NotEmpty(t, token)
+
+	require.NoError(t, src.SendResume(token, &buf))
+	require.NoError(t, dst.Receive("target_fs/zsm_test", true, &buf))
+
+	tokens, err = dst.ResumeTokens("target_fs")
+	assert.NoError(t, err)
+	assert.Empty(t, tokens)
+	assertProperty(t, dst, "target_fs/zsm_test", "receive_resume_token", "-")
+
+	srcGUID, err := src.GUID("zsm_test@snap_1")
+	require.NoError(t, err)
+	dstGUID, err := dst.GUID("target_fs/zsm_test@snap_1")
+	require.NoError(t, err)
+	assert.Equal(t, srcGUID, dstGUID)
+}
+
+func TestZFS_InjectError(t *testing.T) {
+	z := memzfs.New("zsm_test")
+	z.InjectError("snapshot", "cannot create snapshot: pool I/O is currently suspended\n")
+
+	assertZFSError(t, "cannot create snapshot: pool I/O is currently suspended\n",
+		z.CreateSnapshot("zsm_test@snap_1"))
+	assert.NoError(t, z.CreateSnapshot("zsm_test@snap_1"))
+}
+
+func assertZFSError(t *testing.T, expectedStderr string, err error) {
+	t.Helper()
+
+	zfsErr := &zfs.Error{}
+	if !errors.As(err, &zfsErr) {
+		t.Errorf("expected *zfs.Error; got %v", err)
+		return
+	}
+	assert.Equal(t, expectedStderr, zfsErr.Stderr)
+}
+
+func assertProperty(t *testing.T, z *memzfs.ZFS, name, prop, expected string) {
+	t.Helper()
+
+	value, ok, err := z.Property(name, prop)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.True(t, ok, "property %s of %s not set", prop, name)
+	assert.Equal(t, expected, value)
+}
@@ -0,0 +1,168 @@
+package memzfs
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/fhofherr/zsm/internal/zfs"
+)
+
+// streamHeader is the first line of each stream.
+const streamHeader = "MEMZFS STREAM 1"
+
+// record describes a single snapshot within a stream.
+//
+// A stream is a sequence of lines. The first line is the stream header.
+// Each of the following lines contains one record:
+//
+//	snapshot <to name> <to guid> <from guid> <base64 encoded data>
+//
+// Records of volume snapshots start with volsnapshot instead of snapshot.
+// A from guid of 0 denotes a full copy of the snapshot. The stream is
+// terminated by a line containing the word end.
+type record struct {
+	Type     zfs.ListType
+	ToName   string
+	ToGUID   uint64
+	FromGUID uint64
+	Data     []byte
+}
+
+func encodeStream(records []record) []byte {
+	var buf bytes.Buffer
+
+	fmt.Fprintln(&buf, streamHeader)
+	for _, rec := range records {
+		keyword := "snapshot"
+		if rec.Type == zfs.Volume {
+			keyword = "volsnapshot"
+		}
+		fmt.Fprintf(&buf, "%s %s %d %d %s\n",
+			keyword, rec.ToName, rec.ToGUID, rec.FromGUID, base64.StdEncoding.EncodeToString(rec.Data))
+	}
+	fmt.Fprintln(&buf, "end")
+	return buf.Bytes()
+}
+
+type streamDecoder struct {
+	r *bufio.Reader
+}
+
+func newStreamDecoder(r io.Reader) *streamDecoder {
+	return &streamDecoder{r: bufio.NewReader(r)}
+}
+
+// Header reads and checks the stream header.
+func (d *streamDecoder) Header() error {
+	line, err := d.r.ReadString('\n')
+	if err != nil {
+		return fmt.Errorf("read stream header: %w", err)
+	}
+	if strings.TrimSuffix(line, "\n") != streamHeader {
+		return errors.New("invalid stream header")
+	}
+	return nil
+}
+
+// Next returns the next record of the stream. It returns io.EOF once the end
+// of the stream has been reached.
+//
+// If the stream ends in the middle of a record, Next returns an error. If
+// enough of the record has been read to identify the snapshot, Next returns
+// the partial record in addition to the error.
+func (d *streamDecoder) Next() (record, *record, error) {
+	line, err := d.r.ReadString('\n')
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		partial, _ := parseRecord(line, true)
+		return record{}, partial, err
+	}
+	line = strings.TrimSuffix(line, "\n")
+	if line == "end" {
+		return record{}, nil, io.EOF
+	}
+	rec, err := parseRecord(line, false)
+	if err != nil {
+		return record{}, nil, err
+	}
+	return *rec, nil, nil
+}
+
+func parseRecord(line string, partial bool) (*record, error) {
+	fields := strings.Fields(line)
+	if len(fields) < 4 || (fields[0] != "snapshot" && fields[0] != "volsnapshot") {
+		return nil, fmt.Errorf("invalid record: %q", line)
+	}
+	// The from guid of a partial record may have been cut off.
+	if partial && len(fields) == 4 && !strings.HasSuffix(line, " ") {
+		return nil, fmt.Errorf("incomplete record: %q", line)
+	}
+	toGUID, err := strconv.ParseUint(fields[2], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid to guid: %w", err)
+	}
+	fromGUID, err := strconv.ParseUint(fields[3], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid from guid: %w", err)
+	}
+	rec := &record{Type: zfs.FileSystem, ToName: fields[1], ToGUID: toGUID, FromGUID: fromGUID}
+	if fields[0] == "volsnapshot" {
+		rec.Type = zfs.Volume
+	}
+	if partial {
+		return rec, nil
+	}
+	if len(fields) != 4 && len(fields) != 5 {
+		return nil, fmt.Errorf("invalid record: %q", line)
+	}
+	if len(fields) == 5 {
+		rec.Data, err = base64.StdEncoding.DecodeString(fields[4])
+		if err != nil {
+			return nil, fmt.Errorf("invalid data: %w", err)
+		}
+	}
+	return rec, nil
+}
+
+// resumeToken contains everything required to resume an interrupted
+// receive.
+type resumeToken struct {
+	FileSystem string `json:"fs"`
+	ToName     string `json:"toname"`
+	ToGUID     uint64 `json:"toguid"`
+	FromGUID   uint64 `json:"fromguid,omitempty"`
+}
+
+func encodeToken(rt resumeToken) string {
+	bs, err := json.Marshal(rt)
+	if err != nil {
+		// Can't happen, resumeToken only contains strings and numbers.
+		panic(err)
+	}
+	return "1-" + base64.RawURLEncoding.EncodeToString(bs)
+}
+
+func decodeToken(token string) (resumeToken, error) {
+	var rt resumeToken
+
+	if !strings.HasPrefix(token, "1-") {
+		return rt, errors.New("invalid resume token")
+	}
+	bs, err := base64.RawURLEncoding.DecodeString(token[2:])
+	if err != nil {
+		return rt, fmt.Errorf("invalid resume token: %w", err)
+	}
+	if err := json.Unmarshal(bs, &rt); err != nil {
+		return rt, fmt.Errorf("invalid resume token: %w", err)
+	}
+	return rt, nil
+}